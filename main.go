@@ -1,46 +1,49 @@
 package main
 
 import (
+	"context"
 	"fmt"
 
 	"fsedano.net/pq/priorityqueue"
 )
 
 func main() {
+	ctx := context.Background()
+
 	fmt.Println("Slice-based Priority Queue:")
 	var slicePQ priorityqueue.PriorityQueuer = priorityqueue.NewMultiPriorityQueue()
-	testQueue(slicePQ)
+	testQueue(ctx, slicePQ)
 
 	fmt.Println("\nRedis-based Priority Queue:")
 	var redisPQ priorityqueue.PriorityQueuer = priorityqueue.NewRedisPriorityQueue("localhost:6379", "", 0)
-	testQueue(redisPQ)
+	testQueue(ctx, redisPQ)
 }
 
-func testQueue(pq priorityqueue.PriorityQueuer) {
-	pq.AddQueue("tasks")
-	pq.Enqueue("tasks", "Process payment", 2)
-	pq.Enqueue("tasks", "Emergency shutdown", 0)
-	pq.Enqueue("tasks", "Update UI", 5)
-	pq.InsertAtTop("tasks", "Critical task", 0)
-	pq.InsertAtTop("tasks", "Urgent payment", 2)
+func testQueue(ctx context.Context, pq priorityqueue.PriorityQueuer) {
+	pq.AddQueue(ctx, "tasks")
+	pq.Enqueue(ctx, "tasks", "Process payment", 2)
+	pq.Enqueue(ctx, "tasks", "Emergency shutdown", 0)
+	pq.Enqueue(ctx, "tasks", "Update UI", 5)
+	pq.InsertAtTop(ctx, "tasks", "Critical task", 0)
+	pq.InsertAtTop(ctx, "tasks", "Urgent payment", 2)
 
 	fmt.Println("Initial contents:")
-	contents, _ := pq.ListContents("tasks")
+	contents, _ := pq.ListContents(ctx, "tasks")
 	for priority, items := range contents {
 		fmt.Printf("Priority %d: %v\n", priority, items)
 	}
 
-	pq.DeleteItem("tasks", "Process payment")
+	pq.DeleteItem(ctx, "tasks", "Process payment")
 
 	fmt.Println("\nAfter deleting 'Process payment':")
-	contents, _ = pq.ListContents("tasks")
+	contents, _ = pq.ListContents(ctx, "tasks")
 	for priority, items := range contents {
 		fmt.Printf("Priority %d: %v\n", priority, items)
 	}
 
 	fmt.Println("\nDequeuing items:")
 	for i := 0; i < 3; i++ {
-		item, _ := pq.Dequeue("tasks")
+		item, _ := pq.Dequeue(ctx, "tasks")
 		fmt.Printf("Dequeued: %v\n", item)
 	}
 }
@@ -1,14 +1,34 @@
 package main
 
 import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"hash/crc32"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"fsedano.net/pq/priorityqueue"
+	"fsedano.net/pq/priorityqueue/mocks"
+	"github.com/redis/go-redis/v9"
 )
 
 func TestPriorityQueue(t *testing.T) {
+	ctx := context.Background()
 	tests := []struct {
 		name string
 		pq   priorityqueue.PriorityQueuer
@@ -20,6 +40,9 @@ func TestPriorityQueue(t *testing.T) {
 	// List of queue names used in tests
 	queueNames := []string{
 		"addqueue_test",
+		"keyspace_test",
+		"keyspace_test:counters",
+		"keyspace_test:enqueued",
 		"enqueue_test",
 		"dequeue_test",
 		"isempty_test",
@@ -27,6 +50,107 @@ func TestPriorityQueue(t *testing.T) {
 		"getposition_test",
 		"insertattop_test",
 		"deleteitem_test",
+		"weights_test",
+		"insertattopbatch_test",
+		"movetoposition_test",
+		"swapitems_test",
+		"itemage_test",
+		"peekpriority_test",
+		"dequeuewhere_test",
+		"router_orders_test",
+		"router_eu_test",
+		"router_audit_test",
+		"fanout_a_test",
+		"fanout_b_test",
+		"broadcast_test.primary",
+		"broadcast_test.audit",
+		"import_csv_test",
+		"import_jsonl_test",
+		"counters_test",
+		"demotion_test",
+		"quota_test",
+		"iterate_test",
+		"fastlen_test",
+		"policy_test",
+		"tracing_test",
+		"tracing_dst_test",
+		"virtualclock_test",
+		"memoryusage_test",
+		"checksum_test",
+		"blob_test",
+		"validation_test",
+		"validation_other_test",
+		"intercept_test",
+		"intercept_plain_test",
+		"dequeuelevel_test",
+		"freeze_test",
+		"freeze_other_test",
+		"servicewindow_test",
+		"costbudget_test",
+		"escalate_test",
+		"escalate_dup_test",
+		"readonly_test",
+		"duplicates_test",
+		"sweep_live_test",
+		"sequence_test",
+		"legacy_score_test",
+		"compact_test",
+		"multi_a_test",
+		"multi_b_test",
+		"multi_c_test",
+		"spill_test",
+		"spill_overflow_test",
+		"fresh_test",
+		"stats_test",
+		"ctx_cancel_test",
+		"typed_test",
+		"webhook_test",
+		"webhook_rearm_test",
+		"webhook_retry_test",
+		"notify_test",
+		"notify_other_test",
+		"peek_test",
+		"pressure_test",
+		"size_test",
+		"removequeue_test",
+		"verify_test",
+		"listqueues_a_test",
+		"listqueues_b_test",
+		"purge_test",
+		"enqueuebatch_test",
+		"pipeline_test",
+		"dequeuebatch_test",
+		"updatepriority_test",
+		"movesrc_test",
+		"movedst_test",
+		"renameold_test",
+		"renametaken_test",
+		"renamenew_test",
+		"fifo_test",
+		"dupvalues_test",
+		"dupblobs_test",
+		"waitempty_test",
+		"itemids_test",
+		"codec_gob_test",
+		"codec_json_test",
+		"histogram_test",
+		"histogram_legacy_test",
+		"dequeueinto_test",
+		"legacy_member_test",
+		"costbudget_batch_test",
+		"costbudget_move_test",
+		"spill_paths_test",
+		"spill_paths_other_test",
+		"sweep_test",
+		"broadcast:groups:broadcast_test",
+		"broadcast:group:broadcast_test/primary",
+		"broadcast:group:broadcast_test/audit",
+		"broadcast:groups:broadcast_replay_test",
+		"broadcast:log:broadcast_replay_test",
+		"broadcast:group:broadcast_replay_test/live",
+		"broadcast:group:broadcast_replay_test/late",
+		"broadcast:group:broadcast_replay_test/taken",
+		"itemid_paths_test",
 		"bench_enqueue_test",
 		"bench_dequeue_test",
 	}
@@ -38,7 +162,7 @@ func TestPriorityQueue(t *testing.T) {
 			// Cleanup for RedisPQ before running subtests
 			if tt.name == "RedisPQ" {
 				if redisPQ, ok := pq.(*priorityqueue.RedisPriorityQueue); ok {
-					err := redisPQ.ClearQueues(queueNames...)
+					err := redisPQ.ClearQueues(ctx, queueNames...)
 					if err != nil {
 						t.Fatalf("Failed to clear Redis queues: %v", err)
 					}
@@ -52,55 +176,55 @@ func TestPriorityQueue(t *testing.T) {
 			})
 
 			t.Run("AddQueue", func(t *testing.T) {
-				err := pq.AddQueue("addqueue_test")
+				err := pq.AddQueue(ctx, "addqueue_test")
 				if err != nil {
 					t.Errorf("AddQueue failed: %v", err)
 				}
 			})
 
 			t.Run("Enqueue", func(t *testing.T) {
-				pq.AddQueue("enqueue_test")
-				err := pq.Enqueue("enqueue_test", "item1", 0)
+				pq.AddQueue(ctx, "enqueue_test")
+				err := pq.Enqueue(ctx, "enqueue_test", "item1", 0)
 				if err != nil {
 					t.Errorf("Enqueue failed: %v", err)
 				}
 
-				err = pq.Enqueue("enqueue_test", "item2", 10)
+				err = pq.Enqueue(ctx, "enqueue_test", "item2", 10)
 				if err == nil {
 					t.Error("Enqueue should fail with priority > 9")
 				}
 
-				err = pq.Enqueue("nonexistent", "item3", 0)
+				err = pq.Enqueue(ctx, "nonexistent", "item3", 0)
 				if err == nil && tt.name == "SlicePQ" {
 					t.Error("Enqueue should fail with non-existent queue for SlicePQ")
 				}
 			})
 
 			t.Run("Dequeue", func(t *testing.T) {
-				pq.AddQueue("dequeue_test")
-				_, err := pq.Dequeue("dequeue_test")
+				pq.AddQueue(ctx, "dequeue_test")
+				_, err := pq.Dequeue(ctx, "dequeue_test")
 				if err == nil {
 					t.Error("Dequeue should fail on empty queue")
 				}
 
-				pq.Enqueue("dequeue_test", "low", 5)
-				pq.Enqueue("dequeue_test", "high", 0)
-				pq.Enqueue("dequeue_test", "medium", 2)
+				pq.Enqueue(ctx, "dequeue_test", "low", 5)
+				pq.Enqueue(ctx, "dequeue_test", "high", 0)
+				pq.Enqueue(ctx, "dequeue_test", "medium", 2)
 
-				item, err := pq.Dequeue("dequeue_test")
+				item, err := pq.Dequeue(ctx, "dequeue_test")
 				if err != nil || item != "high" {
 					t.Errorf("Dequeue should return highest priority item first, got %v, err: %v", item, err)
 				}
 
-				item, err = pq.Dequeue("dequeue_test")
+				item, err = pq.Dequeue(ctx, "dequeue_test")
 				if err != nil || item != "medium" {
 					t.Errorf("Dequeue should return medium priority next, got %v, err: %v", item, err)
 				}
 			})
 
 			t.Run("IsEmpty", func(t *testing.T) {
-				pq.AddQueue("isempty_test")
-				empty, err := pq.IsEmpty("isempty_test")
+				pq.AddQueue(ctx, "isempty_test")
+				empty, err := pq.IsEmpty(ctx, "isempty_test")
 				if err != nil {
 					t.Errorf("IsEmpty failed: %v", err)
 				}
@@ -108,8 +232,8 @@ func TestPriorityQueue(t *testing.T) {
 					t.Errorf("New queue should be empty, got %v", empty)
 				}
 
-				pq.Enqueue("isempty_test", "item", 0)
-				empty, err = pq.IsEmpty("isempty_test")
+				pq.Enqueue(ctx, "isempty_test", "item", 0)
+				empty, err = pq.IsEmpty(ctx, "isempty_test")
 				if err != nil {
 					t.Errorf("IsEmpty failed: %v", err)
 				}
@@ -119,8 +243,8 @@ func TestPriorityQueue(t *testing.T) {
 			})
 
 			t.Run("ListContents", func(t *testing.T) {
-				pq.AddQueue("listcontents_test")
-				contents, err := pq.ListContents("listcontents_test")
+				pq.AddQueue(ctx, "listcontents_test")
+				contents, err := pq.ListContents(ctx, "listcontents_test")
 				if err != nil {
 					t.Errorf("ListContents failed: %v", err)
 				}
@@ -128,11 +252,11 @@ func TestPriorityQueue(t *testing.T) {
 					t.Errorf("Empty queue should return empty contents, got %v", contents)
 				}
 
-				pq.Enqueue("listcontents_test", "high", 0)
-				pq.Enqueue("listcontents_test", "medium1", 2)
-				pq.Enqueue("listcontents_test", "medium2", 2)
+				pq.Enqueue(ctx, "listcontents_test", "high", 0)
+				pq.Enqueue(ctx, "listcontents_test", "medium1", 2)
+				pq.Enqueue(ctx, "listcontents_test", "medium2", 2)
 
-				contents, err = pq.ListContents("listcontents_test")
+				contents, err = pq.ListContents(ctx, "listcontents_test")
 				if err != nil {
 					t.Errorf("ListContents failed: %v", err)
 				}
@@ -147,89 +271,89 @@ func TestPriorityQueue(t *testing.T) {
 			})
 
 			t.Run("GetPosition", func(t *testing.T) {
-				pq.AddQueue("getposition_test")
-				priority, pos, err := pq.GetPosition("getposition_test", "missing")
+				pq.AddQueue(ctx, "getposition_test")
+				priority, pos, err := pq.GetPosition(ctx, "getposition_test", "missing")
 				if err == nil || priority != -1 || pos != -1 {
 					t.Errorf("GetPosition should fail for non-existent item, got %d, %d, err: %v", priority, pos, err)
 				}
 
-				pq.Enqueue("getposition_test", "first", 0)
-				pq.Enqueue("getposition_test", "second", 0)
-				pq.Enqueue("getposition_test", "third", 2)
+				pq.Enqueue(ctx, "getposition_test", "first", 0)
+				pq.Enqueue(ctx, "getposition_test", "second", 0)
+				pq.Enqueue(ctx, "getposition_test", "third", 2)
 
-				priority, pos, err = pq.GetPosition("getposition_test", "first")
+				priority, pos, err = pq.GetPosition(ctx, "getposition_test", "first")
 				if err != nil || priority != 0 || pos != 0 {
 					t.Errorf("Wrong position for 'first': got %d, %d, err: %v", priority, pos, err)
 				}
 
-				priority, pos, err = pq.GetPosition("getposition_test", "second")
+				priority, pos, err = pq.GetPosition(ctx, "getposition_test", "second")
 				if err != nil || priority != 0 || pos != 1 {
 					t.Errorf("Wrong position for 'second': got %d, %d, err: %v", priority, pos, err)
 				}
 
-				priority, pos, err = pq.GetPosition("getposition_test", "third")
+				priority, pos, err = pq.GetPosition(ctx, "getposition_test", "third")
 				if err != nil || priority != 2 || pos != 0 {
 					t.Errorf("Wrong position for 'third': got %d, %d, err: %v", priority, pos, err)
 				}
 			})
 
 			t.Run("InsertAtTop", func(t *testing.T) {
-				pq.AddQueue("insertattop_test")
-				err := pq.InsertAtTop("insertattop_test", "item", 10)
+				pq.AddQueue(ctx, "insertattop_test")
+				err := pq.InsertAtTop(ctx, "insertattop_test", "item", 10)
 				if err == nil {
 					t.Error("InsertAtTop should fail with priority > 9")
 				}
 
-				err = pq.InsertAtTop("insertattop_test", "urgent", 0)
+				err = pq.InsertAtTop(ctx, "insertattop_test", "urgent", 0)
 				if err != nil {
 					t.Errorf("InsertAtTop failed: %v", err)
 				}
 
-				priority, pos, err := pq.GetPosition("insertattop_test", "urgent")
+				priority, pos, err := pq.GetPosition(ctx, "insertattop_test", "urgent")
 				if err != nil || priority != 0 || pos != 0 {
 					t.Errorf("InsertAtTop item should be at priority 0, position 0, got %d, %d, err: %v", priority, pos, err)
 				}
 
-				pq.Enqueue("insertattop_test", "normal", 0)
-				pq.Enqueue("insertattop_test", "medium", 2)
-				pq.InsertAtTop("insertattop_test", "topmost", 0)
-				pq.InsertAtTop("insertattop_test", "urgent_medium", 2)
+				pq.Enqueue(ctx, "insertattop_test", "normal", 0)
+				pq.Enqueue(ctx, "insertattop_test", "medium", 2)
+				pq.InsertAtTop(ctx, "insertattop_test", "topmost", 0)
+				pq.InsertAtTop(ctx, "insertattop_test", "urgent_medium", 2)
 
-				item, err := pq.Dequeue("insertattop_test")
+				item, err := pq.Dequeue(ctx, "insertattop_test")
 				if err != nil || item != "topmost" {
 					t.Errorf("InsertAtTop item at priority 0 should be dequeued first, got %v, err: %v", item, err)
 				}
 
-				item, err = pq.Dequeue("insertattop_test")
+				item, err = pq.Dequeue(ctx, "insertattop_test")
 				if err != nil || item != "urgent" {
 					t.Errorf("Second priority 0 item should be next, got %v, err: %v", item, err)
 				}
 
-				pq.Dequeue("insertattop_test") // Remove "normal"
-				item, err = pq.Dequeue("insertattop_test")
+				pq.Dequeue(ctx, "insertattop_test") // Remove "normal"
+				item, err = pq.Dequeue(ctx, "insertattop_test")
 				if err != nil || item != "urgent_medium" {
 					t.Errorf("InsertAtTop item at priority 2 should be first in its level, got %v, err: %v", item, err)
 				}
 			})
 
 			t.Run("DeleteItem", func(t *testing.T) {
-				pq.AddQueue("deleteitem_test")
+				pq.AddQueue(ctx, "deleteitem_test")
 
-				err := pq.DeleteItem("deleteitem_test", "missing")
+				err := pq.DeleteItem(ctx, "deleteitem_test", "missing")
 				if err == nil {
 					t.Error("DeleteItem should fail for non-existent item in empty queue")
 				}
 
-				pq.Enqueue("deleteitem_test", "item1", 0)
-				pq.Enqueue("deleteitem_test", "item2", 0)
-				pq.Enqueue("deleteitem_test", "item3", 2)
+				pq.Enqueue(ctx, "deleteitem_test", "item1", 0)
+				pq.Enqueue(ctx, "deleteitem_test", "item2", 0)
+				pq.Enqueue(ctx, "deleteitem_test", "item3", 2)
 
-				err = pq.DeleteItem("deleteitem_test", "item2")
+				err = pq.DeleteItem(ctx, "deleteitem_test", "item2")
 				if err != nil {
 					t.Errorf("DeleteItem failed: %v", err)
 				}
 
-				contents, err := pq.ListContents("deleteitem_test")
+				contents, err := pq.ListContents(ctx, "deleteitem_test")
 				if err != nil {
 					t.Errorf("ListContents failed after delete: %v", err)
 				}
@@ -241,21 +365,3077 @@ func TestPriorityQueue(t *testing.T) {
 					t.Errorf("DeleteItem didn't remove item correctly. Got %v, want %v", contents, expected)
 				}
 
-				err = pq.DeleteItem("deleteitem_test", "nonexistent")
+				err = pq.DeleteItem(ctx, "deleteitem_test", "nonexistent")
 				if err == nil {
 					t.Error("DeleteItem should fail for non-existent item")
 				}
 
-				err = pq.DeleteItem("nonexistent", "item1")
+				err = pq.DeleteItem(ctx, "nonexistent", "item1")
 				if err == nil && tt.name == "SlicePQ" {
 					t.Error("DeleteItem should fail for non-existent queue in SlicePQ")
 				}
 			})
+
+			t.Run("SetPriorityWeights", func(t *testing.T) {
+				pq.AddQueue(ctx, "weights_test")
+
+				err := pq.SetPriorityWeights(ctx, "weights_test", []float64{1, 2, 3})
+				if err == nil {
+					t.Error("SetPriorityWeights should fail with wrong number of weights")
+				}
+
+				err = pq.SetPriorityWeights(ctx, "weights_test", make([]float64, 10))
+				if err == nil {
+					t.Error("SetPriorityWeights should fail when all weights are zero")
+				}
+
+				// Only priority 5 carries weight, so it wins while it has items
+				weights := make([]float64, 10)
+				weights[5] = 1
+				err = pq.SetPriorityWeights(ctx, "weights_test", weights)
+				if err != nil {
+					t.Errorf("SetPriorityWeights failed: %v", err)
+				}
+
+				pq.Enqueue(ctx, "weights_test", "urgent", 0)
+				pq.Enqueue(ctx, "weights_test", "background", 5)
+
+				item, err := pq.Dequeue(ctx, "weights_test")
+				if err != nil || item != "background" {
+					t.Errorf("Weighted dequeue should pick the weighted level, got %v, err: %v", item, err)
+				}
+
+				item, err = pq.Dequeue(ctx, "weights_test")
+				if err != nil || item != "urgent" {
+					t.Errorf("Weighted dequeue should fall back to zero-weight levels, got %v, err: %v", item, err)
+				}
+
+				pq.Enqueue(ctx, "weights_test", "low", 5)
+				pq.Enqueue(ctx, "weights_test", "high", 0)
+				err = pq.SetPriorityWeights(ctx, "weights_test", nil)
+				if err != nil {
+					t.Errorf("SetPriorityWeights(nil) failed: %v", err)
+				}
+
+				item, err = pq.Dequeue(ctx, "weights_test")
+				if err != nil || item != "high" {
+					t.Errorf("Clearing weights should restore strict order, got %v, err: %v", item, err)
+				}
+			})
+
+			t.Run("InsertAtTopBatch", func(t *testing.T) {
+				pq.AddQueue(ctx, "insertattopbatch_test")
+				err := pq.InsertAtTopBatch(ctx, "insertattopbatch_test", []interface{}{"a"}, 10)
+				if err == nil {
+					t.Error("InsertAtTopBatch should fail with priority > 9")
+				}
+
+				pq.Enqueue(ctx, "insertattopbatch_test", "existing", 3)
+				pq.InsertAtTop(ctx, "insertattopbatch_test", "pushed", 3)
+				err = pq.InsertAtTopBatch(ctx, "insertattopbatch_test", []interface{}{"rollback", "drain", "alert"}, 3)
+				if err != nil {
+					t.Errorf("InsertAtTopBatch failed: %v", err)
+				}
+
+				contents, err := pq.ListContents(ctx, "insertattopbatch_test")
+				if err != nil {
+					t.Errorf("ListContents failed: %v", err)
+				}
+				expected := map[int][]interface{}{
+					3: {"rollback", "drain", "alert", "pushed", "existing"},
+				}
+				if !reflect.DeepEqual(contents, expected) {
+					t.Errorf("InsertAtTopBatch wrong order. Got %v, want %v", contents, expected)
+				}
+
+				item, err := pq.Dequeue(ctx, "insertattopbatch_test")
+				if err != nil || item != "rollback" {
+					t.Errorf("First batch item should be dequeued first, got %v, err: %v", item, err)
+				}
+			})
+
+			t.Run("MoveToPosition", func(t *testing.T) {
+				pq.AddQueue(ctx, "movetoposition_test")
+				err := pq.MoveToPosition(ctx, "movetoposition_test", "missing", 0, 0)
+				if err == nil {
+					t.Error("MoveToPosition should fail for non-existent item")
+				}
+
+				ids := make(map[string]string)
+				for _, e := range []struct {
+					value    string
+					priority int
+				}{{"a", 1}, {"b", 1}, {"c", 1}, {"d", 4}} {
+					ids[e.value], _ = pq.EnqueueWithID(ctx, "movetoposition_test", e.value, e.priority)
+				}
+
+				err = pq.MoveToPosition(ctx, "movetoposition_test", ids["c"], 1, 0)
+				if err != nil {
+					t.Errorf("MoveToPosition failed: %v", err)
+				}
+				err = pq.MoveToPosition(ctx, "movetoposition_test", ids["d"], 1, 1)
+				if err != nil {
+					t.Errorf("MoveToPosition across levels failed: %v", err)
+				}
+				err = pq.MoveToPosition(ctx, "movetoposition_test", ids["a"], 1, 99)
+				if err != nil {
+					t.Errorf("MoveToPosition past the end failed: %v", err)
+				}
+				if err := pq.MoveToPosition(ctx, "movetoposition_test", "c", 0, 0); err == nil {
+					t.Error("MoveToPosition should match ids, not values")
+				}
+
+				contents, err := pq.ListContents(ctx, "movetoposition_test")
+				if err != nil {
+					t.Errorf("ListContents failed: %v", err)
+				}
+				expected := map[int][]interface{}{
+					1: {"c", "d", "b", "a"},
+				}
+				if !reflect.DeepEqual(contents, expected) {
+					t.Errorf("MoveToPosition wrong order. Got %v, want %v", contents, expected)
+				}
+
+				pq.Enqueue(ctx, "movetoposition_test", "e", 1)
+				priority, pos, err := pq.GetPosition(ctx, "movetoposition_test", "e")
+				if err != nil || priority != 1 || pos != 4 {
+					t.Errorf("Enqueue after a move should land last, got %d, %d, err: %v", priority, pos, err)
+				}
+
+				// The second of two equal values moves, not the first
+				pq.Enqueue(ctx, "movetoposition_test", "dup", 2)
+				second, _ := pq.EnqueueWithID(ctx, "movetoposition_test", "dup", 5)
+				if err := pq.MoveToPosition(ctx, "movetoposition_test", second, 0, 0); err != nil {
+					t.Errorf("MoveToPosition of a duplicate failed: %v", err)
+				}
+				counts, _ := pq.CountByPriority(ctx, "movetoposition_test")
+				if counts[0] != 1 || counts[2] != 1 || counts[5] != 0 {
+					t.Errorf("Expected the duplicate at 5 to move, got counts %v", counts)
+				}
+			})
+
+			t.Run("SwapItems", func(t *testing.T) {
+				pq.AddQueue(ctx, "swapitems_test")
+				ids := make(map[string]string)
+				for _, e := range []struct {
+					value    string
+					priority int
+				}{{"a", 0}, {"b", 0}, {"c", 0}, {"d", 7}} {
+					ids[e.value], _ = pq.EnqueueWithID(ctx, "swapitems_test", e.value, e.priority)
+				}
+
+				err := pq.SwapItems(ctx, "swapitems_test", ids["a"], "missing")
+				if err == nil {
+					t.Error("SwapItems should fail for non-existent item")
+				}
+
+				err = pq.SwapItems(ctx, "swapitems_test", ids["a"], ids["c"])
+				if err != nil {
+					t.Errorf("SwapItems within a level failed: %v", err)
+				}
+				err = pq.SwapItems(ctx, "swapitems_test", ids["b"], ids["d"])
+				if err != nil {
+					t.Errorf("SwapItems across levels failed: %v", err)
+				}
+
+				contents, err := pq.ListContents(ctx, "swapitems_test")
+				if err != nil {
+					t.Errorf("ListContents failed: %v", err)
+				}
+				expected := map[int][]interface{}{
+					0: {"c", "d", "a"},
+					7: {"b"},
+				}
+				if !reflect.DeepEqual(contents, expected) {
+					t.Errorf("SwapItems wrong result. Got %v, want %v", contents, expected)
+				}
+			})
+
+			t.Run("OldestNewestItem", func(t *testing.T) {
+				pq.AddQueue(ctx, "itemage_test")
+				_, _, err := pq.OldestItem(ctx, "itemage_test")
+				if err == nil {
+					t.Error("OldestItem should fail on empty queue")
+				}
+
+				pq.Enqueue(ctx, "itemage_test", "first", 5)
+				time.Sleep(5 * time.Millisecond)
+				pq.Enqueue(ctx, "itemage_test", "second", 0)
+
+				item, age, err := pq.OldestItem(ctx, "itemage_test")
+				if err != nil || item != "first" || age < 5*time.Millisecond {
+					t.Errorf("OldestItem should return 'first', got %v, age %v, err: %v", item, age, err)
+				}
+
+				item, age, err = pq.NewestItem(ctx, "itemage_test")
+				if err != nil || item != "second" || age >= 5*time.Millisecond {
+					t.Errorf("NewestItem should return 'second', got %v, age %v, err: %v", item, age, err)
+				}
+
+				pq.Dequeue(ctx, "itemage_test")
+				item, _, err = pq.OldestItem(ctx, "itemage_test")
+				if err != nil || item != "first" {
+					t.Errorf("OldestItem should skip dequeued items, got %v, err: %v", item, err)
+				}
+			})
+
+			t.Run("PeekAndDequeueFromPriority", func(t *testing.T) {
+				pq.AddQueue(ctx, "peekpriority_test")
+				_, err := pq.PeekPriority(ctx, "peekpriority_test", 10)
+				if err == nil {
+					t.Error("PeekPriority should fail with priority > 9")
+				}
+				_, err = pq.DequeueFromPriority(ctx, "peekpriority_test", 3)
+				if err == nil {
+					t.Error("DequeueFromPriority should fail on empty level")
+				}
+
+				pq.Enqueue(ctx, "peekpriority_test", "p0", 0)
+				pq.Enqueue(ctx, "peekpriority_test", "p3_first", 3)
+				pq.Enqueue(ctx, "peekpriority_test", "p3_second", 3)
+
+				item, err := pq.PeekPriority(ctx, "peekpriority_test", 3)
+				if err != nil || item != "p3_first" {
+					t.Errorf("PeekPriority should return head of level 3, got %v, err: %v", item, err)
+				}
+
+				item, err = pq.DequeueFromPriority(ctx, "peekpriority_test", 3)
+				if err != nil || item != "p3_first" {
+					t.Errorf("DequeueFromPriority should return head of level 3, got %v, err: %v", item, err)
+				}
+
+				contents, err := pq.ListContents(ctx, "peekpriority_test")
+				if err != nil {
+					t.Errorf("ListContents failed: %v", err)
+				}
+				expected := map[int][]interface{}{
+					0: {"p0"},
+					3: {"p3_second"},
+				}
+				if !reflect.DeepEqual(contents, expected) {
+					t.Errorf("DequeueFromPriority touched other levels. Got %v, want %v", contents, expected)
+				}
+			})
+
+			t.Run("DequeueWhere", func(t *testing.T) {
+				pq.AddQueue(ctx, "dequeuewhere_test")
+				isEU := func(item priorityqueue.Item) bool {
+					return strings.HasPrefix(fmt.Sprintf("%v", item.Value), "eu-")
+				}
+
+				_, err := pq.DequeueWhere(ctx, "dequeuewhere_test", isEU)
+				if err == nil {
+					t.Error("DequeueWhere should fail on empty queue")
+				}
+
+				pq.Enqueue(ctx, "dequeuewhere_test", "us-1", 0)
+				pq.Enqueue(ctx, "dequeuewhere_test", "eu-1", 4)
+				pq.Enqueue(ctx, "dequeuewhere_test", "eu-2", 2)
+
+				item, err := pq.DequeueWhere(ctx, "dequeuewhere_test", isEU)
+				if err != nil || item != "eu-2" {
+					t.Errorf("DequeueWhere should return highest-priority match, got %v, err: %v", item, err)
+				}
+
+				item, err = pq.DequeueWhere(ctx, "dequeuewhere_test", func(item priorityqueue.Item) bool {
+					return item.Priority > 3
+				})
+				if err != nil || item != "eu-1" {
+					t.Errorf("DequeueWhere should see item priority, got %v, err: %v", item, err)
+				}
+
+				_, err = pq.DequeueWhere(ctx, "dequeuewhere_test", isEU)
+				if err == nil {
+					t.Error("DequeueWhere should fail when nothing matches")
+				}
+
+				item, err = pq.Dequeue(ctx, "dequeuewhere_test")
+				if err != nil || item != "us-1" {
+					t.Errorf("Non-matching item should remain queued, got %v, err: %v", item, err)
+				}
+			})
+
+			t.Run("Router", func(t *testing.T) {
+				pq.AddQueue(ctx, "router_orders_test")
+				pq.AddQueue(ctx, "router_eu_test")
+				pq.AddQueue(ctx, "router_audit_test")
+
+				router := priorityqueue.NewRouter(pq)
+				router.Bind("router_orders_test", "orders.*")
+				router.Bind("router_eu_test", "*.eu")
+				router.Bind("router_audit_test", "#")
+				router.Bind("router_audit_test", "orders.#")
+
+				if err := router.Bind("router_eu_test", "*.eu"); err == nil {
+					t.Error("Bind should fail for a duplicate binding")
+				}
+
+				queues, err := router.Publish(ctx, "orders.eu", "order-1", 1)
+				expected := []string{"router_audit_test", "router_eu_test", "router_orders_test"}
+				if err != nil || !reflect.DeepEqual(queues, expected) {
+					t.Errorf("Publish should reach every bound queue once, got %v, err: %v", queues, err)
+				}
+
+				queues, err = router.Publish(ctx, "payments.us", "payment-1", 1)
+				expected = []string{"router_audit_test"}
+				if err != nil || !reflect.DeepEqual(queues, expected) {
+					t.Errorf("Publish should only reach matching queues, got %v, err: %v", queues, err)
+				}
+
+				router.Unbind("router_audit_test", "#")
+				router.Unbind("router_audit_test", "orders.#")
+				queues, err = router.Publish(ctx, "payments.us", "payment-2", 1)
+				if err != nil || len(queues) != 0 {
+					t.Errorf("Publish after Unbind should reach no queues, got %v, err: %v", queues, err)
+				}
+
+				for _, name := range []string{"router_orders_test", "router_eu_test"} {
+					item, err := pq.Dequeue(ctx, name)
+					if err != nil || item != "order-1" {
+						t.Errorf("Queue %s should hold the published item, got %v, err: %v", name, item, err)
+					}
+				}
+			})
+
+			t.Run("EnqueueFanout", func(t *testing.T) {
+				pq.AddQueue(ctx, "fanout_a_test")
+				pq.AddQueue(ctx, "fanout_b_test")
+
+				err := pq.EnqueueFanout(ctx, []string{"fanout_a_test", "fanout_b_test"}, "event", 10)
+				if err == nil {
+					t.Error("EnqueueFanout should fail with priority > 9")
+				}
+
+				if tt.name == "SlicePQ" {
+					err = pq.EnqueueFanout(ctx, []string{"fanout_a_test", "nonexistent"}, "partial", 0)
+					if err == nil {
+						t.Error("EnqueueFanout should fail with non-existent queue for SlicePQ")
+					}
+					empty, _ := pq.IsEmpty(ctx, "fanout_a_test")
+					if !empty {
+						t.Error("Failed EnqueueFanout should not enqueue into any queue")
+					}
+				}
+
+				err = pq.EnqueueFanout(ctx, []string{"fanout_a_test", "fanout_b_test"}, "event", 1)
+				if err != nil {
+					t.Errorf("EnqueueFanout failed: %v", err)
+				}
+				for _, name := range []string{"fanout_a_test", "fanout_b_test"} {
+					item, err := pq.Dequeue(ctx, name)
+					if err != nil || item != "event" {
+						t.Errorf("Queue %s should hold the fanned-out item, got %v, err: %v", name, item, err)
+					}
+				}
+
+				// A repeated name gets one copy, and every copy its own id
+				before, _ := pq.Counters(ctx, "fanout_a_test")
+				err = pq.EnqueueFanout(ctx, []string{"fanout_a_test", "fanout_b_test", "fanout_a_test"}, "again", 1)
+				if err != nil {
+					t.Errorf("EnqueueFanout with a repeated queue failed: %v", err)
+				}
+				if size, _ := pq.Size(ctx, "fanout_a_test"); size != 1 {
+					t.Errorf("Repeated queue should get one copy, got %d", size)
+				}
+				if after, _ := pq.Counters(ctx, "fanout_a_test"); after.Enqueued != before.Enqueued+1 {
+					t.Errorf("Repeated queue should count one enqueue, got %d after %d", after.Enqueued, before.Enqueued)
+				}
+				ids := make(map[string]bool)
+				for _, name := range []string{"fanout_a_test", "fanout_b_test"} {
+					pq.IterateItems(ctx, name, func(item priorityqueue.Item) bool {
+						ids[item.ID] = true
+						return true
+					})
+				}
+				if len(ids) != 2 || ids[""] {
+					t.Errorf("Each copy should have its own id, got %v", ids)
+				}
+			})
+
+			t.Run("Broadcaster", func(t *testing.T) {
+				b := priorityqueue.NewBroadcaster(pq)
+				err := b.Publish(ctx, "broadcast_test", "orphan", 0)
+				if err == nil {
+					t.Error("Publish should fail without consumer groups")
+				}
+
+				if err := b.AddGroup(ctx, "broadcast_test", "primary"); err != nil {
+					t.Errorf("AddGroup failed: %v", err)
+				}
+				if err := b.AddGroup(ctx, "broadcast_test", "audit"); err != nil {
+					t.Errorf("AddGroup failed: %v", err)
+				}
+				if err := b.AddGroup(ctx, "broadcast_test", "audit"); err == nil {
+					t.Error("AddGroup should fail for a duplicate group")
+				}
+
+				b.Publish(ctx, "broadcast_test", "low", 5)
+				b.Publish(ctx, "broadcast_test", "high", 0)
+
+				for _, group := range []string{"primary", "audit"} {
+					item, err := b.Dequeue(ctx, "broadcast_test", group)
+					if err != nil || item != "high" {
+						t.Errorf("Group %s should see 'high' first, got %v, err: %v", group, item, err)
+					}
+					item, err = b.Dequeue(ctx, "broadcast_test", group)
+					if err != nil || item != "low" {
+						t.Errorf("Group %s should see 'low' second, got %v, err: %v", group, item, err)
+					}
+				}
+
+				_, err = b.Dequeue(ctx, "broadcast_test", "unknown")
+				if err == nil {
+					t.Error("Dequeue should fail for an unknown group")
+				}
+			})
+
+			t.Run("BroadcasterReplay", func(t *testing.T) {
+				b := priorityqueue.NewBroadcaster(pq)
+				if err := b.SetRetention(ctx, "broadcast_replay_test", 2); err != nil {
+					t.Fatalf("SetRetention failed: %v", err)
+				}
+				for _, item := range []struct {
+					value    string
+					priority int
+				}{{"a", 1}, {"b", 1}, {"c", 1}, {"urgent", 0}} {
+					if err := b.Publish(ctx, "broadcast_replay_test", item.value, item.priority); err != nil {
+						t.Fatalf("Publish to a retained queue without groups failed: %v", err)
+					}
+				}
+
+				if err := b.AddGroup(ctx, "broadcast_replay_test", "live"); err != nil {
+					t.Fatalf("AddGroup failed: %v", err)
+				}
+				if _, err := b.Dequeue(ctx, "broadcast_replay_test", "live"); !errors.Is(err, priorityqueue.ErrQueueEmpty) {
+					t.Errorf("AddGroup shouldn't replay the log, got %v", err)
+				}
+				if err := b.AddGroupWithReplay(ctx, "broadcast_replay_test", "late"); err != nil {
+					t.Fatalf("AddGroupWithReplay failed: %v", err)
+				}
+				for _, want := range []string{"urgent", "b", "c"} {
+					if item, err := b.Dequeue(ctx, "broadcast_replay_test", "late"); err != nil || item != want {
+						t.Errorf("Replay should deliver %q, got %v, err: %v", want, item, err)
+					}
+				}
+
+				// Groups live on the backend, so another Broadcaster sees them
+				other := priorityqueue.NewBroadcaster(pq)
+				if groups, err := other.Groups(ctx, "broadcast_replay_test"); err != nil || !reflect.DeepEqual(groups, []string{"late", "live"}) {
+					t.Errorf("Groups should be persisted, got %v, err: %v", groups, err)
+				}
+				if err := other.Publish(ctx, "broadcast_replay_test", "d", 2); err != nil {
+					t.Fatalf("Publish failed: %v", err)
+				}
+				for _, group := range []string{"live", "late"} {
+					if item, err := b.Dequeue(ctx, "broadcast_replay_test", group); err != nil || item != "d" {
+						t.Errorf("Group %s should see 'd', got %v, err: %v", group, item, err)
+					}
+				}
+
+				if err := b.AddGroup(ctx, "broadcast_replay_test", "a/b"); err == nil {
+					t.Error("AddGroup should reject '/' in group names")
+				}
+				if err := b.Publish(ctx, "broadcast:log:broadcast_replay_test", "x", 0); err == nil {
+					t.Error("Publish should reject reserved queue names")
+				}
+				pq.AddQueue(ctx, "broadcast:group:broadcast_replay_test/taken")
+				if err := b.AddGroup(ctx, "broadcast_replay_test", "taken"); err == nil {
+					t.Error("AddGroup should reject a private queue name already in use")
+				}
+				if err := b.SetRetention(ctx, "broadcast_replay_test", 0); err != nil {
+					t.Errorf("SetRetention(0) failed: %v", err)
+				}
+			})
+
+			t.Run("ImportFile", func(t *testing.T) {
+				dir := t.TempDir()
+
+				pq.AddQueue(ctx, "import_csv_test")
+				csvPath := filepath.Join(dir, "items.csv")
+				os.WriteFile(csvPath, []byte("value,priority\nlow,7\nhigh,1\n"), 0o644)
+				n, err := priorityqueue.ImportFile(ctx, pq, "import_csv_test", csvPath, priorityqueue.FormatCSV)
+				if err != nil || n != 2 {
+					t.Errorf("ImportFile CSV should import 2 records, got %d, err: %v", n, err)
+				}
+				contents, _ := pq.ListContents(ctx, "import_csv_test")
+				expected := map[int][]interface{}{
+					1: {"high"},
+					7: {"low"},
+				}
+				if !reflect.DeepEqual(contents, expected) {
+					t.Errorf("ImportFile CSV wrong contents. Got %v, want %v", contents, expected)
+				}
+
+				pq.AddQueue(ctx, "import_jsonl_test")
+				jsonlPath := filepath.Join(dir, "items.jsonl")
+				os.WriteFile(jsonlPath, []byte(`{"value": "a", "priority": 2}
+{"value": "b", "priority": 2}
+{"value": "c"}
+{"value": "d", "priority": 2}
+`), 0o644)
+				n, err = priorityqueue.ImportFile(ctx, pq, "import_jsonl_test", jsonlPath, priorityqueue.FormatJSONL)
+				if err == nil || n != 2 {
+					t.Errorf("ImportFile should stop at the bad record, got %d, err: %v", n, err)
+				}
+
+				// Fix the bad record and resume where the failed run stopped
+				os.WriteFile(jsonlPath, []byte(`{"value": "a", "priority": 2}
+{"value": "b", "priority": 2}
+{"value": "c", "priority": 2}
+{"value": "d", "priority": 2}
+`), 0o644)
+				var progress []int
+				imp := priorityqueue.Importer{
+					BatchSize: 1,
+					Skip:      n,
+					Progress:  func(imported int) { progress = append(progress, imported) },
+				}
+				n, err = imp.ImportFile(ctx, pq, "import_jsonl_test", jsonlPath, priorityqueue.FormatJSONL)
+				if err != nil || n != 4 {
+					t.Errorf("Resumed ImportFile should consume 4 records, got %d, err: %v", n, err)
+				}
+				if !reflect.DeepEqual(progress, []int{1, 2, 3, 4}) {
+					t.Errorf("ImportFile wrong progress reports: %v", progress)
+				}
+				contents, _ = pq.ListContents(ctx, "import_jsonl_test")
+				expected = map[int][]interface{}{
+					2: {"a", "b", "c", "d"},
+				}
+				if !reflect.DeepEqual(contents, expected) {
+					t.Errorf("Resumed ImportFile wrong contents. Got %v, want %v", contents, expected)
+				}
+			})
+
+			t.Run("Counters", func(t *testing.T) {
+				pq.AddQueue(ctx, "counters_test")
+				counters, err := pq.Counters(ctx, "counters_test")
+				if err != nil || counters != (priorityqueue.QueueCounters{}) {
+					t.Errorf("New queue should have zero counters, got %+v, err: %v", counters, err)
+				}
+
+				pq.Enqueue(ctx, "counters_test", "a", 0)
+				pq.InsertAtTopBatch(ctx, "counters_test", []interface{}{"b", "c"}, 1)
+				pq.Dequeue(ctx, "counters_test")
+				pq.DequeueFromPriority(ctx, "counters_test", 1)
+				pq.DeleteItem(ctx, "counters_test", "c")
+
+				counters, err = pq.Counters(ctx, "counters_test")
+				expected := priorityqueue.QueueCounters{Enqueued: 3, Dequeued: 2}
+				if err != nil || counters != expected {
+					t.Errorf("Counters wrong result. Got %+v, want %+v, err: %v", counters, expected, err)
+				}
+			})
+
+			t.Run("ProducerGateDemotion", func(t *testing.T) {
+				pq.AddQueue(ctx, "demotion_test")
+				gate := priorityqueue.NewProducerGate(pq)
+				gate.SetDemotionRule("demotion_test", priorityqueue.DemotionRule{Rate: 0.001, Burst: 2, Demote: 3})
+
+				for i, want := range []int{1, 1, 4} {
+					got, err := gate.Enqueue(ctx, "noisy", "demotion_test", fmt.Sprintf("noisy%d", i), 1)
+					if err != nil || got != want {
+						t.Errorf("Enqueue %d from noisy producer should land at %d, got %d, err: %v", i, want, got, err)
+					}
+				}
+
+				got, err := gate.Enqueue(ctx, "quiet", "demotion_test", "quiet0", 1)
+				if err != nil || got != 1 {
+					t.Errorf("Other producers should not be demoted, got %d, err: %v", got, err)
+				}
+
+				priority, _, err := pq.GetPosition(ctx, "demotion_test", "noisy2")
+				if err != nil || priority != 4 {
+					t.Errorf("Demoted item should be stored at priority 4, got %d, err: %v", priority, err)
+				}
+
+				// Failed enqueues don't use up the producer's burst
+				pq.FreezeQueue(ctx, "demotion_test")
+				for i := 0; i < 3; i++ {
+					if _, err := gate.Enqueue(ctx, "retrying", "demotion_test", "retrying0", 1); err == nil {
+						t.Error("Enqueue into a frozen queue should fail")
+					}
+				}
+				pq.UnfreezeQueue(ctx, "demotion_test")
+				got, err = gate.Enqueue(ctx, "retrying", "demotion_test", "retrying0", 1)
+				if err != nil || got != 1 {
+					t.Errorf("Failed enqueues should refund their tokens, got %d, err: %v", got, err)
+				}
+			})
+
+			t.Run("ProducerGateQuota", func(t *testing.T) {
+				pq.AddQueue(ctx, "quota_test")
+				gate := priorityqueue.NewProducerGate(pq)
+				err := gate.SetQuota("quota_test", priorityqueue.Quota{Limit: 2})
+				if err == nil {
+					t.Error("SetQuota should fail without a window")
+				}
+				gate.SetQuota("quota_test", priorityqueue.Quota{Limit: 2, Window: time.Hour})
+
+				for i := 0; i < 2; i++ {
+					if _, err := gate.Enqueue(ctx, "flood", "quota_test", fmt.Sprintf("flood%d", i), 0); err != nil {
+						t.Errorf("Enqueue within quota failed: %v", err)
+					}
+				}
+				_, err = gate.Enqueue(ctx, "flood", "quota_test", "flood2", 0)
+				if err != priorityqueue.ErrQuotaExceeded {
+					t.Errorf("Enqueue over quota should return ErrQuotaExceeded, got %v", err)
+				}
+				if _, err := gate.Enqueue(ctx, "polite", "quota_test", "polite0", 0); err != nil {
+					t.Errorf("Quota should be tracked per producer, got %v", err)
+				}
+
+				rejections := gate.Rejections("quota_test")
+				if !reflect.DeepEqual(rejections, map[string]int64{"flood": 1}) {
+					t.Errorf("Rejections wrong result: %v", rejections)
+				}
+
+				_, _, err = pq.GetPosition(ctx, "quota_test", "flood2")
+				if err == nil {
+					t.Error("Rejected item should not be enqueued")
+				}
+
+				// Failed enqueues don't count against the quota
+				pq.FreezeQueue(ctx, "quota_test")
+				for i := 0; i < 2; i++ {
+					if _, err := gate.Enqueue(ctx, "polite", "quota_test", "polite1", 0); err == nil || err == priorityqueue.ErrQuotaExceeded {
+						t.Errorf("Enqueue into a frozen queue should fail with the backend's error, got %v", err)
+					}
+				}
+				pq.UnfreezeQueue(ctx, "quota_test")
+				if _, err := gate.Enqueue(ctx, "polite", "quota_test", "polite1", 0); err != nil {
+					t.Errorf("Failed enqueues should refund their quota, got %v", err)
+				}
+			})
+
+			t.Run("IterateContents", func(t *testing.T) {
+				pq.AddQueue(ctx, "iterate_test")
+				// Enough items to span several Redis pages
+				for i := 0; i < 250; i++ {
+					pq.Enqueue(ctx, "iterate_test", fmt.Sprintf("item%03d", i), i%3)
+				}
+
+				seen := 0
+				last := -1
+				err := pq.IterateContents(ctx, "iterate_test", func(priority int, value interface{}) bool {
+					if priority < last {
+						t.Errorf("IterateContents went backwards from priority %d to %d", last, priority)
+					}
+					last = priority
+					seen++
+					return true
+				})
+				if err != nil || seen != 250 {
+					t.Errorf("IterateContents should visit 250 items, got %d, err: %v", seen, err)
+				}
+
+				var first []interface{}
+				err = pq.IterateContents(ctx, "iterate_test", func(priority int, value interface{}) bool {
+					first = append(first, value)
+					return len(first) < 2
+				})
+				if err != nil || !reflect.DeepEqual(first, []interface{}{"item000", "item003"}) {
+					t.Errorf("IterateContents should stop early, got %v, err: %v", first, err)
+				}
+
+				items := 0
+				err = pq.IterateItems(ctx, "iterate_test", func(item priorityqueue.Item) bool {
+					if got, err := pq.GetByID(ctx, "iterate_test", item.ID); err != nil || got.Value != item.Value {
+						t.Errorf("IterateItems id %q should address %v, got %v, err: %v", item.ID, item.Value, got.Value, err)
+					}
+					items++
+					return items < 5
+				})
+				if err != nil || items != 5 {
+					t.Errorf("IterateItems should stop early, got %d, err: %v", items, err)
+				}
+
+				priority, pos, err := pq.GetPosition(ctx, "iterate_test", "item248")
+				if err != nil || priority != 2 || pos != 82 {
+					t.Errorf("Wrong position for 'item248': got %d, %d, err: %v", priority, pos, err)
+				}
+			})
+
+			t.Run("FastLen", func(t *testing.T) {
+				pq.AddQueue(ctx, "fastlen_test")
+				pq.Enqueue(ctx, "fastlen_test", "old", 4)
+				time.Sleep(5 * time.Millisecond)
+				pq.Enqueue(ctx, "fastlen_test", "new", 0)
+
+				depth, err := pq.FastLen(ctx, "fastlen_test")
+				if err != nil || depth.Len != 2 || depth.ApproxOldestAge < 5*time.Millisecond {
+					t.Errorf("FastLen wrong result: %+v, err: %v", depth, err)
+				}
+
+				// A second call within the cache TTL reuses the first result
+				pq.Enqueue(ctx, "fastlen_test", "newer", 0)
+				depth, err = pq.FastLen(ctx, "fastlen_test")
+				if err != nil || depth.Len != 2 {
+					t.Errorf("FastLen should serve cached depth, got %+v, err: %v", depth, err)
+				}
+			})
+
+			t.Run("SchedulingPolicy", func(t *testing.T) {
+				mpq, ok := pq.(*priorityqueue.MultiPriorityQueue)
+				if !ok {
+					t.Skip("custom scheduling policies are only supported by the memory backend")
+				}
+				mpq.AddQueue(ctx, "policy_test")
+
+				err := mpq.SetSchedulingPolicy("policy_test", priorityqueue.WeightedPolicy{Weights: []float64{1}})
+				if err == nil {
+					t.Error("SetSchedulingPolicy should validate weighted policies")
+				}
+
+				mpq.Enqueue(ctx, "policy_test", "stale", 9)
+				time.Sleep(20 * time.Millisecond)
+				mpq.Enqueue(ctx, "policy_test", "fresh", 0)
+
+				// After 20ms at one level per millisecond, the stale item outranks priority 0
+				mpq.SetSchedulingPolicy("policy_test", priorityqueue.AgedPolicy{Interval: time.Millisecond})
+				item, err := mpq.Dequeue(ctx, "policy_test")
+				if err != nil || item != "stale" {
+					t.Errorf("AgedPolicy should promote the waiting item, got %v, err: %v", item, err)
+				}
+
+				mpq.Enqueue(ctx, "policy_test", "a", 5)
+				mpq.Enqueue(ctx, "policy_test", "b", 5)
+				mpq.SetSchedulingPolicy("policy_test", lastItemPolicy{})
+				item, err = mpq.Dequeue(ctx, "policy_test")
+				if err != nil || item != "b" {
+					t.Errorf("Custom policy should choose the item, got %v, err: %v", item, err)
+				}
+
+				mpq.SetSchedulingPolicy("policy_test", nil)
+				item, err = mpq.Dequeue(ctx, "policy_test")
+				if err != nil || item != "fresh" {
+					t.Errorf("Clearing the policy should restore strict order, got %v, err: %v", item, err)
+				}
+			})
+
+			t.Run("TracingQueue", func(t *testing.T) {
+				tq := priorityqueue.NewTracingQueue(pq, 3)
+				tq.AddQueue(ctx, "tracing_test")
+				tq.Enqueue(ctx, "tracing_test", "a", 1)
+				tq.Enqueue(ctx, "tracing_test", "b", 2)
+				tq.Enqueue(ctx, "tracing_test", "c", 10)
+				var headID string
+				tq.IterateItems(ctx, "tracing_test", func(item priorityqueue.Item) bool {
+					headID = item.ID
+					return false
+				})
+				tq.Dequeue(ctx, "tracing_test")
+
+				ops := tq.Operations("tracing_test")
+				if len(ops) != 3 {
+					t.Fatalf("Operations should keep the last 3 records, got %d", len(ops))
+				}
+				if ops[0].Op != "Enqueue" || ops[0].Item != "b" || ops[0].Priority != 2 {
+					t.Errorf("Oldest retained record wrong: %+v", ops[0])
+				}
+				if ops[1].Item != "c" || ops[1].Err == nil {
+					t.Errorf("Failed enqueue should record its error: %+v", ops[1])
+				}
+				if ops[2].Op != "Dequeue" || headID == "" || ops[2].Item != headID || ops[2].Err != nil {
+					t.Errorf("Dequeue should record the id %q of the removed item: %+v", headID, ops[2])
+				}
+
+				// Operations by id record the id, so they can be correlated
+				id, _ := tq.EnqueueWithID(ctx, "tracing_test", "d", 3)
+				tq.UpdateByID(ctx, "tracing_test", id, "e")
+				tq.DeleteByID(ctx, "tracing_test", id)
+				for _, op := range tq.Operations("tracing_test") {
+					if op.Item != id {
+						t.Errorf("%s should record item id %q: %+v", op.Op, id, op)
+					}
+				}
+
+				if ops := tq.Operations("untouched"); len(ops) != 0 {
+					t.Errorf("Untouched queue should have no records, got %v", ops)
+				}
+
+				// Spans are timed with the wrapper's clock, and moves are
+				// recorded on both queues
+				clock := priorityqueue.NewVirtualClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+				tq.SetClock(clock)
+				tq.AddQueue(ctx, "tracing_dst_test")
+				tq.Enqueue(ctx, "tracing_test", "m", 1)
+				tq.MoveItem(ctx, "tracing_test", "tracing_dst_test", "m")
+				ops = tq.Operations("tracing_dst_test")
+				if len(ops) != 1 || ops[0].Op != "MoveItem" || ops[0].Target != "tracing_dst_test" || ops[0].Err != nil {
+					t.Errorf("MoveItem should be recorded on the destination queue: %+v", ops)
+				} else if !ops[0].At.Equal(clock.Now()) || ops[0].Latency != 0 {
+					t.Errorf("Records should be timed with the wrapper's clock: %+v", ops[0])
+				}
+				ops = tq.Operations("tracing_test")
+				if last := ops[len(ops)-1]; last.Op != "MoveItem" || last.Target != "tracing_dst_test" {
+					t.Errorf("MoveItem should be recorded on the source queue: %+v", last)
+				}
+
+				tq.SetPriorityWeights(ctx, "tracing_dst_test", []float64{1, 2})
+				tq.SetPriorityWeights(ctx, "tracing_dst_test", nil)
+				tq.FreezeQueue(ctx, "tracing_dst_test")
+				tq.UnfreezeQueue(ctx, "tracing_dst_test")
+				tq.Purge(ctx, "tracing_dst_test")
+				tq.RemoveQueue(ctx, "tracing_dst_test")
+				var names []string
+				for _, op := range tq.Operations("tracing_dst_test") {
+					names = append(names, op.Op)
+				}
+				if got := strings.Join(names, ","); got != "UnfreezeQueue,Purge,RemoveQueue" {
+					t.Errorf("Queue management calls should be traced, got %s", got)
+				}
+			})
+
+			t.Run("VirtualClock", func(t *testing.T) {
+				clocked, ok := pq.(interface{ SetClock(priorityqueue.Clock) })
+				if !ok {
+					t.Fatal("backend should accept a Clock")
+				}
+				clock := priorityqueue.NewVirtualClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+				clocked.SetClock(clock)
+				defer clocked.SetClock(priorityqueue.SystemClock{})
+
+				pq.AddQueue(ctx, "virtualclock_test")
+				pq.Enqueue(ctx, "virtualclock_test", "a", 3)
+				clock.Advance(time.Hour)
+				pq.Enqueue(ctx, "virtualclock_test", "b", 0)
+
+				item, age, err := pq.OldestItem(ctx, "virtualclock_test")
+				if err != nil || item != "a" || age != time.Hour {
+					t.Errorf("OldestItem should be exactly one hour old, got %v, age %v, err: %v", item, age, err)
+				}
+				item, age, err = pq.NewestItem(ctx, "virtualclock_test")
+				if err != nil || item != "b" || age != 0 {
+					t.Errorf("NewestItem should have zero age, got %v, age %v, err: %v", item, age, err)
+				}
+
+				clock.Advance(30 * time.Minute)
+				depth, err := pq.FastLen(ctx, "virtualclock_test")
+				if err != nil || depth.Len != 2 || depth.ApproxOldestAge != 90*time.Minute {
+					t.Errorf("FastLen wrong result under virtual time: %+v, err: %v", depth, err)
+				}
+			})
+
+			t.Run("MemoryUsage", func(t *testing.T) {
+				redisPQ, ok := pq.(*priorityqueue.RedisPriorityQueue)
+				if !ok {
+					t.Skip("MemoryUsage is specific to the Redis backend")
+				}
+
+				report, err := redisPQ.MemoryUsage(ctx, "memoryusage_test", 0)
+				if err != nil || report.Items != 0 || report.Bytes != 0 {
+					t.Errorf("Missing queue should use no memory, got %+v, err: %v", report, err)
+				}
+
+				for i := 0; i < 20; i++ {
+					pq.Enqueue(ctx, "memoryusage_test", fmt.Sprintf("item%d", i), i%10)
+				}
+				report, err = redisPQ.MemoryUsage(ctx, "memoryusage_test", 0)
+				if err != nil || report.Items != 20 || report.Bytes <= 0 || report.Keys["memoryusage_test"] <= 0 {
+					t.Errorf("MemoryUsage wrong result: %+v, err: %v", report, err)
+				}
+			})
+
+			t.Run("PayloadChecksum", func(t *testing.T) {
+				redisPQ, ok := pq.(*priorityqueue.RedisPriorityQueue)
+				if !ok {
+					t.Skip("Payload checksums are specific to the Redis backend")
+				}
+
+				pq.Enqueue(ctx, "checksum_test", "good", 5)
+				client := redis.NewClient(&redis.Options{Addr: "localhost:6379", Password: "nBr3nJu6hn"})
+				defer client.Close()
+				tampered := "tampered\x0000000000"
+				if err := client.ZAdd(context.Background(), "checksum_test", redis.Z{Score: 1, Member: tampered}).Err(); err != nil {
+					t.Fatalf("Failed to write tampered item: %v", err)
+				}
+
+				if _, err := pq.Dequeue(ctx, "checksum_test"); !errors.Is(err, priorityqueue.ErrCorruptPayload) {
+					t.Errorf("Dequeue of tampered item should fail with ErrCorruptPayload, got %v", err)
+				}
+				quarantined, err := redisPQ.Quarantined(ctx, "checksum_test")
+				if err != nil || !reflect.DeepEqual(quarantined, []string{tampered}) {
+					t.Errorf("Tampered item should be quarantined, got %q, err: %v", quarantined, err)
+				}
+				if item, err := pq.Dequeue(ctx, "checksum_test"); err != nil || item != "good" {
+					t.Errorf("Dequeue after quarantine should return 'good', got %v, err: %v", item, err)
+				}
+			})
+
+			t.Run("LegacyMember", func(t *testing.T) {
+				redisPQ, ok := pq.(*priorityqueue.RedisPriorityQueue)
+				if !ok {
+					t.Skip("Stored member formats are specific to the Redis backend")
+				}
+
+				// Written before checksums: the bare value at its bare priority
+				client := redis.NewClient(&redis.Options{Addr: "localhost:6379", Password: "nBr3nJu6hn"})
+				defer client.Close()
+				for _, z := range []redis.Z{{Score: 2, Member: "job-A"}, {Score: 4, Member: "job-B"}} {
+					if err := client.ZAdd(context.Background(), "legacy_member_test", z).Err(); err != nil {
+						t.Fatalf("Failed to write legacy item: %v", err)
+					}
+				}
+
+				if _, err := redisPQ.VerifyQueue(ctx, "legacy_member_test", true); err != nil {
+					t.Fatalf("VerifyQueue failed: %v", err)
+				}
+				if item, err := pq.Dequeue(ctx, "legacy_member_test"); err != nil || item != "job-A" {
+					t.Errorf("Dequeue of legacy item should return 'job-A', got %v, err: %v", item, err)
+				}
+				if item, err := pq.Dequeue(ctx, "legacy_member_test"); err != nil || item != "job-B" {
+					t.Errorf("Legacy item should survive repair, got %v, err: %v", item, err)
+				}
+				if quarantined, err := redisPQ.Quarantined(ctx, "legacy_member_test"); err != nil || len(quarantined) != 0 {
+					t.Errorf("Legacy items shouldn't be quarantined, got %q, err: %v", quarantined, err)
+				}
+			})
+
+			t.Run("BlobOffload", func(t *testing.T) {
+				redisPQ, ok := pq.(*priorityqueue.RedisPriorityQueue)
+				if !ok {
+					t.Skip("Blob offloading is specific to the Redis backend")
+				}
+				redisPQ.SetBlobThreshold(16)
+				defer redisPQ.SetBlobThreshold(0)
+
+				large := strings.Repeat("x", 64)
+				pq.Enqueue(ctx, "blob_test", large, 1)
+				pq.Enqueue(ctx, "blob_test", "small", 2)
+
+				client := redis.NewClient(&redis.Options{Addr: "localhost:6379", Password: "nBr3nJu6hn"})
+				defer client.Close()
+				if n, err := client.HLen(context.Background(), "pq:{blob_test}:blobs").Result(); err != nil || n != 1 {
+					t.Errorf("Large value should be offloaded to one blob, got %d, err: %v", n, err)
+				}
+
+				contents, err := pq.ListContents(ctx, "blob_test")
+				want := map[int][]interface{}{1: {large}, 2: {"small"}}
+				if err != nil || !reflect.DeepEqual(contents, want) {
+					t.Errorf("ListContents should resolve blobs. Got %v, want %v", contents, want)
+				}
+				if p, pos, err := pq.GetPosition(ctx, "blob_test", large); err != nil || p != 1 || pos != 0 {
+					t.Errorf("GetPosition of offloaded value: got %d, %d, err: %v", p, pos, err)
+				}
+
+				if item, err := pq.Dequeue(ctx, "blob_test"); err != nil || item != large {
+					t.Errorf("Dequeue should resolve offloaded value, got %v, err: %v", item, err)
+				}
+				if n, err := client.HLen(context.Background(), "pq:{blob_test}:blobs").Result(); err != nil || n != 0 {
+					t.Errorf("Dequeue should delete the blob, %d left, err: %v", n, err)
+				}
+			})
+
+			t.Run("ValidatingQueue", func(t *testing.T) {
+				vq := priorityqueue.NewValidatingQueue(pq)
+				vq.AddQueue(ctx, "validation_test")
+				vq.AddQueue(ctx, "validation_other_test")
+				errTooUrgent := fmt.Errorf("priority 0 is reserved")
+				vq.SetValidator("validation_test", func(value interface{}, priority int) error {
+					if priority == 0 {
+						return errTooUrgent
+					}
+					return nil
+				})
+
+				if err := vq.Enqueue(ctx, "validation_test", "a", 0); !errors.Is(err, errTooUrgent) {
+					t.Errorf("Enqueue should fail validation, got %v", err)
+				}
+				if err := vq.EnqueueFanout(ctx, []string{"validation_other_test", "validation_test"}, "b", 0); err == nil {
+					t.Error("EnqueueFanout should fail validation")
+				}
+				if empty, _ := vq.IsEmpty(ctx, "validation_other_test"); !empty {
+					t.Error("Rejected fanout should not write to any queue")
+				}
+				if err := vq.InsertAtTopBatch(ctx, "validation_test", []interface{}{"c"}, 0); err == nil {
+					t.Error("InsertAtTopBatch should fail validation")
+				}
+				if err := vq.Enqueue(ctx, "validation_test", "d", 1); err != nil {
+					t.Errorf("Valid item rejected: %v", err)
+				}
+
+				// Moves and priority changes into priority 0 are validated too
+				if err := vq.UpdatePriority(ctx, "validation_test", "d", 0); !errors.Is(err, errTooUrgent) {
+					t.Errorf("UpdatePriority should fail validation, got %v", err)
+				}
+				id, _ := vq.EnqueueWithID(ctx, "validation_test", "f", 2)
+				if err := vq.MoveToPosition(ctx, "validation_test", id, 0, 0); !errors.Is(err, errTooUrgent) {
+					t.Errorf("MoveToPosition should fail validation, got %v", err)
+				}
+				if err := vq.MoveToPosition(ctx, "validation_test", id, 2, 0); err != nil {
+					t.Errorf("MoveToPosition within the level failed: %v", err)
+				}
+				vq.Enqueue(ctx, "validation_other_test", "g", 0)
+				if err := vq.MoveItem(ctx, "validation_other_test", "validation_test", "g"); !errors.Is(err, errTooUrgent) {
+					t.Errorf("MoveItem should fail validation against the destination, got %v", err)
+				}
+				if err := vq.MoveItem(ctx, "validation_test", "validation_other_test", "f"); err != nil {
+					t.Errorf("MoveItem to a queue without a validator failed: %v", err)
+				}
+				vq.Dequeue(ctx, "validation_other_test")
+				vq.Dequeue(ctx, "validation_other_test")
+
+				vq.SetValidator("validation_test", nil)
+				if err := vq.Enqueue(ctx, "validation_test", "e", 0); err != nil {
+					t.Errorf("Enqueue after removing validator failed: %v", err)
+				}
+				contents, _ := vq.ListContents(ctx, "validation_test")
+				want := map[int][]interface{}{0: {"e"}, 1: {"d"}}
+				if !reflect.DeepEqual(contents, want) {
+					t.Errorf("ValidatingQueue wrong contents. Got %v, want %v", contents, want)
+				}
+			})
+
+			t.Run("InterceptingQueue", func(t *testing.T) {
+				iq := priorityqueue.NewInterceptingQueue(pq)
+				iq.AddQueue(ctx, "intercept_test")
+				iq.AddQueue(ctx, "intercept_plain_test")
+				iq.AddProducerInterceptor("intercept_test", func(value interface{}) (interface{}, error) {
+					return "tenant42:" + fmt.Sprintf("%v", value), nil
+				})
+				iq.AddConsumerInterceptor("intercept_test", func(value interface{}) (interface{}, error) {
+					return strings.ToUpper(fmt.Sprintf("%v", value)), nil
+				})
+
+				if err := iq.EnqueueFanout(ctx, []string{"intercept_test", "intercept_plain_test"}, "job", 1); err != nil {
+					t.Fatalf("EnqueueFanout failed: %v", err)
+				}
+				contents, _ := pq.ListContents(ctx, "intercept_test")
+				if want := map[int][]interface{}{1: {"tenant42:job"}}; !reflect.DeepEqual(contents, want) {
+					t.Errorf("Producer interceptor not applied. Got %v, want %v", contents, want)
+				}
+				if item, err := iq.Dequeue(ctx, "intercept_plain_test"); err != nil || item != "job" {
+					t.Errorf("Queue without interceptors should be untouched, got %v, err: %v", item, err)
+				}
+				if item, err := iq.Dequeue(ctx, "intercept_test"); err != nil || item != "TENANT42:JOB" {
+					t.Errorf("Consumer interceptor not applied, got %v, err: %v", item, err)
+				}
+
+				iq.AddProducerInterceptor("intercept_test", func(value interface{}) (interface{}, error) {
+					return nil, fmt.Errorf("rejected")
+				})
+				if err := iq.Enqueue(ctx, "intercept_test", "job", 1); err == nil {
+					t.Error("Failing interceptor should abort Enqueue")
+				}
+
+				// A consumer failing mid-batch still hands back every removed item
+				iq.AddConsumerInterceptor("intercept_plain_test", func(value interface{}) (interface{}, error) {
+					if value == "bad" {
+						return nil, fmt.Errorf("rejected")
+					}
+					return "seen:" + fmt.Sprintf("%v", value), nil
+				})
+				for _, value := range []string{"a", "bad", "c"} {
+					pq.Enqueue(ctx, "intercept_plain_test", value, 1)
+				}
+				values, err := iq.DequeueBatch(ctx, "intercept_plain_test", 3)
+				if want := []interface{}{"seen:a", "bad", "c"}; err == nil || !reflect.DeepEqual(values, want) {
+					t.Errorf("DequeueBatch should return %v with the error, got %v, err: %v", want, values, err)
+				}
+				for _, value := range []string{"bad", "b"} {
+					pq.Enqueue(ctx, "intercept_plain_test", value, 2)
+				}
+				values, err = iq.DequeueLevel(ctx, "intercept_plain_test")
+				if want := []interface{}{"bad", "b"}; err == nil || !reflect.DeepEqual(values, want) {
+					t.Errorf("DequeueLevel should return %v with the error, got %v, err: %v", want, values, err)
+				}
+			})
+
+			t.Run("DequeueLevel", func(t *testing.T) {
+				pq.AddQueue(ctx, "dequeuelevel_test")
+				if _, err := pq.DequeueLevel(ctx, "dequeuelevel_test"); err == nil {
+					t.Error("DequeueLevel on empty queue should fail")
+				}
+				pq.Enqueue(ctx, "dequeuelevel_test", "low", 7)
+				pq.Enqueue(ctx, "dequeuelevel_test", "first", 2)
+				pq.Enqueue(ctx, "dequeuelevel_test", "second", 2)
+				pq.InsertAtTop(ctx, "dequeuelevel_test", "urgent", 2)
+
+				values, err := pq.DequeueLevel(ctx, "dequeuelevel_test")
+				if want := []interface{}{"urgent", "first", "second"}; err != nil || !reflect.DeepEqual(values, want) {
+					t.Errorf("DequeueLevel wrong result. Got %v, want %v, err: %v", values, want, err)
+				}
+				contents, _ := pq.ListContents(ctx, "dequeuelevel_test")
+				if want := map[int][]interface{}{7: {"low"}}; !reflect.DeepEqual(contents, want) {
+					t.Errorf("DequeueLevel touched other levels. Got %v, want %v", contents, want)
+				}
+				if counters, _ := pq.Counters(ctx, "dequeuelevel_test"); counters.Dequeued != 3 {
+					t.Errorf("DequeueLevel should count 3 dequeues, got %+v", counters)
+				}
+			})
+
+			t.Run("FreezeQueue", func(t *testing.T) {
+				pq.AddQueue(ctx, "freeze_test")
+				pq.AddQueue(ctx, "freeze_other_test")
+				pq.Enqueue(ctx, "freeze_test", "a", 1)
+				if err := pq.FreezeQueue(ctx, "freeze_test"); err != nil {
+					t.Fatalf("FreezeQueue failed: %v", err)
+				}
+
+				if err := pq.Enqueue(ctx, "freeze_test", "b", 1); !errors.Is(err, priorityqueue.ErrQueueFrozen) {
+					t.Errorf("Enqueue into frozen queue should fail with ErrQueueFrozen, got %v", err)
+				}
+				if _, err := pq.Dequeue(ctx, "freeze_test"); !errors.Is(err, priorityqueue.ErrQueueFrozen) {
+					t.Errorf("Dequeue from frozen queue should fail with ErrQueueFrozen, got %v", err)
+				}
+				if err := pq.EnqueueFanout(ctx, []string{"freeze_other_test", "freeze_test"}, "c", 1); !errors.Is(err, priorityqueue.ErrQueueFrozen) {
+					t.Errorf("Fanout into frozen queue should fail with ErrQueueFrozen, got %v", err)
+				}
+				if empty, _ := pq.IsEmpty(ctx, "freeze_other_test"); !empty {
+					t.Error("Rejected fanout should not write to any queue")
+				}
+				if err := pq.DeleteItem(ctx, "freeze_test", "a"); !errors.Is(err, priorityqueue.ErrQueueFrozen) {
+					t.Errorf("DeleteItem in frozen queue should fail with ErrQueueFrozen, got %v", err)
+				}
+				if contents, err := pq.ListContents(ctx, "freeze_test"); err != nil || len(contents[1]) != 1 {
+					t.Errorf("Reads should work on a frozen queue, got %v, err: %v", contents, err)
+				}
+
+				pq.UnfreezeQueue(ctx, "freeze_test")
+				if item, err := pq.Dequeue(ctx, "freeze_test"); err != nil || item != "a" {
+					t.Errorf("Dequeue after unfreeze should return 'a', got %v, err: %v", item, err)
+				}
+			})
+
+			t.Run("ServiceWindowQueue", func(t *testing.T) {
+				sq := priorityqueue.NewServiceWindowQueue(pq)
+				ny, err := time.LoadLocation("America/New_York")
+				if err != nil {
+					t.Skipf("timezone data unavailable: %v", err)
+				}
+				// 07:00 in New York
+				clock := priorityqueue.NewVirtualClock(time.Date(2024, 3, 1, 7, 0, 0, 0, ny))
+				sq.SetClock(clock)
+				sq.SetServiceWindows("servicewindow_test", priorityqueue.ServiceWindow{
+					Start: 8 * time.Hour, End: 20 * time.Hour, Location: ny,
+				})
+
+				sq.AddQueue(ctx, "servicewindow_test")
+				if err := sq.Enqueue(ctx, "servicewindow_test", "batch", 1); err != nil {
+					t.Fatalf("Enqueue outside window should succeed: %v", err)
+				}
+				if _, err := sq.Dequeue(ctx, "servicewindow_test"); !errors.Is(err, priorityqueue.ErrOutsideServiceWindow) {
+					t.Errorf("Dequeue before window should fail with ErrOutsideServiceWindow, got %v", err)
+				}
+				clock.Advance(time.Hour)
+				if item, err := sq.Dequeue(ctx, "servicewindow_test"); err != nil || item != "batch" {
+					t.Errorf("Dequeue inside window should return 'batch', got %v, err: %v", item, err)
+				}
+
+				overnight := priorityqueue.ServiceWindow{Start: 22 * time.Hour, End: 6 * time.Hour}
+				sq.SetServiceWindows("servicewindow_test", overnight)
+				sq.Enqueue(ctx, "servicewindow_test", "night", 1)
+				if _, err := sq.DequeueLevel(ctx, "servicewindow_test"); !errors.Is(err, priorityqueue.ErrOutsideServiceWindow) {
+					t.Errorf("DequeueLevel outside overnight window should fail, got %v", err)
+				}
+				sq.SetServiceWindows("servicewindow_test")
+				if _, err := sq.Dequeue(ctx, "servicewindow_test"); err != nil {
+					t.Errorf("Queue without windows should always be open: %v", err)
+				}
+			})
+
+			t.Run("CostBudgetQueue", func(t *testing.T) {
+				cq := priorityqueue.NewCostBudgetQueue(pq)
+				clock := priorityqueue.NewVirtualClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+				cq.SetClock(clock)
+				err := cq.SetCostBudget("costbudget_test", priorityqueue.CostBudget{
+					Limit:  10,
+					Window: time.Minute,
+					Cost:   func(value interface{}) int { return len(fmt.Sprintf("%v", value)) },
+				})
+				if err != nil {
+					t.Fatalf("SetCostBudget failed: %v", err)
+				}
+
+				cq.AddQueue(ctx, "costbudget_test")
+				cq.Enqueue(ctx, "costbudget_test", "light", 0)
+				cq.Enqueue(ctx, "costbudget_test", "heavyjob", 1)
+				cq.Enqueue(ctx, "costbudget_test", "ok", 2)
+
+				if item, err := cq.Dequeue(ctx, "costbudget_test"); err != nil || item != "light" {
+					t.Errorf("First dequeue should return 'light', got %v, err: %v", item, err)
+				}
+				if _, err := cq.Dequeue(ctx, "costbudget_test"); !errors.Is(err, priorityqueue.ErrCostBudgetExceeded) {
+					t.Errorf("Heavy head should exceed budget, got %v", err)
+				}
+				if left := cq.Remaining("costbudget_test"); left != 5 {
+					t.Errorf("Remaining should be 5, got %d", left)
+				}
+
+				clock.Advance(time.Minute)
+				if item, err := cq.Dequeue(ctx, "costbudget_test"); err != nil || item != "heavyjob" {
+					t.Errorf("Heavy item should pass in a new window, got %v, err: %v", item, err)
+				}
+				if item, err := cq.Dequeue(ctx, "costbudget_test"); err != nil || item != "ok" {
+					t.Errorf("Dequeue should return 'ok', got %v, err: %v", item, err)
+				}
+				if _, err := cq.Dequeue(ctx, "costbudget_test"); err == nil || errors.Is(err, priorityqueue.ErrCostBudgetExceeded) {
+					t.Errorf("Empty queue should report empty, got %v", err)
+				}
+
+				// Every dequeue path is charged to the budget or refused
+				unit := priorityqueue.CostBudget{Limit: 1, Window: time.Minute, Cost: func(interface{}) int { return 1 }}
+				if err := cq.SetCostBudget("costbudget_batch_test", unit); err != nil {
+					t.Fatalf("SetCostBudget failed: %v", err)
+				}
+				cq.AddQueue(ctx, "costbudget_batch_test")
+				for i := 1; i <= 4; i++ {
+					cq.Enqueue(ctx, "costbudget_batch_test", i, 0)
+				}
+				if items, err := cq.DequeueBatch(ctx, "costbudget_batch_test", 4); err != nil || fmt.Sprint(items) != "[1]" {
+					t.Errorf("DequeueBatch should stop at the budget after [1], got %v, err: %v", items, err)
+				}
+				if _, err := cq.DequeueBatch(ctx, "costbudget_batch_test", 4); !errors.Is(err, priorityqueue.ErrCostBudgetExceeded) {
+					t.Errorf("DequeueBatch with no budget left should fail, got %v", err)
+				}
+				if _, err := cq.DequeueFromPriority(ctx, "costbudget_batch_test", 0); !errors.Is(err, priorityqueue.ErrCostBudgetExceeded) {
+					t.Errorf("DequeueFromPriority should be charged, got %v", err)
+				}
+				if _, err := cq.DequeueLevel(ctx, "costbudget_batch_test"); err == nil {
+					t.Error("DequeueLevel should be refused on a budgeted queue")
+				}
+				if _, err := cq.DequeueWhere(ctx, "costbudget_batch_test", func(priorityqueue.Item) bool { return true }); err == nil {
+					t.Error("DequeueWhere should be refused on a budgeted queue")
+				}
+				if _, err := cq.DequeueFresh(ctx, "costbudget_batch_test", time.Hour, false); err == nil {
+					t.Error("DequeueFresh should be refused on a budgeted queue")
+				}
+				clock.Advance(time.Minute)
+				if item, err := cq.DequeueFromPriority(ctx, "costbudget_batch_test", 0); err != nil || fmt.Sprint(item) != "2" {
+					t.Errorf("DequeueFromPriority in a new window should return 2, got %v, err: %v", item, err)
+				}
+				if size, _ := cq.Size(ctx, "costbudget_batch_test"); size != 2 {
+					t.Errorf("Expected 2 items left, got %d", size)
+				}
+
+				// Moving an item out of a budgeted queue is charged too
+				cq.AddQueue(ctx, "costbudget_move_test")
+				if err := cq.MoveItem(ctx, "costbudget_batch_test", "costbudget_move_test", 3); !errors.Is(err, priorityqueue.ErrCostBudgetExceeded) {
+					t.Errorf("MoveItem should be charged, got %v", err)
+				}
+				clock.Advance(time.Minute)
+				if err := cq.MoveItem(ctx, "costbudget_batch_test", "costbudget_move_test", 3); err != nil {
+					t.Errorf("MoveItem in a new window failed: %v", err)
+				}
+				if left := cq.Remaining("costbudget_batch_test"); left != 0 {
+					t.Errorf("MoveItem should use up the budget, %d left", left)
+				}
+
+				// A metered dequeue in flight doesn't hold up other queues
+				blocked := &blockingPeek{PriorityQueuer: pq, entered: make(chan struct{}), release: make(chan struct{})}
+				bq := priorityqueue.NewCostBudgetQueue(blocked)
+				bq.SetCostBudget("costbudget_batch_test", unit)
+				done := make(chan struct{})
+				go func() {
+					bq.Dequeue(ctx, "costbudget_batch_test")
+					close(done)
+				}()
+				<-blocked.entered
+				cq.Enqueue(ctx, "costbudget_test", "free", 0)
+				if item, err := bq.Dequeue(ctx, "costbudget_test"); err != nil || item != "free" {
+					t.Errorf("Unbudgeted dequeue should pass through, got %v, err: %v", item, err)
+				}
+				close(blocked.release)
+				<-done
+			})
+
+			t.Run("Escalate", func(t *testing.T) {
+				pq.AddQueue(ctx, "escalate_test")
+				pq.Enqueue(ctx, "escalate_test", "p0", 0)
+				pq.Enqueue(ctx, "escalate_test", "vip1", 3)
+				pq.Enqueue(ctx, "escalate_test", "regular", 4)
+				pq.Enqueue(ctx, "escalate_test", "vip2", 5)
+				caller, _ := pq.EnqueueWithID(ctx, "escalate_test", "caller", 8)
+
+				if err := priorityqueue.EscalateItem(ctx, pq, "escalate_test", caller, true); err != nil {
+					t.Fatalf("EscalateItem failed: %v", err)
+				}
+				isVIP := func(value interface{}) bool { return strings.HasPrefix(fmt.Sprintf("%v", value), "vip") }
+				n, err := priorityqueue.EscalateMatching(ctx, pq, "escalate_test", isVIP, false)
+				if err != nil || n != 2 {
+					t.Errorf("EscalateMatching should move 2 items, got %d, err: %v", n, err)
+				}
+
+				contents, _ := pq.ListContents(ctx, "escalate_test")
+				want := map[int][]interface{}{0: {"caller", "p0", "vip1", "vip2"}, 4: {"regular"}}
+				if !reflect.DeepEqual(contents, want) {
+					t.Errorf("Escalate wrong result. Got %v, want %v", contents, want)
+				}
+				if err := priorityqueue.EscalateItem(ctx, pq, "escalate_test", "missing", true); err == nil {
+					t.Error("EscalateItem of missing item should fail")
+				}
+
+				// Equal payloads are distinct items and each is moved once
+				pq.AddQueue(ctx, "escalate_dup_test")
+				pq.Enqueue(ctx, "escalate_dup_test", "x", 2)
+				pq.Enqueue(ctx, "escalate_dup_test", "x", 5)
+				pq.Enqueue(ctx, "escalate_dup_test", "y", 5)
+				isX := func(value interface{}) bool { return value == "x" }
+				n, err = priorityqueue.EscalateMatching(ctx, pq, "escalate_dup_test", isX, false)
+				if err != nil || n != 2 {
+					t.Errorf("EscalateMatching should move both duplicates, got %d, err: %v", n, err)
+				}
+				counts, _ := pq.CountByPriority(ctx, "escalate_dup_test")
+				if !reflect.DeepEqual(counts, map[int]int{0: 2, 5: 1}) {
+					t.Errorf("Expected both duplicates at priority 0, got %v", counts)
+				}
+			})
+
+			t.Run("ReadOnly", func(t *testing.T) {
+				redisPQ, ok := pq.(*priorityqueue.RedisPriorityQueue)
+				if !ok {
+					t.Skip("Read-only mode is specific to the Redis backend")
+				}
+				other := priorityqueue.NewRedisPriorityQueue("localhost:6379", "nBr3nJu6hn", 0)
+
+				pq.Enqueue(ctx, "readonly_test", "a", 1)
+				if err := redisPQ.SetReadOnly(ctx, "readonly_test", true); err != nil {
+					t.Fatalf("SetReadOnly failed: %v", err)
+				}
+				if err := other.Enqueue(ctx, "readonly_test", "b", 1); !errors.Is(err, priorityqueue.ErrReadOnly) {
+					t.Errorf("Read-only mode should apply to other clients, got %v", err)
+				}
+				if _, err := pq.Dequeue(ctx, "readonly_test"); !errors.Is(err, priorityqueue.ErrReadOnly) {
+					t.Errorf("Dequeue in read-only mode should fail with ErrReadOnly, got %v", err)
+				}
+				if contents, err := pq.ListContents(ctx, "readonly_test"); err != nil || len(contents[1]) != 1 {
+					t.Errorf("Reads should work in read-only mode, got %v, err: %v", contents, err)
+				}
+				redisPQ.SetReadOnly(ctx, "readonly_test", false)
+
+				redisPQ.SetGlobalReadOnly(ctx, true)
+				if ro, err := redisPQ.ReadOnly(ctx, "readonly_test"); err != nil || !ro {
+					t.Errorf("Global switch should make the queue read-only, got %v, err: %v", ro, err)
+				}
+				if err := pq.DeleteItem(ctx, "readonly_test", "a"); !errors.Is(err, priorityqueue.ErrReadOnly) {
+					t.Errorf("DeleteItem in global read-only mode should fail with ErrReadOnly, got %v", err)
+				}
+				redisPQ.SetGlobalReadOnly(ctx, false)
+
+				if item, err := pq.Dequeue(ctx, "readonly_test"); err != nil || item != "a" {
+					t.Errorf("Dequeue after leaving read-only mode should return 'a', got %v, err: %v", item, err)
+				}
+			})
+
+			t.Run("FindDuplicates", func(t *testing.T) {
+				pq.AddQueue(ctx, "duplicates_test")
+				pq.Enqueue(ctx, "duplicates_test", "order-1:a", 2)
+				pq.Enqueue(ctx, "duplicates_test", "order-2:a", 2)
+				pq.Enqueue(ctx, "duplicates_test", "order-1:b", 5)
+				pq.Enqueue(ctx, "duplicates_test", "order-3:a", 5)
+
+				orderID := func(value interface{}) string {
+					return strings.SplitN(fmt.Sprintf("%v", value), ":", 2)[0]
+				}
+				groups, err := priorityqueue.FindDuplicates(ctx, pq, "duplicates_test", orderID)
+				want := []priorityqueue.DuplicateGroup{{
+					Key: "order-1",
+					Items: []priorityqueue.ItemPosition{
+						{Priority: 2, Position: 0, Value: "order-1:a"},
+						{Priority: 5, Position: 0, Value: "order-1:b"},
+					},
+				}}
+				if err != nil || !reflect.DeepEqual(groups, want) {
+					t.Errorf("FindDuplicates wrong result. Got %+v, want %+v, err: %v", groups, want, err)
+				}
+
+				groups, err = priorityqueue.FindDuplicates(ctx, pq, "duplicates_test", nil)
+				if err != nil || len(groups) != 0 {
+					t.Errorf("FindDuplicates by payload should find nothing, got %+v, err: %v", groups, err)
+				}
+			})
+
+			t.Run("SweepOrphans", func(t *testing.T) {
+				redisPQ, ok := pq.(*priorityqueue.RedisPriorityQueue)
+				if !ok {
+					t.Skip("Orphan sweeping is specific to the Redis backend")
+				}
+				client := redis.NewClient(&redis.Options{Addr: "localhost:6379", Password: "nBr3nJu6hn"})
+				defer client.Close()
+				ctx := context.Background()
+
+				pq.Enqueue(ctx, "sweep_live_test", "a", 1)
+				pq.Enqueue(ctx, "sweep_test", "lost", 1)
+				client.Del(ctx, "sweep_test") // Deleted by hand, leaving its index and counter
+				client.HSet(ctx, "pq:{sweep_test}:blobs", "id", "body")
+				client.Set(ctx, "pq:{sweep_app_test}:seq", 42, 0) // A queue outside the registry
+				defer client.Del(ctx, "pq:{sweep_test}:enqueued", "pq:{sweep_test}:blobs", "pq:{sweep_test}:seq", "pq:{sweep_app_test}:seq")
+
+				n, err := redisPQ.SweepOrphans(ctx)
+				if err != nil || n < 3 {
+					t.Errorf("SweepOrphans should remove at least 3 keys, got %d, err: %v", n, err)
+				}
+				if left, _ := client.Exists(ctx, "pq:{sweep_test}:enqueued", "pq:{sweep_test}:blobs", "pq:{sweep_test}:seq").Result(); left != 0 {
+					t.Errorf("Orphaned keys should be deleted, %d left", left)
+				}
+				if v, err := client.Get(ctx, "pq:{sweep_app_test}:seq").Result(); err != nil || v != "42" {
+					t.Errorf("Keys outside the queue registry should survive the sweep, got %q, err: %v", v, err)
+				}
+				if _, age, err := pq.OldestItem(ctx, "sweep_live_test"); err != nil || age < 0 {
+					t.Errorf("Live queue's index should survive the sweep, err: %v", err)
+				}
+			})
+
+			t.Run("HelperKeyNamespace", func(t *testing.T) {
+				if _, ok := pq.(*priorityqueue.RedisPriorityQueue); !ok {
+					t.Skip("Helper keys are specific to the Redis backend")
+				}
+
+				// A queue named like another queue's helper key is its own queue
+				before, _ := pq.Counters(ctx, "keyspace_test")
+				pq.Enqueue(ctx, "keyspace_test", "a", 1)
+				if err := pq.Enqueue(ctx, "keyspace_test:counters", "b", 1); err != nil {
+					t.Fatalf("Enqueue to a queue named like a helper key failed: %v", err)
+				}
+				if err := pq.Enqueue(ctx, "keyspace_test:enqueued", "c", 1); err != nil {
+					t.Fatalf("Enqueue to a queue named like a helper key failed: %v", err)
+				}
+				for queueName, want := range map[string]string{"keyspace_test": "a", "keyspace_test:counters": "b", "keyspace_test:enqueued": "c"} {
+					if value, err := pq.Dequeue(ctx, queueName); err != nil || value != want {
+						t.Errorf("Expected %q from %s, got %v, err: %v", want, queueName, value, err)
+					}
+				}
+				after, err := pq.Counters(ctx, "keyspace_test")
+				if err != nil || after.Enqueued-before.Enqueued != 1 || after.Dequeued-before.Dequeued != 1 {
+					t.Errorf("Expected keyspace_test's own counters to move by one, got %+v then %+v, err: %v", before, after, err)
+				}
+
+				// The namespace holding helper and registry keys is reserved
+				for _, name := range []string{"pq:queues", "pq:{keyspace_test}:counters"} {
+					if err := pq.AddQueue(ctx, name); err == nil {
+						t.Errorf("AddQueue(%q) should be rejected", name)
+					}
+					if err := pq.Enqueue(ctx, name, "x", 1); err == nil {
+						t.Errorf("Enqueue to %q should be rejected", name)
+					}
+				}
+			})
+
+			t.Run("ScoreSequence", func(t *testing.T) {
+				pq.AddQueue(ctx, "sequence_test")
+				pq.Enqueue(ctx, "sequence_test", "zulu", 4)
+				pq.Enqueue(ctx, "sequence_test", "alpha", 4)
+				pq.InsertAtTop(ctx, "sequence_test", "mike", 4)
+				want := map[int][]interface{}{4: {"mike", "zulu", "alpha"}}
+				if contents, _ := pq.ListContents(ctx, "sequence_test"); !reflect.DeepEqual(contents, want) {
+					t.Errorf("Items should keep enqueue order within a level. Got %v, want %v", contents, want)
+				}
+
+				redisPQ, ok := pq.(*priorityqueue.RedisPriorityQueue)
+				if !ok {
+					return
+				}
+				client := redis.NewClient(&redis.Options{Addr: "localhost:6379", Password: "nBr3nJu6hn"})
+				defer client.Close()
+				client.Set(context.Background(), "pq:{sequence_test}:seq", int64(1)<<47-1, 0)
+				if err := pq.Enqueue(ctx, "sequence_test", "late", 4); !errors.Is(err, priorityqueue.ErrSequenceExhausted) {
+					t.Errorf("Enqueue with exhausted sequence should fail with ErrSequenceExhausted, got %v", err)
+				}
+				if err := redisPQ.ResequenceQueue(ctx, "sequence_test"); err != nil {
+					t.Fatalf("ResequenceQueue failed: %v", err)
+				}
+				if err := pq.Enqueue(ctx, "sequence_test", "late", 4); err != nil {
+					t.Errorf("Enqueue after resequencing failed: %v", err)
+				}
+				want[4] = append(want[4], "late")
+				if contents, _ := pq.ListContents(ctx, "sequence_test"); !reflect.DeepEqual(contents, want) {
+					t.Errorf("ResequenceQueue changed order. Got %v, want %v", contents, want)
+				}
+
+				// Queues spanning several pages keep their order too
+				for i := 0; i < 250; i++ {
+					priority := 6 - 2*(i%2)
+					value := fmt.Sprintf("bulk%03d", i)
+					pq.Enqueue(ctx, "sequence_test", value, priority)
+					want[priority] = append(want[priority], value)
+				}
+				if err := redisPQ.ResequenceQueue(ctx, "sequence_test"); err != nil {
+					t.Fatalf("ResequenceQueue failed: %v", err)
+				}
+				if contents, _ := pq.ListContents(ctx, "sequence_test"); !reflect.DeepEqual(contents, want) {
+					t.Errorf("ResequenceQueue changed the order of a large queue. Got %v, want %v", contents, want)
+				}
+				if report, err := redisPQ.VerifyQueue(ctx, "sequence_test", false); err != nil || !report.OK() {
+					t.Errorf("Expected a healthy queue after resequencing, got %q, err %v", report.Problems, err)
+				}
+			})
+
+			t.Run("LegacyScores", func(t *testing.T) {
+				if _, ok := pq.(*priorityqueue.RedisPriorityQueue); !ok {
+					t.Skip("Score encoding is specific to the Redis backend")
+				}
+				// Scored at their bare priority, before sequence numbers
+				client := redis.NewClient(&redis.Options{Addr: "localhost:6379", Password: "nBr3nJu6hn"})
+				defer client.Close()
+				client.ZAdd(context.Background(), "legacy_score_test", redis.Z{Score: 9, Member: "legacy9"}, redis.Z{Score: 3, Member: "legacy3"})
+
+				if n, err := pq.LevelLen(ctx, "legacy_score_test", 9); err != nil || n != 1 {
+					t.Errorf("LevelLen should count the legacy item, got %d, err: %v", n, err)
+				}
+				pq.Enqueue(ctx, "legacy_score_test", "fresh0", 0)
+				pq.Enqueue(ctx, "legacy_score_test", "fresh3", 3)
+				counts, _ := pq.CountByPriority(ctx, "legacy_score_test")
+				if !reflect.DeepEqual(counts, map[int]int{0: 1, 3: 2, 9: 1}) {
+					t.Errorf("Expected legacy items in their levels, got %v", counts)
+				}
+				for _, expected := range []string{"fresh0", "legacy3", "fresh3", "legacy9"} {
+					if item, err := pq.Dequeue(ctx, "legacy_score_test"); err != nil || item != expected {
+						t.Errorf("Expected %s, got %v, err: %v", expected, item, err)
+					}
+				}
+			})
+
+			t.Run("CompactQueue", func(t *testing.T) {
+				pq.AddQueue(ctx, "compact_test")
+				for i := 0; i < 50; i++ {
+					pq.Enqueue(ctx, "compact_test", fmt.Sprintf("item%02d", i), i%3)
+				}
+				for i := 0; i < 20; i++ {
+					pq.Dequeue(ctx, "compact_test")
+				}
+				pq.InsertAtTop(ctx, "compact_test", "urgent", 1)
+				before, _ := pq.ListContents(ctx, "compact_test")
+
+				if err := pq.CompactQueue(ctx, "compact_test"); err != nil {
+					t.Fatalf("CompactQueue failed: %v", err)
+				}
+				after, _ := pq.ListContents(ctx, "compact_test")
+				if !reflect.DeepEqual(before, after) {
+					t.Errorf("CompactQueue changed contents. Got %v, want %v", after, before)
+				}
+				if item, _, err := pq.OldestItem(ctx, "compact_test"); err != nil || item == nil {
+					t.Errorf("OldestItem after compaction: %v, err: %v", item, err)
+				}
+
+				if _, ok := pq.(*priorityqueue.RedisPriorityQueue); ok {
+					client := redis.NewClient(&redis.Options{Addr: "localhost:6379", Password: "nBr3nJu6hn"})
+					defer client.Close()
+					ctx := context.Background()
+					client.ZAdd(ctx, "pq:{compact_test}:enqueued", redis.Z{Score: 1, Member: "stale"})
+					if err := pq.CompactQueue(ctx, "compact_test"); err != nil {
+						t.Fatalf("CompactQueue failed: %v", err)
+					}
+					if n, _ := client.ZCard(ctx, "pq:{compact_test}:enqueued").Result(); n != 31 {
+						t.Errorf("CompactQueue should prune stale index entries, index has %d", n)
+					}
+				}
+			})
+
+			t.Run("EnqueueMulti", func(t *testing.T) {
+				for _, name := range []string{"multi_a_test", "multi_b_test", "multi_c_test"} {
+					pq.AddQueue(ctx, name)
+				}
+				err := pq.EnqueueMulti(ctx, []priorityqueue.QueueEntry{
+					{QueueName: "multi_a_test", Value: "order", Priority: 1},
+					{QueueName: "multi_b_test", Value: "invoice", Priority: 4},
+					{QueueName: "multi_a_test", Value: "audit", Priority: 1},
+				})
+				if err != nil {
+					t.Fatalf("EnqueueMulti failed: %v", err)
+				}
+				contents, _ := pq.ListContents(ctx, "multi_a_test")
+				if want := map[int][]interface{}{1: {"order", "audit"}}; !reflect.DeepEqual(contents, want) {
+					t.Errorf("EnqueueMulti wrong contents. Got %v, want %v", contents, want)
+				}
+				contents, _ = pq.ListContents(ctx, "multi_b_test")
+				if want := map[int][]interface{}{4: {"invoice"}}; !reflect.DeepEqual(contents, want) {
+					t.Errorf("EnqueueMulti wrong contents. Got %v, want %v", contents, want)
+				}
+
+				pq.FreezeQueue(ctx, "multi_b_test")
+				err = pq.EnqueueMulti(ctx, []priorityqueue.QueueEntry{
+					{QueueName: "multi_c_test", Value: "x", Priority: 0},
+					{QueueName: "multi_b_test", Value: "y", Priority: 0},
+				})
+				pq.UnfreezeQueue(ctx, "multi_b_test")
+				if !errors.Is(err, priorityqueue.ErrQueueFrozen) {
+					t.Errorf("EnqueueMulti into frozen queue should fail with ErrQueueFrozen, got %v", err)
+				}
+				if empty, _ := pq.IsEmpty(ctx, "multi_c_test"); !empty {
+					t.Error("Failed EnqueueMulti should not write to any queue")
+				}
+			})
+
+			t.Run("SpillingQueue", func(t *testing.T) {
+				sq := priorityqueue.NewSpillingQueue(pq)
+				sq.AddQueue(ctx, "spill_test")
+				sq.AddQueue(ctx, "spill_overflow_test")
+				sq.SetSpillRule("spill_test", priorityqueue.SpillRule{MaxDepth: 2})
+				for _, v := range []string{"a", "b", "c", "d", "e"} {
+					if err := sq.Enqueue(ctx, "spill_test", v, 0); err != nil {
+						t.Fatalf("Enqueue failed: %v", err)
+					}
+				}
+				contents, _ := sq.ListContents(ctx, "spill_test")
+				want := map[int][]interface{}{0: {"a", "b"}, 1: {"c", "d"}, 2: {"e"}}
+				if !reflect.DeepEqual(contents, want) {
+					t.Errorf("Spill to lower priority wrong result. Got %v, want %v", contents, want)
+				}
+				if n, err := sq.LevelLen(ctx, "spill_test", 1); err != nil || n != 2 {
+					t.Errorf("LevelLen should be 2, got %d, err: %v", n, err)
+				}
+
+				sq.SetSpillRule("spill_test", priorityqueue.SpillRule{MaxDepth: 2, Overflow: "spill_overflow_test"})
+				sq.Enqueue(ctx, "spill_test", "f", 1)
+				contents, _ = sq.ListContents(ctx, "spill_overflow_test")
+				if want := map[int][]interface{}{1: {"f"}}; !reflect.DeepEqual(contents, want) {
+					t.Errorf("Spill to overflow queue wrong result. Got %v, want %v", contents, want)
+				}
+			})
+
+			t.Run("SpillingQueuePaths", func(t *testing.T) {
+				sq := priorityqueue.NewSpillingQueue(pq)
+				sq.AddQueue(ctx, "spill_paths_test")
+				sq.AddQueue(ctx, "spill_paths_other_test")
+				sq.SetSpillRule("spill_paths_test", priorityqueue.SpillRule{MaxDepth: 1})
+
+				paths := []struct {
+					name string
+					add  func() error
+				}{
+					{"EnqueueBatch", func() error {
+						return sq.EnqueueBatch(ctx, "spill_paths_test", []priorityqueue.Item{{Value: "a", Priority: 0}, {Value: "b", Priority: 0}})
+					}},
+					{"EnqueueMulti", func() error {
+						return sq.EnqueueMulti(ctx, []priorityqueue.QueueEntry{
+							{QueueName: "spill_paths_test", Value: "a", Priority: 0},
+							{QueueName: "spill_paths_test", Value: "b", Priority: 0},
+						})
+					}},
+					{"EnqueueFanout", func() error {
+						if err := sq.EnqueueFanout(ctx, []string{"spill_paths_test", "spill_paths_other_test"}, "a", 0); err != nil {
+							return err
+						}
+						return sq.EnqueueFanout(ctx, []string{"spill_paths_test", "spill_paths_other_test"}, "b", 0)
+					}},
+					{"EnqueueWithID", func() error {
+						if _, err := sq.EnqueueWithID(ctx, "spill_paths_test", "a", 0); err != nil {
+							return err
+						}
+						_, err := sq.EnqueueWithID(ctx, "spill_paths_test", "b", 0)
+						return err
+					}},
+					{"InsertAtTop", func() error {
+						if err := sq.InsertAtTop(ctx, "spill_paths_test", "a", 0); err != nil {
+							return err
+						}
+						return sq.InsertAtTop(ctx, "spill_paths_test", "b", 0)
+					}},
+					{"InsertAtTopBatch", func() error {
+						return sq.InsertAtTopBatch(ctx, "spill_paths_test", []interface{}{"a", "b"}, 0)
+					}},
+				}
+				for _, path := range paths {
+					sq.Purge(ctx, "spill_paths_test")
+					if err := path.add(); err != nil {
+						t.Fatalf("%s failed: %v", path.name, err)
+					}
+					contents, _ := sq.ListContents(ctx, "spill_paths_test")
+					if want := map[int][]interface{}{0: {"a"}, 1: {"b"}}; !reflect.DeepEqual(contents, want) {
+						t.Errorf("%s should spill past MaxDepth. Got %v, want %v", path.name, contents, want)
+					}
+				}
+				contents, _ := sq.ListContents(ctx, "spill_paths_other_test")
+				if want := map[int][]interface{}{0: {"a", "b"}}; !reflect.DeepEqual(contents, want) {
+					t.Errorf("Queues without a rule shouldn't spill. Got %v, want %v", contents, want)
+				}
+
+				sq.Purge(ctx, "spill_paths_test")
+				sq.Purge(ctx, "spill_paths_other_test")
+				sq.SetSpillRule("spill_paths_test", priorityqueue.SpillRule{MaxDepth: 1, Overflow: "spill_paths_other_test"})
+				if err := sq.EnqueueBatch(ctx, "spill_paths_test", []priorityqueue.Item{{Value: "a", Priority: 2}, {Value: "b", Priority: 2}}); err != nil {
+					t.Fatalf("EnqueueBatch failed: %v", err)
+				}
+				if contents, _ := sq.ListContents(ctx, "spill_paths_other_test"); !reflect.DeepEqual(contents, map[int][]interface{}{2: {"b"}}) {
+					t.Errorf("EnqueueBatch should spill into the overflow queue, got %v", contents)
+				}
+
+				// A queue named twice gets one copy whether or not it spills
+				sq.Purge(ctx, "spill_paths_test")
+				sq.Purge(ctx, "spill_paths_other_test")
+				sq.SetSpillRule("spill_paths_test", priorityqueue.SpillRule{MaxDepth: 1})
+				sq.Enqueue(ctx, "spill_paths_test", "full", 0)
+				if err := sq.EnqueueFanout(ctx, []string{"spill_paths_test", "spill_paths_other_test", "spill_paths_test"}, "dup", 0); err != nil {
+					t.Fatalf("EnqueueFanout failed: %v", err)
+				}
+				if contents, _ := sq.ListContents(ctx, "spill_paths_test"); !reflect.DeepEqual(contents, map[int][]interface{}{0: {"full"}, 1: {"dup"}}) {
+					t.Errorf("Spilled fan-out should write one copy per queue, got %v", contents)
+				}
+				if contents, _ := sq.ListContents(ctx, "spill_paths_other_test"); !reflect.DeepEqual(contents, map[int][]interface{}{0: {"dup"}}) {
+					t.Errorf("Spilled fan-out should write one copy per queue, got %v", contents)
+				}
+			})
+
+			t.Run("DequeueFresh", func(t *testing.T) {
+				clocked := pq.(interface{ SetClock(priorityqueue.Clock) })
+				clock := priorityqueue.NewVirtualClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+				clocked.SetClock(clock)
+				defer clocked.SetClock(priorityqueue.SystemClock{})
+
+				pq.AddQueue(ctx, "fresh_test")
+				pq.Enqueue(ctx, "fresh_test", "stale-high", 0)
+				pq.Enqueue(ctx, "fresh_test", "stale-low", 5)
+				clock.Advance(time.Minute)
+				pq.Enqueue(ctx, "fresh_test", "fresh1", 5)
+				pq.Enqueue(ctx, "fresh_test", "fresh2", 5)
+
+				if item, err := pq.DequeueFresh(ctx, "fresh_test", 10*time.Second, false); err != nil || item != "fresh1" {
+					t.Errorf("DequeueFresh should skip stale items, got %v, err: %v", item, err)
+				}
+				if contents, _ := pq.ListContents(ctx, "fresh_test"); len(contents[0]) != 1 {
+					t.Errorf("Skipped items should stay queued, got %v", contents)
+				}
+				if item, err := pq.DequeueFresh(ctx, "fresh_test", 10*time.Second, true); err != nil || item != "fresh2" {
+					t.Errorf("DequeueFresh with expire should return 'fresh2', got %v, err: %v", item, err)
+				}
+				if empty, _ := pq.IsEmpty(ctx, "fresh_test"); !empty {
+					contents, _ := pq.ListContents(ctx, "fresh_test")
+					t.Errorf("Expired items should be deleted, left %v", contents)
+				}
+				if _, err := pq.DequeueFresh(ctx, "fresh_test", time.Hour, false); err == nil {
+					t.Error("DequeueFresh on empty queue should fail")
+				}
+				if counters, _ := pq.Counters(ctx, "fresh_test"); counters.Dequeued != 2 {
+					t.Errorf("Expired items should not count as dequeued, got %+v", counters)
+				}
+			})
+
+			t.Run("DequeueFreshUnindexed", func(t *testing.T) {
+				if _, ok := pq.(*priorityqueue.RedisPriorityQueue); !ok {
+					t.Skip("The enqueue-time index is specific to the Redis backend")
+				}
+				client := redis.NewClient(&redis.Options{Addr: "localhost:6379", Password: "nBr3nJu6hn"})
+				defer client.Close()
+				defer pq.RemoveQueue(ctx, "fresh_unindexed_test")
+
+				pq.AddQueue(ctx, "fresh_unindexed_test")
+				pq.Enqueue(ctx, "fresh_unindexed_test", "unindexed", 0)
+				// Lose the enqueue time, as for a legacy or pruned member
+				client.Del(ctx, "pq:{fresh_unindexed_test}:enqueued")
+				pq.Enqueue(ctx, "fresh_unindexed_test", "fresh", 5)
+
+				if item, err := pq.DequeueFresh(ctx, "fresh_unindexed_test", 10*time.Second, true); err != nil || item != "fresh" {
+					t.Errorf("DequeueFresh should skip the unindexed item, got %v, err: %v", item, err)
+				}
+				if item, err := pq.Dequeue(ctx, "fresh_unindexed_test"); err != nil || item != "unindexed" {
+					t.Errorf("An item of unknown age must not be expired, got %v, err: %v", item, err)
+				}
+			})
+
+			t.Run("StatsHistory", func(t *testing.T) {
+				r := priorityqueue.NewStatsRecorder(pq, 2)
+				clock := priorityqueue.NewVirtualClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+				r.SetClock(clock)
+				// Share the clock so the backend's depth cache expires between samples
+				clocked := pq.(interface{ SetClock(priorityqueue.Clock) })
+				clocked.SetClock(clock)
+				defer clocked.SetClock(priorityqueue.SystemClock{})
+
+				pq.AddQueue(ctx, "stats_test")
+				pq.Enqueue(ctx, "stats_test", "a", 1)
+				r.Sample(ctx, "stats_test")
+				clock.Advance(time.Minute)
+				pq.Enqueue(ctx, "stats_test", "b", 1)
+				pq.Enqueue(ctx, "stats_test", "c", 1)
+				pq.Dequeue(ctx, "stats_test")
+				r.Sample(ctx, "stats_test")
+				clock.Advance(time.Minute)
+				r.Sample(ctx, "stats_test")
+
+				history := r.StatsHistory("stats_test", time.Hour)
+				if len(history) != 2 {
+					t.Fatalf("History should keep 2 samples, got %+v", history)
+				}
+				if s := history[0]; s.Depth != 2 || s.Enqueued != 2 || s.Dequeued != 1 {
+					t.Errorf("Wrong sample: %+v", s)
+				}
+				if s := history[1]; s.Enqueued != 0 || s.Dequeued != 0 {
+					t.Errorf("Idle sample should show no throughput: %+v", s)
+				}
+				if recent := r.StatsHistory("stats_test", 30*time.Second); len(recent) != 1 {
+					t.Errorf("Window should limit history, got %+v", recent)
+				}
+			})
+
+			t.Run("ContextCancel", func(t *testing.T) {
+				if _, ok := pq.(*priorityqueue.RedisPriorityQueue); !ok {
+					t.Skip("the memory backend does not block on I/O")
+				}
+				cancelled, cancel := context.WithCancel(ctx)
+				cancel()
+
+				if err := pq.Enqueue(cancelled, "ctx_cancel_test", "a", 1); err == nil {
+					t.Error("Expected error enqueueing with a cancelled context")
+				}
+				if empty, err := pq.IsEmpty(ctx, "ctx_cancel_test"); err != nil || !empty {
+					t.Errorf("Expected empty queue, err %v", err)
+				}
+			})
+
+			t.Run("TypedQueue", func(t *testing.T) {
+				type job struct {
+					ID    int
+					Name  string
+					Label string
+				}
+				tq := priorityqueue.NewTypedQueue[job](pq)
+				tq.AddQueue(ctx, "typed_test")
+				tq.Enqueue(ctx, "typed_test", job{ID: 1, Name: "low"}, 5)
+				tq.Enqueue(ctx, "typed_test", job{ID: 2, Name: "high", Label: "a b"}, 0)
+
+				got, err := tq.Dequeue(ctx, "typed_test")
+				if err != nil {
+					t.Fatalf("Dequeue failed: %v", err)
+				}
+				if got != (job{ID: 2, Name: "high", Label: "a b"}) {
+					t.Errorf("Expected high job, got %+v", got)
+				}
+				contents, err := tq.ListContents(ctx, "typed_test")
+				if err != nil || len(contents[5]) != 1 || contents[5][0].ID != 1 {
+					t.Errorf("Expected low job at priority 5, got %+v, err %v", contents, err)
+				}
+				if err := tq.DeleteItem(ctx, "typed_test", job{ID: 1, Name: "low"}); err != nil {
+					t.Errorf("DeleteItem failed: %v", err)
+				}
+				if _, err := tq.Dequeue(ctx, "typed_test"); err == nil {
+					t.Error("Expected error dequeuing from empty typed queue")
+				}
+			})
+
+			t.Run("Webhooks", func(t *testing.T) {
+				var mutex sync.Mutex
+				var received []string
+				var failed int32
+				srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					body, _ := io.ReadAll(r.Body)
+					mac := hmac.New(sha256.New, []byte("s3cret"))
+					mac.Write(body)
+					if r.Header.Get(priorityqueue.WebhookSignatureHeader) != hex.EncodeToString(mac.Sum(nil)) {
+						t.Errorf("Bad signature on %s", body)
+					}
+					// Fail the first delivery to exercise retries
+					if atomic.AddInt32(&failed, 1) == 1 {
+						w.WriteHeader(http.StatusServiceUnavailable)
+						return
+					}
+					var event priorityqueue.WebhookEvent
+					json.Unmarshal(body, &event)
+					mutex.Lock()
+					received = append(received, event.Type)
+					mutex.Unlock()
+				}))
+				defer srv.Close()
+
+				wp := priorityqueue.NewWebhookPublisher(pq, "s3cret")
+				wp.SetRetries(2, 0)
+				wp.AddWebhook(srv.URL)
+				wp.SetDepthThreshold("webhook_test", 2)
+				wp.AddQueue(ctx, "webhook_test")
+
+				wp.Enqueue(ctx, "webhook_test", "a", 1)
+				wp.Enqueue(ctx, "webhook_test", "b", 1)
+				wp.Enqueue(ctx, "webhook_test", "c", 1)
+				wp.FreezeQueue(ctx, "webhook_test")
+				wp.UnfreezeQueue(ctx, "webhook_test")
+				wp.Wait()
+
+				sort.Strings(received)
+				expected := []string{
+					priorityqueue.EventDepthThreshold,
+					priorityqueue.EventQueueFrozen,
+					priorityqueue.EventQueueUnfrozen,
+				}
+				if !reflect.DeepEqual(received, expected) {
+					t.Errorf("Expected events %v, got %v", expected, received)
+				}
+
+				// Removals re-arm the threshold, even at depth 1
+				mutex.Lock()
+				received = nil
+				mutex.Unlock()
+				wp.SetDepthThreshold("webhook_rearm_test", 1)
+				wp.AddQueue(ctx, "webhook_rearm_test")
+				wp.Enqueue(ctx, "webhook_rearm_test", "a", 1)
+				wp.Dequeue(ctx, "webhook_rearm_test")
+				wp.EnqueueBatch(ctx, "webhook_rearm_test", []priorityqueue.Item{{Value: "b", Priority: 1}, {Value: "c", Priority: 1}})
+				wp.Wait()
+				if !reflect.DeepEqual(received, []string{priorityqueue.EventDepthThreshold, priorityqueue.EventDepthThreshold}) {
+					t.Errorf("Expected the threshold to fire on each refill, got %v", received)
+				}
+			})
+
+			t.Run("WebhookBackoffClock", func(t *testing.T) {
+				var attempts int32
+				srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					atomic.AddInt32(&attempts, 1)
+					w.WriteHeader(http.StatusServiceUnavailable)
+				}))
+				defer srv.Close()
+
+				awaitAttempts := func(n int32) {
+					deadline := time.Now().Add(time.Second)
+					for atomic.LoadInt32(&attempts) < n {
+						if time.Now().After(deadline) {
+							t.Fatalf("Expected %d delivery attempts, got %d", n, atomic.LoadInt32(&attempts))
+						}
+						time.Sleep(time.Millisecond)
+					}
+				}
+
+				var failures int32
+				clock := priorityqueue.NewVirtualClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+				wp := priorityqueue.NewWebhookPublisher(pq, "s3cret")
+				wp.SetClock(clock)
+				wp.SetRetries(1, time.Hour)
+				wp.SetErrorHandler(func(target string, event priorityqueue.WebhookEvent, err error) {
+					atomic.AddInt32(&failures, 1)
+				})
+				wp.AddWebhook(srv.URL)
+				wp.AddQueue(ctx, "webhook_retry_test")
+
+				// The retry waits for the virtual clock, not the wall clock
+				wp.FreezeQueue(ctx, "webhook_retry_test")
+				awaitAttempts(1)
+				awaitTimer := func() {
+					deadline := time.Now().Add(time.Second)
+					for clock.Pending() == 0 {
+						if time.Now().After(deadline) {
+							t.Fatalf("Expected the retry to wait on the clock")
+						}
+						time.Sleep(time.Millisecond)
+					}
+				}
+				awaitTimer()
+				clock.Advance(time.Hour)
+				wp.Wait()
+				if n := atomic.LoadInt32(&attempts); n != 2 {
+					t.Errorf("Expected a retry after the backoff, got %d attempts", n)
+				}
+
+				// Close cancels a delivery waiting out its backoff
+				wp.UnfreezeQueue(ctx, "webhook_retry_test")
+				awaitAttempts(3)
+				wp.Close()
+				if n := atomic.LoadInt32(&attempts); n != 3 {
+					t.Errorf("Expected Close to cancel the retry, got %d attempts", n)
+				}
+				if n := atomic.LoadInt32(&failures); n != 2 {
+					t.Errorf("Expected both failed deliveries to be reported, got %d", n)
+				}
+
+				// Events after Close are dropped
+				wp.FreezeQueue(ctx, "webhook_retry_test")
+				wp.Wait()
+				if n := atomic.LoadInt32(&attempts); n != 3 {
+					t.Errorf("Expected no delivery after Close, got %d attempts", n)
+				}
+			})
+
+			t.Run("SlackNotifier", func(t *testing.T) {
+				texts := make(chan string, 4)
+				srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					var msg map[string]string
+					json.NewDecoder(r.Body).Decode(&msg)
+					texts <- msg["text"]
+				}))
+				defer srv.Close()
+
+				wp := priorityqueue.NewWebhookPublisher(pq, "s3cret")
+				wp.AddNotifier(priorityqueue.NewSlackNotifier(srv.URL), "notify_test", priorityqueue.EventQueueFrozen)
+				wp.AddQueue(ctx, "notify_test")
+				wp.AddQueue(ctx, "notify_other_test")
+
+				wp.FreezeQueue(ctx, "notify_other_test")
+				wp.UnfreezeQueue(ctx, "notify_other_test")
+				wp.FreezeQueue(ctx, "notify_test")
+				wp.UnfreezeQueue(ctx, "notify_test")
+				wp.Wait()
+				close(texts)
+
+				var got []string
+				for text := range texts {
+					got = append(got, text)
+				}
+				if len(got) != 1 || got[0] != "queue 'notify_test' was frozen" {
+					t.Errorf("Expected one freeze notification for notify_test, got %q", got)
+				}
+			})
+
+			t.Run("Peek", func(t *testing.T) {
+				pq.AddQueue(ctx, "peek_test")
+				if _, err := pq.Peek(ctx, "peek_test"); err == nil {
+					t.Error("Expected error peeking an empty queue")
+				}
+				pq.Enqueue(ctx, "peek_test", "low", 7)
+				pq.Enqueue(ctx, "peek_test", "high", 2)
+				pq.Enqueue(ctx, "peek_test", "high2", 2)
+
+				for i := 0; i < 2; i++ {
+					head, err := pq.Peek(ctx, "peek_test")
+					if err != nil || head != "high" {
+						t.Errorf("Expected to peek 'high', got %v, err %v", head, err)
+					}
+				}
+				if value, _ := pq.Dequeue(ctx, "peek_test"); value != "high" {
+					t.Errorf("Expected Dequeue to return the peeked item, got %v", value)
+				}
+			})
+
+			t.Run("Pressure", func(t *testing.T) {
+				prq := priorityqueue.NewPressureQueue(pq)
+				clock := priorityqueue.NewVirtualClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+				prq.SetClock(clock)
+				clocked := pq.(interface{ SetClock(priorityqueue.Clock) })
+				clocked.SetClock(clock)
+				defer clocked.SetClock(priorityqueue.SystemClock{})
+
+				pq.AddQueue(ctx, "pressure_test")
+				if _, err := prq.Pressure(ctx, "pressure_test"); err == nil {
+					t.Error("Expected error for a queue without limits")
+				}
+				prq.SetPressureLimits("pressure_test", priorityqueue.PressureLimits{MaxDepth: 4, MaxGrowth: 1})
+
+				p, err := prq.EnqueueWithPressure(ctx, "pressure_test", "a", 1)
+				if err != nil || p != 0.25 {
+					t.Errorf("Expected pressure 0.25 from depth, got %v, err %v", p, err)
+				}
+				// Three more items in two seconds is 1.5 items/s against a limit of 1
+				clock.Advance(2 * time.Second)
+				for _, v := range []string{"b", "c", "d"} {
+					prq.Enqueue(ctx, "pressure_test", v, 1)
+				}
+				if p, _ := prq.Pressure(ctx, "pressure_test"); p != 1.5 {
+					t.Errorf("Expected pressure 1.5 from growth, got %v", p)
+				}
+			})
+
+			t.Run("SizeAndCountByPriority", func(t *testing.T) {
+				pq.AddQueue(ctx, "size_test")
+				if size, err := pq.Size(ctx, "size_test"); err != nil || size != 0 {
+					t.Errorf("Expected empty queue, got size %d, err %v", size, err)
+				}
+				pq.Enqueue(ctx, "size_test", "a", 0)
+				pq.Enqueue(ctx, "size_test", "b", 3)
+				pq.Enqueue(ctx, "size_test", "c", 3)
+				pq.Enqueue(ctx, "size_test", "d", 9)
+
+				if size, err := pq.Size(ctx, "size_test"); err != nil || size != 4 {
+					t.Errorf("Expected size 4, got %d, err %v", size, err)
+				}
+				counts, err := pq.CountByPriority(ctx, "size_test")
+				expected := map[int]int{0: 1, 3: 2, 9: 1}
+				if err != nil || !reflect.DeepEqual(counts, expected) {
+					t.Errorf("Expected counts %v, got %v, err %v", expected, counts, err)
+				}
+			})
+
+			t.Run("RemoveQueue", func(t *testing.T) {
+				if err := pq.RemoveQueue(ctx, "removequeue_test"); err == nil {
+					t.Error("Expected error removing a queue that doesn't exist")
+				}
+				pq.AddQueue(ctx, "removequeue_test")
+				pq.Enqueue(ctx, "removequeue_test", "a", 1)
+				pq.FreezeQueue(ctx, "removequeue_test")
+				if err := pq.RemoveQueue(ctx, "removequeue_test"); !errors.Is(err, priorityqueue.ErrQueueFrozen) {
+					t.Errorf("Expected ErrQueueFrozen, got %v", err)
+				}
+				pq.UnfreezeQueue(ctx, "removequeue_test")
+
+				if err := pq.RemoveQueue(ctx, "removequeue_test"); err != nil {
+					t.Fatalf("RemoveQueue failed: %v", err)
+				}
+				if err := pq.RemoveQueue(ctx, "removequeue_test"); err == nil {
+					t.Error("Expected error removing the queue twice")
+				}
+				if _, err := pq.Dequeue(ctx, "removequeue_test"); err == nil {
+					t.Error("Expected error dequeuing from a removed queue")
+				}
+			})
+
+			t.Run("VerifyQueue", func(t *testing.T) {
+				pq.AddQueue(ctx, "verify_test")
+				pq.Enqueue(ctx, "verify_test", "a", 1)
+				pq.InsertAtTop(ctx, "verify_test", "b", 1)
+				pq.Enqueue(ctx, "verify_test", "c", 4)
+				pq.Dequeue(ctx, "verify_test")
+				pq.DeleteItem(ctx, "verify_test", "c")
+
+				report, err := pq.VerifyQueue(ctx, "verify_test", false)
+				if err != nil || !report.OK() {
+					t.Fatalf("Expected a healthy queue, got %+v, err %v", report, err)
+				}
+
+				redisPQ, ok := pq.(*priorityqueue.RedisPriorityQueue)
+				if !ok {
+					return
+				}
+				client := redis.NewClient(&redis.Options{Addr: "localhost:6379", Password: "nBr3nJu6hn"})
+				defer client.Close()
+				redisPQ.SetBlobThreshold(4)
+				defer redisPQ.SetBlobThreshold(0)
+				pq.Enqueue(ctx, "verify_test", "a long value", 2)
+
+				// Break every invariant the checker knows about
+				client.ZAdd(ctx, "verify_test", redis.Z{Score: 3, Member: "legacy\x00" + fmt.Sprintf("%08x", crc32.ChecksumIEEE([]byte("legacy")))})
+				client.ZAdd(ctx, "verify_test", redis.Z{Score: 1 << 49, Member: "garbage\x00badcrc00"})
+				client.ZAdd(ctx, "pq:{verify_test}:enqueued", redis.Z{Score: 1, Member: "ghost"})
+				client.HSet(ctx, "pq:{verify_test}:blobs", "stray", "body")
+				client.Set(ctx, "pq:{verify_test}:seq", 0, 0)
+				client.HSet(ctx, "pq:{verify_test}:counters", "dequeued", 10)
+
+				report, err = pq.VerifyQueue(ctx, "verify_test", true)
+				if err != nil || len(report.Problems) != 8 || !report.Repaired {
+					t.Errorf("Expected 8 repaired problems, got %q, err %v", report.Problems, err)
+				}
+				report, err = pq.VerifyQueue(ctx, "verify_test", false)
+				if err != nil || !report.OK() {
+					t.Errorf("Expected the repaired queue to be healthy, got %q, err %v", report.Problems, err)
+				}
+				if q, _ := redisPQ.Quarantined(ctx, "verify_test"); len(q) != 1 {
+					t.Errorf("Expected the corrupt member to be quarantined, got %q", q)
+				}
+				contents, _ := pq.ListContents(ctx, "verify_test")
+				expected := map[int][]interface{}{1: {"a"}, 2: {"a long value"}, 3: {"legacy"}}
+				if !reflect.DeepEqual(contents, expected) {
+					t.Errorf("Expected %v after repair, got %v", expected, contents)
+				}
+			})
+
+			t.Run("ListQueues", func(t *testing.T) {
+				pq.AddQueue(ctx, "listqueues_a_test")
+				pq.AddQueue(ctx, "listqueues_b_test")
+				pq.Enqueue(ctx, "listqueues_b_test", "x", 1)
+				pq.RemoveQueue(ctx, "listqueues_a_test")
+
+				names, err := pq.ListQueues(ctx)
+				if err != nil {
+					t.Fatalf("ListQueues failed: %v", err)
+				}
+				if !sort.StringsAreSorted(names) {
+					t.Errorf("Expected sorted names, got %v", names)
+				}
+				var found []string
+				for _, name := range names {
+					if strings.HasPrefix(name, "listqueues_") {
+						found = append(found, name)
+					}
+				}
+				if !reflect.DeepEqual(found, []string{"listqueues_b_test"}) {
+					t.Errorf("Expected only listqueues_b_test to be listed, got %v", found)
+				}
+			})
+
+			t.Run("Purge", func(t *testing.T) {
+				pq.AddQueue(ctx, "purge_test")
+				pq.Enqueue(ctx, "purge_test", "a", 1)
+				pq.Enqueue(ctx, "purge_test", "b", 5)
+				pq.Dequeue(ctx, "purge_test")
+
+				if err := pq.Purge(ctx, "purge_test"); err != nil {
+					t.Fatalf("Purge failed: %v", err)
+				}
+				if empty, err := pq.IsEmpty(ctx, "purge_test"); err != nil || !empty {
+					t.Errorf("Expected purged queue to be empty, err %v", err)
+				}
+				if counters, _ := pq.Counters(ctx, "purge_test"); counters != (priorityqueue.QueueCounters{Enqueued: 2, Dequeued: 1}) {
+					t.Errorf("Expected counters to survive a purge, got %+v", counters)
+				}
+				if err := pq.Enqueue(ctx, "purge_test", "c", 1); err != nil {
+					t.Errorf("Expected the purged queue to stay usable, got %v", err)
+				}
+			})
+
+			t.Run("EnqueueBatch", func(t *testing.T) {
+				pq.AddQueue(ctx, "enqueuebatch_test")
+				pq.Enqueue(ctx, "enqueuebatch_test", "first", 3)
+
+				err := pq.EnqueueBatch(ctx, "enqueuebatch_test", []priorityqueue.Item{
+					{Value: "a", Priority: 3}, {Value: "bad", Priority: 10},
+				})
+				if err == nil {
+					t.Error("Expected error for a batch with an invalid priority")
+				}
+				err = pq.EnqueueBatch(ctx, "enqueuebatch_test", []priorityqueue.Item{
+					{Value: "a", Priority: 3}, {Value: "b", Priority: 0}, {Value: "c", Priority: 3},
+				})
+				if err != nil {
+					t.Fatalf("EnqueueBatch failed: %v", err)
+				}
+				contents, _ := pq.ListContents(ctx, "enqueuebatch_test")
+				expected := map[int][]interface{}{0: {"b"}, 3: {"first", "a", "c"}}
+				if !reflect.DeepEqual(contents, expected) {
+					t.Errorf("Expected %v, got %v", expected, contents)
+				}
+				if counters, _ := pq.Counters(ctx, "enqueuebatch_test"); counters.Enqueued != 4 {
+					t.Errorf("Expected 4 enqueued, got %+v", counters)
+				}
+			})
+
+			t.Run("SourceAndSink", func(t *testing.T) {
+				pq.AddQueue(ctx, "pipeline_test")
+				in := make(chan interface{}, 3)
+				in <- "low"
+				in <- "high"
+				in <- "mid"
+				close(in)
+				priorities := map[interface{}]int{"high": 0, "mid": 4, "low": 8}
+				err := priorityqueue.Sink(ctx, pq, "pipeline_test", in, func(v interface{}) int { return priorities[v] })
+				if err != nil {
+					t.Fatalf("Sink failed: %v", err)
+				}
+
+				stageCtx, cancel := context.WithCancel(ctx)
+				out := make(chan interface{})
+				done := make(chan error)
+				go func() { done <- priorityqueue.Source(stageCtx, pq, "pipeline_test", out, time.Millisecond) }()
+
+				var got []interface{}
+				for len(got) < 2 {
+					got = append(got, <-out)
+				}
+				cancel()
+				if err := <-done; !errors.Is(err, context.Canceled) {
+					t.Errorf("Expected Source to stop with context.Canceled, got %v", err)
+				}
+				if _, ok := <-out; ok {
+					t.Error("Expected Source to close its output")
+				}
+				if !reflect.DeepEqual(got, []interface{}{"high", "mid"}) {
+					t.Errorf("Expected [high mid], got %v", got)
+				}
+				// The item dequeued while cancelling must be back in the queue
+				if contents, _ := pq.ListContents(ctx, "pipeline_test"); !reflect.DeepEqual(contents, map[int][]interface{}{8: {"low"}}) {
+					t.Errorf("Expected 'low' to stay queued, got %v", contents)
+				}
+			})
+
+			t.Run("DequeueBatch", func(t *testing.T) {
+				pq.AddQueue(ctx, "dequeuebatch_test")
+				if _, err := pq.DequeueBatch(ctx, "dequeuebatch_test", 0); err == nil {
+					t.Error("Expected error for a zero batch size")
+				}
+				if _, err := pq.DequeueBatch(ctx, "dequeuebatch_test", 5); !errors.Is(err, priorityqueue.ErrQueueEmpty) {
+					t.Errorf("Expected ErrQueueEmpty, got %v", err)
+				}
+				pq.Enqueue(ctx, "dequeuebatch_test", "c", 5)
+				pq.Enqueue(ctx, "dequeuebatch_test", "a", 1)
+				pq.Enqueue(ctx, "dequeuebatch_test", "b", 1)
+				pq.Enqueue(ctx, "dequeuebatch_test", "d", 7)
+
+				values, err := pq.DequeueBatch(ctx, "dequeuebatch_test", 3)
+				if err != nil || !reflect.DeepEqual(values, []interface{}{"a", "b", "c"}) {
+					t.Errorf("Expected [a b c], got %v, err %v", values, err)
+				}
+				values, err = pq.DequeueBatch(ctx, "dequeuebatch_test", 3)
+				if err != nil || !reflect.DeepEqual(values, []interface{}{"d"}) {
+					t.Errorf("Expected [d], got %v, err %v", values, err)
+				}
+				if counters, _ := pq.Counters(ctx, "dequeuebatch_test"); counters.Dequeued != 4 {
+					t.Errorf("Expected 4 dequeued, got %+v", counters)
+				}
+			})
+
+			t.Run("UpdatePriority", func(t *testing.T) {
+				pq.AddQueue(ctx, "updatepriority_test")
+				pq.Enqueue(ctx, "updatepriority_test", "a", 5)
+				pq.Enqueue(ctx, "updatepriority_test", "b", 5)
+				pq.Enqueue(ctx, "updatepriority_test", "c", 2)
+				_, ageBefore, _ := pq.OldestItem(ctx, "updatepriority_test")
+
+				if err := pq.UpdatePriority(ctx, "updatepriority_test", "a", 2); err != nil {
+					t.Fatalf("UpdatePriority failed: %v", err)
+				}
+				if err := pq.UpdatePriority(ctx, "updatepriority_test", "b", 5); err != nil {
+					t.Errorf("UpdatePriority to the same level failed: %v", err)
+				}
+				if err := pq.UpdatePriority(ctx, "updatepriority_test", "missing", 1); err == nil {
+					t.Error("Expected error updating a missing item")
+				}
+				if err := pq.UpdatePriority(ctx, "updatepriority_test", "a", 10); err == nil {
+					t.Error("Expected error for an invalid priority")
+				}
+
+				contents, _ := pq.ListContents(ctx, "updatepriority_test")
+				expected := map[int][]interface{}{2: {"c", "a"}, 5: {"b"}}
+				if !reflect.DeepEqual(contents, expected) {
+					t.Errorf("Expected %v, got %v", expected, contents)
+				}
+				// The moved item keeps its enqueue time, so it is still the oldest
+				if oldest, age, _ := pq.OldestItem(ctx, "updatepriority_test"); oldest != "a" || age < ageBefore {
+					t.Errorf("Expected 'a' to stay the oldest item, got %v aged %v", oldest, age)
+				}
+			})
+
+			t.Run("MoveItem", func(t *testing.T) {
+				pq.AddQueue(ctx, "movesrc_test")
+				pq.AddQueue(ctx, "movedst_test")
+				pq.Enqueue(ctx, "movesrc_test", "a", 3)
+				pq.Enqueue(ctx, "movesrc_test", "b", 3)
+				pq.Enqueue(ctx, "movedst_test", "c", 3)
+				srcBefore, _ := pq.Counters(ctx, "movesrc_test")
+				dstBefore, _ := pq.Counters(ctx, "movedst_test")
+
+				if err := pq.MoveItem(ctx, "movesrc_test", "movedst_test", "a"); err != nil {
+					t.Fatalf("MoveItem failed: %v", err)
+				}
+				if err := pq.MoveItem(ctx, "movesrc_test", "movedst_test", "missing"); err == nil {
+					t.Error("Expected error moving a missing item")
+				}
+				if err := pq.MoveItem(ctx, "movesrc_test", "movesrc_test", "b"); err == nil {
+					t.Error("Expected error moving an item within one queue")
+				}
+
+				src, _ := pq.ListContents(ctx, "movesrc_test")
+				if expected := map[int][]interface{}{3: {"b"}}; !reflect.DeepEqual(src, expected) {
+					t.Errorf("Expected source %v, got %v", expected, src)
+				}
+				dst, _ := pq.ListContents(ctx, "movedst_test")
+				if expected := map[int][]interface{}{3: {"c", "a"}}; !reflect.DeepEqual(dst, expected) {
+					t.Errorf("Expected destination %v, got %v", expected, dst)
+				}
+
+				srcAfter, _ := pq.Counters(ctx, "movesrc_test")
+				dstAfter, _ := pq.Counters(ctx, "movedst_test")
+				if srcAfter.Dequeued != srcBefore.Dequeued+1 || dstAfter.Enqueued != dstBefore.Enqueued+1 {
+					t.Errorf("Expected the move to count as one dequeue and one enqueue, got %+v and %+v", srcAfter, dstAfter)
+				}
+			})
+
+			t.Run("RenameQueue", func(t *testing.T) {
+				pq.AddQueue(ctx, "renameold_test")
+				pq.AddQueue(ctx, "renametaken_test")
+				pq.Enqueue(ctx, "renameold_test", "a", 1)
+				pq.Enqueue(ctx, "renameold_test", "b", 4)
+				pq.Enqueue(ctx, "renametaken_test", "c", 1)
+
+				if err := pq.RenameQueue(ctx, "renameold_test", "renametaken_test"); err == nil {
+					t.Error("Expected error renaming onto an existing queue")
+				}
+				if err := pq.RenameQueue(ctx, "renameold_test", "renamenew_test"); err != nil {
+					t.Fatalf("RenameQueue failed: %v", err)
+				}
+				if err := pq.RenameQueue(ctx, "renameold_test", "renameother_test"); err == nil {
+					t.Error("Expected error renaming a queue that no longer exists")
+				}
+
+				contents, _ := pq.ListContents(ctx, "renamenew_test")
+				expected := map[int][]interface{}{1: {"a"}, 4: {"b"}}
+				if !reflect.DeepEqual(contents, expected) {
+					t.Errorf("Expected %v, got %v", expected, contents)
+				}
+				if counters, _ := pq.Counters(ctx, "renamenew_test"); counters.Enqueued != 2 {
+					t.Errorf("Expected counters to follow the queue, got %+v", counters)
+				}
+				queues, _ := pq.ListQueues(ctx)
+				for _, name := range queues {
+					if name == "renameold_test" {
+						t.Error("Expected the old name to be gone")
+					}
+				}
+				if taken, _ := pq.ListContents(ctx, "renametaken_test"); !reflect.DeepEqual(taken, map[int][]interface{}{1: {"c"}}) {
+					t.Errorf("Expected the existing queue untouched, got %v", taken)
+				}
+			})
+
+			t.Run("FIFOWithinPriority", func(t *testing.T) {
+				pq.AddQueue(ctx, "fifo_test")
+				for _, value := range []string{"zulu", "mike", "alpha"} {
+					pq.Enqueue(ctx, "fifo_test", value, 3)
+				}
+				pq.Enqueue(ctx, "fifo_test", "yankee", 1)
+				pq.Enqueue(ctx, "fifo_test", "bravo", 1)
+
+				var got []interface{}
+				for {
+					value, err := pq.Dequeue(ctx, "fifo_test")
+					if err != nil {
+						break
+					}
+					got = append(got, value)
+				}
+				// Payloads sort differently from insertion order, so only FIFO
+				// ordering produces this sequence
+				expected := []interface{}{"yankee", "bravo", "zulu", "mike", "alpha"}
+				if !reflect.DeepEqual(got, expected) {
+					t.Errorf("Expected FIFO order within each level %v, got %v", expected, got)
+				}
+			})
+
+			t.Run("DuplicateValues", func(t *testing.T) {
+				pq.AddQueue(ctx, "dupvalues_test")
+				pq.Enqueue(ctx, "dupvalues_test", "job-A", 2)
+				pq.Enqueue(ctx, "dupvalues_test", "job-B", 2)
+				pq.Enqueue(ctx, "dupvalues_test", "job-A", 2)
+				pq.Enqueue(ctx, "dupvalues_test", "job-A", 4)
+
+				if size, _ := pq.Size(ctx, "dupvalues_test"); size != 4 {
+					t.Errorf("Expected every duplicate kept, got size %d", size)
+				}
+				groups, _ := priorityqueue.FindDuplicates(ctx, pq, "dupvalues_test", nil)
+				if len(groups) != 1 || len(groups[0].Items) != 3 {
+					t.Errorf("Expected one group of three copies, got %+v", groups)
+				}
+
+				if err := pq.DeleteItem(ctx, "dupvalues_test", "job-A"); err != nil {
+					t.Fatalf("DeleteItem failed: %v", err)
+				}
+				if err := pq.UpdatePriority(ctx, "dupvalues_test", "job-A", 4); err != nil {
+					t.Fatalf("UpdatePriority failed: %v", err)
+				}
+				contents, _ := pq.ListContents(ctx, "dupvalues_test")
+				expected := map[int][]interface{}{2: {"job-B"}, 4: {"job-A", "job-A"}}
+				if !reflect.DeepEqual(contents, expected) {
+					t.Errorf("Expected each call to affect only the first copy %v, got %v", expected, contents)
+				}
+			})
+
+			t.Run("DuplicateBlobs", func(t *testing.T) {
+				redisPQ, ok := pq.(*priorityqueue.RedisPriorityQueue)
+				if !ok {
+					t.Skip("Blob offloading is specific to the Redis backend")
+				}
+				redisPQ.SetBlobThreshold(16)
+				defer redisPQ.SetBlobThreshold(0)
+
+				large := strings.Repeat("y", 64)
+				pq.Enqueue(ctx, "dupblobs_test", large, 1)
+				pq.Enqueue(ctx, "dupblobs_test", large, 1)
+				for i := 0; i < 2; i++ {
+					if item, err := pq.Dequeue(ctx, "dupblobs_test"); err != nil || item != large {
+						t.Errorf("Expected copy %d of the offloaded value, got %v, err: %v", i+1, item, err)
+					}
+				}
+			})
+
+			t.Run("WaitEmpty", func(t *testing.T) {
+				pq.AddQueue(ctx, "waitempty_test")
+				pq.Enqueue(ctx, "waitempty_test", "a", 1)
+
+				short, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+				defer cancel()
+				if err := priorityqueue.WaitEmpty(short, pq, "waitempty_test", time.Millisecond, 0); !errors.Is(err, context.DeadlineExceeded) {
+					t.Errorf("Expected WaitEmpty to time out on a busy queue, got %v", err)
+				}
+
+				// The debounce runs on a virtual clock, so nothing fires until
+				// the test advances it, however slowly the polls run
+				probe := &emptyProbe{PriorityQueuer: pq, empty: make(chan bool, 1)}
+				clock := priorityqueue.NewVirtualClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+				watcher := priorityqueue.NewEmptyWatcher(probe, time.Millisecond, time.Minute)
+				watcher.SetClock(clock)
+
+				watchCtx, stop := context.WithCancel(ctx)
+				fired := make(chan string, 4)
+				done := make(chan error)
+				go func() {
+					done <- watcher.OnQueueEmpty(watchCtx, "waitempty_test", func(name string) {
+						fired <- name
+					})
+				}()
+
+				for round := 0; round < 2; round++ {
+					if round > 0 {
+						pq.Enqueue(ctx, "waitempty_test", "b", 1)
+						probe.await(t, false)
+					}
+					pq.Dequeue(ctx, "waitempty_test")
+					// The second empty poll comes after the first was recorded
+					probe.await(t, true)
+					probe.await(t, true)
+					if n := len(fired); n != 0 {
+						t.Fatalf("Expected no hook inside the debounce window in round %d, got %d", round+1, n)
+					}
+
+					clock.Advance(time.Minute)
+					select {
+					case name := <-fired:
+						if name != "waitempty_test" {
+							t.Errorf("Expected the hook for waitempty_test, got %s", name)
+						}
+					case <-time.After(time.Second):
+						t.Fatalf("Expected the hook to fire in round %d", round+1)
+					}
+				}
+
+				clock.Advance(time.Minute)
+				probe.await(t, true)
+				probe.await(t, true)
+				stop()
+				if err := <-done; !errors.Is(err, context.Canceled) {
+					t.Errorf("Expected OnQueueEmpty to stop with context.Canceled, got %v", err)
+				}
+				if n := len(fired); n != 0 {
+					t.Errorf("Expected one call per emptying, got %d extra", n)
+				}
+			})
+
+			t.Run("ItemIDs", func(t *testing.T) {
+				pq.AddQueue(ctx, "itemids_test")
+				first, err := pq.EnqueueWithID(ctx, "itemids_test", "job", 3)
+				if err != nil {
+					t.Fatalf("EnqueueWithID failed: %v", err)
+				}
+				second, _ := pq.EnqueueWithID(ctx, "itemids_test", "job", 3)
+				third, _ := pq.EnqueueWithID(ctx, "itemids_test", "other", 6)
+				if first == "" || first == second {
+					t.Fatalf("Expected distinct ids, got %q and %q", first, second)
+				}
+
+				item, err := pq.GetByID(ctx, "itemids_test", second)
+				if err != nil || item.ID != second || item.Value != "job" || item.Priority != 3 || item.EnqueuedAt.IsZero() {
+					t.Errorf("GetByID returned %+v, err %v", item, err)
+				}
+				if _, err := pq.GetByID(ctx, "itemids_test", "0123456789abcdef"); err == nil {
+					t.Error("Expected error for an unknown id")
+				}
+
+				if err := pq.UpdateByID(ctx, "itemids_test", first, "job-v2"); err != nil {
+					t.Fatalf("UpdateByID failed: %v", err)
+				}
+				if err := pq.DeleteByID(ctx, "itemids_test", second); err != nil {
+					t.Fatalf("DeleteByID failed: %v", err)
+				}
+				if err := pq.DeleteByID(ctx, "itemids_test", second); err == nil {
+					t.Error("Expected error deleting an id twice")
+				}
+
+				contents, _ := pq.ListContents(ctx, "itemids_test")
+				expected := map[int][]interface{}{3: {"job-v2"}, 6: {"other"}}
+				if !reflect.DeepEqual(contents, expected) {
+					t.Errorf("Expected %v, got %v", expected, contents)
+				}
+				if item, _ := pq.GetByID(ctx, "itemids_test", third); item.Value != "other" {
+					t.Errorf("Expected the untouched item by id, got %+v", item)
+				}
+
+				redisPQ, ok := pq.(*priorityqueue.RedisPriorityQueue)
+				if !ok {
+					return
+				}
+				redisPQ.SetBlobThreshold(16)
+				defer redisPQ.SetBlobThreshold(0)
+				large := strings.Repeat("z", 64)
+				if err := pq.UpdateByID(ctx, "itemids_test", third, large); err != nil {
+					t.Fatalf("UpdateByID to an offloaded value failed: %v", err)
+				}
+				if item, err := pq.GetByID(ctx, "itemids_test", third); err != nil || item.Value != large || item.Priority != 6 {
+					t.Errorf("Expected the offloaded value by id, got %+v, err %v", item, err)
+				}
+			})
+
+			t.Run("Codecs", func(t *testing.T) {
+				type point struct{ X, Y int }
+				type shape struct {
+					Name   string
+					Labels map[point]string
+				}
+				tq := priorityqueue.NewTypedQueue[shape](pq)
+				tq.AddQueue(ctx, "codec_gob_test")
+				tq.AddQueue(ctx, "codec_json_test")
+				tq.SetCodec("codec_gob_test", priorityqueue.GobCodec{})
+
+				want := shape{Name: "grid", Labels: map[point]string{{1, 2}: "a"}}
+				if err := tq.Enqueue(ctx, "codec_gob_test", want, 1); err != nil {
+					t.Fatalf("Enqueue with gob failed: %v", err)
+				}
+				if got, err := tq.Dequeue(ctx, "codec_gob_test"); err != nil || !reflect.DeepEqual(got, want) {
+					t.Errorf("Expected %+v through gob, got %+v, err %v", want, got, err)
+				}
+				// JSON can't encode maps keyed by structs
+				if err := tq.Enqueue(ctx, "codec_json_test", want, 1); err == nil {
+					t.Error("Expected the default JSON codec to reject struct map keys")
+				}
+			})
+
+			t.Run("Histogram", func(t *testing.T) {
+				if redisPQ, ok := pq.(*priorityqueue.RedisPriorityQueue); ok {
+					redisPQ.SetBlobThreshold(16)
+					defer redisPQ.SetBlobThreshold(0)
+				}
+				pq.AddQueue(ctx, "histogram_test")
+				pq.Enqueue(ctx, "histogram_test", "ab", 1)
+				pq.Enqueue(ctx, "histogram_test", "abcdefgh", 1)
+				pq.Enqueue(ctx, "histogram_test", strings.Repeat("x", 20), 7)
+
+				bounds := priorityqueue.HistogramBounds{Sizes: []int{4, 16, 32}, Ages: []time.Duration{time.Hour}}
+				hist, err := pq.Histogram(ctx, "histogram_test", bounds)
+				if err != nil {
+					t.Fatalf("Histogram failed: %v", err)
+				}
+				expected := priorityqueue.QueueHistogram{
+					Priorities: map[int]int64{1: 2, 7: 1},
+					Sizes:      []int64{1, 1, 1, 0},
+					Ages:       []int64{3, 0},
+				}
+				// On Redis the 20 byte value is offloaded, and must be sized by
+				// its body rather than its much longer reference
+				if !reflect.DeepEqual(hist, expected) {
+					t.Errorf("Expected %+v, got %+v", expected, hist)
+				}
+
+				bounds.Sizes = []int{16, 4}
+				if _, err := pq.Histogram(ctx, "histogram_test", bounds); err == nil {
+					t.Error("Expected error for descending bounds")
+				}
+
+				if _, ok := pq.(*priorityqueue.RedisPriorityQueue); ok {
+					// Members written before checksums and before item ids
+					client := redis.NewClient(&redis.Options{Addr: "localhost:6379", Password: "nBr3nJu6hn"})
+					defer client.Close()
+					sum := fmt.Sprintf("%08x", crc32.ChecksumIEEE([]byte("abc")))
+					client.ZAdd(ctx, "histogram_legacy_test", redis.Z{Score: 2, Member: "abcdefghij"}, redis.Z{Score: 3, Member: "abc\x00" + sum})
+
+					bounds.Sizes = []int{4, 16}
+					hist, err := pq.Histogram(ctx, "histogram_legacy_test", bounds)
+					if err != nil {
+						t.Fatalf("Histogram of legacy members failed: %v", err)
+					}
+					if !reflect.DeepEqual(hist.Sizes, []int64{1, 1, 0}) {
+						t.Errorf("Legacy members should be sized by their payload, got %v", hist.Sizes)
+					}
+				}
+			})
+
+			t.Run("DequeueInto", func(t *testing.T) {
+				type job struct {
+					ID   int
+					Tags []string
+				}
+				type jobRef struct {
+					ID int
+				}
+				tq := priorityqueue.NewTypedQueue[job](pq)
+				tq.AddQueue(ctx, "dequeueinto_test")
+				tq.SetCodec("dequeueinto_test", priorityqueue.GobCodec{})
+				tq.Enqueue(ctx, "dequeueinto_test", job{ID: 1, Tags: []string{"a"}}, 2)
+				tq.Enqueue(ctx, "dequeueinto_test", job{ID: 2}, 3)
+				pq.Enqueue(ctx, "dequeueinto_test", `{"ID": 3}`, 5)
+
+				var got job
+				if err := tq.DequeueInto(ctx, "dequeueinto_test", got); err == nil {
+					t.Error("Expected error for a non-pointer destination")
+				}
+				if err := tq.DequeueInto(ctx, "dequeueinto_test", &got); err != nil {
+					t.Fatalf("DequeueInto failed: %v", err)
+				}
+				if !reflect.DeepEqual(got, job{ID: 1, Tags: []string{"a"}}) {
+					t.Errorf("Expected job 1, got %+v", got)
+				}
+				var ref jobRef
+				if err := tq.DequeueInto(ctx, "dequeueinto_test", &ref); err != nil || ref.ID != 2 {
+					t.Errorf("Expected job 2 decoded into another type, got %+v, err: %v", ref, err)
+				}
+				if err := tq.DequeueInto(ctx, "dequeueinto_test", &got); err == nil {
+					t.Error("Expected error decoding a JSON item with the queue's gob codec")
+				}
+				if err := tq.DequeueInto(ctx, "dequeueinto_test", &got); !errors.Is(err, priorityqueue.ErrQueueEmpty) {
+					t.Errorf("Expected ErrQueueEmpty, got %v", err)
+				}
+			})
+
+			t.Run("ItemIDsOnEveryInsert", func(t *testing.T) {
+				pq.AddQueue(ctx, "itemid_paths_test")
+				pq.Enqueue(ctx, "itemid_paths_test", "enqueued", 2)
+				pq.InsertAtTop(ctx, "itemid_paths_test", "top", 2)
+				pq.InsertAtTopBatch(ctx, "itemid_paths_test", []interface{}{"topbatch"}, 3)
+				pq.EnqueueBatch(ctx, "itemid_paths_test", []priorityqueue.Item{{Value: "batch", Priority: 4}})
+				pq.EnqueueMulti(ctx, []priorityqueue.QueueEntry{{QueueName: "itemid_paths_test", Value: "multi", Priority: 5}})
+				pq.EnqueueFanout(ctx, []string{"itemid_paths_test"}, "fanout", 6)
+
+				// Learn every item's id without removing anything
+				ids := make(map[string]string)
+				pq.DequeueWhere(ctx, "itemid_paths_test", func(item priorityqueue.Item) bool {
+					ids[fmt.Sprint(item.Value)] = item.ID
+					return false
+				})
+				for _, value := range []string{"enqueued", "top", "topbatch", "batch", "multi", "fanout"} {
+					id := ids[value]
+					if id == "" {
+						t.Errorf("Item %q has no id", value)
+						continue
+					}
+					item, err := pq.GetByID(ctx, "itemid_paths_test", id)
+					if err != nil || item.Value != value || item.ID != id {
+						t.Errorf("GetByID(%s) should return %q, got %+v, err: %v", id, value, item, err)
+					}
+				}
+
+				if err := pq.UpdateByID(ctx, "itemid_paths_test", ids["enqueued"], "updated"); err != nil {
+					t.Errorf("UpdateByID of an enqueued item failed: %v", err)
+				}
+				if err := pq.DeleteByID(ctx, "itemid_paths_test", ids["top"]); err != nil {
+					t.Errorf("DeleteByID of an inserted item failed: %v", err)
+				}
+				if item, err := pq.Dequeue(ctx, "itemid_paths_test"); err != nil || item != "updated" {
+					t.Errorf("Expected 'updated' at the head, got %v, err: %v", item, err)
+				}
+				if _, err := pq.GetByID(ctx, "itemid_paths_test", ids["top"]); err == nil {
+					t.Error("GetByID of a deleted item should fail")
+				}
+			})
+		})
+	}
+}
+
+func TestSimulation(t *testing.T) {
+	trace := []priorityqueue.TraceEvent{
+		{At: 0, Priority: 9},
+		{At: 0, Priority: 0},
+		{At: 0, Priority: 0},
+		{At: 10 * time.Second, Priority: 9},
+	}
+
+	stats, err := priorityqueue.Simulation{Trace: trace, ServiceTime: time.Second}.Run()
+	if err != nil {
+		t.Fatalf("Simulation failed: %v", err)
+	}
+	if s := stats[0]; s.Count != 2 || s.Mean != 500*time.Millisecond || s.Max != time.Second {
+		t.Errorf("Strict policy wrong waits for priority 0: %+v", s)
+	}
+	if s := stats[9]; s.Count != 2 || s.Max != 2*time.Second || s.P50 != 0 {
+		t.Errorf("Strict policy wrong waits for priority 9: %+v", s)
+	}
+
+	weights := make([]float64, 10)
+	weights[9] = 1
+	stats, err = priorityqueue.Simulation{
+		Trace:       trace,
+		Policy:      priorityqueue.WeightedPolicy{Weights: weights},
+		ServiceTime: time.Second,
+	}.Run()
+	if err != nil {
+		t.Fatalf("Simulation failed: %v", err)
+	}
+	if s := stats[9]; s.Max != 0 {
+		t.Errorf("Weighted policy should serve priority 9 immediately: %+v", s)
+	}
+
+	stats, err = priorityqueue.Simulation{Trace: trace, Workers: 3, ServiceTime: time.Second}.Run()
+	if err != nil {
+		t.Fatalf("Simulation failed: %v", err)
+	}
+	if stats[0].Max != 0 || stats[9].Max != 0 {
+		t.Errorf("Three workers should serve every item on arrival: %+v", stats)
+	}
+
+	deep := append(trace, priorityqueue.TraceEvent{At: 0, Priority: 15})
+	if _, err := (priorityqueue.Simulation{Trace: deep, ServiceTime: time.Second}).Run(); err == nil {
+		t.Error("Expected error for a priority beyond the default levels")
+	}
+	stats, err = priorityqueue.Simulation{Trace: deep, ServiceTime: time.Second, Levels: 20}.Run()
+	if err != nil || stats[15].Count != 1 {
+		t.Errorf("Expected priority 15 to be served with 20 levels, got %+v, err: %v", stats, err)
+	}
+	if _, err := (priorityqueue.Simulation{Trace: trace, Levels: 5}).Run(); err == nil {
+		t.Error("Expected error for a priority beyond 5 levels")
+	}
+}
+
+func TestMockQueue(t *testing.T) {
+	ctx := context.Background()
+	mock := &mocks.PriorityQueuer{
+		DequeueFunc: func(ctx context.Context, queueName string) (interface{}, error) {
+			return "from mock", nil
+		},
+	}
+	vq := priorityqueue.NewValidatingQueue(mock)
+	vq.SetValidator("mock_test", func(value interface{}, priority int) error {
+		if priority > 5 {
+			return fmt.Errorf("too low")
+		}
+		return nil
+	})
+
+	vq.Enqueue(ctx, "mock_test", "rejected", 7)
+	vq.Enqueue(ctx, "mock_test", "accepted", 2)
+	if value, err := vq.Dequeue(ctx, "mock_test"); err != nil || value != "from mock" {
+		t.Errorf("Expected the mock's value, got %v, err %v", value, err)
+	}
+
+	calls := mock.Calls("Enqueue")
+	expected := []mocks.Call{{Method: "Enqueue", Args: []interface{}{"mock_test", "accepted", 2}}}
+	if !reflect.DeepEqual(calls, expected) {
+		t.Errorf("Expected only the valid item to reach the backend, got %+v", calls)
+	}
+	if n := len(mock.Calls()); n != 2 {
+		t.Errorf("Expected 2 recorded calls, got %d", n)
+	}
+}
+
+func TestObserverQueue(t *testing.T) {
+	ctx := context.Background()
+	pq := priorityqueue.NewMultiPriorityQueue()
+	pq.AddQueue(ctx, "observer_test")
+	pq.Enqueue(ctx, "observer_test", "a", 2)
+	var oq priorityqueue.PriorityQueuer = priorityqueue.NewObserverQueue(pq)
+
+	if value, err := oq.Peek(ctx, "observer_test"); err != nil || value != "a" {
+		t.Errorf("Expected to peek 'a', got %v, err %v", value, err)
+	}
+	if counters, err := oq.Counters(ctx, "observer_test"); err != nil || counters.Enqueued != 1 {
+		t.Errorf("Expected counters to pass through, got %+v, err %v", counters, err)
+	}
+	if _, err := oq.Dequeue(ctx, "observer_test"); !errors.Is(err, priorityqueue.ErrReadOnly) {
+		t.Errorf("Expected ErrReadOnly from Dequeue, got %v", err)
+	}
+	if err := oq.Enqueue(ctx, "observer_test", "b", 1); !errors.Is(err, priorityqueue.ErrReadOnly) {
+		t.Errorf("Expected ErrReadOnly from Enqueue, got %v", err)
+	}
+	if _, err := oq.VerifyQueue(ctx, "observer_test", true); !errors.Is(err, priorityqueue.ErrReadOnly) {
+		t.Errorf("Expected ErrReadOnly from a repair, got %v", err)
+	}
+	if contents, _ := pq.ListContents(ctx, "observer_test"); !reflect.DeepEqual(contents, map[int][]interface{}{2: {"a"}}) {
+		t.Errorf("Expected the queue untouched, got %v", contents)
+	}
+}
+
+func TestRedisACLUser(t *testing.T) {
+	ctx := context.Background()
+	pq := priorityqueue.NewRedisPriorityQueueWithUser("localhost:6379", "default", "nBr3nJu6hn", 0)
+	defer pq.RemoveQueue(ctx, "acl_test")
+
+	pq.Enqueue(ctx, "acl_test", "a", 1)
+	if value, err := pq.Dequeue(ctx, "acl_test"); err != nil || value != "a" {
+		t.Errorf("Expected 'a' as an ACL user, got %v, err %v", value, err)
+	}
+
+	rule := priorityqueue.RedisACLRule("acl_*")
+	for _, part := range []string{"-@all", "+zadd", "+evalsha", "~pq:*", "~acl_*"} {
+		if !strings.Contains(rule, part) {
+			t.Errorf("Expected %q in rule %q", part, rule)
+		}
+	}
+	if strings.Contains(rule, "+flushall") || strings.Contains(rule, "+@all") {
+		t.Errorf("Rule grants more than the queue needs: %q", rule)
+	}
+}
+
+func TestLevels(t *testing.T) {
+	ctx := context.Background()
+	pqs := []struct {
+		name string
+		pq   priorityqueue.PriorityQueuer
+	}{
+		{"SlicePQ", priorityqueue.NewMultiPriorityQueue()},
+		{"RedisPQ", priorityqueue.NewRedisPriorityQueue("localhost:6379", "nBr3nJu6hn", 0)},
+	}
+
+	for _, tc := range pqs {
+		t.Run(tc.name, func(t *testing.T) {
+			pq := tc.pq
+			defer pq.RemoveQueue(ctx, "levels_test")
+
+			leveled := pq.(interface{ SetLevels(int) error })
+			if err := leveled.SetLevels(0); err == nil {
+				t.Error("Expected error for zero levels")
+			}
+			if err := leveled.SetLevels(20); err != nil {
+				t.Fatalf("SetLevels failed: %v", err)
+			}
+			if n := pq.Levels(); n != 20 {
+				t.Errorf("Expected 20 levels, got %d", n)
+			}
+
+			pq.AddQueue(ctx, "levels_test")
+			if err := pq.Enqueue(ctx, "levels_test", "low", 19); err != nil {
+				t.Fatalf("Enqueue at the last level failed: %v", err)
+			}
+			pq.Enqueue(ctx, "levels_test", "high", 12)
+			if err := pq.Enqueue(ctx, "levels_test", "out", 20); err == nil {
+				t.Error("Expected error beyond the last level")
+			}
+
+			counts, _ := pq.CountByPriority(ctx, "levels_test")
+			if !reflect.DeepEqual(counts, map[int]int{12: 1, 19: 1}) {
+				t.Errorf("Expected counts at levels 12 and 19, got %v", counts)
+			}
+			for _, expected := range []string{"high", "low"} {
+				if value, err := pq.Dequeue(ctx, "levels_test"); err != nil || value != expected {
+					t.Errorf("Expected %v, got %v, err %v", expected, value, err)
+				}
+			}
+			if err := pq.SetPriorityWeights(ctx, "levels_test", make([]float64, 10)); err == nil {
+				t.Error("Expected error for weights not covering every level")
+			}
+		})
+	}
+
+	if err := priorityqueue.NewMultiPriorityQueue().(*priorityqueue.MultiPriorityQueue).SetLevels(priorityqueue.MaxRedisLevels + 1); err != nil {
+		t.Errorf("Expected the memory backend to allow more levels than Redis: %v", err)
+	}
+	redisPQ := priorityqueue.NewRedisPriorityQueue("localhost:6379", "nBr3nJu6hn", 0).(*priorityqueue.RedisPriorityQueue)
+	if err := redisPQ.SetLevels(priorityqueue.MaxRedisLevels + 1); err == nil {
+		t.Error("Expected error beyond MaxRedisLevels")
+	}
+
+	weighted := []struct {
+		name string
+		pq   priorityqueue.PriorityQueuer
+	}{
+		{"SlicePQ", priorityqueue.NewMultiPriorityQueue()},
+		{"RedisPQ", priorityqueue.NewRedisPriorityQueue("localhost:6379", "nBr3nJu6hn", 0)},
+	}
+	for _, tc := range weighted {
+		t.Run(tc.name+"/RaiseAfterWeights", func(t *testing.T) {
+			pq := tc.pq
+			defer pq.RemoveQueue(ctx, "levels_weights_test")
+
+			pq.AddQueue(ctx, "levels_weights_test")
+			weights := make([]float64, priorityqueue.DefaultLevels)
+			weights[0] = 1
+			if err := pq.SetPriorityWeights(ctx, "levels_weights_test", weights); err != nil {
+				t.Fatalf("SetPriorityWeights failed: %v", err)
+			}
+			leveled := pq.(interface{ SetLevels(int) error })
+			if err := leveled.SetLevels(20); err == nil {
+				t.Error("Expected error raising levels after setting weights")
+			}
+			if n := pq.Levels(); n != priorityqueue.DefaultLevels {
+				t.Errorf("Expected %d levels, got %d", priorityqueue.DefaultLevels, n)
+			}
+
+			pq.Enqueue(ctx, "levels_weights_test", "last", priorityqueue.DefaultLevels-1)
+			if value, err := pq.Dequeue(ctx, "levels_weights_test"); err != nil || value != "last" {
+				t.Errorf("Expected last, got %v, err %v", value, err)
+			}
 		})
 	}
+
+	if err := redisPQ.SetPriorityWeights(ctx, "levels_weights_test", nil); err != nil {
+		t.Fatalf("Clearing weights failed: %v", err)
+	}
+	if err := redisPQ.SetLevels(20); err != nil {
+		t.Errorf("Expected SetLevels to succeed without weights: %v", err)
+	}
+
+	// A client with fewer levels leaves the higher ones alone
+	narrow := priorityqueue.NewRedisPriorityQueue("localhost:6379", "nBr3nJu6hn", 0).(*priorityqueue.RedisPriorityQueue)
+	defer redisPQ.RemoveQueue(ctx, "levels_narrow_test")
+	redisPQ.Enqueue(ctx, "levels_narrow_test", "wide", 15)
+	redisPQ.Enqueue(ctx, "levels_narrow_test", "a", 2)
+	redisPQ.Enqueue(ctx, "levels_narrow_test", "b", 3)
+	if value, err := narrow.Peek(ctx, "levels_narrow_test"); err != nil || value != "a" {
+		t.Errorf("Expected Peek to return a, got %v, err %v", value, err)
+	}
+	if values, err := narrow.DequeueBatch(ctx, "levels_narrow_test", 10); err != nil || !reflect.DeepEqual(values, []interface{}{"a", "b"}) {
+		t.Errorf("Expected DequeueBatch to stop at the last level, got %v, err %v", values, err)
+	}
+	if value, err := narrow.Dequeue(ctx, "levels_narrow_test"); err == nil {
+		t.Errorf("Expected Dequeue to skip levels above the last, got %v", value)
+	}
+	if values, err := narrow.DequeueLevel(ctx, "levels_narrow_test"); err == nil {
+		t.Errorf("Expected DequeueLevel to skip levels above the last, got %v", values)
+	}
+	if _, err := narrow.Peek(ctx, "levels_narrow_test"); err == nil {
+		t.Error("Expected Peek to skip levels above the last")
+	}
+	report, err := narrow.VerifyQueue(ctx, "levels_narrow_test", true)
+	if err != nil || report.OK() {
+		t.Errorf("Expected VerifyQueue to report the item above the last level, got %+v, err %v", report, err)
+	}
+	if value, err := redisPQ.Dequeue(ctx, "levels_narrow_test"); err != nil || value != "wide" {
+		t.Errorf("Expected the wider client to still serve its item, got %v, err %v", value, err)
+	}
+}
+
+// blockingPeek holds the first PeekPriority until released, so tests can
+// act while a peek is in flight
+type blockingPeek struct {
+	priorityqueue.PriorityQueuer
+	entered chan struct{}
+	release chan struct{}
+	once    sync.Once
+}
+
+func (b *blockingPeek) PeekPriority(ctx context.Context, queueName string, priority int) (interface{}, error) {
+	b.once.Do(func() {
+		close(b.entered)
+		<-b.release
+	})
+	return b.PriorityQueuer.PeekPriority(ctx, queueName, priority)
+}
+
+// lastItemPolicy picks the newest item of the lowest non-empty level
+type lastItemPolicy struct{}
+
+func (lastItemPolicy) NextCandidate(state priorityqueue.SchedulingState) (int, int) {
+	for priority := len(state.Levels) - 1; priority >= 0; priority-- {
+		if n := len(state.Levels[priority]); n > 0 {
+			return priority, n - 1
+		}
+	}
+	return -1, -1
+}
+
+// emptyProbe reports every IsEmpty result, so tests can wait for a watcher
+// to observe a queue state instead of sleeping
+type emptyProbe struct {
+	priorityqueue.PriorityQueuer
+	empty chan bool
+}
+
+func (p *emptyProbe) IsEmpty(ctx context.Context, queueName string) (bool, error) {
+	empty, err := p.PriorityQueuer.IsEmpty(ctx, queueName)
+	if err == nil {
+		select {
+		case p.empty <- empty:
+		default:
+		}
+	}
+	return empty, err
+}
+
+// await drains stale results and waits for a poll that returns want
+func (p *emptyProbe) await(t *testing.T, want bool) {
+	t.Helper()
+	select {
+	case <-p.empty:
+	default:
+	}
+	deadline := time.After(time.Second)
+	for {
+		select {
+		case got := <-p.empty:
+			if got == want {
+				return
+			}
+		case <-deadline:
+			t.Fatalf("Expected a poll returning empty=%v", want)
+		}
+	}
 }
 
 func BenchmarkEnqueue(b *testing.B) {
+	ctx := context.Background()
+	pqs := []struct {
+		name string
+		pq   priorityqueue.PriorityQueuer
+	}{
+		{"SlicePQ", priorityqueue.NewMultiPriorityQueue()},
+		{"RedisPQ", priorityqueue.NewRedisPriorityQueue("localhost:6379", "", 0)},
+	}
+
+	for _, pq := range pqs {
+		b.Run(pq.name, func(b *testing.B) {
+			// Cleanup for RedisPQ before benchmark
+			if redisPQ, ok := pq.pq.(*priorityqueue.RedisPriorityQueue); ok {
+				err := redisPQ.ClearQueues(ctx, "bench_enqueue_test")
+				if err != nil {
+					b.Fatalf("Failed to clear Redis queue: %v", err)
+				}
+			}
+
+			pq.pq.AddQueue(ctx, "bench_enqueue_test")
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				pq.pq.Enqueue(ctx, "bench_enqueue_test", fmt.Sprintf("item%d", i), i%10)
+			}
+		})
+	}
+}
+
+func BenchmarkEnqueueBatch(b *testing.B) {
+	ctx := context.Background()
 	pqs := []struct {
 		name string
 		pq   priorityqueue.PriorityQueuer
@@ -268,22 +3448,28 @@ func BenchmarkEnqueue(b *testing.B) {
 		b.Run(pq.name, func(b *testing.B) {
 			// Cleanup for RedisPQ before benchmark
 			if redisPQ, ok := pq.pq.(*priorityqueue.RedisPriorityQueue); ok {
-				err := redisPQ.ClearQueues("bench_enqueue_test")
+				err := redisPQ.ClearQueues(ctx, "bench_enqueuebatch_test")
 				if err != nil {
 					b.Fatalf("Failed to clear Redis queue: %v", err)
 				}
 			}
 
-			pq.pq.AddQueue("bench_enqueue_test")
+			pq.pq.AddQueue(ctx, "bench_enqueuebatch_test")
+			batch := make([]priorityqueue.Item, 0, 1000)
 			b.ResetTimer()
 			for i := 0; i < b.N; i++ {
-				pq.pq.Enqueue("bench_enqueue_test", fmt.Sprintf("item%d", i), i%10)
+				batch = append(batch, priorityqueue.Item{Value: fmt.Sprintf("item%d", i), Priority: i % 10})
+				if len(batch) == cap(batch) || i == b.N-1 {
+					pq.pq.EnqueueBatch(ctx, "bench_enqueuebatch_test", batch)
+					batch = batch[:0]
+				}
 			}
 		})
 	}
 }
 
 func BenchmarkDequeue(b *testing.B) {
+	ctx := context.Background()
 	pqs := []struct {
 		name string
 		pq   priorityqueue.PriorityQueuer
@@ -296,24 +3482,68 @@ func BenchmarkDequeue(b *testing.B) {
 		b.Run(pq.name, func(b *testing.B) {
 			// Cleanup for RedisPQ before benchmark
 			if redisPQ, ok := pq.pq.(*priorityqueue.RedisPriorityQueue); ok {
-				err := redisPQ.ClearQueues("bench_dequeue_test")
+				err := redisPQ.ClearQueues(ctx, "bench_dequeue_test")
 				if err != nil {
 					b.Fatalf("Failed to clear Redis queue: %v", err)
 				}
 			}
 
-			pq.pq.AddQueue("bench_dequeue_test")
+			pq.pq.AddQueue(ctx, "bench_dequeue_test")
 			for i := 0; i < 1000; i++ {
 				if i%2 == 0 {
-					pq.pq.Enqueue("bench_dequeue_test", fmt.Sprintf("item%d", i), i%10)
+					pq.pq.Enqueue(ctx, "bench_dequeue_test", fmt.Sprintf("item%d", i), i%10)
 				} else {
-					pq.pq.InsertAtTop("bench_dequeue_test", fmt.Sprintf("item%d", i), i%10)
+					pq.pq.InsertAtTop(ctx, "bench_dequeue_test", fmt.Sprintf("item%d", i), i%10)
 				}
 			}
 			b.ResetTimer()
 			for i := 0; i < b.N; i++ {
-				pq.pq.Dequeue("bench_dequeue_test")
+				pq.pq.Dequeue(ctx, "bench_dequeue_test")
 			}
 		})
 	}
 }
+
+func BenchmarkMultiQueueEnqueue(b *testing.B) {
+	ctx := context.Background()
+	pqs := []struct {
+		name string
+		pq   priorityqueue.PriorityQueuer
+	}{
+		{"SlicePQ", priorityqueue.NewMultiPriorityQueue()},
+		{"RedisPQ", priorityqueue.NewRedisPriorityQueue("localhost:6379", "", 0)},
+	}
+
+	for _, pq := range pqs {
+		for _, queues := range []int{1, 8} {
+			b.Run(fmt.Sprintf("%s/%dqueues", pq.name, queues), func(b *testing.B) {
+				names := make([]string, queues)
+				for i := range names {
+					names[i] = fmt.Sprintf("bench_multiqueue_test_%d", i)
+				}
+				// Cleanup for RedisPQ before benchmark
+				if redisPQ, ok := pq.pq.(*priorityqueue.RedisPriorityQueue); ok {
+					err := redisPQ.ClearQueues(ctx, names...)
+					if err != nil {
+						b.Fatalf("Failed to clear Redis queues: %v", err)
+					}
+				}
+				for _, name := range names {
+					pq.pq.AddQueue(ctx, name)
+				}
+
+				var next int64
+				b.ResetTimer()
+				b.RunParallel(func(pb *testing.PB) {
+					// Spread goroutines across queues so each mostly works alone
+					name := names[int(atomic.AddInt64(&next, 1))%queues]
+					i := 0
+					for pb.Next() {
+						pq.pq.Enqueue(ctx, name, fmt.Sprintf("item%d", i), i%10)
+						i++
+					}
+				})
+			})
+		}
+	}
+}
@@ -0,0 +1,309 @@
+package priorityqueue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Broadcaster gives every registered consumer group its own copy of each item
+// published to a broadcast queue, so independent processors (for example a
+// primary worker pool and an auditor) each see every item exactly once.
+//
+// A broadcast queue's state lives in queues of the wrapped backend, under the
+// reserved "broadcast:" prefix, so on Redis it survives restarts and is
+// shared by every process using the server:
+//
+//	broadcast:groups:<queue>         the registered group names
+//	broadcast:log:<queue>            items retained for replay
+//	broadcast:group:<queue>/<group>  a group's private queue
+//
+// Group names can't contain '/', and broadcast queue names can't start with
+// the prefix, so these names never collide with each other. Groups added with
+// AddGroup receive the items published after they joined; AddGroupWithReplay
+// also delivers what the queue's log retains.
+type Broadcaster struct {
+	pq        PriorityQueuer
+	retention map[string]int
+	mutex     sync.RWMutex
+}
+
+// broadcastPrefix starts the name of every queue a Broadcaster manages
+const broadcastPrefix = "broadcast:"
+
+// NewBroadcaster creates a broadcaster on top of pq
+func NewBroadcaster(pq PriorityQueuer) *Broadcaster {
+	return &Broadcaster{
+		pq:        pq,
+		retention: make(map[string]int),
+	}
+}
+
+// SetRetention keeps the last n items published at each priority level of a
+// broadcast queue in its log, for AddGroupWithReplay. Retention is counted per
+// level so a flood of low-priority items can't push urgent ones out. Zero
+// stops logging and deletes the log. The setting belongs to this Broadcaster,
+// so every process publishing to the queue should use the same value.
+func (b *Broadcaster) SetRetention(ctx context.Context, queueName string, n int) error {
+	if err := checkBroadcastName(queueName); err != nil {
+		return err
+	}
+	if n < 0 {
+		return fmt.Errorf("retention must not be negative")
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if n == 0 {
+		delete(b.retention, queueName)
+		exists, err := b.exists(ctx, logQueueName(queueName))
+		if err != nil || !exists {
+			return err
+		}
+		return b.pq.RemoveQueue(ctx, logQueueName(queueName))
+	}
+	if err := b.ensureQueue(ctx, logQueueName(queueName)); err != nil {
+		return err
+	}
+	b.retention[queueName] = n
+	return nil
+}
+
+// AddGroup registers a consumer group on a broadcast queue and creates the
+// group's private queue. The group receives items published from now on.
+func (b *Broadcaster) AddGroup(ctx context.Context, queueName, group string) error {
+	return b.addGroup(ctx, queueName, group, false)
+}
+
+// AddGroupWithReplay registers a consumer group like AddGroup and first
+// delivers every item the queue's log retains. Publishes through this
+// Broadcaster wait for the replay, so no item is missed or delivered twice;
+// one published by another process meanwhile may be delivered twice.
+func (b *Broadcaster) AddGroupWithReplay(ctx context.Context, queueName, group string) error {
+	return b.addGroup(ctx, queueName, group, true)
+}
+
+func (b *Broadcaster) addGroup(ctx context.Context, queueName, group string, replay bool) error {
+	if err := checkBroadcastName(queueName); err != nil {
+		return err
+	}
+	if group == "" || strings.Contains(group, "/") {
+		return fmt.Errorf("group name must not be empty or contain '/'")
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	groups, err := b.groups(ctx, queueName)
+	if err != nil {
+		return err
+	}
+	for _, existing := range groups {
+		if existing == group {
+			return fmt.Errorf("group '%s' already exists on queue '%s'", group, queueName)
+		}
+	}
+	private := groupQueueName(queueName, group)
+	exists, err := b.exists(ctx, private)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return fmt.Errorf("queue '%s' already exists", private)
+	}
+
+	var entries []QueueEntry
+	if replay {
+		if entries, err = b.retained(ctx, queueName, private); err != nil {
+			return err
+		}
+	}
+	if err := b.ensureQueue(ctx, groupsQueueName(queueName)); err != nil {
+		return err
+	}
+	if err := b.pq.AddQueue(ctx, private); err != nil {
+		return err
+	}
+	if len(entries) > 0 {
+		err = b.pq.EnqueueMulti(ctx, entries)
+	}
+	if err == nil {
+		err = b.pq.Enqueue(ctx, groupsQueueName(queueName), group, 0)
+	}
+	if err != nil {
+		b.pq.RemoveQueue(ctx, private)
+		return err
+	}
+	return nil
+}
+
+// retained returns the items of a queue's log as entries for target, in
+// delivery order
+func (b *Broadcaster) retained(ctx context.Context, queueName, target string) ([]QueueEntry, error) {
+	contents, err := b.pq.ListContents(ctx, logQueueName(queueName))
+	if err != nil {
+		if exists, existsErr := b.exists(ctx, logQueueName(queueName)); existsErr == nil && !exists {
+			return nil, nil
+		}
+		return nil, err
+	}
+	priorities := make([]int, 0, len(contents))
+	for priority := range contents {
+		priorities = append(priorities, priority)
+	}
+	sort.Ints(priorities)
+
+	var entries []QueueEntry
+	for _, priority := range priorities {
+		for _, value := range contents[priority] {
+			entries = append(entries, QueueEntry{QueueName: target, Value: value, Priority: priority})
+		}
+	}
+	return entries, nil
+}
+
+// Groups returns the consumer groups registered on a broadcast queue
+func (b *Broadcaster) Groups(ctx context.Context, queueName string) ([]string, error) {
+	return b.groups(ctx, queueName)
+}
+
+// groups reads a queue's group registry. A group registered twice by
+// racing processes is reported once.
+func (b *Broadcaster) groups(ctx context.Context, queueName string) ([]string, error) {
+	contents, err := b.pq.ListContents(ctx, groupsQueueName(queueName))
+	if err != nil {
+		if exists, existsErr := b.exists(ctx, groupsQueueName(queueName)); existsErr == nil && !exists {
+			return nil, nil
+		}
+		return nil, err
+	}
+	seen := make(map[string]bool)
+	var groups []string
+	for _, value := range contents[0] {
+		group := fmt.Sprint(value)
+		if !seen[group] {
+			seen[group] = true
+			groups = append(groups, group)
+		}
+	}
+	sort.Strings(groups)
+	return groups, nil
+}
+
+// Publish delivers a copy of value to every group registered on queueName,
+// and to the queue's log when it has a retention, in one EnqueueFanout
+func (b *Broadcaster) Publish(ctx context.Context, queueName string, value interface{}, priority int) error {
+	if err := checkBroadcastName(queueName); err != nil {
+		return err
+	}
+
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+
+	groups, err := b.groups(ctx, queueName)
+	if err != nil {
+		return err
+	}
+	targets := make([]string, 0, len(groups)+1)
+	for _, group := range groups {
+		targets = append(targets, groupQueueName(queueName, group))
+	}
+	retain := b.retention[queueName]
+	if retain > 0 {
+		targets = append(targets, logQueueName(queueName))
+	}
+
+	if len(targets) == 0 {
+		return fmt.Errorf("queue '%s' has no consumer groups", queueName)
+	}
+	if err := b.pq.EnqueueFanout(ctx, targets, value, priority); err != nil {
+		return err
+	}
+	if retain > 0 {
+		return b.trimLog(ctx, queueName, priority, retain)
+	}
+	return nil
+}
+
+// trimLog drops the oldest items of a log level beyond the retention
+func (b *Broadcaster) trimLog(ctx context.Context, queueName string, priority, retain int) error {
+	for {
+		n, err := b.pq.LevelLen(ctx, logQueueName(queueName), priority)
+		if err != nil {
+			return err
+		}
+		if n <= int64(retain) {
+			return nil
+		}
+		_, err = b.pq.DequeueFromPriority(ctx, logQueueName(queueName), priority)
+		if errors.Is(err, ErrQueueEmpty) {
+			return nil // Trimmed by another publisher
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// Dequeue returns the next item for a consumer group
+func (b *Broadcaster) Dequeue(ctx context.Context, queueName, group string) (interface{}, error) {
+	groups, err := b.groups(ctx, queueName)
+	if err != nil {
+		return nil, err
+	}
+	i := sort.SearchStrings(groups, group)
+	if i == len(groups) || groups[i] != group {
+		return nil, fmt.Errorf("group '%s' does not exist on queue '%s'", group, queueName)
+	}
+	return b.pq.Dequeue(ctx, groupQueueName(queueName, group))
+}
+
+// exists reports whether the backend lists a queue
+func (b *Broadcaster) exists(ctx context.Context, name string) (bool, error) {
+	queues, err := b.pq.ListQueues(ctx)
+	if err != nil {
+		return false, err
+	}
+	for _, queue := range queues {
+		if queue == name {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// ensureQueue adds a queue unless it already exists
+func (b *Broadcaster) ensureQueue(ctx context.Context, name string) error {
+	exists, err := b.exists(ctx, name)
+	if err != nil || exists {
+		return err
+	}
+	return b.pq.AddQueue(ctx, name)
+}
+
+// checkBroadcastName rejects broadcast queue names in the reserved namespace
+func checkBroadcastName(queueName string) error {
+	if strings.HasPrefix(queueName, broadcastPrefix) {
+		return fmt.Errorf("queue name '%s' is reserved for broadcast queues", queueName)
+	}
+	return nil
+}
+
+// groupsQueueName names the queue registering a broadcast queue's groups
+func groupsQueueName(queueName string) string {
+	return broadcastPrefix + "groups:" + queueName
+}
+
+// logQueueName names the queue retaining a broadcast queue's items
+func logQueueName(queueName string) string {
+	return broadcastPrefix + "log:" + queueName
+}
+
+// groupQueueName names the private queue backing a consumer group
+func groupQueueName(queueName, group string) string {
+	return broadcastPrefix + "group:" + queueName + "/" + group
+}
@@ -0,0 +1,117 @@
+package priorityqueue
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Clock supplies the current time for enqueue timestamps, ages, caches and
+// rate windows. Tests can swap in a VirtualClock to control time exactly.
+type Clock interface {
+	Now() time.Time
+}
+
+// TimerClock is a Clock that can also wake a waiter after a delay. Waits on
+// a Clock without After, such as retry backoff, fall back to the system
+// timer.
+type TimerClock interface {
+	Clock
+	After(d time.Duration) <-chan time.Time
+}
+
+// waitOn blocks for d as measured by clock, or until ctx is done
+func waitOn(ctx context.Context, clock Clock, d time.Duration) error {
+	var after <-chan time.Time
+	if timer, ok := clock.(TimerClock); ok {
+		after = timer.After(d)
+	} else {
+		after = time.After(d)
+	}
+	select {
+	case <-after:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// SystemClock reads the system clock. It is the default Clock.
+type SystemClock struct{}
+
+func (SystemClock) Now() time.Time {
+	return time.Now()
+}
+
+// After waits on the system timer, like time.After
+func (SystemClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+// VirtualClock is a Clock that only moves when told to, for deterministic
+// tests of time-dependent behaviour without sleeps
+type VirtualClock struct {
+	now    time.Time
+	timers []virtualTimer
+	mutex  sync.Mutex
+}
+
+// virtualTimer is a pending After on a VirtualClock
+type virtualTimer struct {
+	at time.Time
+	ch chan time.Time
+}
+
+// NewVirtualClock creates a virtual clock reading start
+func NewVirtualClock(start time.Time) *VirtualClock {
+	return &VirtualClock{now: start}
+}
+
+// Now returns the clock's current virtual time
+func (c *VirtualClock) Now() time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.now
+}
+
+// After returns a channel that receives the virtual time once Advance has
+// moved the clock d past now. A non-positive d fires at once.
+func (c *VirtualClock) After(d time.Duration) <-chan time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	ch := make(chan time.Time, 1)
+	if d <= 0 {
+		ch <- c.now
+		return ch
+	}
+	c.timers = append(c.timers, virtualTimer{at: c.now.Add(d), ch: ch})
+	return ch
+}
+
+// Pending returns how many After timers are waiting for the clock to reach
+// them, so tests can advance only once a waiter has started waiting
+func (c *VirtualClock) Pending() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return len(c.timers)
+}
+
+// Advance moves the clock forward by d, firing the timers it passes
+func (c *VirtualClock) Advance(d time.Duration) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.now = c.now.Add(d)
+	pending := c.timers[:0]
+	for _, timer := range c.timers {
+		if timer.at.After(c.now) {
+			pending = append(pending, timer)
+		} else {
+			timer.ch <- c.now
+		}
+	}
+	c.timers = pending
+}
@@ -0,0 +1,54 @@
+package priorityqueue
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/gob"
+	"encoding/json"
+)
+
+// Codec turns values into the strings a queue stores and back. Other formats,
+// such as msgpack, plug in by implementing it. Codecs are applied by
+// TypedQueue alone, since decoding needs the item's type: the backends and
+// the other wrappers store values as given, which the Redis backend does with
+// fmt's %v. Structs and maps round-trip through Redis only via a TypedQueue.
+type Codec interface {
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+// JSONCodec encodes values as JSON. It is the default codec.
+type JSONCodec struct{}
+
+func (JSONCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (JSONCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+// GobCodec encodes values with encoding/gob, which keeps Go types that JSON
+// can't, such as maps with struct keys. The gob stream is base64 encoded, so
+// stored items stay printable in listings. Interface values inside T must be
+// registered with gob.Register.
+type GobCodec struct{}
+
+func (GobCodec) Marshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+	out := make([]byte, base64.StdEncoding.EncodedLen(buf.Len()))
+	base64.StdEncoding.Encode(out, buf.Bytes())
+	return out, nil
+}
+
+func (GobCodec) Unmarshal(data []byte, v interface{}) error {
+	raw := make([]byte, base64.StdEncoding.DecodedLen(len(data)))
+	n, err := base64.StdEncoding.Decode(raw, data)
+	if err != nil {
+		return err
+	}
+	return gob.NewDecoder(bytes.NewReader(raw[:n])).Decode(v)
+}
@@ -0,0 +1,303 @@
+package priorityqueue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// CostBudget caps the total cost of items dequeued from a queue within each
+// fixed Window. Cost reports how many units an item consumes.
+type CostBudget struct {
+	Limit  int
+	Window time.Duration
+	Cost   func(value interface{}) int
+}
+
+// ErrCostBudgetExceeded is returned when the head of a queue costs more than
+// what is left of the queue's budget for the current window
+var ErrCostBudgetExceeded = errors.New("cost budget exceeded")
+
+// CostBudgetQueue wraps a PriorityQueuer and meters Dequeue,
+// DequeueFromPriority, DequeueBatch and moves out of a queue with MoveItem
+// against a per-queue cost budget, so
+// heavy items use up more of a downstream rate limit than light ones. Items
+// are still taken strictly in priority order: a head that doesn't fit blocks
+// the queue until the next window. A head costing more than the whole budget
+// is let through at the start of a window so it can't block the queue
+// forever. DequeueLevel, DequeueWhere and DequeueFresh can't cost an item
+// before removing it, so they fail on budgeted queues.
+type CostBudgetQueue struct {
+	PriorityQueuer
+	budgets map[string]CostBudget
+	windows map[string]*quotaWindow
+	locks   map[string]*sync.Mutex
+	clock   Clock
+	mutex   sync.Mutex
+}
+
+// NewCostBudgetQueue wraps pq with no budgets configured
+func NewCostBudgetQueue(pq PriorityQueuer) *CostBudgetQueue {
+	return &CostBudgetQueue{
+		PriorityQueuer: pq,
+		budgets:        make(map[string]CostBudget),
+		windows:        make(map[string]*quotaWindow),
+		locks:          make(map[string]*sync.Mutex),
+		clock:          SystemClock{},
+	}
+}
+
+// SetClock replaces the time source used for budget windows. It must be
+// called before the queue is shared between goroutines.
+func (cq *CostBudgetQueue) SetClock(clock Clock) {
+	cq.clock = clock
+}
+
+// SetCostBudget configures a queue's budget. A zero budget removes it.
+func (cq *CostBudgetQueue) SetCostBudget(queueName string, budget CostBudget) error {
+	if budget.Limit != 0 || budget.Window != 0 || budget.Cost != nil {
+		if budget.Limit <= 0 || budget.Window <= 0 || budget.Cost == nil {
+			return fmt.Errorf("cost budget needs a positive limit and window and a cost function")
+		}
+	}
+
+	cq.mutex.Lock()
+	defer cq.mutex.Unlock()
+
+	delete(cq.windows, queueName)
+	if budget.Cost == nil {
+		delete(cq.budgets, queueName)
+	} else {
+		cq.budgets[queueName] = budget
+	}
+	return nil
+}
+
+// Remaining returns how many cost units are left in a queue's current window,
+// or -1 if the queue has no budget
+func (cq *CostBudgetQueue) Remaining(queueName string) int {
+	cq.mutex.Lock()
+	defer cq.mutex.Unlock()
+
+	budget, ok := cq.budgets[queueName]
+	if !ok {
+		return -1
+	}
+	return budget.Limit - cq.window(queueName, budget).used
+}
+
+// window returns a queue's current budget window, starting a new one when the
+// previous has ended. The caller must hold the mutex.
+func (cq *CostBudgetQueue) window(queueName string, budget CostBudget) *quotaWindow {
+	now := cq.clock.Now()
+	window, ok := cq.windows[queueName]
+	if !ok || now.Sub(window.start) >= budget.Window {
+		window = &quotaWindow{start: now}
+		cq.windows[queueName] = window
+	}
+	return window
+}
+
+// budget returns a queue's budget, if it has one
+func (cq *CostBudgetQueue) budget(queueName string) (CostBudget, bool) {
+	cq.mutex.Lock()
+	defer cq.mutex.Unlock()
+
+	budget, ok := cq.budgets[queueName]
+	return budget, ok
+}
+
+// queueLock returns the mutex serializing metered removals from a queue
+func (cq *CostBudgetQueue) queueLock(queueName string) *sync.Mutex {
+	cq.mutex.Lock()
+	defer cq.mutex.Unlock()
+
+	lock, ok := cq.locks[queueName]
+	if !ok {
+		lock = &sync.Mutex{}
+		cq.locks[queueName] = lock
+	}
+	return lock
+}
+
+// fits fails with ErrCostBudgetExceeded if cost doesn't fit in what is left
+// of the queue's budget for the current window
+func (cq *CostBudgetQueue) fits(queueName string, budget CostBudget, cost int) error {
+	cq.mutex.Lock()
+	defer cq.mutex.Unlock()
+
+	window := cq.window(queueName, budget)
+	if window.used > 0 && window.used+cost > budget.Limit {
+		return fmt.Errorf("%w: queue '%s' has %d units left, item costs %d",
+			ErrCostBudgetExceeded, queueName, budget.Limit-window.used, cost)
+	}
+	return nil
+}
+
+// charge counts cost against the queue's current window
+func (cq *CostBudgetQueue) charge(queueName string, budget CostBudget, cost int) {
+	cq.mutex.Lock()
+	defer cq.mutex.Unlock()
+
+	cq.window(queueName, budget).used += cost
+}
+
+// Dequeue removes the head of the queue if its cost fits in the budget left
+// for the current window. The head is found by peeking each level, so custom
+// scheduling policies and priority weights are bypassed for budgeted queues.
+// The queue's lock is held from peek to removal, so consumers sharing this
+// wrapper can't race each other; other clients of a Redis queue still can.
+// Queues without a budget are passed straight through.
+func (cq *CostBudgetQueue) Dequeue(ctx context.Context, queueName string) (interface{}, error) {
+	budget, ok := cq.budget(queueName)
+	if !ok {
+		return cq.PriorityQueuer.Dequeue(ctx, queueName)
+	}
+
+	lock := cq.queueLock(queueName)
+	lock.Lock()
+	defer lock.Unlock()
+
+	return cq.dequeueHead(ctx, queueName, budget)
+}
+
+// dequeueHead removes the head of the highest non-empty level if it fits the
+// budget. The caller must hold the queue's lock.
+func (cq *CostBudgetQueue) dequeueHead(ctx context.Context, queueName string, budget CostBudget) (interface{}, error) {
+	for priority := 0; priority < cq.PriorityQueuer.Levels(); priority++ {
+		value, err := cq.dequeueLevelHead(ctx, queueName, priority, budget)
+		if errors.Is(err, ErrQueueEmpty) {
+			continue
+		}
+		return value, err
+	}
+	// Let the backend report why there is nothing to dequeue
+	return cq.PriorityQueuer.Dequeue(ctx, queueName)
+}
+
+// dequeueLevelHead removes the head of one level if it fits the budget. The
+// caller must hold the queue's lock.
+func (cq *CostBudgetQueue) dequeueLevelHead(ctx context.Context, queueName string, priority int, budget CostBudget) (interface{}, error) {
+	head, err := cq.PriorityQueuer.PeekPriority(ctx, queueName, priority)
+	if err != nil {
+		return nil, err
+	}
+	cost := budget.Cost(head)
+	if err := cq.fits(queueName, budget, cost); err != nil {
+		return nil, err
+	}
+	value, err := cq.PriorityQueuer.DequeueFromPriority(ctx, queueName, priority)
+	if err != nil {
+		return nil, err
+	}
+	cq.charge(queueName, budget, cost)
+	return value, nil
+}
+
+// DequeueFromPriority removes the head of a level if its cost fits in the
+// budget left for the current window
+func (cq *CostBudgetQueue) DequeueFromPriority(ctx context.Context, queueName string, priority int) (interface{}, error) {
+	budget, ok := cq.budget(queueName)
+	if !ok {
+		return cq.PriorityQueuer.DequeueFromPriority(ctx, queueName, priority)
+	}
+
+	lock := cq.queueLock(queueName)
+	lock.Lock()
+	defer lock.Unlock()
+
+	return cq.dequeueLevelHead(ctx, queueName, priority, budget)
+}
+
+// DequeueBatch removes up to n items in strict priority order, stopping early
+// at the first head that doesn't fit the budget. The budget error is only
+// returned when nothing could be taken.
+func (cq *CostBudgetQueue) DequeueBatch(ctx context.Context, queueName string, n int) ([]interface{}, error) {
+	budget, ok := cq.budget(queueName)
+	if !ok {
+		return cq.PriorityQueuer.DequeueBatch(ctx, queueName, n)
+	}
+
+	lock := cq.queueLock(queueName)
+	lock.Lock()
+	defer lock.Unlock()
+
+	if n <= 0 {
+		return nil, fmt.Errorf("batch size must be positive")
+	}
+
+	var values []interface{}
+	for len(values) < n {
+		value, err := cq.dequeueHead(ctx, queueName, budget)
+		if err != nil {
+			if len(values) > 0 && (errors.Is(err, ErrCostBudgetExceeded) || errors.Is(err, ErrQueueEmpty)) {
+				break
+			}
+			return values, err
+		}
+		values = append(values, value)
+	}
+	return values, nil
+}
+
+// unmeteredError reports a dequeue whose items can't be costed before they
+// are removed
+func unmeteredError(op, queueName string) error {
+	return fmt.Errorf("queue '%s' has a cost budget, %s can't be metered", queueName, op)
+}
+
+// budgeted reports whether a queue has a budget
+func (cq *CostBudgetQueue) budgeted(queueName string) bool {
+	_, ok := cq.budget(queueName)
+	return ok
+}
+
+// DequeueLevel is refused for budgeted queues
+func (cq *CostBudgetQueue) DequeueLevel(ctx context.Context, queueName string) ([]interface{}, error) {
+	if cq.budgeted(queueName) {
+		return nil, unmeteredError("DequeueLevel", queueName)
+	}
+	return cq.PriorityQueuer.DequeueLevel(ctx, queueName)
+}
+
+// DequeueWhere is refused for budgeted queues
+func (cq *CostBudgetQueue) DequeueWhere(ctx context.Context, queueName string, pred func(Item) bool) (interface{}, error) {
+	if cq.budgeted(queueName) {
+		return nil, unmeteredError("DequeueWhere", queueName)
+	}
+	return cq.PriorityQueuer.DequeueWhere(ctx, queueName, pred)
+}
+
+// DequeueFresh is refused for budgeted queues
+func (cq *CostBudgetQueue) DequeueFresh(ctx context.Context, queueName string, maxAge time.Duration, expire bool) (interface{}, error) {
+	if cq.budgeted(queueName) {
+		return nil, unmeteredError("DequeueFresh", queueName)
+	}
+	return cq.PriorityQueuer.DequeueFresh(ctx, queueName, maxAge, expire)
+}
+
+// MoveItem charges an item moved out of a budgeted queue like a dequeue,
+// refusing the move if the item's cost doesn't fit in the budget left for
+// the current window
+func (cq *CostBudgetQueue) MoveItem(ctx context.Context, srcQueue, dstQueue string, value interface{}) error {
+	budget, ok := cq.budget(srcQueue)
+	if !ok {
+		return cq.PriorityQueuer.MoveItem(ctx, srcQueue, dstQueue, value)
+	}
+
+	lock := cq.queueLock(srcQueue)
+	lock.Lock()
+	defer lock.Unlock()
+
+	cost := budget.Cost(value)
+	if err := cq.fits(srcQueue, budget, cost); err != nil {
+		return err
+	}
+	if err := cq.PriorityQueuer.MoveItem(ctx, srcQueue, dstQueue, value); err != nil {
+		return err
+	}
+	cq.charge(srcQueue, budget, cost)
+	return nil
+}
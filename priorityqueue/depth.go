@@ -0,0 +1,65 @@
+package priorityqueue
+
+import (
+	"sync"
+	"time"
+)
+
+// QueueDepth is a cheap summary of a queue's size and backlog age, meant for
+// monitoring hot paths
+type QueueDepth struct {
+	Len             int64
+	ApproxOldestAge time.Duration
+}
+
+// depthCacheTTL is how long FastLen results are reused before the backend is
+// asked again
+const depthCacheTTL = time.Second
+
+// depthSamples is how many random items the memory backend inspects, on top
+// of each level's head, when estimating the oldest item's age
+const depthSamples = 16
+
+// depthCache remembers recent FastLen results per queue
+type depthCache struct {
+	entries map[string]depthEntry
+	mutex   sync.Mutex
+}
+
+type depthEntry struct {
+	depth     QueueDepth
+	fetchedAt time.Time
+}
+
+func newDepthCache() *depthCache {
+	return &depthCache{entries: make(map[string]depthEntry)}
+}
+
+// get returns the cached depth for a queue, calling fetch when the entry is
+// missing or older than depthCacheTTL at now
+func (c *depthCache) get(queueName string, now time.Time, fetch func() (QueueDepth, error)) (QueueDepth, error) {
+	c.mutex.Lock()
+	entry, ok := c.entries[queueName]
+	c.mutex.Unlock()
+
+	if ok && now.Sub(entry.fetchedAt) < depthCacheTTL {
+		return entry.depth, nil
+	}
+
+	depth, err := fetch()
+	if err != nil {
+		return QueueDepth{}, err
+	}
+
+	c.mutex.Lock()
+	c.entries[queueName] = depthEntry{depth: depth, fetchedAt: now}
+	c.mutex.Unlock()
+	return depth, nil
+}
+
+// forget drops a queue's cached depth
+func (c *depthCache) forget(queueName string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	delete(c.entries, queueName)
+}
@@ -0,0 +1,59 @@
+package priorityqueue
+
+import (
+	"context"
+	"fmt"
+)
+
+// ItemPosition locates one item within a queue
+type ItemPosition struct {
+	Priority int
+	Position int // index within the priority level
+	Value    interface{}
+}
+
+// DuplicateGroup is a set of items sharing the same dedup key, in queue order
+type DuplicateGroup struct {
+	Key   string
+	Items []ItemPosition
+}
+
+// FindDuplicates reports groups of two or more items in a queue that share a
+// dedup key, ordered by where each group first appears. A nil key compares
+// items by their string form.
+func FindDuplicates(ctx context.Context, pq PriorityQueuer, queueName string, key func(value interface{}) string) ([]DuplicateGroup, error) {
+	if key == nil {
+		key = func(value interface{}) string { return fmt.Sprintf("%v", value) }
+	}
+
+	index := make(map[string]int)
+	var groups []DuplicateGroup
+	counts := make(map[int]int)
+	err := pq.IterateContents(ctx, queueName, func(priority int, value interface{}) bool {
+		k := key(value)
+		i, ok := index[k]
+		if !ok {
+			i = len(groups)
+			index[k] = i
+			groups = append(groups, DuplicateGroup{Key: k})
+		}
+		groups[i].Items = append(groups[i].Items, ItemPosition{
+			Priority: priority,
+			Position: counts[priority],
+			Value:    value,
+		})
+		counts[priority]++
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	duplicates := groups[:0]
+	for _, group := range groups {
+		if len(group.Items) > 1 {
+			duplicates = append(duplicates, group)
+		}
+	}
+	return duplicates, nil
+}
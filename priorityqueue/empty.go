@@ -0,0 +1,114 @@
+package priorityqueue
+
+import (
+	"context"
+	"time"
+)
+
+// EmptyWatcher polls a queue to notice when it drains. The debounce window is
+// measured on its Clock, so tests can drive it with a VirtualClock; polls are
+// spaced by real time.
+type EmptyWatcher struct {
+	pq       PriorityQueuer
+	interval time.Duration
+	debounce time.Duration
+	clock    Clock
+}
+
+// NewEmptyWatcher creates a watcher that checks queues of pq every interval
+// and treats them as drained once they have stayed empty for debounce
+func NewEmptyWatcher(pq PriorityQueuer, interval, debounce time.Duration) *EmptyWatcher {
+	return &EmptyWatcher{
+		pq:       pq,
+		interval: interval,
+		debounce: debounce,
+		clock:    SystemClock{},
+	}
+}
+
+// SetClock replaces the time source used to measure the debounce window. It
+// must be called before the watcher is used.
+func (w *EmptyWatcher) SetClock(clock Clock) {
+	w.clock = clock
+}
+
+// WaitEmpty blocks until a queue has stayed empty for the debounce window. It
+// returns nil then, or ctx's error if ctx is done first. A queue that is
+// already empty counts from the first check, and a single item arriving
+// inside the window restarts it.
+func (w *EmptyWatcher) WaitEmpty(ctx context.Context, queueName string) error {
+	var emptySince time.Time
+	for {
+		empty, err := w.pq.IsEmpty(ctx, queueName)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err() // Backends don't all wrap context errors
+			}
+			return err
+		}
+		if !empty {
+			emptySince = time.Time{}
+		} else if emptySince.IsZero() {
+			emptySince = w.clock.Now()
+		}
+		if !emptySince.IsZero() && w.clock.Now().Sub(emptySince) >= w.debounce {
+			return nil
+		}
+
+		if err := w.pause(ctx); err != nil {
+			return err
+		}
+	}
+}
+
+// OnQueueEmpty calls fn each time a queue becomes empty and stays empty for
+// the debounce window. After a call the queue must receive items again
+// before fn can fire again. It runs until ctx is done, returning ctx's
+// error, or until a check fails.
+func (w *EmptyWatcher) OnQueueEmpty(ctx context.Context, queueName string, fn func(queueName string)) error {
+	for {
+		if err := w.WaitEmpty(ctx, queueName); err != nil {
+			return err
+		}
+		fn(queueName)
+
+		for {
+			empty, err := w.pq.IsEmpty(ctx, queueName)
+			if err != nil {
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+				return err
+			}
+			if !empty {
+				break
+			}
+			if err := w.pause(ctx); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// pause waits one polling interval, or until ctx is done
+func (w *EmptyWatcher) pause(ctx context.Context) error {
+	select {
+	case <-time.After(w.interval):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// WaitEmpty blocks until a queue has stayed empty for debounce, checking it
+// every interval, like EmptyWatcher.WaitEmpty on the system clock
+func WaitEmpty(ctx context.Context, pq PriorityQueuer, queueName string, interval, debounce time.Duration) error {
+	return NewEmptyWatcher(pq, interval, debounce).WaitEmpty(ctx, queueName)
+}
+
+// OnQueueEmpty calls fn each time a queue drains and stays empty for
+// debounce, checking it every interval, like EmptyWatcher.OnQueueEmpty on the
+// system clock
+func OnQueueEmpty(ctx context.Context, pq PriorityQueuer, queueName string, interval, debounce time.Duration, fn func(queueName string)) error {
+	return NewEmptyWatcher(pq, interval, debounce).OnQueueEmpty(ctx, queueName, fn)
+}
@@ -0,0 +1,49 @@
+package priorityqueue
+
+import (
+	"context"
+	"math"
+)
+
+// EscalateItem moves the item with the given id to priority 0.
+// With atTop it goes to the head of the level, ahead of everything already
+// there; otherwise it joins the end of the level.
+func EscalateItem(ctx context.Context, pq PriorityQueuer, queueName, itemID string, atTop bool) error {
+	position := math.MaxInt
+	if atTop {
+		position = 0
+	}
+	return pq.MoveToPosition(ctx, queueName, itemID, 0, position)
+}
+
+// EscalateMatching moves every item for which match returns true to priority
+// 0, keeping their relative order, and returns how many were moved. Items are
+// matched from a snapshot of the queue and moved one by one by id, so items
+// with equal values are each moved once, but the call is not atomic with
+// respect to other writers. Redis members written before item ids can't be
+// addressed and are left where they are.
+func EscalateMatching(ctx context.Context, pq PriorityQueuer, queueName string, match func(value interface{}) bool, atTop bool) (int, error) {
+	var matched []string
+	err := pq.IterateItems(ctx, queueName, func(item Item) bool {
+		if item.ID != "" && match(item.Value) && (item.Priority > 0 || atTop) {
+			matched = append(matched, item.ID)
+		}
+		return true
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	moved := 0
+	for _, itemID := range matched {
+		position := math.MaxInt
+		if atTop {
+			position = moved
+		}
+		if err := pq.MoveToPosition(ctx, queueName, itemID, 0, position); err != nil {
+			return moved, err
+		}
+		moved++
+	}
+	return moved, nil
+}
@@ -0,0 +1,91 @@
+package priorityqueue
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// HistogramBounds are the upper bounds of a histogram's size and age
+// buckets, in ascending order. Each dimension gets one bucket per bound, for
+// values up to and including it, plus a last bucket for everything larger.
+type HistogramBounds struct {
+	Sizes []int // payload sizes in bytes
+	Ages  []time.Duration
+}
+
+// QueueHistogram is the distribution of a queue's items, for capacity
+// planning. Sizes and Ages have one count per bucket of the bounds they were
+// built with. Items whose enqueue time is unknown are left out of Ages.
+type QueueHistogram struct {
+	Priorities map[int]int64 // non-empty levels only
+	Sizes      []int64
+	Ages       []int64
+}
+
+// validate checks that both dimensions' bounds are ascending and
+// non-negative
+func (b HistogramBounds) validate() error {
+	for i, size := range b.Sizes {
+		if size < 0 || (i > 0 && size <= b.Sizes[i-1]) {
+			return fmt.Errorf("size bounds must be ascending and non-negative")
+		}
+	}
+	for i, age := range b.Ages {
+		if age < 0 || (i > 0 && age <= b.Ages[i-1]) {
+			return fmt.Errorf("age bounds must be ascending and non-negative")
+		}
+	}
+	return nil
+}
+
+// newHistogram returns an empty histogram with buckets for b
+func (b HistogramBounds) newHistogram() QueueHistogram {
+	return QueueHistogram{
+		Priorities: make(map[int]int64),
+		Sizes:      make([]int64, len(b.Sizes)+1),
+		Ages:       make([]int64, len(b.Ages)+1),
+	}
+}
+
+// bucket returns the index of the first bound v fits under, or len(bounds)
+func bucket[T int | time.Duration](bounds []T, v T) int {
+	for i, bound := range bounds {
+		if v <= bound {
+			return i
+		}
+	}
+	return len(bounds)
+}
+
+// Histogram counts a queue's items by priority, by the size of their string
+// form and by age
+func (mpq *MultiPriorityQueue) Histogram(ctx context.Context, queueName string, bounds HistogramBounds) (QueueHistogram, error) {
+	if err := bounds.validate(); err != nil {
+		return QueueHistogram{}, err
+	}
+
+	mpq.mutex.Lock()
+	pq, exists := mpq.queues[queueName]
+	mpq.mutex.Unlock()
+
+	if !exists {
+		return QueueHistogram{}, fmt.Errorf("queue '%s' does not exist", queueName)
+	}
+
+	pq.mutex.Lock()
+	defer pq.mutex.Unlock()
+
+	hist := bounds.newHistogram()
+	now := mpq.clock.Now()
+	for priority, level := range pq.queues {
+		if len(level) > 0 {
+			hist.Priorities[priority] = int64(len(level))
+		}
+		for _, item := range level {
+			hist.Sizes[bucket(bounds.Sizes, len(fmt.Sprintf("%v", item.Value)))]++
+			hist.Ages[bucket(bounds.Ages, now.Sub(item.EnqueuedAt))]++
+		}
+	}
+	return hist, nil
+}
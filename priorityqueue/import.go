@@ -0,0 +1,183 @@
+package priorityqueue
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Format identifies the layout of an import file
+type Format int
+
+const (
+	// FormatCSV reads "value,priority" rows. A leading "value,priority"
+	// header row is skipped.
+	FormatCSV Format = iota
+	// FormatJSONL reads one {"value": ..., "priority": n} object per line
+	FormatJSONL
+)
+
+// Importer streams items from a file into a queue. The zero value is ready to
+// use.
+type Importer struct {
+	// BatchSize is how many records are enqueued per EnqueueBatch call,
+	// and between Progress calls. Defaults to 1000.
+	BatchSize int
+	// Progress, if set, is called with the running count of imported
+	// records after every batch and once at the end
+	Progress func(imported int)
+	// Skip is the number of leading records to pass over, used to resume an
+	// import from the count returned by a failed run
+	Skip int
+}
+
+// ImportFile imports a file with the default Importer settings
+func ImportFile(ctx context.Context, pq PriorityQueuer, queueName, path string, format Format) (int, error) {
+	var imp Importer
+	return imp.ImportFile(ctx, pq, queueName, path, format)
+}
+
+// ImportFile enqueues every record of the file into queueName. It returns the
+// number of records consumed from the file, including skipped ones, so a
+// failed import can be resumed by setting Skip to that count.
+func (imp *Importer) ImportFile(ctx context.Context, pq PriorityQueuer, queueName, path string, format Format) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, fmt.Errorf("opening import file: %v", err)
+	}
+	defer f.Close()
+
+	var next func() (interface{}, int, error)
+	switch format {
+	case FormatCSV:
+		next = csvRecords(f)
+	case FormatJSONL:
+		next = jsonlRecords(f)
+	default:
+		return 0, fmt.Errorf("unknown import format %d", format)
+	}
+
+	batchSize := imp.BatchSize
+	if batchSize <= 0 {
+		batchSize = 1000
+	}
+
+	// flushed counts the records consumed up to the last enqueued batch, so
+	// a failed batch is retried whole on resume
+	imported, flushed := 0, 0
+	batch := make([]Item, 0, batchSize)
+	flush := func() error {
+		if len(batch) > 0 {
+			if err := pq.EnqueueBatch(ctx, queueName, batch); err != nil {
+				return fmt.Errorf("records %d-%d: %v", flushed+1, imported, err)
+			}
+		}
+		batch = batch[:0]
+		flushed = imported
+		return nil
+	}
+
+	for {
+		value, priority, err := next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			if err := flush(); err != nil {
+				return flushed, err
+			}
+			return imported, fmt.Errorf("record %d: %v", imported+1, err)
+		}
+		if imported >= imp.Skip {
+			if err := checkPriority(priority, pq.Levels()); err != nil {
+				if err := flush(); err != nil {
+					return flushed, err
+				}
+				return imported, fmt.Errorf("record %d: %v", imported+1, err)
+			}
+			batch = append(batch, Item{Value: value, Priority: priority})
+		}
+		imported++
+		if imported%batchSize == 0 {
+			if err := flush(); err != nil {
+				return flushed, err
+			}
+			if imp.Progress != nil {
+				imp.Progress(imported)
+			}
+		}
+	}
+	if err := flush(); err != nil {
+		return flushed, err
+	}
+	if imp.Progress != nil && imported%batchSize != 0 {
+		imp.Progress(imported)
+	}
+	return imported, nil
+}
+
+// csvRecords returns a reader of "value,priority" rows
+func csvRecords(r io.Reader) func() (interface{}, int, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = 2
+	first := true
+	return func() (interface{}, int, error) {
+		row, err := reader.Read()
+		if err != nil {
+			return nil, 0, err
+		}
+		if first {
+			first = false
+			if strings.EqualFold(row[0], "value") && strings.EqualFold(row[1], "priority") {
+				if row, err = reader.Read(); err != nil {
+					return nil, 0, err
+				}
+			}
+		}
+		return parseCSVRow(row)
+	}
+}
+
+func parseCSVRow(row []string) (interface{}, int, error) {
+	priority, err := strconv.Atoi(strings.TrimSpace(row[1]))
+	if err != nil {
+		return nil, 0, fmt.Errorf("invalid priority '%s'", row[1])
+	}
+	return row[0], priority, nil
+}
+
+// jsonlRecords returns a reader of {"value": ..., "priority": n} lines.
+// Blank lines are ignored.
+func jsonlRecords(r io.Reader) func() (interface{}, int, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	return func() (interface{}, int, error) {
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" {
+				continue
+			}
+			var record struct {
+				Value    interface{} `json:"value"`
+				Priority *int        `json:"priority"`
+			}
+			if err := json.Unmarshal([]byte(line), &record); err != nil {
+				return nil, 0, fmt.Errorf("invalid JSON: %v", err)
+			}
+			if record.Priority == nil {
+				return nil, 0, fmt.Errorf("missing priority")
+			}
+			return record.Value, *record.Priority, nil
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, 0, err
+		}
+		return nil, 0, io.EOF
+	}
+}
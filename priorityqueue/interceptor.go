@@ -0,0 +1,244 @@
+package priorityqueue
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Interceptor transforms a value on its way into or out of a queue. A non-nil
+// error aborts the operation.
+type Interceptor func(value interface{}) (interface{}, error)
+
+// InterceptingQueue wraps a PriorityQueuer and runs per-queue chains of
+// interceptors, producer-side before items are written and consumer-side
+// after they are removed, so payload handling such as redaction or format
+// conversion lives in one place instead of in every service
+type InterceptingQueue struct {
+	PriorityQueuer
+	producers map[string][]Interceptor
+	consumers map[string][]Interceptor
+	mutex     sync.Mutex
+}
+
+// NewInterceptingQueue wraps pq with no interceptors registered
+func NewInterceptingQueue(pq PriorityQueuer) *InterceptingQueue {
+	return &InterceptingQueue{
+		PriorityQueuer: pq,
+		producers:      make(map[string][]Interceptor),
+		consumers:      make(map[string][]Interceptor),
+	}
+}
+
+// AddProducerInterceptor appends an interceptor to the chain run on values
+// enqueued into a queue
+func (iq *InterceptingQueue) AddProducerInterceptor(queueName string, fn Interceptor) {
+	iq.mutex.Lock()
+	defer iq.mutex.Unlock()
+	iq.producers[queueName] = append(iq.producers[queueName], fn)
+}
+
+// AddConsumerInterceptor appends an interceptor to the chain run on values
+// dequeued or peeked from a queue
+func (iq *InterceptingQueue) AddConsumerInterceptor(queueName string, fn Interceptor) {
+	iq.mutex.Lock()
+	defer iq.mutex.Unlock()
+	iq.consumers[queueName] = append(iq.consumers[queueName], fn)
+}
+
+// run passes value through a chain of interceptors in registration order
+func (iq *InterceptingQueue) run(chains map[string][]Interceptor, queueName string, value interface{}) (interface{}, error) {
+	iq.mutex.Lock()
+	chain := chains[queueName]
+	iq.mutex.Unlock()
+
+	for _, fn := range chain {
+		var err error
+		if value, err = fn(value); err != nil {
+			return nil, fmt.Errorf("interceptor for queue '%s' failed: %w", queueName, err)
+		}
+	}
+	return value, nil
+}
+
+// consume runs the consumer chain over the result of a removal or peek
+func (iq *InterceptingQueue) consume(queueName string, value interface{}, err error) (interface{}, error) {
+	if err != nil {
+		return nil, err
+	}
+	return iq.run(iq.consumers, queueName, value)
+}
+
+// consumeAll runs the consumer chain over the values of a multi-item
+// removal. If it fails on values[i], every value is returned with the error:
+// values[:i] have been through the chain and values[i:] are as stored.
+func (iq *InterceptingQueue) consumeAll(queueName string, values []interface{}, err error) ([]interface{}, error) {
+	for i, value := range values {
+		v, ierr := iq.run(iq.consumers, queueName, value)
+		if ierr != nil {
+			return values, fmt.Errorf("item %d of %d: %w", i, len(values), ierr)
+		}
+		values[i] = v
+	}
+	return values, err
+}
+
+func (iq *InterceptingQueue) Enqueue(ctx context.Context, queueName string, value interface{}, priority int) error {
+	value, err := iq.run(iq.producers, queueName, value)
+	if err != nil {
+		return err
+	}
+	return iq.PriorityQueuer.Enqueue(ctx, queueName, value, priority)
+}
+
+func (iq *InterceptingQueue) EnqueueWithID(ctx context.Context, queueName string, value interface{}, priority int) (string, error) {
+	value, err := iq.run(iq.producers, queueName, value)
+	if err != nil {
+		return "", err
+	}
+	return iq.PriorityQueuer.EnqueueWithID(ctx, queueName, value, priority)
+}
+
+func (iq *InterceptingQueue) UpdateByID(ctx context.Context, queueName, id string, value interface{}) error {
+	value, err := iq.run(iq.producers, queueName, value)
+	if err != nil {
+		return err
+	}
+	return iq.PriorityQueuer.UpdateByID(ctx, queueName, id, value)
+}
+
+// EnqueueFanout runs each queue's producer chain. Queues whose chains yield
+// the same value are written in one EnqueueFanout call, so the fanout is only
+// atomic across queues that end up with identical items.
+func (iq *InterceptingQueue) EnqueueFanout(ctx context.Context, queueNames []string, value interface{}, priority int) error {
+	groups := make(map[string][]string)
+	values := make(map[string]interface{})
+	for _, queueName := range queueNames {
+		v, err := iq.run(iq.producers, queueName, value)
+		if err != nil {
+			return err
+		}
+		key := fmt.Sprintf("%v", v)
+		groups[key] = append(groups[key], queueName)
+		values[key] = v
+	}
+
+	keys := make([]string, 0, len(groups))
+	for key := range groups {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if err := iq.PriorityQueuer.EnqueueFanout(ctx, groups[key], values[key], priority); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (iq *InterceptingQueue) EnqueueMulti(ctx context.Context, entries []QueueEntry) error {
+	transformed := make([]QueueEntry, len(entries))
+	for i, e := range entries {
+		v, err := iq.run(iq.producers, e.QueueName, e.Value)
+		if err != nil {
+			return err
+		}
+		transformed[i] = QueueEntry{QueueName: e.QueueName, Value: v, Priority: e.Priority}
+	}
+	return iq.PriorityQueuer.EnqueueMulti(ctx, transformed)
+}
+
+func (iq *InterceptingQueue) EnqueueBatch(ctx context.Context, queueName string, items []Item) error {
+	transformed := make([]Item, len(items))
+	for i, item := range items {
+		v, err := iq.run(iq.producers, queueName, item.Value)
+		if err != nil {
+			return err
+		}
+		transformed[i] = Item{Value: v, Priority: item.Priority}
+	}
+	return iq.PriorityQueuer.EnqueueBatch(ctx, queueName, transformed)
+}
+
+func (iq *InterceptingQueue) InsertAtTop(ctx context.Context, queueName string, value interface{}, priority int) error {
+	value, err := iq.run(iq.producers, queueName, value)
+	if err != nil {
+		return err
+	}
+	return iq.PriorityQueuer.InsertAtTop(ctx, queueName, value, priority)
+}
+
+func (iq *InterceptingQueue) InsertAtTopBatch(ctx context.Context, queueName string, values []interface{}, priority int) error {
+	transformed := make([]interface{}, len(values))
+	for i, value := range values {
+		v, err := iq.run(iq.producers, queueName, value)
+		if err != nil {
+			return err
+		}
+		transformed[i] = v
+	}
+	return iq.PriorityQueuer.InsertAtTopBatch(ctx, queueName, transformed, priority)
+}
+
+func (iq *InterceptingQueue) Dequeue(ctx context.Context, queueName string) (interface{}, error) {
+	value, err := iq.PriorityQueuer.Dequeue(ctx, queueName)
+	return iq.consume(queueName, value, err)
+}
+
+func (iq *InterceptingQueue) DequeueFromPriority(ctx context.Context, queueName string, priority int) (interface{}, error) {
+	value, err := iq.PriorityQueuer.DequeueFromPriority(ctx, queueName, priority)
+	return iq.consume(queueName, value, err)
+}
+
+// DequeueWhere runs the consumer chain on the removed item. The predicate
+// sees items as stored.
+func (iq *InterceptingQueue) DequeueWhere(ctx context.Context, queueName string, pred func(Item) bool) (interface{}, error) {
+	value, err := iq.PriorityQueuer.DequeueWhere(ctx, queueName, pred)
+	return iq.consume(queueName, value, err)
+}
+
+func (iq *InterceptingQueue) Peek(ctx context.Context, queueName string) (interface{}, error) {
+	value, err := iq.PriorityQueuer.Peek(ctx, queueName)
+	return iq.consume(queueName, value, err)
+}
+
+func (iq *InterceptingQueue) GetByID(ctx context.Context, queueName, id string) (Item, error) {
+	item, err := iq.PriorityQueuer.GetByID(ctx, queueName, id)
+	if err != nil {
+		return Item{}, err
+	}
+	value, err := iq.consume(queueName, item.Value, nil)
+	if err != nil {
+		return Item{}, err
+	}
+	item.Value = value
+	return item, nil
+}
+
+func (iq *InterceptingQueue) PeekPriority(ctx context.Context, queueName string, priority int) (interface{}, error) {
+	value, err := iq.PriorityQueuer.PeekPriority(ctx, queueName, priority)
+	return iq.consume(queueName, value, err)
+}
+
+// DequeueLevel runs the consumer chain on every removed item. The items are
+// already gone from the queue when an interceptor fails, so they are all
+// returned with the error: those before the failing one have been through
+// the chain and the rest are as stored. The caller owns all of them.
+func (iq *InterceptingQueue) DequeueLevel(ctx context.Context, queueName string) ([]interface{}, error) {
+	values, err := iq.PriorityQueuer.DequeueLevel(ctx, queueName)
+	return iq.consumeAll(queueName, values, err)
+}
+
+// DequeueBatch runs the consumer chain on every removed item, returning them
+// all with the error if an interceptor fails, like DequeueLevel
+func (iq *InterceptingQueue) DequeueBatch(ctx context.Context, queueName string, n int) ([]interface{}, error) {
+	values, err := iq.PriorityQueuer.DequeueBatch(ctx, queueName, n)
+	return iq.consumeAll(queueName, values, err)
+}
+
+func (iq *InterceptingQueue) DequeueFresh(ctx context.Context, queueName string, maxAge time.Duration, expire bool) (interface{}, error) {
+	value, err := iq.PriorityQueuer.DequeueFresh(ctx, queueName, maxAge, expire)
+	return iq.consume(queueName, value, err)
+}
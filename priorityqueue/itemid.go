@@ -0,0 +1,183 @@
+package priorityqueue
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sync"
+)
+
+// itemIDLen is the length of an item id in hex digits
+const itemIDLen = 16
+
+// newItemID returns a random item id
+func newItemID() string {
+	var b [itemIDLen / 2]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(fmt.Sprintf("failed to generate item id: %v", err))
+	}
+	return hex.EncodeToString(b[:])
+}
+
+// itemNotFoundError reports an id that matches no item in a queue
+func itemNotFoundError(queueName, id string) error {
+	return fmt.Errorf("item '%s' not found in queue '%s'", id, queueName)
+}
+
+// removalLogKey is the context key a removalLog travels under
+type removalLogKey struct{}
+
+// removalLog collects the ids of the items a call takes from a queue, in the
+// order their values are returned. Wrappers that only see values, such as
+// TracingQueue, attach one to the call's context and the backends note each
+// item they hand back. Items without an id are noted as "".
+type removalLog struct {
+	parent *removalLog // an outer wrapper's log, which sees the same items
+	ids    []string
+	mutex  sync.Mutex
+}
+
+// withRemovalLog returns ctx carrying a new removalLog
+func withRemovalLog(ctx context.Context) (context.Context, *removalLog) {
+	parent, _ := ctx.Value(removalLogKey{}).(*removalLog)
+	log := &removalLog{parent: parent}
+	return context.WithValue(ctx, removalLogKey{}, log), log
+}
+
+// noteRemoved records the id of an item taken from a queue, if ctx carries a
+// removalLog
+func noteRemoved(ctx context.Context, id string) {
+	log, _ := ctx.Value(removalLogKey{}).(*removalLog)
+	for ; log != nil; log = log.parent {
+		log.mutex.Lock()
+		log.ids = append(log.ids, id)
+		log.mutex.Unlock()
+	}
+}
+
+// id returns the id of the i-th item taken, or "" if it isn't known
+func (log *removalLog) id(i int) string {
+	log.mutex.Lock()
+	defer log.mutex.Unlock()
+
+	if i < len(log.ids) {
+		return log.ids[i]
+	}
+	return ""
+}
+
+// EnqueueWithID appends value like Enqueue and returns an id that addresses
+// this item alone, even when other items have the same value
+func (mpq *MultiPriorityQueue) EnqueueWithID(ctx context.Context, queueName string, value interface{}, priority int) (string, error) {
+	if err := checkPriority(priority, mpq.levels); err != nil {
+		return "", err
+	}
+
+	mpq.mutex.Lock()
+	pq, exists := mpq.queues[queueName]
+	mpq.mutex.Unlock()
+
+	if !exists {
+		return "", fmt.Errorf("queue '%s' does not exist", queueName)
+	}
+
+	pq.mutex.Lock()
+	defer pq.mutex.Unlock()
+
+	if pq.frozen {
+		return "", frozenError(queueName)
+	}
+
+	id := newItemID()
+	pq.queues[priority] = append(pq.queues[priority], Item{ID: id, Value: value, Priority: priority, EnqueuedAt: mpq.clock.Now()})
+	pq.counters.Enqueued++
+	return id, nil
+}
+
+// findByID returns the priority and index of the item with the given id, or
+// -1, -1. pq.mutex must be held.
+func (pq *PriorityQueue) findByID(id string) (int, int) {
+	if id == "" {
+		return -1, -1
+	}
+	for priority, level := range pq.queues {
+		for i, item := range level {
+			if item.ID == id {
+				return priority, i
+			}
+		}
+	}
+	return -1, -1
+}
+
+// GetByID returns the item with the given id without removing it
+func (mpq *MultiPriorityQueue) GetByID(ctx context.Context, queueName, id string) (Item, error) {
+	mpq.mutex.Lock()
+	pq, exists := mpq.queues[queueName]
+	mpq.mutex.Unlock()
+
+	if !exists {
+		return Item{}, fmt.Errorf("queue '%s' does not exist", queueName)
+	}
+
+	pq.mutex.Lock()
+	defer pq.mutex.Unlock()
+
+	priority, i := pq.findByID(id)
+	if priority < 0 {
+		return Item{}, itemNotFoundError(queueName, id)
+	}
+	return pq.queues[priority][i], nil
+}
+
+// DeleteByID removes the item with the given id
+func (mpq *MultiPriorityQueue) DeleteByID(ctx context.Context, queueName, id string) error {
+	mpq.mutex.Lock()
+	pq, exists := mpq.queues[queueName]
+	mpq.mutex.Unlock()
+
+	if !exists {
+		return fmt.Errorf("queue '%s' does not exist", queueName)
+	}
+
+	pq.mutex.Lock()
+	defer pq.mutex.Unlock()
+
+	if pq.frozen {
+		return frozenError(queueName)
+	}
+
+	priority, i := pq.findByID(id)
+	if priority < 0 {
+		return itemNotFoundError(queueName, id)
+	}
+	pq.queues[priority] = append(pq.queues[priority][:i], pq.queues[priority][i+1:]...)
+	return nil
+}
+
+// UpdateByID replaces the value of the item with the given id, keeping its
+// id, priority, position and enqueue time
+func (mpq *MultiPriorityQueue) UpdateByID(ctx context.Context, queueName, id string, value interface{}) error {
+	mpq.mutex.Lock()
+	pq, exists := mpq.queues[queueName]
+	mpq.mutex.Unlock()
+
+	if !exists {
+		return fmt.Errorf("queue '%s' does not exist", queueName)
+	}
+
+	pq.mutex.Lock()
+	defer pq.mutex.Unlock()
+
+	if pq.frozen {
+		return frozenError(queueName)
+	}
+
+	priority, i := pq.findByID(id)
+	if priority < 0 {
+		return itemNotFoundError(queueName, id)
+	}
+	pq.queues[priority][i].Value = value
+	return nil
+}
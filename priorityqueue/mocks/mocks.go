@@ -0,0 +1,481 @@
+// Package mocks provides test doubles for the priorityqueue package, kept in
+// step with its interfaces so downstream tests don't generate their own
+package mocks
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"fsedano.net/pq/priorityqueue"
+)
+
+// The mock must keep up with the interface; this fails to compile otherwise
+var _ priorityqueue.PriorityQueuer = (*PriorityQueuer)(nil)
+
+// Call is one recorded method call. Args holds the arguments after ctx.
+type Call struct {
+	Method string
+	Args   []interface{}
+}
+
+// PriorityQueuer is a priorityqueue.PriorityQueuer whose methods call the
+// matching Func field when it is set and return zero values otherwise, except
+// Levels, which reports DefaultLevels. Every call is recorded. The zero value
+// is ready to use.
+type PriorityQueuer struct {
+	AddQueueFunc            func(ctx context.Context, name string) error
+	RemoveQueueFunc         func(ctx context.Context, name string) error
+	ListQueuesFunc          func(ctx context.Context) ([]string, error)
+	PurgeFunc               func(ctx context.Context, queueName string) error
+	EnqueueFunc             func(ctx context.Context, queueName string, value interface{}, priority int) error
+	EnqueueFanoutFunc       func(ctx context.Context, queueNames []string, value interface{}, priority int) error
+	EnqueueMultiFunc        func(ctx context.Context, entries []priorityqueue.QueueEntry) error
+	EnqueueBatchFunc        func(ctx context.Context, queueName string, items []priorityqueue.Item) error
+	DequeueFunc             func(ctx context.Context, queueName string) (interface{}, error)
+	IsEmptyFunc             func(ctx context.Context, queueName string) (bool, error)
+	SizeFunc                func(ctx context.Context, queueName string) (int, error)
+	CountByPriorityFunc     func(ctx context.Context, queueName string) (map[int]int, error)
+	LevelLenFunc            func(ctx context.Context, queueName string, priority int) (int64, error)
+	FastLenFunc             func(ctx context.Context, queueName string) (priorityqueue.QueueDepth, error)
+	ListContentsFunc        func(ctx context.Context, queueName string) (map[int][]interface{}, error)
+	IterateContentsFunc     func(ctx context.Context, queueName string, fn func(priority int, value interface{}) bool) error
+	IterateItemsFunc        func(ctx context.Context, queueName string, fn func(item priorityqueue.Item) bool) error
+	GetPositionFunc         func(ctx context.Context, queueName string, value interface{}) (int, int, error)
+	InsertAtTopFunc         func(ctx context.Context, queueName string, value interface{}, priority int) error
+	InsertAtTopBatchFunc    func(ctx context.Context, queueName string, values []interface{}, priority int) error
+	DeleteItemFunc          func(ctx context.Context, queueName string, value interface{}) error
+	MoveToPositionFunc      func(ctx context.Context, queueName string, itemID string, priority, position int) error
+	UpdatePriorityFunc      func(ctx context.Context, queueName string, value interface{}, newPriority int) error
+	MoveItemFunc            func(ctx context.Context, srcQueue, dstQueue string, value interface{}) error
+	RenameQueueFunc         func(ctx context.Context, oldName, newName string) error
+	LevelsFunc              func() int
+	EnqueueWithIDFunc       func(ctx context.Context, queueName string, value interface{}, priority int) (string, error)
+	GetByIDFunc             func(ctx context.Context, queueName, id string) (priorityqueue.Item, error)
+	DeleteByIDFunc          func(ctx context.Context, queueName, id string) error
+	UpdateByIDFunc          func(ctx context.Context, queueName, id string, value interface{}) error
+	SwapItemsFunc           func(ctx context.Context, queueName, itemA, itemB string) error
+	OldestItemFunc          func(ctx context.Context, queueName string) (interface{}, time.Duration, error)
+	NewestItemFunc          func(ctx context.Context, queueName string) (interface{}, time.Duration, error)
+	CountersFunc            func(ctx context.Context, queueName string) (priorityqueue.QueueCounters, error)
+	PeekFunc                func(ctx context.Context, queueName string) (interface{}, error)
+	PeekPriorityFunc        func(ctx context.Context, queueName string, priority int) (interface{}, error)
+	DequeueFromPriorityFunc func(ctx context.Context, queueName string, priority int) (interface{}, error)
+	DequeueLevelFunc        func(ctx context.Context, queueName string) ([]interface{}, error)
+	DequeueBatchFunc        func(ctx context.Context, queueName string, n int) ([]interface{}, error)
+	DequeueWhereFunc        func(ctx context.Context, queueName string, pred func(priorityqueue.Item) bool) (interface{}, error)
+	DequeueFreshFunc        func(ctx context.Context, queueName string, maxAge time.Duration, expire bool) (interface{}, error)
+	SetPriorityWeightsFunc  func(ctx context.Context, queueName string, weights []float64) error
+	FreezeQueueFunc         func(ctx context.Context, queueName string) error
+	UnfreezeQueueFunc       func(ctx context.Context, queueName string) error
+	CompactQueueFunc        func(ctx context.Context, queueName string) error
+	VerifyQueueFunc         func(ctx context.Context, queueName string, repair bool) (priorityqueue.VerifyReport, error)
+	HistogramFunc           func(ctx context.Context, queueName string, bounds priorityqueue.HistogramBounds) (priorityqueue.QueueHistogram, error)
+
+	calls []Call
+	mutex sync.Mutex
+}
+
+func (m *PriorityQueuer) record(method string, args ...interface{}) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.calls = append(m.calls, Call{Method: method, Args: args})
+}
+
+// Calls returns the recorded calls in order, all of them if no methods are
+// named or only calls to the named methods otherwise
+func (m *PriorityQueuer) Calls(methods ...string) []Call {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	var calls []Call
+	for _, call := range m.calls {
+		if len(methods) == 0 {
+			calls = append(calls, call)
+			continue
+		}
+		for _, method := range methods {
+			if call.Method == method {
+				calls = append(calls, call)
+				break
+			}
+		}
+	}
+	return calls
+}
+
+func (m *PriorityQueuer) AddQueue(ctx context.Context, name string) error {
+	m.record("AddQueue", name)
+	if m.AddQueueFunc != nil {
+		return m.AddQueueFunc(ctx, name)
+	}
+	return nil
+}
+
+func (m *PriorityQueuer) RemoveQueue(ctx context.Context, name string) error {
+	m.record("RemoveQueue", name)
+	if m.RemoveQueueFunc != nil {
+		return m.RemoveQueueFunc(ctx, name)
+	}
+	return nil
+}
+
+func (m *PriorityQueuer) ListQueues(ctx context.Context) ([]string, error) {
+	m.record("ListQueues")
+	if m.ListQueuesFunc != nil {
+		return m.ListQueuesFunc(ctx)
+	}
+	return nil, nil
+}
+
+func (m *PriorityQueuer) Purge(ctx context.Context, queueName string) error {
+	m.record("Purge", queueName)
+	if m.PurgeFunc != nil {
+		return m.PurgeFunc(ctx, queueName)
+	}
+	return nil
+}
+
+func (m *PriorityQueuer) Enqueue(ctx context.Context, queueName string, value interface{}, priority int) error {
+	m.record("Enqueue", queueName, value, priority)
+	if m.EnqueueFunc != nil {
+		return m.EnqueueFunc(ctx, queueName, value, priority)
+	}
+	return nil
+}
+
+func (m *PriorityQueuer) EnqueueFanout(ctx context.Context, queueNames []string, value interface{}, priority int) error {
+	m.record("EnqueueFanout", queueNames, value, priority)
+	if m.EnqueueFanoutFunc != nil {
+		return m.EnqueueFanoutFunc(ctx, queueNames, value, priority)
+	}
+	return nil
+}
+
+func (m *PriorityQueuer) EnqueueMulti(ctx context.Context, entries []priorityqueue.QueueEntry) error {
+	m.record("EnqueueMulti", entries)
+	if m.EnqueueMultiFunc != nil {
+		return m.EnqueueMultiFunc(ctx, entries)
+	}
+	return nil
+}
+
+func (m *PriorityQueuer) EnqueueBatch(ctx context.Context, queueName string, items []priorityqueue.Item) error {
+	m.record("EnqueueBatch", queueName, items)
+	if m.EnqueueBatchFunc != nil {
+		return m.EnqueueBatchFunc(ctx, queueName, items)
+	}
+	return nil
+}
+
+func (m *PriorityQueuer) Dequeue(ctx context.Context, queueName string) (interface{}, error) {
+	m.record("Dequeue", queueName)
+	if m.DequeueFunc != nil {
+		return m.DequeueFunc(ctx, queueName)
+	}
+	return nil, nil
+}
+
+func (m *PriorityQueuer) IsEmpty(ctx context.Context, queueName string) (bool, error) {
+	m.record("IsEmpty", queueName)
+	if m.IsEmptyFunc != nil {
+		return m.IsEmptyFunc(ctx, queueName)
+	}
+	return false, nil
+}
+
+func (m *PriorityQueuer) Size(ctx context.Context, queueName string) (int, error) {
+	m.record("Size", queueName)
+	if m.SizeFunc != nil {
+		return m.SizeFunc(ctx, queueName)
+	}
+	return 0, nil
+}
+
+func (m *PriorityQueuer) CountByPriority(ctx context.Context, queueName string) (map[int]int, error) {
+	m.record("CountByPriority", queueName)
+	if m.CountByPriorityFunc != nil {
+		return m.CountByPriorityFunc(ctx, queueName)
+	}
+	return nil, nil
+}
+
+func (m *PriorityQueuer) LevelLen(ctx context.Context, queueName string, priority int) (int64, error) {
+	m.record("LevelLen", queueName, priority)
+	if m.LevelLenFunc != nil {
+		return m.LevelLenFunc(ctx, queueName, priority)
+	}
+	return 0, nil
+}
+
+func (m *PriorityQueuer) FastLen(ctx context.Context, queueName string) (priorityqueue.QueueDepth, error) {
+	m.record("FastLen", queueName)
+	if m.FastLenFunc != nil {
+		return m.FastLenFunc(ctx, queueName)
+	}
+	return priorityqueue.QueueDepth{}, nil
+}
+
+func (m *PriorityQueuer) ListContents(ctx context.Context, queueName string) (map[int][]interface{}, error) {
+	m.record("ListContents", queueName)
+	if m.ListContentsFunc != nil {
+		return m.ListContentsFunc(ctx, queueName)
+	}
+	return nil, nil
+}
+
+func (m *PriorityQueuer) IterateContents(ctx context.Context, queueName string, fn func(priority int, value interface{}) bool) error {
+	m.record("IterateContents", queueName, fn)
+	if m.IterateContentsFunc != nil {
+		return m.IterateContentsFunc(ctx, queueName, fn)
+	}
+	return nil
+}
+
+func (m *PriorityQueuer) IterateItems(ctx context.Context, queueName string, fn func(item priorityqueue.Item) bool) error {
+	m.record("IterateItems", queueName, fn)
+	if m.IterateItemsFunc != nil {
+		return m.IterateItemsFunc(ctx, queueName, fn)
+	}
+	return nil
+}
+
+func (m *PriorityQueuer) GetPosition(ctx context.Context, queueName string, value interface{}) (int, int, error) {
+	m.record("GetPosition", queueName, value)
+	if m.GetPositionFunc != nil {
+		return m.GetPositionFunc(ctx, queueName, value)
+	}
+	return 0, 0, nil
+}
+
+func (m *PriorityQueuer) InsertAtTop(ctx context.Context, queueName string, value interface{}, priority int) error {
+	m.record("InsertAtTop", queueName, value, priority)
+	if m.InsertAtTopFunc != nil {
+		return m.InsertAtTopFunc(ctx, queueName, value, priority)
+	}
+	return nil
+}
+
+func (m *PriorityQueuer) InsertAtTopBatch(ctx context.Context, queueName string, values []interface{}, priority int) error {
+	m.record("InsertAtTopBatch", queueName, values, priority)
+	if m.InsertAtTopBatchFunc != nil {
+		return m.InsertAtTopBatchFunc(ctx, queueName, values, priority)
+	}
+	return nil
+}
+
+func (m *PriorityQueuer) DeleteItem(ctx context.Context, queueName string, value interface{}) error {
+	m.record("DeleteItem", queueName, value)
+	if m.DeleteItemFunc != nil {
+		return m.DeleteItemFunc(ctx, queueName, value)
+	}
+	return nil
+}
+
+func (m *PriorityQueuer) MoveToPosition(ctx context.Context, queueName string, itemID string, priority, position int) error {
+	m.record("MoveToPosition", queueName, itemID, priority, position)
+	if m.MoveToPositionFunc != nil {
+		return m.MoveToPositionFunc(ctx, queueName, itemID, priority, position)
+	}
+	return nil
+}
+
+func (m *PriorityQueuer) UpdatePriority(ctx context.Context, queueName string, value interface{}, newPriority int) error {
+	m.record("UpdatePriority", queueName, value, newPriority)
+	if m.UpdatePriorityFunc != nil {
+		return m.UpdatePriorityFunc(ctx, queueName, value, newPriority)
+	}
+	return nil
+}
+
+func (m *PriorityQueuer) MoveItem(ctx context.Context, srcQueue, dstQueue string, value interface{}) error {
+	m.record("MoveItem", srcQueue, dstQueue, value)
+	if m.MoveItemFunc != nil {
+		return m.MoveItemFunc(ctx, srcQueue, dstQueue, value)
+	}
+	return nil
+}
+
+func (m *PriorityQueuer) RenameQueue(ctx context.Context, oldName, newName string) error {
+	m.record("RenameQueue", oldName, newName)
+	if m.RenameQueueFunc != nil {
+		return m.RenameQueueFunc(ctx, oldName, newName)
+	}
+	return nil
+}
+
+func (m *PriorityQueuer) Levels() int {
+	m.record("Levels")
+	if m.LevelsFunc != nil {
+		return m.LevelsFunc()
+	}
+	return priorityqueue.DefaultLevels
+}
+
+func (m *PriorityQueuer) EnqueueWithID(ctx context.Context, queueName string, value interface{}, priority int) (string, error) {
+	m.record("EnqueueWithID", queueName, value, priority)
+	if m.EnqueueWithIDFunc != nil {
+		return m.EnqueueWithIDFunc(ctx, queueName, value, priority)
+	}
+	return "", nil
+}
+
+func (m *PriorityQueuer) GetByID(ctx context.Context, queueName, id string) (priorityqueue.Item, error) {
+	m.record("GetByID", queueName, id)
+	if m.GetByIDFunc != nil {
+		return m.GetByIDFunc(ctx, queueName, id)
+	}
+	return priorityqueue.Item{}, nil
+}
+
+func (m *PriorityQueuer) DeleteByID(ctx context.Context, queueName, id string) error {
+	m.record("DeleteByID", queueName, id)
+	if m.DeleteByIDFunc != nil {
+		return m.DeleteByIDFunc(ctx, queueName, id)
+	}
+	return nil
+}
+
+func (m *PriorityQueuer) UpdateByID(ctx context.Context, queueName, id string, value interface{}) error {
+	m.record("UpdateByID", queueName, id, value)
+	if m.UpdateByIDFunc != nil {
+		return m.UpdateByIDFunc(ctx, queueName, id, value)
+	}
+	return nil
+}
+
+func (m *PriorityQueuer) SwapItems(ctx context.Context, queueName, itemA, itemB string) error {
+	m.record("SwapItems", queueName, itemA, itemB)
+	if m.SwapItemsFunc != nil {
+		return m.SwapItemsFunc(ctx, queueName, itemA, itemB)
+	}
+	return nil
+}
+
+func (m *PriorityQueuer) OldestItem(ctx context.Context, queueName string) (interface{}, time.Duration, error) {
+	m.record("OldestItem", queueName)
+	if m.OldestItemFunc != nil {
+		return m.OldestItemFunc(ctx, queueName)
+	}
+	return nil, 0, nil
+}
+
+func (m *PriorityQueuer) NewestItem(ctx context.Context, queueName string) (interface{}, time.Duration, error) {
+	m.record("NewestItem", queueName)
+	if m.NewestItemFunc != nil {
+		return m.NewestItemFunc(ctx, queueName)
+	}
+	return nil, 0, nil
+}
+
+func (m *PriorityQueuer) Counters(ctx context.Context, queueName string) (priorityqueue.QueueCounters, error) {
+	m.record("Counters", queueName)
+	if m.CountersFunc != nil {
+		return m.CountersFunc(ctx, queueName)
+	}
+	return priorityqueue.QueueCounters{}, nil
+}
+
+func (m *PriorityQueuer) Peek(ctx context.Context, queueName string) (interface{}, error) {
+	m.record("Peek", queueName)
+	if m.PeekFunc != nil {
+		return m.PeekFunc(ctx, queueName)
+	}
+	return nil, nil
+}
+
+func (m *PriorityQueuer) PeekPriority(ctx context.Context, queueName string, priority int) (interface{}, error) {
+	m.record("PeekPriority", queueName, priority)
+	if m.PeekPriorityFunc != nil {
+		return m.PeekPriorityFunc(ctx, queueName, priority)
+	}
+	return nil, nil
+}
+
+func (m *PriorityQueuer) DequeueFromPriority(ctx context.Context, queueName string, priority int) (interface{}, error) {
+	m.record("DequeueFromPriority", queueName, priority)
+	if m.DequeueFromPriorityFunc != nil {
+		return m.DequeueFromPriorityFunc(ctx, queueName, priority)
+	}
+	return nil, nil
+}
+
+func (m *PriorityQueuer) DequeueLevel(ctx context.Context, queueName string) ([]interface{}, error) {
+	m.record("DequeueLevel", queueName)
+	if m.DequeueLevelFunc != nil {
+		return m.DequeueLevelFunc(ctx, queueName)
+	}
+	return nil, nil
+}
+
+func (m *PriorityQueuer) DequeueBatch(ctx context.Context, queueName string, n int) ([]interface{}, error) {
+	m.record("DequeueBatch", queueName, n)
+	if m.DequeueBatchFunc != nil {
+		return m.DequeueBatchFunc(ctx, queueName, n)
+	}
+	return nil, nil
+}
+
+func (m *PriorityQueuer) DequeueWhere(ctx context.Context, queueName string, pred func(priorityqueue.Item) bool) (interface{}, error) {
+	m.record("DequeueWhere", queueName, pred)
+	if m.DequeueWhereFunc != nil {
+		return m.DequeueWhereFunc(ctx, queueName, pred)
+	}
+	return nil, nil
+}
+
+func (m *PriorityQueuer) DequeueFresh(ctx context.Context, queueName string, maxAge time.Duration, expire bool) (interface{}, error) {
+	m.record("DequeueFresh", queueName, maxAge, expire)
+	if m.DequeueFreshFunc != nil {
+		return m.DequeueFreshFunc(ctx, queueName, maxAge, expire)
+	}
+	return nil, nil
+}
+
+func (m *PriorityQueuer) SetPriorityWeights(ctx context.Context, queueName string, weights []float64) error {
+	m.record("SetPriorityWeights", queueName, weights)
+	if m.SetPriorityWeightsFunc != nil {
+		return m.SetPriorityWeightsFunc(ctx, queueName, weights)
+	}
+	return nil
+}
+
+func (m *PriorityQueuer) FreezeQueue(ctx context.Context, queueName string) error {
+	m.record("FreezeQueue", queueName)
+	if m.FreezeQueueFunc != nil {
+		return m.FreezeQueueFunc(ctx, queueName)
+	}
+	return nil
+}
+
+func (m *PriorityQueuer) UnfreezeQueue(ctx context.Context, queueName string) error {
+	m.record("UnfreezeQueue", queueName)
+	if m.UnfreezeQueueFunc != nil {
+		return m.UnfreezeQueueFunc(ctx, queueName)
+	}
+	return nil
+}
+
+func (m *PriorityQueuer) CompactQueue(ctx context.Context, queueName string) error {
+	m.record("CompactQueue", queueName)
+	if m.CompactQueueFunc != nil {
+		return m.CompactQueueFunc(ctx, queueName)
+	}
+	return nil
+}
+
+func (m *PriorityQueuer) VerifyQueue(ctx context.Context, queueName string, repair bool) (priorityqueue.VerifyReport, error) {
+	m.record("VerifyQueue", queueName, repair)
+	if m.VerifyQueueFunc != nil {
+		return m.VerifyQueueFunc(ctx, queueName, repair)
+	}
+	return priorityqueue.VerifyReport{}, nil
+}
+
+func (m *PriorityQueuer) Histogram(ctx context.Context, queueName string, bounds priorityqueue.HistogramBounds) (priorityqueue.QueueHistogram, error) {
+	m.record("Histogram", queueName, bounds)
+	if m.HistogramFunc != nil {
+		return m.HistogramFunc(ctx, queueName, bounds)
+	}
+	return priorityqueue.QueueHistogram{}, nil
+}
@@ -0,0 +1,87 @@
+package priorityqueue
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// Notifier delivers a queue event to people, for example through chat or
+// email. Register one with WebhookPublisher.AddNotifier.
+type Notifier interface {
+	Notify(ctx context.Context, event WebhookEvent) error
+}
+
+// EventText renders an event as a one-line human readable message
+func EventText(event WebhookEvent) string {
+	switch event.Type {
+	case EventQueueFrozen:
+		return fmt.Sprintf("queue '%s' was frozen", event.Queue)
+	case EventQueueUnfrozen:
+		return fmt.Sprintf("queue '%s' was unfrozen", event.Queue)
+	case EventDepthThreshold:
+		return fmt.Sprintf("queue '%s' reached depth %d", event.Queue, event.Depth)
+	case EventItemQuarantined:
+		return fmt.Sprintf("a corrupt item was quarantined from queue '%s'", event.Queue)
+	}
+	return fmt.Sprintf("%s on queue '%s'", event.Type, event.Queue)
+}
+
+// SlackNotifier posts events to a Slack incoming webhook
+type SlackNotifier struct {
+	url    string
+	client *http.Client
+}
+
+// NewSlackNotifier posts to the incoming webhook at url
+func NewSlackNotifier(url string) *SlackNotifier {
+	return &SlackNotifier{url: url, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+func (sn *SlackNotifier) Notify(ctx context.Context, event WebhookEvent) error {
+	body, err := json.Marshal(map[string]string{"text": EventText(event)})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, sn.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := sn.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("slack returned %s", resp.Status)
+	}
+	return nil
+}
+
+// SMTPNotifier emails events through an SMTP server
+type SMTPNotifier struct {
+	addr string
+	auth smtp.Auth
+	from string
+	to   []string
+}
+
+// NewSMTPNotifier sends mail from one address to the given recipients
+// through the server at addr ("host:port"). auth may be nil.
+func NewSMTPNotifier(addr string, auth smtp.Auth, from string, to ...string) *SMTPNotifier {
+	return &SMTPNotifier{addr: addr, auth: auth, from: from, to: to}
+}
+
+func (sn *SMTPNotifier) Notify(ctx context.Context, event WebhookEvent) error {
+	text := EventText(event)
+	msg := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: [pq] %s\r\n\r\n%s at %s\r\n",
+		sn.from, strings.Join(sn.to, ", "), text, text, event.Time.Format(time.RFC3339))
+	return smtp.SendMail(sn.addr, sn.auth, sn.from, sn.to, []byte(msg))
+}
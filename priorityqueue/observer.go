@@ -0,0 +1,225 @@
+package priorityqueue
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// ObserverQueue wraps a PriorityQueuer for dashboards and reporting jobs.
+// Reads pass through; every call that adds, removes or reorders items, or
+// changes a queue's settings, fails with ErrReadOnly without reaching the
+// wrapped queue. It doesn't embed the queue, so a method added to
+// PriorityQueuer must be classified here before ObserverQueue satisfies the
+// interface again.
+type ObserverQueue struct {
+	pq PriorityQueuer
+}
+
+// NewObserverQueue wraps pq read-only
+func NewObserverQueue(pq PriorityQueuer) *ObserverQueue {
+	return &ObserverQueue{pq: pq}
+}
+
+// observerError reports a mutation attempted through an ObserverQueue
+func observerError(op, queueName string) error {
+	return fmt.Errorf("%w: observer can't %s queue '%s'", ErrReadOnly, op, queueName)
+}
+
+func (oq *ObserverQueue) ListQueues(ctx context.Context) ([]string, error) {
+	return oq.pq.ListQueues(ctx)
+}
+
+func (oq *ObserverQueue) IsEmpty(ctx context.Context, queueName string) (bool, error) {
+	return oq.pq.IsEmpty(ctx, queueName)
+}
+
+func (oq *ObserverQueue) Size(ctx context.Context, queueName string) (int, error) {
+	return oq.pq.Size(ctx, queueName)
+}
+
+func (oq *ObserverQueue) CountByPriority(ctx context.Context, queueName string) (map[int]int, error) {
+	return oq.pq.CountByPriority(ctx, queueName)
+}
+
+func (oq *ObserverQueue) LevelLen(ctx context.Context, queueName string, priority int) (int64, error) {
+	return oq.pq.LevelLen(ctx, queueName, priority)
+}
+
+func (oq *ObserverQueue) FastLen(ctx context.Context, queueName string) (QueueDepth, error) {
+	return oq.pq.FastLen(ctx, queueName)
+}
+
+func (oq *ObserverQueue) ListContents(ctx context.Context, queueName string) (map[int][]interface{}, error) {
+	return oq.pq.ListContents(ctx, queueName)
+}
+
+func (oq *ObserverQueue) IterateContents(ctx context.Context, queueName string, fn func(priority int, value interface{}) bool) error {
+	return oq.pq.IterateContents(ctx, queueName, fn)
+}
+
+func (oq *ObserverQueue) IterateItems(ctx context.Context, queueName string, fn func(item Item) bool) error {
+	return oq.pq.IterateItems(ctx, queueName, fn)
+}
+
+func (oq *ObserverQueue) GetPosition(ctx context.Context, queueName string, value interface{}) (int, int, error) {
+	return oq.pq.GetPosition(ctx, queueName, value)
+}
+
+func (oq *ObserverQueue) Levels() int {
+	return oq.pq.Levels()
+}
+
+func (oq *ObserverQueue) OldestItem(ctx context.Context, queueName string) (interface{}, time.Duration, error) {
+	return oq.pq.OldestItem(ctx, queueName)
+}
+
+func (oq *ObserverQueue) NewestItem(ctx context.Context, queueName string) (interface{}, time.Duration, error) {
+	return oq.pq.NewestItem(ctx, queueName)
+}
+
+func (oq *ObserverQueue) Counters(ctx context.Context, queueName string) (QueueCounters, error) {
+	return oq.pq.Counters(ctx, queueName)
+}
+
+func (oq *ObserverQueue) Peek(ctx context.Context, queueName string) (interface{}, error) {
+	return oq.pq.Peek(ctx, queueName)
+}
+
+func (oq *ObserverQueue) PeekPriority(ctx context.Context, queueName string, priority int) (interface{}, error) {
+	return oq.pq.PeekPriority(ctx, queueName, priority)
+}
+
+func (oq *ObserverQueue) GetByID(ctx context.Context, queueName, id string) (Item, error) {
+	return oq.pq.GetByID(ctx, queueName, id)
+}
+
+func (oq *ObserverQueue) Histogram(ctx context.Context, queueName string, bounds HistogramBounds) (QueueHistogram, error) {
+	return oq.pq.Histogram(ctx, queueName, bounds)
+}
+
+// VerifyQueue checks a queue but refuses to repair it
+func (oq *ObserverQueue) VerifyQueue(ctx context.Context, queueName string, repair bool) (VerifyReport, error) {
+	if repair {
+		return VerifyReport{}, observerError("repair", queueName)
+	}
+	return oq.pq.VerifyQueue(ctx, queueName, false)
+}
+
+func (oq *ObserverQueue) AddQueue(ctx context.Context, name string) error {
+	return observerError("add", name)
+}
+
+func (oq *ObserverQueue) RemoveQueue(ctx context.Context, name string) error {
+	return observerError("remove", name)
+}
+
+func (oq *ObserverQueue) Purge(ctx context.Context, queueName string) error {
+	return observerError("purge", queueName)
+}
+
+func (oq *ObserverQueue) Enqueue(ctx context.Context, queueName string, value interface{}, priority int) error {
+	return observerError("enqueue into", queueName)
+}
+
+func (oq *ObserverQueue) EnqueueFanout(ctx context.Context, queueNames []string, value interface{}, priority int) error {
+	if len(queueNames) == 0 {
+		return nil
+	}
+	return observerError("enqueue into", queueNames[0])
+}
+
+func (oq *ObserverQueue) EnqueueMulti(ctx context.Context, entries []QueueEntry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	return observerError("enqueue into", entries[0].QueueName)
+}
+
+func (oq *ObserverQueue) EnqueueWithID(ctx context.Context, queueName string, value interface{}, priority int) (string, error) {
+	return "", observerError("enqueue into", queueName)
+}
+
+func (oq *ObserverQueue) EnqueueBatch(ctx context.Context, queueName string, items []Item) error {
+	return observerError("enqueue into", queueName)
+}
+
+func (oq *ObserverQueue) InsertAtTop(ctx context.Context, queueName string, value interface{}, priority int) error {
+	return observerError("insert into", queueName)
+}
+
+func (oq *ObserverQueue) InsertAtTopBatch(ctx context.Context, queueName string, values []interface{}, priority int) error {
+	return observerError("insert into", queueName)
+}
+
+func (oq *ObserverQueue) Dequeue(ctx context.Context, queueName string) (interface{}, error) {
+	return nil, observerError("dequeue from", queueName)
+}
+
+func (oq *ObserverQueue) DequeueFromPriority(ctx context.Context, queueName string, priority int) (interface{}, error) {
+	return nil, observerError("dequeue from", queueName)
+}
+
+func (oq *ObserverQueue) DequeueLevel(ctx context.Context, queueName string) ([]interface{}, error) {
+	return nil, observerError("dequeue from", queueName)
+}
+
+func (oq *ObserverQueue) DequeueBatch(ctx context.Context, queueName string, n int) ([]interface{}, error) {
+	return nil, observerError("dequeue from", queueName)
+}
+
+func (oq *ObserverQueue) DequeueWhere(ctx context.Context, queueName string, pred func(Item) bool) (interface{}, error) {
+	return nil, observerError("dequeue from", queueName)
+}
+
+func (oq *ObserverQueue) DequeueFresh(ctx context.Context, queueName string, maxAge time.Duration, expire bool) (interface{}, error) {
+	return nil, observerError("dequeue from", queueName)
+}
+
+func (oq *ObserverQueue) DeleteItem(ctx context.Context, queueName string, value interface{}) error {
+	return observerError("delete from", queueName)
+}
+
+func (oq *ObserverQueue) DeleteByID(ctx context.Context, queueName, id string) error {
+	return observerError("delete from", queueName)
+}
+
+func (oq *ObserverQueue) UpdateByID(ctx context.Context, queueName, id string, value interface{}) error {
+	return observerError("update", queueName)
+}
+
+func (oq *ObserverQueue) MoveToPosition(ctx context.Context, queueName string, itemID string, priority, position int) error {
+	return observerError("reorder", queueName)
+}
+
+func (oq *ObserverQueue) UpdatePriority(ctx context.Context, queueName string, value interface{}, newPriority int) error {
+	return observerError("reorder", queueName)
+}
+
+func (oq *ObserverQueue) SwapItems(ctx context.Context, queueName, itemA, itemB string) error {
+	return observerError("reorder", queueName)
+}
+
+func (oq *ObserverQueue) MoveItem(ctx context.Context, srcQueue, dstQueue string, value interface{}) error {
+	return observerError("move items out of", srcQueue)
+}
+
+func (oq *ObserverQueue) RenameQueue(ctx context.Context, oldName, newName string) error {
+	return observerError("rename", oldName)
+}
+
+func (oq *ObserverQueue) SetPriorityWeights(ctx context.Context, queueName string, weights []float64) error {
+	return observerError("set weights on", queueName)
+}
+
+func (oq *ObserverQueue) FreezeQueue(ctx context.Context, queueName string) error {
+	return observerError("freeze", queueName)
+}
+
+func (oq *ObserverQueue) UnfreezeQueue(ctx context.Context, queueName string) error {
+	return observerError("unfreeze", queueName)
+}
+
+func (oq *ObserverQueue) CompactQueue(ctx context.Context, queueName string) error {
+	return observerError("compact", queueName)
+}
@@ -0,0 +1,92 @@
+package priorityqueue
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// Source is a pipeline stage that dequeues items from a queue in strict
+// priority order and sends them to out, polling every interval while the
+// queue is empty. It closes out and returns when ctx is done, with ctx's
+// error, or on the first error other than an empty queue. An item dequeued
+// as ctx is cancelled is put back at the top of its level, so none are lost.
+// Weights and scheduling policies are not applied.
+func Source(ctx context.Context, pq PriorityQueuer, queueName string, out chan<- interface{}, interval time.Duration) error {
+	defer close(out)
+
+	for {
+		value, priority, err := dequeueHead(ctx, pq, queueName)
+		if errors.Is(err, ErrQueueEmpty) {
+			select {
+			case <-time.After(interval):
+				continue
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err() // Backends don't all wrap context errors
+			}
+			return err
+		}
+
+		select {
+		case out <- value:
+		case <-ctx.Done():
+			if err := pq.InsertAtTop(context.WithoutCancel(ctx), queueName, value, priority); err != nil {
+				return err
+			}
+			return ctx.Err()
+		}
+	}
+}
+
+// dequeueHead removes the head of the highest non-empty priority level and
+// returns it with its priority
+func dequeueHead(ctx context.Context, pq PriorityQueuer, queueName string) (interface{}, int, error) {
+	for {
+		counts, err := pq.CountByPriority(ctx, queueName)
+		if err != nil {
+			return nil, 0, err
+		}
+		priority := -1
+		for p := 0; p < pq.Levels(); p++ {
+			if counts[p] > 0 {
+				priority = p
+				break
+			}
+		}
+		if priority < 0 {
+			return nil, 0, emptyError(queueName)
+		}
+		value, err := pq.DequeueFromPriority(ctx, queueName, priority)
+		if errors.Is(err, ErrQueueEmpty) {
+			continue // Another consumer emptied the level first
+		}
+		return value, priority, err
+	}
+}
+
+// Sink is a pipeline stage that enqueues every value received from in at the
+// priority priorityFn assigns it. It returns nil once in is closed, ctx's
+// error when ctx is done, or the first enqueue error.
+func Sink(ctx context.Context, pq PriorityQueuer, queueName string, in <-chan interface{}, priorityFn func(interface{}) int) error {
+	for {
+		select {
+		case value, ok := <-in:
+			if !ok {
+				return nil
+			}
+			if err := pq.Enqueue(ctx, queueName, value, priorityFn(value)); err != nil {
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+				return err
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
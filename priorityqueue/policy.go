@@ -0,0 +1,78 @@
+package priorityqueue
+
+import (
+	"time"
+)
+
+// SchedulingState is the view of a queue a SchedulingPolicy chooses from.
+// Levels[p] holds the items at priority p in queue order; policies must treat
+// it as read-only.
+type SchedulingState struct {
+	Levels [][]Item
+	Now    time.Time
+}
+
+// SchedulingPolicy decides which item Dequeue removes next. NextCandidate
+// returns the priority level and index within that level of the chosen item,
+// or (-1, -1) if nothing should be dequeued.
+type SchedulingPolicy interface {
+	NextCandidate(state SchedulingState) (priority, index int)
+}
+
+// StrictPolicy always takes the head of the highest non-empty priority level.
+// It is the default.
+type StrictPolicy struct{}
+
+func (StrictPolicy) NextCandidate(state SchedulingState) (int, int) {
+	for priority, level := range state.Levels {
+		if len(level) > 0 {
+			return priority, 0
+		}
+	}
+	return -1, -1
+}
+
+// WeightedPolicy takes the head of a non-empty level chosen at random with
+// probability proportional to Weights, which has one entry per level
+type WeightedPolicy struct {
+	Weights []float64
+}
+
+func (p WeightedPolicy) NextCandidate(state SchedulingState) (int, int) {
+	nonEmpty := make([]bool, len(state.Levels))
+	for priority, level := range state.Levels {
+		nonEmpty[priority] = len(level) > 0
+	}
+	if priority := pickWeightedLevel(p.Weights, nonEmpty); priority >= 0 {
+		return priority, 0
+	}
+	return -1, -1
+}
+
+// AgedPolicy raises the effective priority of waiting items by one level for
+// every Interval they have been queued, so low-priority work can't starve.
+// Only level heads are compared, which keeps FIFO order within a level; ties
+// go to the higher base priority.
+type AgedPolicy struct {
+	Interval time.Duration
+}
+
+func (p AgedPolicy) NextCandidate(state SchedulingState) (int, int) {
+	best, bestScore := -1, 0.0
+	for priority, level := range state.Levels {
+		if len(level) == 0 {
+			continue
+		}
+		score := float64(priority)
+		if p.Interval > 0 {
+			score -= float64(state.Now.Sub(level[0].EnqueuedAt)) / float64(p.Interval)
+		}
+		if best == -1 || score < bestScore {
+			best, bestScore = priority, score
+		}
+	}
+	if best == -1 {
+		return -1, -1
+	}
+	return best, 0
+}
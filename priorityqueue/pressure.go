@@ -0,0 +1,135 @@
+package priorityqueue
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// PressureLimits are the soft limits a queue's pressure is measured against.
+// MaxDepth is a depth in items and MaxGrowth a net growth rate in items per
+// second; either may be zero to ignore it.
+type PressureLimits struct {
+	MaxDepth  int64
+	MaxGrowth float64
+}
+
+// pressureSampleInterval is the shortest span the growth rate is measured
+// over; calls in between reuse the last rate
+const pressureSampleInterval = time.Second
+
+type pressureState struct {
+	counters QueueCounters
+	at       time.Time
+	growth   float64
+}
+
+// PressureQueue wraps a PriorityQueuer and reports how close each queue is to
+// its soft limits, so producers can shed or degrade load before hard limits
+// such as a SpillRule or a ProducerGate quota start rejecting work
+type PressureQueue struct {
+	PriorityQueuer
+	limits map[string]PressureLimits
+	state  map[string]*pressureState
+	clock  Clock
+	mutex  sync.Mutex
+}
+
+// NewPressureQueue wraps pq with no limits configured
+func NewPressureQueue(pq PriorityQueuer) *PressureQueue {
+	return &PressureQueue{
+		PriorityQueuer: pq,
+		limits:         make(map[string]PressureLimits),
+		state:          make(map[string]*pressureState),
+		clock:          SystemClock{},
+	}
+}
+
+// SetClock replaces the time source used to measure growth. It must be
+// called before the queue is shared between goroutines.
+func (prq *PressureQueue) SetClock(clock Clock) {
+	prq.clock = clock
+}
+
+// SetPressureLimits configures a queue's limits. Zero limits remove them.
+func (prq *PressureQueue) SetPressureLimits(queueName string, limits PressureLimits) error {
+	if limits.MaxDepth < 0 || limits.MaxGrowth < 0 {
+		return fmt.Errorf("pressure limits must not be negative")
+	}
+
+	prq.mutex.Lock()
+	defer prq.mutex.Unlock()
+
+	if limits == (PressureLimits{}) {
+		delete(prq.limits, queueName)
+	} else {
+		prq.limits[queueName] = limits
+	}
+	delete(prq.state, queueName)
+	return nil
+}
+
+// Pressure returns how loaded a queue is relative to its limits: 0 when idle,
+// 1 when depth or growth reaches its limit, and more beyond that. It reads
+// the cached FastLen depth and the queue counters, so it is cheap enough to
+// call before every enqueue.
+func (prq *PressureQueue) Pressure(ctx context.Context, queueName string) (float64, error) {
+	prq.mutex.Lock()
+	limits, ok := prq.limits[queueName]
+	prq.mutex.Unlock()
+
+	if !ok {
+		return 0, fmt.Errorf("no pressure limits set for queue '%s'", queueName)
+	}
+
+	depth, err := prq.PriorityQueuer.FastLen(ctx, queueName)
+	if err != nil {
+		return 0, err
+	}
+	counters, err := prq.PriorityQueuer.Counters(ctx, queueName)
+	if err != nil {
+		return 0, err
+	}
+	now := prq.clock.Now()
+
+	prq.mutex.Lock()
+	state, ok := prq.state[queueName]
+	if !ok {
+		state = &pressureState{counters: counters, at: now}
+		prq.state[queueName] = state
+	} else if elapsed := now.Sub(state.at); elapsed >= pressureSampleInterval {
+		net := (counters.Enqueued - state.counters.Enqueued) - (counters.Dequeued - state.counters.Dequeued)
+		state.growth = float64(net) / elapsed.Seconds()
+		state.counters = counters
+		state.at = now
+	}
+	growth := state.growth
+	prq.mutex.Unlock()
+
+	var pressure float64
+	if limits.MaxDepth > 0 {
+		pressure = float64(depth.Len) / float64(limits.MaxDepth)
+	}
+	if limits.MaxGrowth > 0 && growth/limits.MaxGrowth > pressure {
+		pressure = growth / limits.MaxGrowth
+	}
+	return pressure, nil
+}
+
+// EnqueueWithPressure enqueues value and returns the queue's pressure
+// afterwards. Queues without limits report 0.
+func (prq *PressureQueue) EnqueueWithPressure(ctx context.Context, queueName string, value interface{}, priority int) (float64, error) {
+	if err := prq.PriorityQueuer.Enqueue(ctx, queueName, value, priority); err != nil {
+		return 0, err
+	}
+
+	prq.mutex.Lock()
+	_, ok := prq.limits[queueName]
+	prq.mutex.Unlock()
+
+	if !ok {
+		return 0, nil
+	}
+	return prq.Pressure(ctx, queueName)
+}
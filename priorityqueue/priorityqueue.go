@@ -1,37 +1,155 @@
 package priorityqueue
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"math/rand"
+	"sort"
 	"sync"
+	"time"
 )
 
 // PriorityQueuer defines the interface for priority queue operations
 type PriorityQueuer interface {
-	AddQueue(name string) error
-	Enqueue(queueName string, value interface{}, priority int) error
-	Dequeue(queueName string) (interface{}, error)
-	IsEmpty(queueName string) (bool, error)
-	ListContents(queueName string) (map[int][]interface{}, error)
-	GetPosition(queueName string, value interface{}) (int, int, error)
-	InsertAtTop(queueName string, value interface{}, priority int) error
-	DeleteItem(queueName string, value interface{}) error
+	AddQueue(ctx context.Context, name string) error
+	RemoveQueue(ctx context.Context, name string) error
+	ListQueues(ctx context.Context) ([]string, error)
+	Purge(ctx context.Context, queueName string) error
+	Enqueue(ctx context.Context, queueName string, value interface{}, priority int) error
+	EnqueueFanout(ctx context.Context, queueNames []string, value interface{}, priority int) error
+	EnqueueMulti(ctx context.Context, entries []QueueEntry) error
+	EnqueueBatch(ctx context.Context, queueName string, items []Item) error
+	Dequeue(ctx context.Context, queueName string) (interface{}, error)
+	IsEmpty(ctx context.Context, queueName string) (bool, error)
+	Size(ctx context.Context, queueName string) (int, error)
+	CountByPriority(ctx context.Context, queueName string) (map[int]int, error)
+	LevelLen(ctx context.Context, queueName string, priority int) (int64, error)
+	FastLen(ctx context.Context, queueName string) (QueueDepth, error)
+	ListContents(ctx context.Context, queueName string) (map[int][]interface{}, error)
+	IterateContents(ctx context.Context, queueName string, fn func(priority int, value interface{}) bool) error
+	IterateItems(ctx context.Context, queueName string, fn func(item Item) bool) error
+	GetPosition(ctx context.Context, queueName string, value interface{}) (int, int, error)
+	InsertAtTop(ctx context.Context, queueName string, value interface{}, priority int) error
+	InsertAtTopBatch(ctx context.Context, queueName string, values []interface{}, priority int) error
+	DeleteItem(ctx context.Context, queueName string, value interface{}) error
+	MoveToPosition(ctx context.Context, queueName string, itemID string, priority, position int) error
+	UpdatePriority(ctx context.Context, queueName string, value interface{}, newPriority int) error
+	MoveItem(ctx context.Context, srcQueue, dstQueue string, value interface{}) error
+	RenameQueue(ctx context.Context, oldName, newName string) error
+	Levels() int
+	EnqueueWithID(ctx context.Context, queueName string, value interface{}, priority int) (string, error)
+	GetByID(ctx context.Context, queueName, id string) (Item, error)
+	DeleteByID(ctx context.Context, queueName, id string) error
+	UpdateByID(ctx context.Context, queueName, id string, value interface{}) error
+	SwapItems(ctx context.Context, queueName, itemA, itemB string) error
+	OldestItem(ctx context.Context, queueName string) (interface{}, time.Duration, error)
+	NewestItem(ctx context.Context, queueName string) (interface{}, time.Duration, error)
+	Counters(ctx context.Context, queueName string) (QueueCounters, error)
+	Peek(ctx context.Context, queueName string) (interface{}, error)
+	PeekPriority(ctx context.Context, queueName string, priority int) (interface{}, error)
+	DequeueFromPriority(ctx context.Context, queueName string, priority int) (interface{}, error)
+	DequeueLevel(ctx context.Context, queueName string) ([]interface{}, error)
+	DequeueBatch(ctx context.Context, queueName string, n int) ([]interface{}, error)
+	DequeueWhere(ctx context.Context, queueName string, pred func(Item) bool) (interface{}, error)
+	DequeueFresh(ctx context.Context, queueName string, maxAge time.Duration, expire bool) (interface{}, error)
+	SetPriorityWeights(ctx context.Context, queueName string, weights []float64) error
+	FreezeQueue(ctx context.Context, queueName string) error
+	UnfreezeQueue(ctx context.Context, queueName string) error
+	CompactQueue(ctx context.Context, queueName string) error
+	VerifyQueue(ctx context.Context, queueName string, repair bool) (VerifyReport, error)
+	Histogram(ctx context.Context, queueName string, bounds HistogramBounds) (QueueHistogram, error)
+}
+
+// ErrQueueFrozen is returned by calls that would change a frozen queue
+var ErrQueueFrozen = errors.New("queue is frozen")
+
+// DefaultLevels is the number of priority levels, 0 to 9, a queue has unless
+// SetLevels changes it
+const DefaultLevels = 10
+
+// checkPriority fails if priority is outside levels 0 to levels-1
+func checkPriority(priority, levels int) error {
+	if priority < 0 || priority >= levels {
+		return fmt.Errorf("priority must be between 0 and %d", levels-1)
+	}
+	return nil
+}
+
+// frozenError reports an attempt to change a frozen queue
+func frozenError(queueName string) error {
+	return fmt.Errorf("%w: queue '%s'", ErrQueueFrozen, queueName)
+}
+
+// ErrQueueEmpty is returned by calls that read or remove an item from an
+// empty queue or priority level
+var ErrQueueEmpty = errors.New("queue is empty")
+
+func emptyError(queueName string) error {
+	return fmt.Errorf("%w: queue '%s'", ErrQueueEmpty, queueName)
+}
+
+func levelEmptyError(priority int, queueName string) error {
+	return fmt.Errorf("%w: priority %d of queue '%s'", ErrQueueEmpty, priority, queueName)
 }
 
 // Item represents an element in the priority queue
 type Item struct {
-	Value    interface{}
-	Priority int
+	ID         string // assigned on insert, see EnqueueWithID
+	Value      interface{}
+	Priority   int
+	EnqueuedAt time.Time
+}
+
+// scanAction tells a queue scan what to do with an item
+type scanAction int
+
+const (
+	scanKeep scanAction = iota // leave the item and keep looking
+	scanTake                   // remove and return the item
+	scanDrop                   // delete the item and keep looking
+)
+
+// freshAction decides DequeueFresh's handling of an item of the given age
+func freshAction(age, maxAge time.Duration, expire bool) scanAction {
+	switch {
+	case age <= maxAge:
+		return scanTake
+	case expire:
+		return scanDrop
+	default:
+		return scanKeep
+	}
+}
+
+// QueueEntry is one item to enqueue with EnqueueMulti
+type QueueEntry struct {
+	QueueName string
+	Value     interface{}
+	Priority  int
+}
+
+// QueueCounters holds monotonically increasing totals for a queue
+type QueueCounters struct {
+	Enqueued int64
+	Dequeued int64
 }
 
 // PriorityQueue represents a single priority queue with multiple priority levels
 type PriorityQueue struct {
-	queues [][]Item
-	mutex  sync.Mutex
+	queues   [][]Item
+	policy   SchedulingPolicy
+	counters QueueCounters
+	frozen   bool
+	mutex    sync.Mutex
 }
 
 // MultiPriorityQueue manages multiple named priority queues
 type MultiPriorityQueue struct {
 	queues map[string]*PriorityQueue
+	depths *depthCache
+	clock  Clock
+	levels int
 	mutex  sync.Mutex
 }
 
@@ -39,13 +157,51 @@ type MultiPriorityQueue struct {
 func NewMultiPriorityQueue() PriorityQueuer {
 	return &MultiPriorityQueue{
 		queues: make(map[string]*PriorityQueue),
+		depths: newDepthCache(),
+		clock:  SystemClock{},
+		levels: DefaultLevels,
+	}
+}
+
+// SetClock replaces the time source used for enqueue timestamps, ages and
+// scheduling. It must be called before the queue is shared between goroutines.
+func (mpq *MultiPriorityQueue) SetClock(clock Clock) {
+	mpq.clock = clock
+}
+
+// SetLevels changes the number of priority levels, 0 to levels-1, that new
+// queues get. It fails once a queue has been added, so every queue of a
+// MultiPriorityQueue has the same levels.
+func (mpq *MultiPriorityQueue) SetLevels(levels int) error {
+	if levels < 1 {
+		return fmt.Errorf("levels must be at least 1")
+	}
+
+	mpq.mutex.Lock()
+	defer mpq.mutex.Unlock()
+
+	if len(mpq.queues) > 0 {
+		return fmt.Errorf("levels can't be changed once queues exist")
 	}
+	mpq.levels = levels
+	return nil
+}
+
+// Levels returns the number of priority levels
+func (mpq *MultiPriorityQueue) Levels() int {
+	mpq.mutex.Lock()
+	defer mpq.mutex.Unlock()
+	return mpq.levels
 }
 
 // NewPriorityQueue creates a new single priority queue with 10 priority levels
 func NewPriorityQueue() *PriorityQueue {
+	return newPriorityQueue(DefaultLevels)
+}
+
+func newPriorityQueue(levels int) *PriorityQueue {
 	pq := &PriorityQueue{
-		queues: make([][]Item, 10),
+		queues: make([][]Item, levels),
 	}
 	for i := range pq.queues {
 		pq.queues[i] = make([]Item, 0)
@@ -53,7 +209,7 @@ func NewPriorityQueue() *PriorityQueue {
 	return pq
 }
 
-func (mpq *MultiPriorityQueue) AddQueue(name string) error {
+func (mpq *MultiPriorityQueue) AddQueue(ctx context.Context, name string) error {
 	mpq.mutex.Lock()
 	defer mpq.mutex.Unlock()
 
@@ -61,13 +217,132 @@ func (mpq *MultiPriorityQueue) AddQueue(name string) error {
 		return fmt.Errorf("queue '%s' already exists", name)
 	}
 
-	mpq.queues[name] = NewPriorityQueue()
+	mpq.queues[name] = newPriorityQueue(mpq.levels)
+	return nil
+}
+
+// ListQueues returns the names of every queue in order
+func (mpq *MultiPriorityQueue) ListQueues(ctx context.Context) ([]string, error) {
+	mpq.mutex.Lock()
+	defer mpq.mutex.Unlock()
+
+	names := make([]string, 0, len(mpq.queues))
+	for name := range mpq.queues {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// Purge removes every item from a queue, keeping the queue, its settings and
+// its counters
+func (mpq *MultiPriorityQueue) Purge(ctx context.Context, queueName string) error {
+	mpq.mutex.Lock()
+	pq, exists := mpq.queues[queueName]
+	mpq.mutex.Unlock()
+
+	if !exists {
+		return fmt.Errorf("queue '%s' does not exist", queueName)
+	}
+
+	pq.mutex.Lock()
+	defer pq.mutex.Unlock()
+
+	if pq.frozen {
+		return frozenError(queueName)
+	}
+
+	for i := range pq.queues {
+		pq.queues[i] = make([]Item, 0)
+	}
+	mpq.depths.forget(queueName)
+	return nil
+}
+
+// RemoveQueue deletes a queue and every item in it. Frozen queues can't be
+// removed.
+func (mpq *MultiPriorityQueue) RemoveQueue(ctx context.Context, name string) error {
+	mpq.mutex.Lock()
+	defer mpq.mutex.Unlock()
+
+	pq, exists := mpq.queues[name]
+	if !exists {
+		return fmt.Errorf("queue '%s' does not exist", name)
+	}
+
+	pq.mutex.Lock()
+	frozen := pq.frozen
+	pq.mutex.Unlock()
+	if frozen {
+		return frozenError(name)
+	}
+
+	delete(mpq.queues, name)
+	mpq.depths.forget(name)
+	return nil
+}
+
+// RenameQueue moves a queue, with its items, counters and settings, to a new
+// name. It fails if newName already exists.
+func (mpq *MultiPriorityQueue) RenameQueue(ctx context.Context, oldName, newName string) error {
+	mpq.mutex.Lock()
+	defer mpq.mutex.Unlock()
+
+	pq, exists := mpq.queues[oldName]
+	if !exists {
+		return fmt.Errorf("queue '%s' does not exist", oldName)
+	}
+	if _, exists := mpq.queues[newName]; exists {
+		return fmt.Errorf("queue '%s' already exists", newName)
+	}
+
+	pq.mutex.Lock()
+	frozen := pq.frozen
+	pq.mutex.Unlock()
+	if frozen {
+		return frozenError(oldName)
+	}
+
+	mpq.queues[newName] = pq
+	delete(mpq.queues, oldName)
+	mpq.depths.forget(oldName)
+	mpq.depths.forget(newName)
+	return nil
+}
+
+func (mpq *MultiPriorityQueue) Enqueue(ctx context.Context, queueName string, value interface{}, priority int) error {
+	if err := checkPriority(priority, mpq.levels); err != nil {
+		return err
+	}
+
+	mpq.mutex.Lock()
+	pq, exists := mpq.queues[queueName]
+	mpq.mutex.Unlock()
+
+	if !exists {
+		return fmt.Errorf("queue '%s' does not exist", queueName)
+	}
+
+	pq.mutex.Lock()
+	defer pq.mutex.Unlock()
+
+	if pq.frozen {
+		return frozenError(queueName)
+	}
+
+	pq.queues[priority] = append(pq.queues[priority], Item{ID: newItemID(), Value: value, Priority: priority, EnqueuedAt: mpq.clock.Now()})
+	pq.counters.Enqueued++
 	return nil
 }
 
-func (mpq *MultiPriorityQueue) Enqueue(queueName string, value interface{}, priority int) error {
-	if priority < 0 || priority > 9 {
-		return fmt.Errorf("priority must be between 0 and 9")
+// EnqueueBatch appends every item to the tail of its priority level in one
+// call. Either every item is enqueued or none are. Each item's Value and
+// Priority are used; EnqueuedAt is set to the current time.
+func (mpq *MultiPriorityQueue) EnqueueBatch(ctx context.Context, queueName string, items []Item) error {
+	for _, item := range items {
+		if err := checkPriority(item.Priority, mpq.levels); err != nil {
+			return err
+		}
 	}
 
 	mpq.mutex.Lock()
@@ -81,11 +356,108 @@ func (mpq *MultiPriorityQueue) Enqueue(queueName string, value interface{}, prio
 	pq.mutex.Lock()
 	defer pq.mutex.Unlock()
 
-	pq.queues[priority] = append(pq.queues[priority], Item{Value: value, Priority: priority})
+	if pq.frozen {
+		return frozenError(queueName)
+	}
+
+	now := mpq.clock.Now()
+	for _, item := range items {
+		pq.queues[item.Priority] = append(pq.queues[item.Priority], Item{ID: newItemID(), Value: item.Value, Priority: item.Priority, EnqueuedAt: now})
+	}
+	pq.counters.Enqueued += int64(len(items))
+	return nil
+}
+
+// EnqueueFanout adds a copy of value to each of the named queues. Either every
+// queue receives the item or, if any queue is missing, none do.
+func (mpq *MultiPriorityQueue) EnqueueFanout(ctx context.Context, queueNames []string, value interface{}, priority int) error {
+	if err := checkPriority(priority, mpq.levels); err != nil {
+		return err
+	}
+
+	// Lock queues in name order so concurrent fan-outs can't deadlock
+	names := append([]string(nil), queueNames...)
+	sort.Strings(names)
+
+	mpq.mutex.Lock()
+	pqs := make(map[string]*PriorityQueue, len(names))
+	for _, name := range names {
+		pq, exists := mpq.queues[name]
+		if !exists {
+			mpq.mutex.Unlock()
+			return fmt.Errorf("queue '%s' does not exist", name)
+		}
+		pqs[name] = pq
+	}
+	mpq.mutex.Unlock()
+
+	for i, name := range names {
+		if i > 0 && name == names[i-1] {
+			continue
+		}
+		pqs[name].mutex.Lock()
+		defer pqs[name].mutex.Unlock()
+	}
+	for _, name := range names {
+		if pqs[name].frozen {
+			return frozenError(name)
+		}
+	}
+
+	now := mpq.clock.Now()
+	for _, pq := range pqs {
+		pq.queues[priority] = append(pq.queues[priority], Item{ID: newItemID(), Value: value, Priority: priority, EnqueuedAt: now})
+		pq.counters.Enqueued++
+	}
+	return nil
+}
+
+// EnqueueMulti adds each entry to its queue. Either every entry is queued or,
+// if any queue is missing or frozen, none are.
+func (mpq *MultiPriorityQueue) EnqueueMulti(ctx context.Context, entries []QueueEntry) error {
+	names := make([]string, 0, len(entries))
+	for _, e := range entries {
+		if err := checkPriority(e.Priority, mpq.levels); err != nil {
+			return err
+		}
+		names = append(names, e.QueueName)
+	}
+	// Lock queues in name order so concurrent multi-queue calls can't deadlock
+	sort.Strings(names)
+
+	mpq.mutex.Lock()
+	pqs := make(map[string]*PriorityQueue, len(names))
+	for _, name := range names {
+		pq, exists := mpq.queues[name]
+		if !exists {
+			mpq.mutex.Unlock()
+			return fmt.Errorf("queue '%s' does not exist", name)
+		}
+		pqs[name] = pq
+	}
+	mpq.mutex.Unlock()
+
+	for i, name := range names {
+		if i > 0 && name == names[i-1] {
+			continue
+		}
+		pqs[name].mutex.Lock()
+		defer pqs[name].mutex.Unlock()
+		if pqs[name].frozen {
+			return frozenError(name)
+		}
+	}
+
+	now := mpq.clock.Now()
+	for _, e := range entries {
+		pq := pqs[e.QueueName]
+		pq.queues[e.Priority] = append(pq.queues[e.Priority], Item{ID: newItemID(), Value: e.Value, Priority: e.Priority, EnqueuedAt: now})
+		pq.counters.Enqueued++
+	}
 	return nil
 }
 
-func (mpq *MultiPriorityQueue) Dequeue(queueName string) (interface{}, error) {
+func (mpq *MultiPriorityQueue) Dequeue(ctx context.Context, queueName string) (interface{}, error) {
 	mpq.mutex.Lock()
 	pq, exists := mpq.queues[queueName]
 	mpq.mutex.Unlock()
@@ -97,18 +469,40 @@ func (mpq *MultiPriorityQueue) Dequeue(queueName string) (interface{}, error) {
 	pq.mutex.Lock()
 	defer pq.mutex.Unlock()
 
-	for i := 0; i < 10; i++ {
+	if pq.frozen {
+		return nil, frozenError(queueName)
+	}
+
+	if pq.policy != nil {
+		priority, i := pq.policy.NextCandidate(SchedulingState{Levels: pq.queues, Now: mpq.clock.Now()})
+		if priority < 0 || priority >= len(pq.queues) || i < 0 || i >= len(pq.queues[priority]) {
+			return nil, emptyError(queueName)
+		}
+		item := pq.queues[priority][i]
+		if i == 0 {
+			pq.queues[priority] = pq.queues[priority][1:]
+		} else {
+			pq.queues[priority] = append(pq.queues[priority][:i], pq.queues[priority][i+1:]...)
+		}
+		pq.counters.Dequeued++
+		noteRemoved(ctx, item.ID)
+		return item.Value, nil
+	}
+
+	for i := 0; i < mpq.levels; i++ {
 		if len(pq.queues[i]) > 0 {
 			item := pq.queues[i][0]
 			pq.queues[i] = pq.queues[i][1:]
+			pq.counters.Dequeued++
+			noteRemoved(ctx, item.ID)
 			return item.Value, nil
 		}
 	}
 
-	return nil, fmt.Errorf("queue '%s' is empty", queueName)
+	return nil, emptyError(queueName)
 }
 
-func (mpq *MultiPriorityQueue) IsEmpty(queueName string) (bool, error) {
+func (mpq *MultiPriorityQueue) IsEmpty(ctx context.Context, queueName string) (bool, error) {
 	mpq.mutex.Lock()
 	pq, exists := mpq.queues[queueName]
 	mpq.mutex.Unlock()
@@ -120,7 +514,7 @@ func (mpq *MultiPriorityQueue) IsEmpty(queueName string) (bool, error) {
 	pq.mutex.Lock()
 	defer pq.mutex.Unlock()
 
-	for i := 0; i < 10; i++ {
+	for i := 0; i < mpq.levels; i++ {
 		if len(pq.queues[i]) > 0 {
 			return false, nil
 		}
@@ -128,7 +522,111 @@ func (mpq *MultiPriorityQueue) IsEmpty(queueName string) (bool, error) {
 	return true, nil
 }
 
-func (mpq *MultiPriorityQueue) ListContents(queueName string) (map[int][]interface{}, error) {
+// Size returns the number of items in a queue
+func (mpq *MultiPriorityQueue) Size(ctx context.Context, queueName string) (int, error) {
+	mpq.mutex.Lock()
+	pq, exists := mpq.queues[queueName]
+	mpq.mutex.Unlock()
+
+	if !exists {
+		return 0, fmt.Errorf("queue '%s' does not exist", queueName)
+	}
+
+	pq.mutex.Lock()
+	defer pq.mutex.Unlock()
+
+	size := 0
+	for i := 0; i < mpq.levels; i++ {
+		size += len(pq.queues[i])
+	}
+	return size, nil
+}
+
+// CountByPriority returns the number of items at each non-empty priority level
+func (mpq *MultiPriorityQueue) CountByPriority(ctx context.Context, queueName string) (map[int]int, error) {
+	mpq.mutex.Lock()
+	pq, exists := mpq.queues[queueName]
+	mpq.mutex.Unlock()
+
+	if !exists {
+		return nil, fmt.Errorf("queue '%s' does not exist", queueName)
+	}
+
+	pq.mutex.Lock()
+	defer pq.mutex.Unlock()
+
+	counts := make(map[int]int)
+	for i := 0; i < mpq.levels; i++ {
+		if n := len(pq.queues[i]); n > 0 {
+			counts[i] = n
+		}
+	}
+	return counts, nil
+}
+
+// LevelLen returns the number of items queued at a single priority level
+func (mpq *MultiPriorityQueue) LevelLen(ctx context.Context, queueName string, priority int) (int64, error) {
+	if err := checkPriority(priority, mpq.levels); err != nil {
+		return 0, err
+	}
+
+	mpq.mutex.Lock()
+	pq, exists := mpq.queues[queueName]
+	mpq.mutex.Unlock()
+
+	if !exists {
+		return 0, fmt.Errorf("queue '%s' does not exist", queueName)
+	}
+
+	pq.mutex.Lock()
+	defer pq.mutex.Unlock()
+
+	return int64(len(pq.queues[priority])), nil
+}
+
+// FastLen returns the exact number of queued items and an estimate of the
+// oldest item's age, taken from the head of every level plus a few random
+// samples. Results are cached for depthCacheTTL.
+func (mpq *MultiPriorityQueue) FastLen(ctx context.Context, queueName string) (QueueDepth, error) {
+	mpq.mutex.Lock()
+	pq, exists := mpq.queues[queueName]
+	mpq.mutex.Unlock()
+
+	if !exists {
+		return QueueDepth{}, fmt.Errorf("queue '%s' does not exist", queueName)
+	}
+
+	now := mpq.clock.Now()
+	return mpq.depths.get(queueName, now, func() (QueueDepth, error) {
+		pq.mutex.Lock()
+		defer pq.mutex.Unlock()
+
+		var depth QueueDepth
+		var oldest time.Time
+		consider := func(item Item) {
+			if oldest.IsZero() || item.EnqueuedAt.Before(oldest) {
+				oldest = item.EnqueuedAt
+			}
+		}
+		for priority := 0; priority < mpq.levels; priority++ {
+			level := pq.queues[priority]
+			depth.Len += int64(len(level))
+			if len(level) == 0 {
+				continue
+			}
+			consider(level[0])
+			for i := 0; i < depthSamples && i < len(level)-1; i++ {
+				consider(level[1+rand.Intn(len(level)-1)])
+			}
+		}
+		if !oldest.IsZero() {
+			depth.ApproxOldestAge = now.Sub(oldest)
+		}
+		return depth, nil
+	})
+}
+
+func (mpq *MultiPriorityQueue) ListContents(ctx context.Context, queueName string) (map[int][]interface{}, error) {
 	mpq.mutex.Lock()
 	pq, exists := mpq.queues[queueName]
 	mpq.mutex.Unlock()
@@ -141,7 +639,7 @@ func (mpq *MultiPriorityQueue) ListContents(queueName string) (map[int][]interfa
 	defer pq.mutex.Unlock()
 
 	contents := make(map[int][]interface{})
-	for priority := 0; priority < 10; priority++ {
+	for priority := 0; priority < mpq.levels; priority++ {
 		if len(pq.queues[priority]) > 0 {
 			values := make([]interface{}, len(pq.queues[priority]))
 			for i, item := range pq.queues[priority] {
@@ -153,7 +651,59 @@ func (mpq *MultiPriorityQueue) ListContents(queueName string) (map[int][]interfa
 	return contents, nil
 }
 
-func (mpq *MultiPriorityQueue) GetPosition(queueName string, value interface{}) (int, int, error) {
+// IterateContents calls fn for every item in priority order, stopping early
+// if fn returns false. Each level is copied before fn sees it, so fn may call
+// back into the queue.
+func (mpq *MultiPriorityQueue) IterateContents(ctx context.Context, queueName string, fn func(priority int, value interface{}) bool) error {
+	mpq.mutex.Lock()
+	pq, exists := mpq.queues[queueName]
+	mpq.mutex.Unlock()
+
+	if !exists {
+		return fmt.Errorf("queue '%s' does not exist", queueName)
+	}
+
+	for priority := 0; priority < mpq.levels; priority++ {
+		pq.mutex.Lock()
+		level := append([]Item(nil), pq.queues[priority]...)
+		pq.mutex.Unlock()
+
+		for _, item := range level {
+			if !fn(priority, item.Value) {
+				return nil
+			}
+		}
+	}
+	return nil
+}
+
+// IterateItems calls fn for every item, with its id, in priority order,
+// stopping early if fn returns false. Like IterateContents, fn may call back
+// into the queue.
+func (mpq *MultiPriorityQueue) IterateItems(ctx context.Context, queueName string, fn func(item Item) bool) error {
+	mpq.mutex.Lock()
+	pq, exists := mpq.queues[queueName]
+	mpq.mutex.Unlock()
+
+	if !exists {
+		return fmt.Errorf("queue '%s' does not exist", queueName)
+	}
+
+	for priority := 0; priority < mpq.levels; priority++ {
+		pq.mutex.Lock()
+		level := append([]Item(nil), pq.queues[priority]...)
+		pq.mutex.Unlock()
+
+		for _, item := range level {
+			if !fn(item) {
+				return nil
+			}
+		}
+	}
+	return nil
+}
+
+func (mpq *MultiPriorityQueue) GetPosition(ctx context.Context, queueName string, value interface{}) (int, int, error) {
 	mpq.mutex.Lock()
 	pq, exists := mpq.queues[queueName]
 	mpq.mutex.Unlock()
@@ -166,7 +716,7 @@ func (mpq *MultiPriorityQueue) GetPosition(queueName string, value interface{})
 	defer pq.mutex.Unlock()
 
 	valueStr := fmt.Sprintf("%v", value)
-	for priority := 0; priority < 10; priority++ {
+	for priority := 0; priority < mpq.levels; priority++ {
 		for pos, item := range pq.queues[priority] {
 			if fmt.Sprintf("%v", item.Value) == valueStr {
 				return priority, pos, nil
@@ -176,9 +726,9 @@ func (mpq *MultiPriorityQueue) GetPosition(queueName string, value interface{})
 	return -1, -1, fmt.Errorf("value '%v' not found in queue '%s'", value, queueName)
 }
 
-func (mpq *MultiPriorityQueue) InsertAtTop(queueName string, value interface{}, priority int) error {
-	if priority < 0 || priority > 9 {
-		return fmt.Errorf("priority must be between 0 and 9")
+func (mpq *MultiPriorityQueue) InsertAtTop(ctx context.Context, queueName string, value interface{}, priority int) error {
+	if err := checkPriority(priority, mpq.levels); err != nil {
+		return err
 	}
 
 	mpq.mutex.Lock()
@@ -192,11 +742,22 @@ func (mpq *MultiPriorityQueue) InsertAtTop(queueName string, value interface{},
 	pq.mutex.Lock()
 	defer pq.mutex.Unlock()
 
-	pq.queues[priority] = append([]Item{{Value: value, Priority: priority}}, pq.queues[priority]...)
+	if pq.frozen {
+		return frozenError(queueName)
+	}
+
+	pq.queues[priority] = append([]Item{{ID: newItemID(), Value: value, Priority: priority, EnqueuedAt: mpq.clock.Now()}}, pq.queues[priority]...)
+	pq.counters.Enqueued++
 	return nil
 }
 
-func (mpq *MultiPriorityQueue) DeleteItem(queueName string, value interface{}) error {
+// InsertAtTopBatch places values at the head of the priority level in the
+// given order, so values[0] is dequeued first
+func (mpq *MultiPriorityQueue) InsertAtTopBatch(ctx context.Context, queueName string, values []interface{}, priority int) error {
+	if err := checkPriority(priority, mpq.levels); err != nil {
+		return err
+	}
+
 	mpq.mutex.Lock()
 	pq, exists := mpq.queues[queueName]
 	mpq.mutex.Unlock()
@@ -208,14 +769,590 @@ func (mpq *MultiPriorityQueue) DeleteItem(queueName string, value interface{}) e
 	pq.mutex.Lock()
 	defer pq.mutex.Unlock()
 
-	valueStr := fmt.Sprintf("%v", value)
-	for priority := 0; priority < 10; priority++ {
-		for i, item := range pq.queues[priority] {
-			if fmt.Sprintf("%v", item.Value) == valueStr {
-				pq.queues[priority] = append(pq.queues[priority][:i], pq.queues[priority][i+1:]...)
-				return nil
-			}
-		}
+	if pq.frozen {
+		return frozenError(queueName)
 	}
-	return fmt.Errorf("value '%v' not found in queue '%s'", value, queueName)
+
+	now := mpq.clock.Now()
+	items := make([]Item, 0, len(values)+len(pq.queues[priority]))
+	for _, value := range values {
+		items = append(items, Item{ID: newItemID(), Value: value, Priority: priority, EnqueuedAt: now})
+	}
+	pq.queues[priority] = append(items, pq.queues[priority]...)
+	pq.counters.Enqueued += int64(len(values))
+	return nil
+}
+
+func (mpq *MultiPriorityQueue) DeleteItem(ctx context.Context, queueName string, value interface{}) error {
+	mpq.mutex.Lock()
+	pq, exists := mpq.queues[queueName]
+	mpq.mutex.Unlock()
+
+	if !exists {
+		return fmt.Errorf("queue '%s' does not exist", queueName)
+	}
+
+	pq.mutex.Lock()
+	defer pq.mutex.Unlock()
+
+	if pq.frozen {
+		return frozenError(queueName)
+	}
+
+	valueStr := fmt.Sprintf("%v", value)
+	for priority := 0; priority < mpq.levels; priority++ {
+		for i, item := range pq.queues[priority] {
+			if fmt.Sprintf("%v", item.Value) == valueStr {
+				pq.queues[priority] = append(pq.queues[priority][:i], pq.queues[priority][i+1:]...)
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("value '%v' not found in queue '%s'", value, queueName)
+}
+
+// MoveToPosition moves the item with the given id to the given position
+// within the given priority level. Positions past the end of the level place
+// the item last.
+func (mpq *MultiPriorityQueue) MoveToPosition(ctx context.Context, queueName string, itemID string, priority, position int) error {
+	if err := checkPriority(priority, mpq.levels); err != nil {
+		return err
+	}
+	if position < 0 {
+		return fmt.Errorf("position must not be negative")
+	}
+
+	mpq.mutex.Lock()
+	pq, exists := mpq.queues[queueName]
+	mpq.mutex.Unlock()
+
+	if !exists {
+		return fmt.Errorf("queue '%s' does not exist", queueName)
+	}
+
+	pq.mutex.Lock()
+	defer pq.mutex.Unlock()
+
+	if pq.frozen {
+		return frozenError(queueName)
+	}
+
+	p, i := pq.findByID(itemID)
+	if p == -1 {
+		return itemNotFoundError(queueName, itemID)
+	}
+	item := pq.queues[p][i]
+	pq.queues[p] = append(pq.queues[p][:i], pq.queues[p][i+1:]...)
+	item.Priority = priority
+	if position > len(pq.queues[priority]) {
+		position = len(pq.queues[priority])
+	}
+	level := append(pq.queues[priority], Item{})
+	copy(level[position+1:], level[position:])
+	level[position] = item
+	pq.queues[priority] = level
+	return nil
+}
+
+// UpdatePriority moves the first item matching value to the tail of
+// newPriority, keeping its enqueue time. An item already at newPriority keeps
+// its position.
+func (mpq *MultiPriorityQueue) UpdatePriority(ctx context.Context, queueName string, value interface{}, newPriority int) error {
+	if err := checkPriority(newPriority, mpq.levels); err != nil {
+		return err
+	}
+
+	mpq.mutex.Lock()
+	pq, exists := mpq.queues[queueName]
+	mpq.mutex.Unlock()
+
+	if !exists {
+		return fmt.Errorf("queue '%s' does not exist", queueName)
+	}
+
+	pq.mutex.Lock()
+	defer pq.mutex.Unlock()
+
+	if pq.frozen {
+		return frozenError(queueName)
+	}
+
+	valueStr := fmt.Sprintf("%v", value)
+	for p := 0; p < mpq.levels; p++ {
+		for i, item := range pq.queues[p] {
+			if fmt.Sprintf("%v", item.Value) != valueStr {
+				continue
+			}
+			if p == newPriority {
+				return nil
+			}
+			pq.queues[p] = append(pq.queues[p][:i], pq.queues[p][i+1:]...)
+			item.Priority = newPriority
+			pq.queues[newPriority] = append(pq.queues[newPriority], item)
+			return nil
+		}
+	}
+	return fmt.Errorf("value '%v' not found in queue '%s'", value, queueName)
+}
+
+// MoveItem transfers the first item matching value from srcQueue to the tail
+// of the same priority level in dstQueue, keeping its enqueue time. The move
+// counts as a dequeue from srcQueue and an enqueue into dstQueue.
+func (mpq *MultiPriorityQueue) MoveItem(ctx context.Context, srcQueue, dstQueue string, value interface{}) error {
+	if srcQueue == dstQueue {
+		return fmt.Errorf("can't move an item within queue '%s'", srcQueue)
+	}
+
+	mpq.mutex.Lock()
+	src, srcExists := mpq.queues[srcQueue]
+	dst, dstExists := mpq.queues[dstQueue]
+	mpq.mutex.Unlock()
+
+	if !srcExists {
+		return fmt.Errorf("queue '%s' does not exist", srcQueue)
+	}
+	if !dstExists {
+		return fmt.Errorf("queue '%s' does not exist", dstQueue)
+	}
+
+	// Lock queues in name order so concurrent moves can't deadlock
+	first, second := src, dst
+	if dstQueue < srcQueue {
+		first, second = dst, src
+	}
+	first.mutex.Lock()
+	defer first.mutex.Unlock()
+	second.mutex.Lock()
+	defer second.mutex.Unlock()
+
+	if src.frozen {
+		return frozenError(srcQueue)
+	}
+	if dst.frozen {
+		return frozenError(dstQueue)
+	}
+
+	valueStr := fmt.Sprintf("%v", value)
+	for p := 0; p < mpq.levels; p++ {
+		for i, item := range src.queues[p] {
+			if fmt.Sprintf("%v", item.Value) == valueStr {
+				src.queues[p] = append(src.queues[p][:i], src.queues[p][i+1:]...)
+				dst.queues[p] = append(dst.queues[p], item)
+				src.counters.Dequeued++
+				dst.counters.Enqueued++
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("value '%v' not found in queue '%s'", value, srcQueue)
+}
+
+// SwapItems exchanges the priority and position of the items with ids itemA
+// and itemB
+func (mpq *MultiPriorityQueue) SwapItems(ctx context.Context, queueName, itemA, itemB string) error {
+	mpq.mutex.Lock()
+	pq, exists := mpq.queues[queueName]
+	mpq.mutex.Unlock()
+
+	if !exists {
+		return fmt.Errorf("queue '%s' does not exist", queueName)
+	}
+
+	pq.mutex.Lock()
+	defer pq.mutex.Unlock()
+
+	if pq.frozen {
+		return frozenError(queueName)
+	}
+
+	pa, ia := pq.findByID(itemA)
+	if pa == -1 {
+		return itemNotFoundError(queueName, itemA)
+	}
+	pb, ib := pq.findByID(itemB)
+	if pb == -1 {
+		return itemNotFoundError(queueName, itemB)
+	}
+
+	a, b := pq.queues[pa][ia], pq.queues[pb][ib]
+	a.Priority, b.Priority = pb, pa
+	pq.queues[pa][ia], pq.queues[pb][ib] = b, a
+	return nil
+}
+
+// OldestItem returns the item that has been queued the longest and its age
+func (mpq *MultiPriorityQueue) OldestItem(ctx context.Context, queueName string) (interface{}, time.Duration, error) {
+	return mpq.itemByAge(queueName, func(a, b time.Time) bool { return a.Before(b) })
+}
+
+// NewestItem returns the most recently queued item and its age
+func (mpq *MultiPriorityQueue) NewestItem(ctx context.Context, queueName string) (interface{}, time.Duration, error) {
+	return mpq.itemByAge(queueName, func(a, b time.Time) bool { return a.After(b) })
+}
+
+// itemByAge returns the item whose enqueue time wins the better comparison
+func (mpq *MultiPriorityQueue) itemByAge(queueName string, better func(a, b time.Time) bool) (interface{}, time.Duration, error) {
+	mpq.mutex.Lock()
+	pq, exists := mpq.queues[queueName]
+	mpq.mutex.Unlock()
+
+	if !exists {
+		return nil, 0, fmt.Errorf("queue '%s' does not exist", queueName)
+	}
+
+	pq.mutex.Lock()
+	defer pq.mutex.Unlock()
+
+	var found *Item
+	for priority := 0; priority < mpq.levels; priority++ {
+		for i := range pq.queues[priority] {
+			item := &pq.queues[priority][i]
+			if found == nil || better(item.EnqueuedAt, found.EnqueuedAt) {
+				found = item
+			}
+		}
+	}
+	if found == nil {
+		return nil, 0, emptyError(queueName)
+	}
+	return found.Value, mpq.clock.Now().Sub(found.EnqueuedAt), nil
+}
+
+// Peek returns the highest-priority item without removing it. With weights
+// or a scheduling policy set, Dequeue may pick a different item.
+func (mpq *MultiPriorityQueue) Peek(ctx context.Context, queueName string) (interface{}, error) {
+	mpq.mutex.Lock()
+	pq, exists := mpq.queues[queueName]
+	mpq.mutex.Unlock()
+
+	if !exists {
+		return nil, fmt.Errorf("queue '%s' does not exist", queueName)
+	}
+
+	pq.mutex.Lock()
+	defer pq.mutex.Unlock()
+
+	for i := 0; i < mpq.levels; i++ {
+		if len(pq.queues[i]) > 0 {
+			return pq.queues[i][0].Value, nil
+		}
+	}
+	return nil, emptyError(queueName)
+}
+
+// PeekPriority returns the head of a single priority level without removing it
+func (mpq *MultiPriorityQueue) PeekPriority(ctx context.Context, queueName string, priority int) (interface{}, error) {
+	if err := checkPriority(priority, mpq.levels); err != nil {
+		return nil, err
+	}
+
+	mpq.mutex.Lock()
+	pq, exists := mpq.queues[queueName]
+	mpq.mutex.Unlock()
+
+	if !exists {
+		return nil, fmt.Errorf("queue '%s' does not exist", queueName)
+	}
+
+	pq.mutex.Lock()
+	defer pq.mutex.Unlock()
+
+	if len(pq.queues[priority]) == 0 {
+		return nil, levelEmptyError(priority, queueName)
+	}
+	return pq.queues[priority][0].Value, nil
+}
+
+// DequeueFromPriority removes and returns the head of a single priority level,
+// ignoring items at every other level
+func (mpq *MultiPriorityQueue) DequeueFromPriority(ctx context.Context, queueName string, priority int) (interface{}, error) {
+	if err := checkPriority(priority, mpq.levels); err != nil {
+		return nil, err
+	}
+
+	mpq.mutex.Lock()
+	pq, exists := mpq.queues[queueName]
+	mpq.mutex.Unlock()
+
+	if !exists {
+		return nil, fmt.Errorf("queue '%s' does not exist", queueName)
+	}
+
+	pq.mutex.Lock()
+	defer pq.mutex.Unlock()
+
+	if pq.frozen {
+		return nil, frozenError(queueName)
+	}
+
+	if len(pq.queues[priority]) == 0 {
+		return nil, levelEmptyError(priority, queueName)
+	}
+	item := pq.queues[priority][0]
+	pq.queues[priority] = pq.queues[priority][1:]
+	pq.counters.Dequeued++
+	noteRemoved(ctx, item.ID)
+	return item.Value, nil
+}
+
+// DequeueLevel removes and returns every item of the highest non-empty
+// priority level, in queue order
+func (mpq *MultiPriorityQueue) DequeueLevel(ctx context.Context, queueName string) ([]interface{}, error) {
+	mpq.mutex.Lock()
+	pq, exists := mpq.queues[queueName]
+	mpq.mutex.Unlock()
+
+	if !exists {
+		return nil, fmt.Errorf("queue '%s' does not exist", queueName)
+	}
+
+	pq.mutex.Lock()
+	defer pq.mutex.Unlock()
+
+	if pq.frozen {
+		return nil, frozenError(queueName)
+	}
+
+	for priority := 0; priority < mpq.levels; priority++ {
+		level := pq.queues[priority]
+		if len(level) == 0 {
+			continue
+		}
+		values := make([]interface{}, len(level))
+		for i, item := range level {
+			values[i] = item.Value
+			noteRemoved(ctx, item.ID)
+		}
+		pq.queues[priority] = nil
+		pq.counters.Dequeued += int64(len(level))
+		return values, nil
+	}
+	return nil, emptyError(queueName)
+}
+
+// DequeueBatch atomically removes and returns up to n items in strict
+// priority order. Weights and scheduling policies are not applied.
+func (mpq *MultiPriorityQueue) DequeueBatch(ctx context.Context, queueName string, n int) ([]interface{}, error) {
+	if n <= 0 {
+		return nil, fmt.Errorf("batch size must be positive")
+	}
+
+	mpq.mutex.Lock()
+	pq, exists := mpq.queues[queueName]
+	mpq.mutex.Unlock()
+
+	if !exists {
+		return nil, fmt.Errorf("queue '%s' does not exist", queueName)
+	}
+
+	pq.mutex.Lock()
+	defer pq.mutex.Unlock()
+
+	if pq.frozen {
+		return nil, frozenError(queueName)
+	}
+
+	var values []interface{}
+	for priority := 0; priority < mpq.levels && len(values) < n; priority++ {
+		level := pq.queues[priority]
+		take := min(n-len(values), len(level))
+		for _, item := range level[:take] {
+			values = append(values, item.Value)
+			noteRemoved(ctx, item.ID)
+		}
+		pq.queues[priority] = level[take:]
+	}
+	if len(values) == 0 {
+		return nil, emptyError(queueName)
+	}
+	pq.counters.Dequeued += int64(len(values))
+	return values, nil
+}
+
+// DequeueWhere removes and returns the highest-priority item for which pred
+// returns true, leaving non-matching items in place
+func (mpq *MultiPriorityQueue) DequeueWhere(ctx context.Context, queueName string, pred func(Item) bool) (interface{}, error) {
+	return mpq.dequeueScan(ctx, queueName, func(item Item) scanAction {
+		if pred(item) {
+			return scanTake
+		}
+		return scanKeep
+	})
+}
+
+// DequeueFresh removes and returns the highest-priority item queued no longer
+// than maxAge ago. Older items are skipped, or deleted if expire is set;
+// deleted items don't count as dequeued.
+func (mpq *MultiPriorityQueue) DequeueFresh(ctx context.Context, queueName string, maxAge time.Duration, expire bool) (interface{}, error) {
+	now := mpq.clock.Now()
+	return mpq.dequeueScan(ctx, queueName, func(item Item) scanAction {
+		return freshAction(now.Sub(item.EnqueuedAt), maxAge, expire)
+	})
+}
+
+// dequeueScan walks the queue in order, dropping items as decide says, until
+// it finds one to take
+func (mpq *MultiPriorityQueue) dequeueScan(ctx context.Context, queueName string, decide func(Item) scanAction) (interface{}, error) {
+	mpq.mutex.Lock()
+	pq, exists := mpq.queues[queueName]
+	mpq.mutex.Unlock()
+
+	if !exists {
+		return nil, fmt.Errorf("queue '%s' does not exist", queueName)
+	}
+
+	pq.mutex.Lock()
+	defer pq.mutex.Unlock()
+
+	if pq.frozen {
+		return nil, frozenError(queueName)
+	}
+
+	for priority := 0; priority < mpq.levels; priority++ {
+		level := pq.queues[priority]
+		for i := 0; i < len(level); {
+			item := level[i]
+			switch decide(item) {
+			case scanTake:
+				pq.queues[priority] = append(level[:i], level[i+1:]...)
+				pq.counters.Dequeued++
+				noteRemoved(ctx, item.ID)
+				return item.Value, nil
+			case scanDrop:
+				level = append(level[:i], level[i+1:]...)
+				pq.queues[priority] = level
+			default:
+				i++
+			}
+		}
+	}
+	return nil, fmt.Errorf("no matching item in queue '%s'", queueName)
+}
+
+// Counters returns the total number of items ever enqueued into and dequeued
+// from the queue
+func (mpq *MultiPriorityQueue) Counters(ctx context.Context, queueName string) (QueueCounters, error) {
+	mpq.mutex.Lock()
+	pq, exists := mpq.queues[queueName]
+	mpq.mutex.Unlock()
+
+	if !exists {
+		return QueueCounters{}, fmt.Errorf("queue '%s' does not exist", queueName)
+	}
+
+	pq.mutex.Lock()
+	defer pq.mutex.Unlock()
+
+	return pq.counters, nil
+}
+
+// SetPriorityWeights switches the queue to weighted-random dequeue, where each
+// non-empty priority level is chosen with probability proportional to its
+// weight. Passing nil restores strict priority order.
+func (mpq *MultiPriorityQueue) SetPriorityWeights(ctx context.Context, queueName string, weights []float64) error {
+	if weights != nil {
+		if err := validateWeights(weights, mpq.levels); err != nil {
+			return err
+		}
+		weights = append([]float64(nil), weights...)
+	}
+
+	mpq.mutex.Lock()
+	pq, exists := mpq.queues[queueName]
+	mpq.mutex.Unlock()
+
+	if !exists {
+		return fmt.Errorf("queue '%s' does not exist", queueName)
+	}
+
+	pq.mutex.Lock()
+	defer pq.mutex.Unlock()
+
+	if weights == nil {
+		pq.policy = nil
+	} else {
+		pq.policy = WeightedPolicy{Weights: weights}
+	}
+	return nil
+}
+
+// CompactQueue copies each priority level into a slice of exactly its length,
+// releasing the memory that dequeues from the head of a level leave pinned in
+// the old backing array. Order and contents are unchanged.
+func (mpq *MultiPriorityQueue) CompactQueue(ctx context.Context, queueName string) error {
+	mpq.mutex.Lock()
+	pq, exists := mpq.queues[queueName]
+	mpq.mutex.Unlock()
+
+	if !exists {
+		return fmt.Errorf("queue '%s' does not exist", queueName)
+	}
+
+	pq.mutex.Lock()
+	defer pq.mutex.Unlock()
+
+	if pq.frozen {
+		return frozenError(queueName)
+	}
+
+	for priority, level := range pq.queues {
+		if len(level) == 0 {
+			pq.queues[priority] = nil
+			continue
+		}
+		pq.queues[priority] = append(make([]Item, 0, len(level)), level...)
+	}
+	return nil
+}
+
+// FreezeQueue makes every call that adds, removes or reorders items fail
+// with ErrQueueFrozen until UnfreezeQueue is called, so the queue stays
+// quiescent during maintenance. Read-only calls keep working.
+func (mpq *MultiPriorityQueue) FreezeQueue(ctx context.Context, queueName string) error {
+	return mpq.setFrozen(queueName, true)
+}
+
+// UnfreezeQueue lifts a FreezeQueue
+func (mpq *MultiPriorityQueue) UnfreezeQueue(ctx context.Context, queueName string) error {
+	return mpq.setFrozen(queueName, false)
+}
+
+func (mpq *MultiPriorityQueue) setFrozen(queueName string, frozen bool) error {
+	mpq.mutex.Lock()
+	pq, exists := mpq.queues[queueName]
+	mpq.mutex.Unlock()
+
+	if !exists {
+		return fmt.Errorf("queue '%s' does not exist", queueName)
+	}
+
+	pq.mutex.Lock()
+	defer pq.mutex.Unlock()
+
+	pq.frozen = frozen
+	return nil
+}
+
+// SetSchedulingPolicy replaces the policy Dequeue uses to choose the next
+// item. Passing nil restores strict priority order.
+func (mpq *MultiPriorityQueue) SetSchedulingPolicy(queueName string, policy SchedulingPolicy) error {
+	if wp, ok := policy.(WeightedPolicy); ok {
+		if err := validateWeights(wp.Weights, mpq.levels); err != nil {
+			return err
+		}
+	}
+
+	mpq.mutex.Lock()
+	pq, exists := mpq.queues[queueName]
+	mpq.mutex.Unlock()
+
+	if !exists {
+		return fmt.Errorf("queue '%s' does not exist", queueName)
+	}
+
+	pq.mutex.Lock()
+	defer pq.mutex.Unlock()
+
+	pq.policy = policy
+	return nil
 }
@@ -0,0 +1,238 @@
+package priorityqueue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// DemotionRule lowers the priority of items from producers that enqueue
+// faster than Rate items per second, after allowing an initial Burst
+type DemotionRule struct {
+	Rate   float64
+	Burst  int
+	Demote int // priority levels added to items over the rate, capped at the last level
+}
+
+// Quota caps how many items a single producer may enqueue into a queue within
+// each fixed Window
+type Quota struct {
+	Limit  int
+	Window time.Duration
+}
+
+// ErrQuotaExceeded is returned by ProducerGate.Enqueue when a producer has
+// used up its quota for the current window
+var ErrQuotaExceeded = errors.New("producer quota exceeded")
+
+// ProducerGate enforces per-producer fair-use rules on top of a queue backend.
+// Producers identify themselves on every Enqueue call.
+type ProducerGate struct {
+	pq        PriorityQueuer
+	demotions map[string]DemotionRule
+	buckets   map[producerKey]*tokenBucket
+	quotas    map[string]Quota
+	windows   map[producerKey]*quotaWindow
+	rejected  map[producerKey]int64
+	clock     Clock
+	mutex     sync.Mutex
+}
+
+// quotaWindow counts a producer's enqueues in the current quota window
+type quotaWindow struct {
+	start time.Time
+	used  int
+}
+
+// producerKey identifies one producer's usage of one queue
+type producerKey struct {
+	queueName string
+	producer  string
+}
+
+// NewProducerGate creates a gate that enqueues into pq
+func NewProducerGate(pq PriorityQueuer) *ProducerGate {
+	return &ProducerGate{
+		pq:        pq,
+		demotions: make(map[string]DemotionRule),
+		buckets:   make(map[producerKey]*tokenBucket),
+		quotas:    make(map[string]Quota),
+		windows:   make(map[producerKey]*quotaWindow),
+		rejected:  make(map[producerKey]int64),
+		clock:     SystemClock{},
+	}
+}
+
+// SetClock replaces the time source used for rates and quota windows. It must
+// be called before the gate is shared between goroutines.
+func (g *ProducerGate) SetClock(clock Clock) {
+	g.clock = clock
+}
+
+// SetDemotionRule configures rate-based demotion for a queue. A zero rule
+// disables demotion.
+func (g *ProducerGate) SetDemotionRule(queueName string, rule DemotionRule) error {
+	if rule.Rate < 0 || rule.Burst < 0 || rule.Demote < 0 {
+		return fmt.Errorf("demotion rule values must not be negative")
+	}
+
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	for key := range g.buckets {
+		if key.queueName == queueName {
+			delete(g.buckets, key)
+		}
+	}
+	if rule == (DemotionRule{}) {
+		delete(g.demotions, queueName)
+	} else {
+		g.demotions[queueName] = rule
+	}
+	return nil
+}
+
+// SetQuota limits how many items each producer may enqueue into a queue per
+// window. A zero quota removes the limit.
+func (g *ProducerGate) SetQuota(queueName string, quota Quota) error {
+	if quota != (Quota{}) && (quota.Limit <= 0 || quota.Window <= 0) {
+		return fmt.Errorf("quota limit and window must be positive")
+	}
+
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	for key := range g.windows {
+		if key.queueName == queueName {
+			delete(g.windows, key)
+		}
+	}
+	if quota == (Quota{}) {
+		delete(g.quotas, queueName)
+	} else {
+		g.quotas[queueName] = quota
+	}
+	return nil
+}
+
+// Rejections returns, per producer, how many enqueues into queueName were
+// refused with ErrQuotaExceeded
+func (g *ProducerGate) Rejections(queueName string) map[string]int64 {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	counts := make(map[string]int64)
+	for key, n := range g.rejected {
+		if key.queueName == queueName {
+			counts[key.producer] = n
+		}
+	}
+	return counts
+}
+
+// Enqueue adds value on behalf of producer and returns the priority the item
+// was actually queued at, which is lower than requested when the producer is
+// over its rate. It fails with ErrQuotaExceeded once the producer has used up
+// its quota for the current window. Items the backend fails to enqueue don't
+// count against the producer's quota or rate.
+func (g *ProducerGate) Enqueue(ctx context.Context, producer, queueName string, value interface{}, priority int) (int, error) {
+	if err := checkPriority(priority, g.pq.Levels()); err != nil {
+		return priority, err
+	}
+
+	key := producerKey{queueName, producer}
+	now := g.clock.Now()
+
+	var window *quotaWindow
+	var bucket *tokenBucket
+	g.mutex.Lock()
+	if quota, ok := g.quotas[queueName]; ok {
+		window, ok = g.windows[key]
+		if !ok || now.Sub(window.start) >= quota.Window {
+			window = &quotaWindow{start: now}
+			g.windows[key] = window
+		}
+		if window.used >= quota.Limit {
+			g.rejected[key]++
+			g.mutex.Unlock()
+			return priority, ErrQuotaExceeded
+		}
+		window.used++
+	}
+	if rule, ok := g.demotions[queueName]; ok {
+		bucket, ok = g.buckets[key]
+		if !ok {
+			bucket = newTokenBucket(rule.Rate, rule.Burst)
+			g.buckets[key] = bucket
+		}
+		if !bucket.take(now) {
+			bucket = nil
+			priority += rule.Demote
+			if last := g.pq.Levels() - 1; priority > last {
+				priority = last
+			}
+		}
+	}
+	g.mutex.Unlock()
+
+	if err := g.pq.Enqueue(ctx, queueName, value, priority); err != nil {
+		g.refund(key, window, bucket)
+		return priority, err
+	}
+	return priority, nil
+}
+
+// refund gives back the quota slot and token an enqueue took, unless the
+// rules were replaced or the window rolled over in the meantime. Either may
+// be nil.
+func (g *ProducerGate) refund(key producerKey, window *quotaWindow, bucket *tokenBucket) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	if window != nil && g.windows[key] == window && window.used > 0 {
+		window.used--
+	}
+	if bucket != nil && g.buckets[key] == bucket {
+		bucket.untake()
+	}
+}
+
+// tokenBucket is a classic token bucket refilled continuously at rate tokens
+// per second up to burst tokens
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64, burst int) *tokenBucket {
+	return &tokenBucket{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+	}
+}
+
+// take consumes a token if one is available at now
+func (b *tokenBucket) take(now time.Time) bool {
+	if !b.last.IsZero() {
+		b.tokens += now.Sub(b.last).Seconds() * b.rate
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+	}
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return true
+	}
+	return false
+}
+
+// untake returns a token consumed by take
+func (b *tokenBucket) untake() {
+	b.tokens = min(b.tokens+1, b.burst)
+}
@@ -0,0 +1,79 @@
+package priorityqueue
+
+import (
+	"context"
+	"strings"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// redisCommands is the narrow facade every RedisPriorityQueue feature goes
+// through instead of the full client. Commands issued inside pipelines,
+// transactions and scripts can't be narrowed by type, so RedisACLCommands
+// lists those too; keep both in step when a feature needs a new command.
+type redisCommands interface {
+	redis.Scripter
+
+	Ping(ctx context.Context) *redis.StatusCmd
+	Del(ctx context.Context, keys ...string) *redis.IntCmd
+
+	Get(ctx context.Context, key string) *redis.StringCmd
+
+	HGet(ctx context.Context, key, field string) *redis.StringCmd
+	HMGet(ctx context.Context, key string, fields ...string) *redis.SliceCmd
+	HIncrBy(ctx context.Context, key, field string, incr int64) *redis.IntCmd
+	HDel(ctx context.Context, key string, fields ...string) *redis.IntCmd
+	HScan(ctx context.Context, key string, cursor uint64, match string, count int64) *redis.ScanCmd
+
+	LRange(ctx context.Context, key string, start, stop int64) *redis.StringSliceCmd
+
+	SAdd(ctx context.Context, key string, members ...interface{}) *redis.IntCmd
+	SRem(ctx context.Context, key string, members ...interface{}) *redis.IntCmd
+	SMembers(ctx context.Context, key string) *redis.StringSliceCmd
+	SMIsMember(ctx context.Context, key string, members ...interface{}) *redis.BoolSliceCmd
+	SScan(ctx context.Context, key string, cursor uint64, match string, count int64) *redis.ScanCmd
+
+	ZCard(ctx context.Context, key string) *redis.IntCmd
+	ZCount(ctx context.Context, key, min, max string) *redis.IntCmd
+	ZMScore(ctx context.Context, key string, members ...string) *redis.FloatSliceCmd
+	ZRange(ctx context.Context, key string, start, stop int64) *redis.StringSliceCmd
+	ZRangeWithScores(ctx context.Context, key string, start, stop int64) *redis.ZSliceCmd
+	ZRangeByScore(ctx context.Context, key string, opt *redis.ZRangeBy) *redis.StringSliceCmd
+	ZScan(ctx context.Context, key string, cursor uint64, match string, count int64) *redis.ScanCmd
+
+	Pipeline() redis.Pipeliner
+	TxPipelined(ctx context.Context, fn func(redis.Pipeliner) error) ([]redis.Cmder, error)
+	Watch(ctx context.Context, fn func(*redis.Tx) error, keys ...string) error
+}
+
+// RedisACLCommands is every command a RedisPriorityQueue issues, directly,
+// in pipelines and transactions, or from its scripts, in Redis ACL syntax.
+// It includes the connection handshake and SELECT for non-zero databases.
+var RedisACLCommands = []string{
+	"auth", "hello", "client|setinfo", "select", "ping",
+	"multi", "exec", "watch", "unwatch",
+	"eval", "evalsha", "script|exists", "script|load",
+	"exists", "del", "rename", "expire", "memory|usage",
+	"get", "set", "incr",
+	"hget", "hmget", "hset", "hdel", "hincrby", "hexists", "hscan", "hstrlen",
+	"lrange", "rpush",
+	"sadd", "srem", "smembers", "sismember", "smismember", "sscan",
+	"zadd", "zrem", "zcard", "zcount", "zscore", "zmscore",
+	"zrange", "zrangebyscore", "zscan",
+}
+
+// RedisACLRule returns an ACL SETUSER rule granting only RedisACLCommands,
+// on the package's own "pq:" keys and on keys matching the given patterns. A
+// queue's helper keys live under "pq:", so a pattern such as "jobs*" only
+// needs to cover the queue names themselves.
+func RedisACLRule(queuePatterns ...string) string {
+	rule := []string{"resetkeys", "-@all"}
+	for _, cmd := range RedisACLCommands {
+		rule = append(rule, "+"+cmd)
+	}
+	rule = append(rule, "~pq:*")
+	for _, pattern := range queuePatterns {
+		rule = append(rule, "~"+pattern)
+	}
+	return strings.Join(rule, " ")
+}
@@ -0,0 +1,123 @@
+package priorityqueue
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// blobRefPrefix marks a stored payload that refers to an entry in the queue's
+// blob hash instead of holding the value itself
+const blobRefPrefix = "\x00blob:"
+
+// SetBlobThreshold offloads values whose string form is longer than threshold
+// bytes to a per-queue hash, keeping only a reference in the sorted set so it
+// stays small and replicates quickly. References are resolved transparently on
+// read. Bodies are keyed by their SHA-256, which is checked when they are read
+// back. Zero disables offloading. Every client of a queue must be able to
+// resolve references, but only producers need the threshold set.
+func (rpq *RedisPriorityQueue) SetBlobThreshold(threshold int) {
+	rpq.mutex.Lock()
+	defer rpq.mutex.Unlock()
+	rpq.blobThreshold = threshold
+}
+
+// storedMember returns a new sorted set member for a value. If the value is
+// offloaded, id names the blob its body must be stored under: the body's
+// SHA-256 and the item id, so duplicate items don't share a blob.
+func (rpq *RedisPriorityQueue) storedMember(value interface{}) (member, id, body string) {
+	return rpq.storedMemberWithID(value, newItemID())
+}
+
+// storedMemberWithID is storedMember for a given item id
+func (rpq *RedisPriorityQueue) storedMemberWithID(value interface{}, itemID string) (member, id, body string) {
+	body = fmt.Sprintf("%v", value)
+
+	rpq.mutex.Lock()
+	threshold := rpq.blobThreshold
+	rpq.mutex.Unlock()
+
+	if threshold <= 0 || len(body) <= threshold {
+		return encodeMember(body, itemID), "", ""
+	}
+	id = bodyHash(body) + "." + itemID
+	return encodeMember(blobRefPrefix+id, itemID), id, body
+}
+
+// bodyHash returns the SHA-256 of a blob body in hex
+func bodyHash(body string) string {
+	sum := sha256.Sum256([]byte(body))
+	return hex.EncodeToString(sum[:])
+}
+
+// blobID returns the blob a stored payload refers to, if any
+func blobID(payload string) (string, bool) {
+	return strings.CutPrefix(payload, blobRefPrefix)
+}
+
+// blobHash returns the body hash a blob id starts with. Blobs stored before
+// item ids existed are named by the hash alone.
+func blobHash(id string) string {
+	hash, _, _ := strings.Cut(id, ".")
+	return hash
+}
+
+// memberMatcher returns a predicate reporting whether a stored member holds
+// value, by its string form, without reading offloaded bodies
+func memberMatcher(value interface{}) func(member string) bool {
+	body := fmt.Sprintf("%v", value)
+	hash := ""
+	return func(member string) bool {
+		payload, err := decodeMember(member)
+		if err != nil {
+			return false
+		}
+		id, ok := blobID(payload)
+		if !ok {
+			return payload == body
+		}
+		if hash == "" {
+			hash = bodyHash(body)
+		}
+		return blobHash(id) == hash
+	}
+}
+
+// resolvePayload returns the value a stored payload stands for, reading the
+// body of offloaded values from the blob hash
+func (rpq *RedisPriorityQueue) resolvePayload(ctx context.Context, queueName, payload string) (string, error) {
+	id, ok := blobID(payload)
+	if !ok {
+		return payload, nil
+	}
+	body, err := rpq.client.HGet(ctx, blobsKey(queueName), id).Result()
+	if err == redis.Nil {
+		return "", fmt.Errorf("%w: blob %s of queue '%s' is missing", ErrCorruptPayload, id, queueName)
+	}
+	if err != nil {
+		return "", fmt.Errorf("redis error: %v", err)
+	}
+	if bodyHash(body) != blobHash(id) {
+		return "", fmt.Errorf("%w: blob %s of queue '%s' fails its hash", ErrCorruptPayload, id, queueName)
+	}
+	return body, nil
+}
+
+// displayValue returns the value of a stored member for listings, falling
+// back to the stored form when it can't be decoded or resolved
+func (rpq *RedisPriorityQueue) displayValue(ctx context.Context, queueName, member string) interface{} {
+	payload := memberPayload(member)
+	if value, err := rpq.resolvePayload(ctx, queueName, payload); err == nil {
+		return value
+	}
+	return payload
+}
+
+// blobsKey names the hash holding a queue's offloaded bodies
+func blobsKey(queueName string) string {
+	return helperKey(queueName, "blobs")
+}
@@ -0,0 +1,118 @@
+package priorityqueue
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Histogram counts a queue's items by priority, by the size of their value
+// and by age. A script aggregates each page of scanBatch items on the server,
+// so only counts cross the network and the server is never blocked for long.
+// Items moved by concurrent writers between pages may be counted twice or
+// not at all.
+func (rpq *RedisPriorityQueue) Histogram(ctx context.Context, queueName string, bounds HistogramBounds) (QueueHistogram, error) {
+	if err := bounds.validate(); err != nil {
+		return QueueHistogram{}, err
+	}
+
+	args := []interface{}{0, seqSpace, rpq.clock.Now().UnixMicro(), rpq.levels, scanBatch, len(bounds.Sizes)}
+	for _, size := range bounds.Sizes {
+		args = append(args, size)
+	}
+	args = append(args, len(bounds.Ages))
+	for _, age := range bounds.Ages {
+		args = append(args, age.Microseconds())
+	}
+
+	hist := bounds.newHistogram()
+	keys := []string{queueName, enqueuedKey(queueName), blobsKey(queueName)}
+	for start := 0; ; start += scanBatch {
+		args[0] = start
+		counts, err := histogramScript.Run(ctx, rpq.client, keys, args...).Int64Slice()
+		if err != nil {
+			return QueueHistogram{}, fmt.Errorf("redis error: %v", err)
+		}
+		// counts holds the page size, then per-level, size and age counts
+		for priority, n := range counts[1 : 1+rpq.levels] {
+			if n > 0 {
+				hist.Priorities[priority] += n
+			}
+		}
+		for i := range hist.Sizes {
+			hist.Sizes[i] += counts[1+rpq.levels+i]
+		}
+		for i := range hist.Ages {
+			hist.Ages[i] += counts[1+rpq.levels+len(hist.Sizes)+i]
+		}
+		if counts[0] < scanBatch {
+			return hist, nil
+		}
+	}
+}
+
+// histogramScript aggregates ARGV[5] members of queue KEYS[1] from rank
+// ARGV[1]. ARGV[2] is seqSpace, ARGV[3] the current time in microseconds
+// and ARGV[4] the number of levels; then come the size bounds and the age
+// bounds in microseconds, each preceded by their count. Ages come from the
+// index KEYS[2] and offloaded sizes from the blob hash KEYS[3]. Returns the
+// page size, then the per-level, size bucket and age bucket counts.
+var histogramScript = redis.NewScript(`
+local start, space, now, levels = tonumber(ARGV[1]), tonumber(ARGV[2]), tonumber(ARGV[3]), tonumber(ARGV[4])
+local members = redis.call('ZRANGE', KEYS[1], start, start + tonumber(ARGV[5]) - 1, 'WITHSCORES')
+local nsizes = tonumber(ARGV[6])
+local sizes = {}
+for i = 1, nsizes do sizes[i] = tonumber(ARGV[6 + i]) end
+local nages = tonumber(ARGV[7 + nsizes])
+local ages = {}
+for i = 1, nages do ages[i] = tonumber(ARGV[7 + nsizes + i]) end
+
+local function bucket(bounds, v)
+	for i, bound in ipairs(bounds) do
+		if v <= bound then return i end
+	end
+	return #bounds + 1
+end
+
+local counts = {#members / 2}
+for i = 1, levels + nsizes + 1 + nages + 1 do counts[i + 1] = 0 end
+for i = 1, #members, 2 do
+	local member, score = members[i], tonumber(members[i + 1])
+	local priority
+	if score < space then
+		priority = math.floor(score + 0.5)
+	else
+		priority = math.floor(score / space) - 1
+	end
+	if priority >= 0 and priority < levels then
+		counts[2 + priority] = counts[2 + priority] + 1
+	end
+
+	-- Strip the checksum and, for tagged members, the item id, telling the
+	-- formats apart as decodeMember does. Members without a NUL byte were
+	-- written before checksums and are all payload.
+	local payload = member
+	if string.find(member, '\0', 1, true) then
+		local sep = string.byte(member, #member - 8)
+		if sep == 1 and #member >= 26 then
+			payload = string.sub(member, 1, #member - 26)
+		elseif sep == 0 then
+			payload = string.sub(member, 1, #member - 9)
+		end
+	end
+	local size = #payload
+	if string.sub(payload, 1, 6) == '\0blob:' then
+		size = redis.call('HSTRLEN', KEYS[3], string.sub(payload, 7))
+	end
+	local s = 1 + levels + bucket(sizes, size)
+	counts[s] = counts[s] + 1
+
+	local enqueued = redis.call('ZSCORE', KEYS[2], member)
+	if enqueued then
+		local a = 1 + levels + nsizes + 1 + bucket(ages, now - tonumber(enqueued))
+		counts[a] = counts[a] + 1
+	end
+end
+return counts
+`)
@@ -0,0 +1,185 @@
+package priorityqueue
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// EnqueueWithID appends value like Enqueue and returns the id embedded in its
+// member, which addresses this item alone even when other items have the
+// same value
+func (rpq *RedisPriorityQueue) EnqueueWithID(ctx context.Context, queueName string, value interface{}, priority int) (string, error) {
+	if err := rpq.upgradeScores(ctx, queueName); err != nil {
+		return "", err
+	}
+
+	if err := checkPriority(priority, rpq.levels); err != nil {
+		return "", err
+	}
+
+	lock := rpq.queueLock(queueName)
+	lock.Lock()
+	defer lock.Unlock()
+
+	if err := rpq.checkWritable(ctx, queueName); err != nil {
+		return "", err
+	}
+
+	itemID := newItemID()
+	member, id, body := rpq.storedMemberWithID(value, itemID)
+	if err := rpq.appendMember(ctx, []string{queueName}, priority, member, id, body); err != nil {
+		return "", err
+	}
+	return itemID, nil
+}
+
+// findByID returns the member with the given item id and its score. Members
+// are matched server-side by ZSCAN, so only the match crosses the network,
+// but the whole queue may still be walked.
+func (rpq *RedisPriorityQueue) findByID(ctx context.Context, queueName, id string) (redis.Z, bool, error) {
+	if len(id) != itemIDLen {
+		return redis.Z{}, false, nil
+	}
+	match := "*\x00" + id + "\x01*"
+	var cursor uint64
+	for {
+		pairs, next, err := rpq.client.ZScan(ctx, queueName, cursor, match, scanBatch).Result()
+		if err != nil {
+			return redis.Z{}, false, fmt.Errorf("redis error: %v", err)
+		}
+		for i := 0; i+1 < len(pairs); i += 2 {
+			if _, memberID, err := decodeMemberID(pairs[i]); err != nil || memberID != id {
+				continue
+			}
+			score, err := strconv.ParseFloat(pairs[i+1], 64)
+			if err != nil {
+				return redis.Z{}, false, fmt.Errorf("redis error: %v", err)
+			}
+			return redis.Z{Score: score, Member: pairs[i]}, true, nil
+		}
+		if next == 0 {
+			return redis.Z{}, false, nil
+		}
+		cursor = next
+	}
+}
+
+// GetByID returns the item with the given id without removing it
+func (rpq *RedisPriorityQueue) GetByID(ctx context.Context, queueName, id string) (Item, error) {
+	z, ok, err := rpq.findByID(ctx, queueName, id)
+	if err != nil {
+		return Item{}, err
+	}
+	if !ok {
+		return Item{}, itemNotFoundError(queueName, id)
+	}
+	member := z.Member.(string)
+
+	payload, err := decodeMember(member)
+	if err != nil {
+		return Item{}, fmt.Errorf("%w: item '%s' of queue '%s'", err, id, queueName)
+	}
+	value, err := rpq.resolvePayload(ctx, queueName, payload)
+	if err != nil {
+		return Item{}, err
+	}
+	item := Item{ID: id, Value: value, Priority: priorityOf(z.Score)}
+	times, err := rpq.client.ZMScore(ctx, enqueuedKey(queueName), member).Result()
+	if err != nil {
+		return Item{}, fmt.Errorf("redis error: %v", err)
+	}
+	if len(times) == 1 && times[0] != 0 {
+		item.EnqueuedAt = time.UnixMicro(int64(times[0]))
+	}
+	return item, nil
+}
+
+// DeleteByID removes the item with the given id
+func (rpq *RedisPriorityQueue) DeleteByID(ctx context.Context, queueName, id string) error {
+	lock := rpq.queueLock(queueName)
+	lock.Lock()
+	defer lock.Unlock()
+
+	if err := rpq.checkWritable(ctx, queueName); err != nil {
+		return err
+	}
+
+	z, ok, err := rpq.findByID(ctx, queueName, id)
+	if err != nil {
+		return err
+	}
+	count := int64(0)
+	if ok {
+		if count, err = rpq.removeMembers(ctx, queueName, z.Member.(string)); err != nil {
+			return err
+		}
+	}
+	if count == 0 {
+		return itemNotFoundError(queueName, id)
+	}
+	return nil
+}
+
+// UpdateByID replaces the value of the item with the given id, keeping its
+// id, priority, position and enqueue time. The old member is swapped for the
+// new one in a WATCH/MULTI transaction.
+func (rpq *RedisPriorityQueue) UpdateByID(ctx context.Context, queueName, id string, value interface{}) error {
+	lock := rpq.queueLock(queueName)
+	lock.Lock()
+	defer lock.Unlock()
+
+	if err := rpq.checkWritable(ctx, queueName); err != nil {
+		return err
+	}
+
+	z, ok, err := rpq.findByID(ctx, queueName, id)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return itemNotFoundError(queueName, id)
+	}
+	old := z.Member.(string)
+	oldBlob, _ := blobID(memberPayload(old))
+	member, blob, body := rpq.storedMemberWithID(value, id)
+
+	txf := func(tx *redis.Tx) error {
+		times, err := tx.ZMScore(ctx, enqueuedKey(queueName), old).Result()
+		if err != nil {
+			return fmt.Errorf("redis error: %v", err)
+		}
+		if err := tx.ZScore(ctx, queueName, old).Err(); err == redis.Nil {
+			return itemNotFoundError(queueName, id)
+		} else if err != nil {
+			return fmt.Errorf("redis error: %v", err)
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.ZRem(ctx, queueName, old)
+			pipe.ZAdd(ctx, queueName, redis.Z{Score: z.Score, Member: member})
+			pipe.ZRem(ctx, enqueuedKey(queueName), old)
+			if len(times) == 1 && times[0] != 0 {
+				pipe.ZAdd(ctx, enqueuedKey(queueName), redis.Z{Score: times[0], Member: member})
+			}
+			if oldBlob != "" {
+				pipe.HDel(ctx, blobsKey(queueName), oldBlob)
+			}
+			if blob != "" {
+				pipe.HSet(ctx, blobsKey(queueName), blob, body)
+			}
+			return nil
+		})
+		return err
+	}
+
+	for {
+		err := rpq.client.Watch(ctx, txf, queueName)
+		if err != redis.TxFailedErr {
+			return err
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package priorityqueue
+
+import (
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"strconv"
+	"strings"
+)
+
+// ErrCorruptPayload is returned when a stored item fails its checksum. The
+// damaged entry is moved to the queue's quarantine list.
+var ErrCorruptPayload = errors.New("corrupt payload")
+
+// Items are stored in Redis as
+//
+//	<payload>\x00<item id in 16 hex digits>\x01<crc32 in 8 hex digits>
+//
+// The item id makes every enqueued copy of a value a distinct sorted set
+// member, so duplicates are kept as they are by the memory backend. The
+// checksum covers the payload and id, so truncation or corruption of a member
+// is detected when it is read back. Members written before item ids existed
+// are "<payload>\x00<crc32>" and still decode. Members written before
+// checksums are the bare payload, with no NUL byte; they are returned
+// unverified rather than quarantined.
+const checksumLen = 8
+
+// encodeMember tags a payload with an item id and appends the checksum
+func encodeMember(payload, id string) string {
+	tagged := payload + "\x00" + id
+	return fmt.Sprintf("%s\x01%08x", tagged, crc32.ChecksumIEEE([]byte(tagged)))
+}
+
+// decodeMember verifies a stored member and returns its payload
+func decodeMember(member string) (string, error) {
+	payload, _, err := decodeMemberID(member)
+	return payload, err
+}
+
+// decodeMemberID verifies a stored member and returns its payload and item
+// id, which is empty for members written before item ids
+func decodeMemberID(member string) (string, string, error) {
+	if !strings.Contains(member, "\x00") {
+		return member, "", nil // Written before checksums, can't be verified
+	}
+	sep := len(member) - checksumLen - 1
+	if sep < 0 || (member[sep] != 0 && member[sep] != 1) {
+		return "", "", ErrCorruptPayload
+	}
+	sum, err := strconv.ParseUint(member[sep+1:], 16, 32)
+	if err != nil {
+		return "", "", ErrCorruptPayload
+	}
+	payload := member[:sep]
+	if crc32.ChecksumIEEE([]byte(payload)) != uint32(sum) {
+		return "", "", ErrCorruptPayload
+	}
+	if member[sep] == 0 {
+		return payload, "", nil // Written before item ids
+	}
+	tag := len(payload) - itemIDLen - 1
+	if tag < 0 || payload[tag] != 0 {
+		return "", "", ErrCorruptPayload
+	}
+	return payload[:tag], payload[tag+1:], nil
+}
+
+// memberPayload returns a member's payload for display, falling back to the
+// raw member when it can't be decoded
+func memberPayload(member string) string {
+	if payload, err := decodeMember(member); err == nil {
+		return payload
+	}
+	return member
+}
@@ -3,167 +3,1776 @@ package priorityqueue
 import (
 	"context"
 	"fmt"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/redis/go-redis/v9"
 )
 
-// RedisPriorityQueue implements PriorityQueuer using Redis
+// RedisPriorityQueue implements PriorityQueuer using Redis. Operations that
+// read and then write a queue are serialized by a per-queue lock, so work on
+// unrelated queues never contends; mutex only guards the client-side maps.
+// Values are stored as their fmt %v string and come back as strings; wrap the
+// queue in a TypedQueue to encode them with a Codec instead.
 type RedisPriorityQueue struct {
-	client *redis.Client
-	ctx    context.Context
-	mutex  sync.Mutex
+	client        redisCommands
+	weights       map[string][]float64
+	locks         map[string]*sync.Mutex
+	depths        *depthCache
+	clock         Clock
+	blobThreshold int
+	frozen        map[string]bool
+	upgraded      map[string]bool
+	levels        int
+	mutex         sync.Mutex
 }
 
 // NewRedisPriorityQueue creates a new Redis-based priority queue
 func NewRedisPriorityQueue(addr, password string, db int) PriorityQueuer {
+	return newRedisPriorityQueue(&redis.Options{
+		Addr:     addr,
+		Password: password,
+		DB:       db,
+	})
+}
+
+// NewRedisPriorityQueueWithUser creates a Redis-based priority queue that
+// authenticates as a Redis 6+ ACL user. RedisACLRule gives the least
+// privilege such a user needs.
+func NewRedisPriorityQueueWithUser(addr, username, password string, db int) PriorityQueuer {
+	return newRedisPriorityQueue(&redis.Options{
+		Addr:     addr,
+		Username: username,
+		Password: password,
+		DB:       db,
+	})
+}
+
+func newRedisPriorityQueue(opts *redis.Options) *RedisPriorityQueue {
 	rpq := &RedisPriorityQueue{
-		client: redis.NewClient(&redis.Options{
-			Addr:     addr,
-			Password: password,
-			DB:       db,
-		}),
-		ctx: context.Background(),
+		client:   redis.NewClient(opts),
+		weights:  make(map[string][]float64),
+		locks:    make(map[string]*sync.Mutex),
+		frozen:   make(map[string]bool),
+		upgraded: make(map[string]bool),
+		depths:   newDepthCache(),
+		clock:    SystemClock{},
+		levels:   DefaultLevels,
 	}
 	// Verify connection
-	if err := rpq.client.Ping(rpq.ctx).Err(); err != nil {
-		panic(fmt.Sprintf("failed to connect to Redis at %s: %v", addr, err))
+	if err := rpq.client.Ping(context.Background()).Err(); err != nil {
+		panic(fmt.Sprintf("failed to connect to Redis at %s: %v", opts.Addr, err))
 	}
 	return rpq
 }
 
-// ClearQueues removes specified queues from Redis
-func (rpq *RedisPriorityQueue) ClearQueues(queues ...string) error {
+// SetClock replaces the time source used for enqueue timestamps and ages. It
+// must be called before the queue is shared between goroutines, and every
+// client of a queue should agree on the time.
+func (rpq *RedisPriorityQueue) SetClock(clock Clock) {
+	rpq.clock = clock
+}
+
+// SetLevels changes the number of priority levels, 0 to levels-1, up to
+// MaxRedisLevels. It must be called before the queue is shared between
+// goroutines, and every client of a queue should agree on it: items above
+// the last level are left in Redis but are not dequeued or peeked by this
+// client. It fails while any
+// queue has priority weights, since those cover the old levels.
+func (rpq *RedisPriorityQueue) SetLevels(levels int) error {
+	if levels < 1 || levels > MaxRedisLevels {
+		return fmt.Errorf("levels must be between 1 and %d", MaxRedisLevels)
+	}
+
+	rpq.mutex.Lock()
+	defer rpq.mutex.Unlock()
+
+	if len(rpq.weights) > 0 {
+		return fmt.Errorf("levels can't be changed while priority weights are set")
+	}
+	rpq.levels = levels
+	return nil
+}
+
+// Levels returns the number of priority levels
+func (rpq *RedisPriorityQueue) Levels() int {
+	return rpq.levels
+}
+
+// FreezeQueue makes every call that adds, removes or reorders items fail
+// with ErrQueueFrozen until UnfreezeQueue is called. Like priority weights,
+// the flag is held by this client; other processes using the same Redis key
+// are not stopped.
+func (rpq *RedisPriorityQueue) FreezeQueue(ctx context.Context, queueName string) error {
+	rpq.mutex.Lock()
+	defer rpq.mutex.Unlock()
+	rpq.frozen[queueName] = true
+	return nil
+}
+
+// UnfreezeQueue lifts a FreezeQueue
+func (rpq *RedisPriorityQueue) UnfreezeQueue(ctx context.Context, queueName string) error {
+	rpq.mutex.Lock()
+	defer rpq.mutex.Unlock()
+	delete(rpq.frozen, queueName)
+	return nil
+}
+
+// checkWritable fails if any of the queues is frozen or read-only
+func (rpq *RedisPriorityQueue) checkWritable(ctx context.Context, queueNames ...string) error {
+	rpq.mutex.Lock()
+	for _, queueName := range queueNames {
+		if rpq.frozen[queueName] {
+			rpq.mutex.Unlock()
+			return frozenError(queueName)
+		}
+	}
+	rpq.mutex.Unlock()
+	return rpq.checkReadOnly(ctx, queueNames...)
+}
+
+// queueLock returns the mutex serializing client-side operations on a queue
+func (rpq *RedisPriorityQueue) queueLock(queueName string) *sync.Mutex {
 	rpq.mutex.Lock()
 	defer rpq.mutex.Unlock()
 
+	lock, ok := rpq.locks[queueName]
+	if !ok {
+		lock = &sync.Mutex{}
+		rpq.locks[queueName] = lock
+	}
+	return lock
+}
+
+// lockQueues locks several queues in name order, so concurrent multi-queue
+// operations can't deadlock, and returns a function that unlocks them
+func (rpq *RedisPriorityQueue) lockQueues(queueNames ...string) func() {
+	names := append([]string(nil), queueNames...)
+	sort.Strings(names)
+
+	var held []*sync.Mutex
+	for i, name := range names {
+		if i > 0 && name == names[i-1] {
+			continue
+		}
+		lock := rpq.queueLock(name)
+		lock.Lock()
+		held = append(held, lock)
+	}
+	return func() {
+		for _, lock := range held {
+			lock.Unlock()
+		}
+	}
+}
+
+// ClearQueues removes specified queues from Redis
+func (rpq *RedisPriorityQueue) ClearQueues(ctx context.Context, queues ...string) error {
+	defer rpq.lockQueues(queues...)()
+
 	if len(queues) == 0 {
 		return nil
 	}
-	_, err := rpq.client.Del(rpq.ctx, queues...).Result()
+	var keys []string
+	for _, queue := range queues {
+		keys = append(keys, queueKeys(queue)...)
+	}
+	_, err := rpq.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, keys...)
+		pipe.SRem(ctx, registryKey, queues)
+		return nil
+	})
 	if err != nil {
 		return fmt.Errorf("redis error clearing queues: %v", err)
 	}
 	return nil
 }
 
-func (rpq *RedisPriorityQueue) AddQueue(name string) error {
+// AddQueue registers a queue so ListQueues reports it before anything is
+// enqueued. Enqueueing registers a queue too, so calling it is optional.
+// Names starting with "pq:" are reserved for the package's own keys.
+func (rpq *RedisPriorityQueue) AddQueue(ctx context.Context, name string) error {
+	if err := checkQueueName(name); err != nil {
+		return err
+	}
+	if err := rpq.client.SAdd(ctx, registryKey, name).Err(); err != nil {
+		return fmt.Errorf("redis error: %v", err)
+	}
+	return nil
+}
+
+// ListQueues returns the names of the registered queues in order
+func (rpq *RedisPriorityQueue) ListQueues(ctx context.Context) ([]string, error) {
+	names, err := rpq.client.SMembers(ctx, registryKey).Result()
+	if err != nil {
+		return nil, fmt.Errorf("redis error: %v", err)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// Purge removes every item from a queue along with its enqueue-time index,
+// blobs and sequence counter. The queue stays registered, and its counters,
+// quarantine and settings are kept. Unlike ClearQueues it honours frozen and
+// read-only queues.
+func (rpq *RedisPriorityQueue) Purge(ctx context.Context, queueName string) error {
+	lock := rpq.queueLock(queueName)
+	lock.Lock()
+	defer lock.Unlock()
+
+	if err := rpq.checkWritable(ctx, queueName); err != nil {
+		return err
+	}
+
+	err := rpq.client.Del(ctx, queueName, enqueuedKey(queueName), blobsKey(queueName), seqKey(queueName)).Err()
+	if err != nil {
+		return fmt.Errorf("redis error: %v", err)
+	}
+	rpq.depths.forget(queueName)
+	return nil
+}
+
+// RemoveQueue deletes a queue's keys, unregisters it and drops its
+// client-side settings. A queue that is neither registered nor has keys left
+// in Redis does not exist.
+func (rpq *RedisPriorityQueue) RemoveQueue(ctx context.Context, name string) error {
+	lock := rpq.queueLock(name)
+	lock.Lock()
+	defer lock.Unlock()
+
+	if err := rpq.checkWritable(ctx, name); err != nil {
+		return err
+	}
+
+	var del *redis.IntCmd
+	var unregister *redis.IntCmd
+	_, err := rpq.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		del = pipe.Del(ctx, queueKeys(name)...)
+		unregister = pipe.SRem(ctx, registryKey, name)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("redis error: %v", err)
+	}
+	if del.Val() == 0 && unregister.Val() == 0 {
+		return fmt.Errorf("queue '%s' does not exist", name)
+	}
+
+	rpq.mutex.Lock()
+	delete(rpq.weights, name)
+	delete(rpq.upgraded, name)
+	rpq.mutex.Unlock()
+	rpq.depths.forget(name)
 	return nil
 }
 
-func (rpq *RedisPriorityQueue) Enqueue(queueName string, value interface{}, priority int) error {
-	if priority < 0 || priority > 9 {
-		return fmt.Errorf("priority must be between 0 and 9")
+// RenameQueue renames every key of a queue in one script and carries its
+// priority weights over. It fails if newName already has any keys or is
+// registered.
+func (rpq *RedisPriorityQueue) RenameQueue(ctx context.Context, oldName, newName string) error {
+	if oldName == newName {
+		return fmt.Errorf("queue '%s' already exists", newName)
+	}
+
+	if err := checkQueueName(newName); err != nil {
+		return err
+	}
+
+	defer rpq.lockQueues(oldName, newName)()
+
+	if err := rpq.checkWritable(ctx, oldName, newName); err != nil {
+		return err
+	}
+
+	keys := append(append(queueKeys(oldName), queueKeys(newName)...), registryKey)
+	err := renameScript.Run(ctx, rpq.client, keys, oldName, newName).Err()
+	if err == redis.Nil {
+		return fmt.Errorf("queue '%s' does not exist", oldName)
+	}
+	if err != nil && strings.Contains(err.Error(), "already exists") {
+		return fmt.Errorf("queue '%s' already exists", newName)
+	}
+	if err != nil {
+		return fmt.Errorf("redis error: %v", err)
 	}
 
 	rpq.mutex.Lock()
-	defer rpq.mutex.Unlock()
+	if weights, ok := rpq.weights[oldName]; ok {
+		rpq.weights[newName] = weights
+		delete(rpq.weights, oldName)
+	}
+	delete(rpq.upgraded, oldName)
+	delete(rpq.upgraded, newName)
+	rpq.mutex.Unlock()
+	rpq.depths.forget(oldName)
+	rpq.depths.forget(newName)
+	return nil
+}
+
+func (rpq *RedisPriorityQueue) Enqueue(ctx context.Context, queueName string, value interface{}, priority int) error {
+	if err := rpq.upgradeScores(ctx, queueName); err != nil {
+		return err
+	}
+
+	if err := checkPriority(priority, rpq.levels); err != nil {
+		return err
+	}
+
+	lock := rpq.queueLock(queueName)
+	lock.Lock()
+	defer lock.Unlock()
+
+	if err := rpq.checkWritable(ctx, queueName); err != nil {
+		return err
+	}
+
+	member, id, body := rpq.storedMember(value)
+	return rpq.appendMember(ctx, []string{queueName}, priority, member, id, body)
+}
+
+// EnqueueFanout adds a copy of value to each of the named queues in a single
+// script, so either every queue receives it or none does. A queue named more
+// than once gets one copy, and each copy has its own item id.
+func (rpq *RedisPriorityQueue) EnqueueFanout(ctx context.Context, queueNames []string, value interface{}, priority int) error {
+	if err := rpq.upgradeScores(ctx, queueNames...); err != nil {
+		return err
+	}
+
+	if err := checkPriority(priority, rpq.levels); err != nil {
+		return err
+	}
+
+	defer rpq.lockQueues(queueNames...)()
+
+	if err := rpq.checkWritable(ctx, queueNames...); err != nil {
+		return err
+	}
+
+	seen := make(map[string]bool, len(queueNames))
+	entries := make([]storedEntry, 0, len(queueNames))
+	for _, queueName := range queueNames {
+		if seen[queueName] {
+			continue
+		}
+		seen[queueName] = true
+		member, id, body := rpq.storedMember(value)
+		entries = append(entries, storedEntry{queueName, priority, member, id, body})
+	}
+	return rpq.appendEntries(ctx, entries)
+}
+
+// EnqueueMulti adds each entry to its queue in a single script, so either
+// every entry is queued or none is
+func (rpq *RedisPriorityQueue) EnqueueMulti(ctx context.Context, entries []QueueEntry) error {
+	names := make([]string, len(entries))
+	stored := make([]storedEntry, len(entries))
+	for i, e := range entries {
+		if err := checkPriority(e.Priority, rpq.levels); err != nil {
+			return err
+		}
+		names[i] = e.QueueName
+		member, id, body := rpq.storedMember(e.Value)
+		stored[i] = storedEntry{e.QueueName, e.Priority, member, id, body}
+	}
+	if len(entries) == 0 {
+		return nil
+	}
+	if err := rpq.upgradeScores(ctx, names...); err != nil {
+		return err
+	}
+
+	defer rpq.lockQueues(names...)()
+
+	if err := rpq.checkWritable(ctx, names...); err != nil {
+		return err
+	}
+	return rpq.appendEntries(ctx, stored)
+}
+
+// EnqueueBatch appends every item to the tail of its priority level with a
+// single script call, so the cost is one round trip however many items there
+// are. Either every item is enqueued or none are. Each item's Value and
+// Priority are used; EnqueuedAt is set to the current time. Redis runs the
+// whole batch without serving other clients, so batches of a few thousand
+// items keep latency for everyone else low.
+func (rpq *RedisPriorityQueue) EnqueueBatch(ctx context.Context, queueName string, items []Item) error {
+	if err := rpq.upgradeScores(ctx, queueName); err != nil {
+		return err
+	}
+
+	stored := make([]storedEntry, len(items))
+	for i, item := range items {
+		if err := checkPriority(item.Priority, rpq.levels); err != nil {
+			return err
+		}
+		member, id, body := rpq.storedMember(item.Value)
+		stored[i] = storedEntry{queueName, item.Priority, member, id, body}
+	}
+	if len(items) == 0 {
+		return nil
+	}
+
+	lock := rpq.queueLock(queueName)
+	lock.Lock()
+	defer lock.Unlock()
 
-	err := rpq.client.ZAdd(rpq.ctx, queueName, redis.Z{
-		Score:  float64(priority),
-		Member: fmt.Sprintf("%v", value),
-	}).Err()
-	return err
+	if err := rpq.checkWritable(ctx, queueName); err != nil {
+		return err
+	}
+	return rpq.appendEntries(ctx, stored)
 }
 
-func (rpq *RedisPriorityQueue) Dequeue(queueName string) (interface{}, error) {
+func (rpq *RedisPriorityQueue) Dequeue(ctx context.Context, queueName string) (interface{}, error) {
+	if err := rpq.upgradeScores(ctx, queueName); err != nil {
+		return nil, err
+	}
+
 	rpq.mutex.Lock()
-	defer rpq.mutex.Unlock()
+	weights, weighted := rpq.weights[queueName]
+	rpq.mutex.Unlock()
+
+	lock := rpq.queueLock(queueName)
+	lock.Lock()
+	defer lock.Unlock()
+
+	if err := rpq.checkWritable(ctx, queueName); err != nil {
+		return nil, err
+	}
+
+	if weighted {
+		return rpq.dequeueWeighted(ctx, queueName, weights)
+	}
 
-	result, err := rpq.client.ZPopMin(rpq.ctx, queueName, 1).Result()
+	result, err := popLevelScript.Run(ctx, rpq.client, rpq.popKeys(queueName), "-inf", rpq.servedMax()).Result()
+	if err == redis.Nil {
+		return nil, emptyError(queueName)
+	}
 	if err != nil {
 		return nil, fmt.Errorf("redis error: %v", err)
 	}
-	if len(result) == 0 {
-		return nil, fmt.Errorf("queue '%s' is empty", queueName)
+	return rpq.verifyPopped(ctx, queueName, result.(string))
+}
+
+// verifyPopped checks the checksum of a member that has already been removed
+// from the queue and resolves offloaded bodies, deleting the blob. Corrupt
+// members and members whose blob is missing or damaged are pushed to the
+// quarantine list.
+func (rpq *RedisPriorityQueue) verifyPopped(ctx context.Context, queueName, member string) (interface{}, error) {
+	payload, itemID, err := decodeMemberID(member)
+	if err == nil {
+		var value string
+		value, err = rpq.resolvePayload(ctx, queueName, payload)
+		if err == nil {
+			if id, ok := blobID(payload); ok {
+				if err := rpq.client.HDel(ctx, blobsKey(queueName), id).Err(); err != nil {
+					return nil, fmt.Errorf("redis error: %v", err)
+				}
+			}
+			noteRemoved(ctx, itemID)
+			return value, nil
+		}
+	}
+	_, err = rpq.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.RPush(ctx, quarantineKey(queueName), member)
+		pipe.Expire(ctx, quarantineKey(queueName), quarantineTTL)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("redis error quarantining corrupt item: %v", err)
 	}
-	return result[0].Member, nil
+	return nil, fmt.Errorf("%w: item %q in queue '%s' was quarantined", ErrCorruptPayload, member, queueName)
 }
 
-func (rpq *RedisPriorityQueue) IsEmpty(queueName string) (bool, error) {
-	rpq.mutex.Lock()
-	defer rpq.mutex.Unlock()
+// Quarantined returns the raw stored form of items that failed their checksum
+// on dequeue, oldest first
+func (rpq *RedisPriorityQueue) Quarantined(ctx context.Context, queueName string) ([]string, error) {
+	members, err := rpq.client.LRange(ctx, quarantineKey(queueName), 0, -1).Result()
+	if err != nil {
+		return nil, fmt.Errorf("redis error: %v", err)
+	}
+	return members, nil
+}
+
+// dequeueWeighted pops the head of a priority level chosen by weight. The
+// caller must hold the queue's lock.
+func (rpq *RedisPriorityQueue) dequeueWeighted(ctx context.Context, queueName string, weights []float64) (interface{}, error) {
+	for {
+		pipe := rpq.client.Pipeline()
+		counts := make([]*redis.IntCmd, rpq.levels)
+		for priority := 0; priority < rpq.levels; priority++ {
+			min, max := levelRange(priority)
+			counts[priority] = pipe.ZCount(ctx, queueName, min, max)
+		}
+		if _, err := pipe.Exec(ctx); err != nil {
+			return nil, fmt.Errorf("redis error: %v", err)
+		}
+
+		nonEmpty := make([]bool, rpq.levels)
+		for priority, cmd := range counts {
+			nonEmpty[priority] = cmd.Val() > 0
+		}
+		priority := pickWeightedLevel(weights, nonEmpty)
+		if priority < 0 {
+			return nil, emptyError(queueName)
+		}
+
+		// Another client may have drained the level since it was counted, in
+		// which case we pick again
+		value, err := rpq.popLevel(ctx, queueName, priority)
+		if err != redis.Nil {
+			return value, err
+		}
+	}
+}
+
+// popLevel atomically removes the head of a priority level. It returns
+// redis.Nil if the level is empty.
+func (rpq *RedisPriorityQueue) popLevel(ctx context.Context, queueName string, priority int) (interface{}, error) {
+	min, max := levelRange(priority)
+	result, err := popLevelScript.Run(ctx, rpq.client, rpq.popKeys(queueName), min, max).Result()
+	if err == redis.Nil {
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("redis error: %v", err)
+	}
+	return rpq.verifyPopped(ctx, queueName, result.(string))
+}
+
+func (rpq *RedisPriorityQueue) IsEmpty(ctx context.Context, queueName string) (bool, error) {
+	lock := rpq.queueLock(queueName)
+	lock.Lock()
+	defer lock.Unlock()
 
-	count, err := rpq.client.ZCard(rpq.ctx, queueName).Result()
+	count, err := rpq.client.ZCard(ctx, queueName).Result()
 	if err != nil {
 		return false, fmt.Errorf("redis error: %v", err)
 	}
 	return count == 0, nil
 }
 
-func (rpq *RedisPriorityQueue) ListContents(queueName string) (map[int][]interface{}, error) {
-	rpq.mutex.Lock()
-	defer rpq.mutex.Unlock()
-
-	members, err := rpq.client.ZRangeWithScores(rpq.ctx, queueName, 0, -1).Result()
+// Size returns the number of items in a queue with one ZCARD
+func (rpq *RedisPriorityQueue) Size(ctx context.Context, queueName string) (int, error) {
+	size, err := rpq.client.ZCard(ctx, queueName).Result()
 	if err != nil {
+		return 0, fmt.Errorf("redis error: %v", err)
+	}
+	return int(size), nil
+}
+
+// CountByPriority returns the number of items at each non-empty priority
+// level, with one pipelined ZCOUNT per level
+func (rpq *RedisPriorityQueue) CountByPriority(ctx context.Context, queueName string) (map[int]int, error) {
+	if err := rpq.upgradeScores(ctx, queueName); err != nil {
+		return nil, err
+	}
+
+	pipe := rpq.client.Pipeline()
+	cmds := make([]*redis.IntCmd, rpq.levels)
+	for i := range cmds {
+		min, max := levelRange(i)
+		cmds[i] = pipe.ZCount(ctx, queueName, min, max)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
 		return nil, fmt.Errorf("redis error: %v", err)
 	}
 
-	contents := make(map[int][]interface{})
-	for _, member := range members {
-		priority := int(member.Score + 0.5) // Round to handle micro-decrements
-		if priority >= 0 && priority <= 9 {
-			contents[priority] = append(contents[priority], member.Member)
+	counts := make(map[int]int)
+	for i, cmd := range cmds {
+		if n := cmd.Val(); n > 0 {
+			counts[i] = int(n)
 		}
 	}
-	return contents, nil
+	return counts, nil
 }
 
-func (rpq *RedisPriorityQueue) GetPosition(queueName string, value interface{}) (int, int, error) {
-	rpq.mutex.Lock()
-	defer rpq.mutex.Unlock()
+// LevelLen returns the number of items queued at a single priority level
+func (rpq *RedisPriorityQueue) LevelLen(ctx context.Context, queueName string, priority int) (int64, error) {
+	if err := rpq.upgradeScores(ctx, queueName); err != nil {
+		return 0, err
+	}
+
+	if err := checkPriority(priority, rpq.levels); err != nil {
+		return 0, err
+	}
 
-	members, err := rpq.client.ZRangeWithScores(rpq.ctx, queueName, 0, -1).Result()
+	min, max := levelRange(priority)
+	count, err := rpq.client.ZCount(ctx, queueName, min, max).Result()
 	if err != nil {
-		return -1, -1, fmt.Errorf("redis error: %v", err)
+		return 0, fmt.Errorf("redis error: %v", err)
 	}
+	return count, nil
+}
 
-	valueStr := fmt.Sprintf("%v", value)
-	for i, member := range members {
-		if member.Member == valueStr {
-			priority := int(member.Score + 0.5) // Round to nearest integer
-			pos := 0
-			for j := 0; j < i; j++ {
-				if int(members[j].Score+0.5) == priority {
-					pos++
-				}
-			}
-			return priority, pos, nil
+// FastLen returns the exact number of queued items and the oldest item's age,
+// read with one ZCARD and one lookup at the head of the enqueue-time index.
+// Results are cached for depthCacheTTL.
+func (rpq *RedisPriorityQueue) FastLen(ctx context.Context, queueName string) (QueueDepth, error) {
+	now := rpq.clock.Now()
+	return rpq.depths.get(queueName, now, func() (QueueDepth, error) {
+		pipe := rpq.client.Pipeline()
+		card := pipe.ZCard(ctx, queueName)
+		oldest := pipe.ZRangeWithScores(ctx, enqueuedKey(queueName), 0, 0)
+		if _, err := pipe.Exec(ctx); err != nil {
+			return QueueDepth{}, fmt.Errorf("redis error: %v", err)
+		}
+
+		depth := QueueDepth{Len: card.Val()}
+		if head := oldest.Val(); len(head) > 0 {
+			depth.ApproxOldestAge = now.Sub(time.UnixMicro(int64(head[0].Score)))
+		}
+		return depth, nil
+	})
+}
+
+func (rpq *RedisPriorityQueue) ListContents(ctx context.Context, queueName string) (map[int][]interface{}, error) {
+	if err := rpq.upgradeScores(ctx, queueName); err != nil {
+		return nil, err
+	}
+
+	lock := rpq.queueLock(queueName)
+	lock.Lock()
+	defer lock.Unlock()
+
+	contents := make(map[int][]interface{})
+	err := rpq.scanQueue(ctx, queueName, func(member redis.Z) bool {
+		priority := priorityOf(member.Score)
+		if priority >= 0 && priority < rpq.levels {
+			contents[priority] = append(contents[priority], rpq.displayValue(ctx, queueName, member.Member.(string)))
 		}
+		return true
+	})
+	if err != nil {
+		return nil, err
 	}
-	return -1, -1, fmt.Errorf("value '%v' not found in queue '%s'", value, queueName)
+	return contents, nil
 }
 
-func (rpq *RedisPriorityQueue) InsertAtTop(queueName string, value interface{}, priority int) error {
-	if priority < 0 || priority > 9 {
-		return fmt.Errorf("priority must be between 0 and 9")
+// IterateContents calls fn for every item in priority order, stopping early
+// if fn returns false. The queue is read in pages of scanBatch items, so huge
+// queues are never held in memory at once; pages are separate reads, and
+// items moved by concurrent writers between pages may be skipped or repeated.
+func (rpq *RedisPriorityQueue) IterateContents(ctx context.Context, queueName string, fn func(priority int, value interface{}) bool) error {
+	if err := rpq.upgradeScores(ctx, queueName); err != nil {
+		return err
 	}
 
-	rpq.mutex.Lock()
-	defer rpq.mutex.Unlock()
+	return rpq.scanQueue(ctx, queueName, func(member redis.Z) bool {
+		return fn(priorityOf(member.Score), rpq.displayValue(ctx, queueName, member.Member.(string)))
+	})
+}
+
+// IterateItems calls fn for every item, with its id, in priority order,
+// stopping early if fn returns false. Members written before item ids have an
+// empty ID, and members missing from the enqueue-time index a zero
+// EnqueuedAt. The queue is paged like IterateContents.
+func (rpq *RedisPriorityQueue) IterateItems(ctx context.Context, queueName string, fn func(item Item) bool) error {
+	if err := rpq.upgradeScores(ctx, queueName); err != nil {
+		return err
+	}
+
+	for start := int64(0); ; start += scanBatch {
+		members, err := rpq.client.ZRangeWithScores(ctx, queueName, start, start+scanBatch-1).Result()
+		if err != nil {
+			return fmt.Errorf("redis error: %v", err)
+		}
+		if len(members) == 0 {
+			return nil
+		}
 
-	valueStr := fmt.Sprintf("%v", value)
-	rpq.client.ZRem(rpq.ctx, queueName, valueStr)
+		names := make([]string, len(members))
+		for i, member := range members {
+			names[i] = member.Member.(string)
+		}
+		times, err := rpq.client.ZMScore(ctx, enqueuedKey(queueName), names...).Result()
+		if err != nil {
+			return fmt.Errorf("redis error: %v", err)
+		}
 
-	score := float64(priority) - 0.000001
-	return rpq.client.ZAdd(rpq.ctx, queueName, redis.Z{
-		Score:  score,
-		Member: valueStr,
-	}).Err()
+		for i, member := range members {
+			_, id, _ := decodeMemberID(names[i])
+			item := Item{
+				ID:       id,
+				Value:    rpq.displayValue(ctx, queueName, names[i]),
+				Priority: priorityOf(member.Score),
+			}
+			if times[i] != 0 {
+				item.EnqueuedAt = time.UnixMicro(int64(times[i]))
+			}
+			if !fn(item) {
+				return nil
+			}
+		}
+		if len(members) < scanBatch {
+			return nil
+		}
+	}
 }
 
-func (rpq *RedisPriorityQueue) DeleteItem(queueName string, value interface{}) error {
-	rpq.mutex.Lock()
-	defer rpq.mutex.Unlock()
+func (rpq *RedisPriorityQueue) GetPosition(ctx context.Context, queueName string, value interface{}) (int, int, error) {
+	if err := rpq.upgradeScores(ctx, queueName); err != nil {
+		return -1, -1, err
+	}
+
+	lock := rpq.queueLock(queueName)
+	lock.Lock()
+	defer lock.Unlock()
 
-	valueStr := fmt.Sprintf("%v", value)
-	count, err := rpq.client.ZRem(rpq.ctx, queueName, valueStr).Result()
+	matches := memberMatcher(value)
+	priority, pos := -1, -1
+	counts := make(map[int]int)
+	err := rpq.scanQueue(ctx, queueName, func(member redis.Z) bool {
+		p := priorityOf(member.Score)
+		if matches(member.Member.(string)) {
+			priority, pos = p, counts[p]
+			return false
+		}
+		counts[p]++
+		return true
+	})
 	if err != nil {
-		return fmt.Errorf("redis error: %v", err)
+		return -1, -1, err
 	}
-	if count == 0 {
-		return fmt.Errorf("value '%v' not found in queue '%s'", value, queueName)
+	if priority == -1 {
+		return -1, -1, fmt.Errorf("value '%v' not found in queue '%s'", value, queueName)
 	}
-	return nil
+	return priority, pos, nil
+}
+
+// findMember returns the first member of a queue, in queue order, holding
+// value, or "" if there is none
+func (rpq *RedisPriorityQueue) findMember(ctx context.Context, queueName string, value interface{}) (string, error) {
+	matches := memberMatcher(value)
+	found := ""
+	err := rpq.scanQueue(ctx, queueName, func(member redis.Z) bool {
+		if name := member.Member.(string); matches(name) {
+			found = name
+			return false
+		}
+		return true
+	})
+	return found, err
+}
+
+// scanQueue walks a queue in score order, reading scanBatch members per
+// round trip. ZSCAN would bound memory too but returns members unordered,
+// and callers here depend on queue order.
+func (rpq *RedisPriorityQueue) scanQueue(ctx context.Context, queueName string, fn func(member redis.Z) bool) error {
+	for start := int64(0); ; start += scanBatch {
+		members, err := rpq.client.ZRangeWithScores(ctx, queueName, start, start+scanBatch-1).Result()
+		if err != nil {
+			return fmt.Errorf("redis error: %v", err)
+		}
+		for _, member := range members {
+			if !fn(member) {
+				return nil
+			}
+		}
+		if len(members) < scanBatch {
+			return nil
+		}
+	}
+}
+
+func (rpq *RedisPriorityQueue) InsertAtTop(ctx context.Context, queueName string, value interface{}, priority int) error {
+	return rpq.InsertAtTopBatch(ctx, queueName, []interface{}{value}, priority)
 }
+
+// InsertAtTopBatch places values at the head of the priority level in the
+// given order, so values[0] is dequeued first. Each value is scored just below
+// the current head of the level, and the read and write run in a single
+// WATCH/MULTI transaction so concurrent writers can't interleave.
+func (rpq *RedisPriorityQueue) InsertAtTopBatch(ctx context.Context, queueName string, values []interface{}, priority int) error {
+	if err := rpq.upgradeScores(ctx, queueName); err != nil {
+		return err
+	}
+
+	if err := checkQueueName(queueName); err != nil {
+		return err
+	}
+	if err := checkPriority(priority, rpq.levels); err != nil {
+		return err
+	}
+	if len(values) == 0 {
+		return nil
+	}
+
+	lock := rpq.queueLock(queueName)
+	lock.Lock()
+	defer lock.Unlock()
+
+	if err := rpq.checkWritable(ctx, queueName); err != nil {
+		return err
+	}
+
+	members := make([]string, len(values))
+	blobs := make(map[string]interface{})
+	for i, value := range values {
+		var id, body string
+		members[i], id, body = rpq.storedMember(value)
+		if id != "" {
+			blobs[id] = body
+		}
+	}
+
+	txf := func(tx *redis.Tx) error {
+		head, err := rpq.headScore(ctx, tx, queueName, priority)
+		if err != nil {
+			return err
+		}
+		zs := make([]redis.Z, len(members))
+		for i, member := range members {
+			zs[i] = redis.Z{
+				Score:  head - float64(len(members)-i),
+				Member: member,
+			}
+		}
+		if zs[0].Score < levelBase(priority)-float64(seqBase) {
+			return fmt.Errorf("%w: no room left at the top of priority %d in queue '%s'", ErrSequenceExhausted, priority, queueName)
+		}
+		now := float64(rpq.clock.Now().UnixMicro())
+		times := make([]redis.Z, len(members))
+		for i, member := range members {
+			times[i] = redis.Z{Score: now, Member: member}
+		}
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			if len(blobs) > 0 {
+				pipe.HSet(ctx, blobsKey(queueName), blobs)
+			}
+			pipe.ZAdd(ctx, queueName, zs...)
+			pipe.ZAdd(ctx, enqueuedKey(queueName), times...)
+			pipe.HIncrBy(ctx, countersKey(queueName), "enqueued", int64(len(members)))
+			pipe.SAdd(ctx, registryKey, queueName)
+			return nil
+		})
+		return err
+	}
+
+	for {
+		err := rpq.client.Watch(ctx, txf, queueName)
+		if err != redis.TxFailedErr {
+			return err
+		}
+	}
+}
+
+// headScore returns the score of the first item in a priority level, or the
+// level's base score if it is empty
+func (rpq *RedisPriorityQueue) headScore(ctx context.Context, cmd redis.Cmdable, queueName string, priority int) (float64, error) {
+	min, max := levelRange(priority)
+	head, err := cmd.ZRangeByScoreWithScores(ctx, queueName, &redis.ZRangeBy{
+		Min:   min,
+		Max:   max,
+		Count: 1,
+	}).Result()
+	if err != nil {
+		return 0, fmt.Errorf("redis error: %v", err)
+	}
+	if len(head) == 0 || head[0].Score > levelBase(priority) {
+		return levelBase(priority), nil
+	}
+	return head[0].Score, nil
+}
+
+func (rpq *RedisPriorityQueue) DeleteItem(ctx context.Context, queueName string, value interface{}) error {
+	lock := rpq.queueLock(queueName)
+	lock.Lock()
+	defer lock.Unlock()
+
+	if err := rpq.checkWritable(ctx, queueName); err != nil {
+		return err
+	}
+
+	member, err := rpq.findMember(ctx, queueName, value)
+	if err != nil {
+		return err
+	}
+	count := int64(0)
+	if member != "" {
+		if count, err = rpq.removeMembers(ctx, queueName, member); err != nil {
+			return err
+		}
+	}
+	if count == 0 {
+		return fmt.Errorf("value '%v' not found in queue '%s'", value, queueName)
+	}
+	return nil
+}
+
+// MoveToPosition moves the item with the given id to the given position
+// within the given priority level. Positions past the end of the level place
+// the item last. The target level is rescored in one WATCH/MULTI
+// transaction so its order no longer depends on member names.
+func (rpq *RedisPriorityQueue) MoveToPosition(ctx context.Context, queueName string, itemID string, priority, position int) error {
+	if err := rpq.upgradeScores(ctx, queueName); err != nil {
+		return err
+	}
+
+	if err := checkPriority(priority, rpq.levels); err != nil {
+		return err
+	}
+	if position < 0 {
+		return fmt.Errorf("position must not be negative")
+	}
+
+	lock := rpq.queueLock(queueName)
+	lock.Lock()
+	defer lock.Unlock()
+
+	if err := rpq.checkWritable(ctx, queueName); err != nil {
+		return err
+	}
+
+	z, ok, err := rpq.findByID(ctx, queueName, itemID)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return itemNotFoundError(queueName, itemID)
+	}
+	target := z.Member.(string)
+	txf := func(tx *redis.Tx) error {
+		if err := tx.ZScore(ctx, queueName, target).Err(); err == redis.Nil {
+			return itemNotFoundError(queueName, itemID)
+		} else if err != nil {
+			return fmt.Errorf("redis error: %v", err)
+		}
+
+		min, max := levelRange(priority)
+		current, err := tx.ZRangeByScore(ctx, queueName, &redis.ZRangeBy{Min: min, Max: max}).Result()
+		if err != nil {
+			return fmt.Errorf("redis error: %v", err)
+		}
+
+		level := make([]string, 0, len(current)+1)
+		for _, member := range current {
+			if member != target {
+				level = append(level, member)
+			}
+		}
+		if position > len(level) {
+			position = len(level)
+		}
+		level = append(level[:position], append([]string{target}, level[position:]...)...)
+
+		zs, err := levelScores(priority, level)
+		if err != nil {
+			return err
+		}
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.ZAdd(ctx, queueName, zs...)
+			return nil
+		})
+		return err
+	}
+
+	for {
+		err := rpq.client.Watch(ctx, txf, queueName)
+		if err != redis.TxFailedErr {
+			return err
+		}
+	}
+}
+
+// UpdatePriority moves the item matching value to the tail of newPriority,
+// keeping its enqueue time. The item is rescored in place by a script, so it
+// is never missing from the queue mid-move. An item already at newPriority
+// keeps its position.
+func (rpq *RedisPriorityQueue) UpdatePriority(ctx context.Context, queueName string, value interface{}, newPriority int) error {
+	if err := rpq.upgradeScores(ctx, queueName); err != nil {
+		return err
+	}
+
+	if err := checkPriority(newPriority, rpq.levels); err != nil {
+		return err
+	}
+
+	lock := rpq.queueLock(queueName)
+	lock.Lock()
+	defer lock.Unlock()
+
+	if err := rpq.checkWritable(ctx, queueName); err != nil {
+		return err
+	}
+
+	member, err := rpq.findMember(ctx, queueName, value)
+	if err != nil {
+		return err
+	}
+	if member == "" {
+		return fmt.Errorf("value '%v' not found in queue '%s'", value, queueName)
+	}
+	keys := []string{queueName, seqKey(queueName)}
+	err = reprioritizeScript.Run(ctx, rpq.client, keys, member, newPriority, seqSpace, seqBase).Err()
+	if err == redis.Nil {
+		return fmt.Errorf("value '%v' not found in queue '%s'", value, queueName)
+	}
+	if err != nil && strings.Contains(err.Error(), "sequence exhausted") {
+		return fmt.Errorf("%w: %v", ErrSequenceExhausted, err)
+	}
+	if err != nil {
+		return fmt.Errorf("redis error: %v", err)
+	}
+	return nil
+}
+
+// MoveItem transfers the item matching value from srcQueue to the tail of the
+// same priority level in dstQueue, keeping its enqueue time. A script moves
+// the member, its index entry and any offloaded body in one step, so the
+// item can't be lost or duplicated mid-move. The move counts as a dequeue
+// from srcQueue and an enqueue into dstQueue.
+func (rpq *RedisPriorityQueue) MoveItem(ctx context.Context, srcQueue, dstQueue string, value interface{}) error {
+	if err := rpq.upgradeScores(ctx, srcQueue, dstQueue); err != nil {
+		return err
+	}
+
+	if srcQueue == dstQueue {
+		return fmt.Errorf("can't move an item within queue '%s'", srcQueue)
+	}
+	if err := checkQueueName(dstQueue); err != nil {
+		return err
+	}
+
+	defer rpq.lockQueues(srcQueue, dstQueue)()
+
+	if err := rpq.checkWritable(ctx, srcQueue, dstQueue); err != nil {
+		return err
+	}
+
+	member, err := rpq.findMember(ctx, srcQueue, value)
+	if err != nil {
+		return err
+	}
+	if member == "" {
+		return fmt.Errorf("value '%v' not found in queue '%s'", value, srcQueue)
+	}
+	id, _ := blobID(memberPayload(member))
+	keys := []string{
+		srcQueue, enqueuedKey(srcQueue), countersKey(srcQueue), blobsKey(srcQueue),
+		dstQueue, enqueuedKey(dstQueue), countersKey(dstQueue), blobsKey(dstQueue), seqKey(dstQueue),
+		registryKey,
+	}
+	err = moveScript.Run(ctx, rpq.client, keys, member, id, seqSpace, seqBase).Err()
+	if err == redis.Nil {
+		return fmt.Errorf("value '%v' not found in queue '%s'", value, srcQueue)
+	}
+	if err != nil && strings.Contains(err.Error(), "sequence exhausted") {
+		return fmt.Errorf("%w: %v", ErrSequenceExhausted, err)
+	}
+	if err != nil {
+		return fmt.Errorf("redis error: %v", err)
+	}
+	return nil
+}
+
+// SwapItems exchanges the priority and position of the items with ids itemA
+// and itemB by swapping their scores. Both items are found by ZSCAN and the
+// swap is a WATCH/MULTI transaction, so no level is read in full.
+func (rpq *RedisPriorityQueue) SwapItems(ctx context.Context, queueName, itemA, itemB string) error {
+	if err := rpq.upgradeScores(ctx, queueName); err != nil {
+		return err
+	}
+
+	lock := rpq.queueLock(queueName)
+	lock.Lock()
+	defer lock.Unlock()
+
+	if err := rpq.checkWritable(ctx, queueName); err != nil {
+		return err
+	}
+
+	txf := func(tx *redis.Tx) error {
+		a, ok, err := rpq.findByID(ctx, queueName, itemA)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return itemNotFoundError(queueName, itemA)
+		}
+		b, ok, err := rpq.findByID(ctx, queueName, itemB)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return itemNotFoundError(queueName, itemB)
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.ZAdd(ctx, queueName, redis.Z{Score: b.Score, Member: a.Member}, redis.Z{Score: a.Score, Member: b.Member})
+			return nil
+		})
+		return err
+	}
+
+	for {
+		err := rpq.client.Watch(ctx, txf, queueName)
+		if err != redis.TxFailedErr {
+			return err
+		}
+	}
+}
+
+// levelScores assigns strictly increasing scores to members of a priority
+// level, ending just below the level's base score so later Enqueue calls
+// still land after them
+func levelScores(priority int, members []string) ([]redis.Z, error) {
+	if int64(len(members)) > seqBase {
+		return nil, fmt.Errorf("%w: priority %d has too many items to reorder", ErrSequenceExhausted, priority)
+	}
+	zs := make([]redis.Z, len(members))
+	for i, member := range members {
+		zs[i] = redis.Z{
+			Score:  levelBase(priority) - float64(len(members)-i),
+			Member: member,
+		}
+	}
+	return zs, nil
+}
+
+// Peek returns the highest-priority item without removing it. With weights
+// set, Dequeue may pick a different item.
+func (rpq *RedisPriorityQueue) Peek(ctx context.Context, queueName string) (interface{}, error) {
+	if err := rpq.upgradeScores(ctx, queueName); err != nil {
+		return nil, err
+	}
+
+	head, err := rpq.client.ZRangeByScore(ctx, queueName, &redis.ZRangeBy{Min: "-inf", Max: rpq.servedMax(), Count: 1}).Result()
+	if err != nil {
+		return nil, fmt.Errorf("redis error: %v", err)
+	}
+	if len(head) == 0 {
+		return nil, emptyError(queueName)
+	}
+	payload, err := decodeMember(head[0])
+	if err != nil {
+		return nil, fmt.Errorf("%w: item %q in queue '%s'", err, head[0], queueName)
+	}
+	return rpq.resolvePayload(ctx, queueName, payload)
+}
+
+// PeekPriority returns the head of a single priority level without removing it
+func (rpq *RedisPriorityQueue) PeekPriority(ctx context.Context, queueName string, priority int) (interface{}, error) {
+	if err := rpq.upgradeScores(ctx, queueName); err != nil {
+		return nil, err
+	}
+
+	if err := checkPriority(priority, rpq.levels); err != nil {
+		return nil, err
+	}
+
+	lock := rpq.queueLock(queueName)
+	lock.Lock()
+	defer lock.Unlock()
+
+	min, max := levelRange(priority)
+	head, err := rpq.client.ZRangeByScore(ctx, queueName, &redis.ZRangeBy{
+		Min:   min,
+		Max:   max,
+		Count: 1,
+	}).Result()
+	if err != nil {
+		return nil, fmt.Errorf("redis error: %v", err)
+	}
+	if len(head) == 0 {
+		return nil, levelEmptyError(priority, queueName)
+	}
+	payload, err := decodeMember(head[0])
+	if err != nil {
+		return nil, fmt.Errorf("%w: item %q in queue '%s'", err, head[0], queueName)
+	}
+	return rpq.resolvePayload(ctx, queueName, payload)
+}
+
+// DequeueFromPriority removes and returns the head of a single priority level,
+// ignoring items at every other level
+func (rpq *RedisPriorityQueue) DequeueFromPriority(ctx context.Context, queueName string, priority int) (interface{}, error) {
+	if err := rpq.upgradeScores(ctx, queueName); err != nil {
+		return nil, err
+	}
+
+	if err := checkPriority(priority, rpq.levels); err != nil {
+		return nil, err
+	}
+
+	lock := rpq.queueLock(queueName)
+	lock.Lock()
+	defer lock.Unlock()
+
+	if err := rpq.checkWritable(ctx, queueName); err != nil {
+		return nil, err
+	}
+
+	value, err := rpq.popLevel(ctx, queueName, priority)
+	if err == redis.Nil {
+		return nil, levelEmptyError(priority, queueName)
+	}
+	return value, err
+}
+
+// DequeueLevel atomically removes and returns every item of the highest
+// non-empty priority level, in queue order. Items failing their checksum are
+// quarantined; the rest are still returned, together with an error wrapping
+// ErrCorruptPayload.
+func (rpq *RedisPriorityQueue) DequeueLevel(ctx context.Context, queueName string) ([]interface{}, error) {
+	if err := rpq.upgradeScores(ctx, queueName); err != nil {
+		return nil, err
+	}
+
+	lock := rpq.queueLock(queueName)
+	lock.Lock()
+	defer lock.Unlock()
+
+	if err := rpq.checkWritable(ctx, queueName); err != nil {
+		return nil, err
+	}
+
+	members, err := popBandScript.Run(ctx, rpq.client, rpq.popKeys(queueName), seqSpace, rpq.servedMax()).StringSlice()
+	if err != nil && err != redis.Nil {
+		return nil, fmt.Errorf("redis error: %v", err)
+	}
+	if len(members) == 0 {
+		return nil, emptyError(queueName)
+	}
+
+	values := make([]interface{}, 0, len(members))
+	var corrupt error
+	for _, member := range members {
+		value, err := rpq.verifyPopped(ctx, queueName, member)
+		if err != nil {
+			if corrupt == nil {
+				corrupt = err
+			}
+			continue
+		}
+		values = append(values, value)
+	}
+	return values, corrupt
+}
+
+// DequeueBatch atomically removes and returns up to n items in strict
+// priority order with a single script. Weights are not applied. Corrupt
+// items are quarantined and the first such error is returned with the rest.
+func (rpq *RedisPriorityQueue) DequeueBatch(ctx context.Context, queueName string, n int) ([]interface{}, error) {
+	if err := rpq.upgradeScores(ctx, queueName); err != nil {
+		return nil, err
+	}
+
+	if n <= 0 {
+		return nil, fmt.Errorf("batch size must be positive")
+	}
+
+	lock := rpq.queueLock(queueName)
+	lock.Lock()
+	defer lock.Unlock()
+
+	if err := rpq.checkWritable(ctx, queueName); err != nil {
+		return nil, err
+	}
+
+	members, err := popManyScript.Run(ctx, rpq.client, rpq.popKeys(queueName), n, rpq.servedMax()).StringSlice()
+	if err != nil && err != redis.Nil {
+		return nil, fmt.Errorf("redis error: %v", err)
+	}
+	if len(members) == 0 {
+		return nil, emptyError(queueName)
+	}
+
+	values := make([]interface{}, 0, len(members))
+	var corrupt error
+	for _, member := range members {
+		value, err := rpq.verifyPopped(ctx, queueName, member)
+		if err != nil {
+			if corrupt == nil {
+				corrupt = err
+			}
+			continue
+		}
+		values = append(values, value)
+	}
+	return values, corrupt
+}
+
+// DequeueWhere removes and returns the highest-priority item for which pred
+// returns true, leaving non-matching items in place. The predicate is Go code,
+// so the queue is scanned client-side in pages of scanBatch items.
+func (rpq *RedisPriorityQueue) DequeueWhere(ctx context.Context, queueName string, pred func(Item) bool) (interface{}, error) {
+	return rpq.dequeueScan(ctx, queueName, func(item Item) scanAction {
+		if pred(item) {
+			return scanTake
+		}
+		return scanKeep
+	})
+}
+
+// DequeueFresh removes and returns the highest-priority item queued no longer
+// than maxAge ago. Older items are skipped, or deleted if expire is set;
+// deleted items don't count as dequeued. Items with no enqueue time in the
+// index have an unknown age and are always skipped, never expired. The
+// queue is scanned like DequeueWhere.
+func (rpq *RedisPriorityQueue) DequeueFresh(ctx context.Context, queueName string, maxAge time.Duration, expire bool) (interface{}, error) {
+	now := rpq.clock.Now()
+	return rpq.dequeueScan(ctx, queueName, func(item Item) scanAction {
+		if item.EnqueuedAt.IsZero() {
+			return scanKeep
+		}
+		return freshAction(now.Sub(item.EnqueuedAt), maxAge, expire)
+	})
+}
+
+// dequeueScan walks the queue in pages, dropping items as decide says, until
+// it finds one to take
+func (rpq *RedisPriorityQueue) dequeueScan(ctx context.Context, queueName string, decide func(Item) scanAction) (interface{}, error) {
+	if err := rpq.upgradeScores(ctx, queueName); err != nil {
+		return nil, err
+	}
+
+	lock := rpq.queueLock(queueName)
+	lock.Lock()
+	defer lock.Unlock()
+
+	if err := rpq.checkWritable(ctx, queueName); err != nil {
+		return nil, err
+	}
+
+	for start := int64(0); ; start += scanBatch {
+		members, err := rpq.client.ZRangeWithScores(ctx, queueName, start, start+scanBatch-1).Result()
+		if err != nil {
+			return nil, fmt.Errorf("redis error: %v", err)
+		}
+		if len(members) == 0 {
+			return nil, fmt.Errorf("no matching item in queue '%s'", queueName)
+		}
+
+		names := make([]string, len(members))
+		for i, member := range members {
+			names[i] = member.Member.(string)
+		}
+		times, err := rpq.client.ZMScore(ctx, enqueuedKey(queueName), names...).Result()
+		if err != nil {
+			return nil, fmt.Errorf("redis error: %v", err)
+		}
+
+		for i, member := range members {
+			// Corrupt items are left for Dequeue to quarantine
+			payload, id, err := decodeMemberID(names[i])
+			if err != nil {
+				continue
+			}
+			value, err := rpq.resolvePayload(ctx, queueName, payload)
+			if err != nil {
+				continue
+			}
+			item := Item{
+				ID:       id,
+				Value:    value,
+				Priority: priorityOf(member.Score),
+			}
+			// Members missing from the index keep a zero, unknown, time
+			if times[i] != 0 {
+				item.EnqueuedAt = time.UnixMicro(int64(times[i]))
+			}
+			action := decide(item)
+			if action == scanKeep {
+				continue
+			}
+			removed, err := rpq.removeMembers(ctx, queueName, names[i])
+			if err != nil {
+				return nil, err
+			}
+			// Later items shift down to fill the gap
+			start -= removed
+			// Skip items another client dequeued after we read the page
+			if removed == 1 && action == scanTake {
+				if err := rpq.client.HIncrBy(ctx, countersKey(queueName), "dequeued", 1).Err(); err != nil {
+					return nil, fmt.Errorf("redis error: %v", err)
+				}
+				noteRemoved(ctx, id)
+				return value, nil
+			}
+		}
+		if len(members) < scanBatch {
+			return nil, fmt.Errorf("no matching item in queue '%s'", queueName)
+		}
+	}
+}
+
+// SetPriorityWeights switches the queue to weighted-random dequeue, where each
+// non-empty priority level is chosen with probability proportional to its
+// weight. Passing nil restores strict priority order. The setting is held by
+// this client and does not affect other processes using the same Redis key.
+func (rpq *RedisPriorityQueue) SetPriorityWeights(ctx context.Context, queueName string, weights []float64) error {
+	if weights != nil {
+		if err := validateWeights(weights, rpq.levels); err != nil {
+			return err
+		}
+		weights = append([]float64(nil), weights...)
+	}
+
+	rpq.mutex.Lock()
+	defer rpq.mutex.Unlock()
+
+	if weights == nil {
+		delete(rpq.weights, queueName)
+	} else {
+		rpq.weights[queueName] = weights
+	}
+	return nil
+}
+
+// removeMembers deletes members from a queue, its enqueue-time index and its
+// blob hash, returning how many were present in the queue
+func (rpq *RedisPriorityQueue) removeMembers(ctx context.Context, queueName string, members ...string) (int64, error) {
+	names := make([]interface{}, len(members))
+	var ids []string
+	for i, member := range members {
+		names[i] = member
+		if id, ok := blobID(memberPayload(member)); ok {
+			ids = append(ids, id)
+		}
+	}
+	var removed *redis.IntCmd
+	_, err := rpq.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		removed = pipe.ZRem(ctx, queueName, names...)
+		pipe.ZRem(ctx, enqueuedKey(queueName), names...)
+		if len(ids) > 0 {
+			pipe.HDel(ctx, blobsKey(queueName), ids...)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("redis error: %v", err)
+	}
+	return removed.Val(), nil
+}
+
+// CompactQueue resequences the queue's scores, see ResequenceQueue, and drops
+// enqueue-time index entries whose item is no longer queued. The index is
+// pruned by a single script, which blocks the server while it runs.
+func (rpq *RedisPriorityQueue) CompactQueue(ctx context.Context, queueName string) error {
+	lock := rpq.queueLock(queueName)
+	lock.Lock()
+	defer lock.Unlock()
+
+	if err := rpq.checkWritable(ctx, queueName); err != nil {
+		return err
+	}
+	if err := rpq.resequence(ctx, queueName); err != nil {
+		return err
+	}
+	if err := pruneIndexScript.Run(ctx, rpq.client, []string{queueName, enqueuedKey(queueName)}).Err(); err != nil {
+		return fmt.Errorf("redis error: %v", err)
+	}
+	return nil
+}
+
+// OldestItem returns the item that has been queued the longest and its age
+func (rpq *RedisPriorityQueue) OldestItem(ctx context.Context, queueName string) (interface{}, time.Duration, error) {
+	return rpq.itemByAge(ctx, queueName, 0)
+}
+
+// NewestItem returns the most recently queued item and its age
+func (rpq *RedisPriorityQueue) NewestItem(ctx context.Context, queueName string) (interface{}, time.Duration, error) {
+	return rpq.itemByAge(ctx, queueName, -1)
+}
+
+// itemByAge reads a single entry from the enqueue-time index, which is ordered
+// by enqueue time in microseconds
+func (rpq *RedisPriorityQueue) itemByAge(ctx context.Context, queueName string, index int64) (interface{}, time.Duration, error) {
+	lock := rpq.queueLock(queueName)
+	lock.Lock()
+	defer lock.Unlock()
+
+	result, err := rpq.client.ZRangeWithScores(ctx, enqueuedKey(queueName), index, index).Result()
+	if err != nil {
+		return nil, 0, fmt.Errorf("redis error: %v", err)
+	}
+	if len(result) == 0 {
+		return nil, 0, emptyError(queueName)
+	}
+	enqueuedAt := time.UnixMicro(int64(result[0].Score))
+	return rpq.displayValue(ctx, queueName, result[0].Member.(string)), rpq.clock.Now().Sub(enqueuedAt), nil
+}
+
+// Counters returns the total number of items ever enqueued into and dequeued
+// from the queue. The totals are kept in Redis, so they survive restarts of
+// this process and are shared by every client of the queue.
+func (rpq *RedisPriorityQueue) Counters(ctx context.Context, queueName string) (QueueCounters, error) {
+	lock := rpq.queueLock(queueName)
+	lock.Lock()
+	defer lock.Unlock()
+
+	return rpq.readCounters(ctx, queueName)
+}
+
+// readCounters implements Counters. The caller must hold the queue's lock.
+func (rpq *RedisPriorityQueue) readCounters(ctx context.Context, queueName string) (QueueCounters, error) {
+	values, err := rpq.client.HMGet(ctx, countersKey(queueName), "enqueued", "dequeued").Result()
+	if err != nil {
+		return QueueCounters{}, fmt.Errorf("redis error: %v", err)
+	}
+	var counters QueueCounters
+	for i, field := range []*int64{&counters.Enqueued, &counters.Dequeued} {
+		if values[i] == nil {
+			continue
+		}
+		n, err := strconv.ParseInt(values[i].(string), 10, 64)
+		if err != nil {
+			return QueueCounters{}, fmt.Errorf("invalid counter in queue '%s': %v", queueName, err)
+		}
+		*field = n
+	}
+	return counters, nil
+}
+
+// MemoryReport attributes Redis memory to a queue
+type MemoryReport struct {
+	Items int64            // members in the queue
+	Bytes int64            // total over Keys
+	Keys  map[string]int64 // MEMORY USAGE of each key backing the queue
+}
+
+// MemoryUsage reports how much Redis memory a queue occupies, using MEMORY
+// USAGE with the given number of samples per nested value (0 lets Redis pick
+// its default, which estimates large ZSETs from a handful of members)
+func (rpq *RedisPriorityQueue) MemoryUsage(ctx context.Context, queueName string, samples int) (MemoryReport, error) {
+	keys := queueKeys(queueName)
+
+	pipe := rpq.client.Pipeline()
+	card := pipe.ZCard(ctx, queueName)
+	usages := make([]*redis.IntCmd, len(keys))
+	for i, key := range keys {
+		if samples > 0 {
+			usages[i] = pipe.MemoryUsage(ctx, key, samples)
+		} else {
+			usages[i] = pipe.MemoryUsage(ctx, key)
+		}
+	}
+	// Missing keys answer with a nil reply, which only means zero bytes
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return MemoryReport{}, fmt.Errorf("redis error: %v", err)
+	}
+
+	report := MemoryReport{Items: card.Val(), Keys: make(map[string]int64)}
+	for i, key := range keys {
+		n, err := usages[i].Result()
+		if err == redis.Nil {
+			continue
+		}
+		if err != nil {
+			return MemoryReport{}, fmt.Errorf("redis error: %v", err)
+		}
+		report.Keys[key] = n
+		report.Bytes += n
+	}
+	return report, nil
+}
+
+// queueKeys lists every Redis key backing a queue
+func queueKeys(queueName string) []string {
+	return []string{queueName, enqueuedKey(queueName), countersKey(queueName), quarantineKey(queueName), blobsKey(queueName), seqKey(queueName)}
+}
+
+// keyPrefix starts every key the package keeps besides the queues
+// themselves, so helper keys can't collide with a queue. Queue names may not
+// start with it.
+const keyPrefix = "pq:"
+
+// registryKey names the set of every queue added or enqueued to, so queues
+// can be listed without scanning the keyspace
+const registryKey = keyPrefix + "queues"
+
+// checkQueueName rejects queue names inside the reserved key namespace
+func checkQueueName(queueName string) error {
+	if strings.HasPrefix(queueName, keyPrefix) {
+		return fmt.Errorf("queue name '%s' is reserved: names starting with '%s' hold internal keys", queueName, keyPrefix)
+	}
+	return nil
+}
+
+// helperKey names one of a queue's auxiliary keys, e.g. pq:{jobs}:counters
+func helperKey(queueName, suffix string) string {
+	return keyPrefix + "{" + queueName + "}:" + suffix
+}
+
+// quarantineKey names the list holding a queue's corrupt items
+func quarantineKey(queueName string) string {
+	return helperKey(queueName, "quarantine")
+}
+
+// countersKey names the hash holding a queue's enqueue and dequeue totals
+func countersKey(queueName string) string {
+	return helperKey(queueName, "counters")
+}
+
+// enqueuedKey names the ZSET that indexes a queue's members by enqueue time
+func enqueuedKey(queueName string) string {
+	return helperKey(queueName, "enqueued")
+}
+
+// servedMax is the exclusive upper score bound of the levels this client
+// serves, so items a client with more levels enqueued aren't dequeued here
+func (rpq *RedisPriorityQueue) servedMax() string {
+	_, max := levelRange(rpq.levels - 1)
+	return max
+}
+
+// popKeys lists the keys touched by the pop scripts: the queue, its
+// enqueue-time index and its counters hash
+func (rpq *RedisPriorityQueue) popKeys(queueName string) []string {
+	return []string{queueName, enqueuedKey(queueName), countersKey(queueName)}
+}
+
+// reprioritizeScript moves member ARGV[1] of queue KEYS[1] to the tail of
+// priority ARGV[2], taking a sequence number from KEYS[2]. ARGV[3] and
+// ARGV[4] are seqSpace and seqBase. Returns false if the member is missing.
+var reprioritizeScript = redis.NewScript(`
+local current = redis.call('ZSCORE', KEYS[1], ARGV[1])
+if not current then
+	return false
+end
+current = tonumber(current)
+local priority, space, base = tonumber(ARGV[2]), tonumber(ARGV[3]), tonumber(ARGV[4])
+local level
+if current < space then
+	level = math.floor(current + 0.5)
+else
+	level = math.floor(current / space) - 1
+end
+if level == priority then
+	return 1
+end
+if tonumber(redis.call('GET', KEYS[2]) or '0') + 1 >= base then
+	return redis.error_reply('sequence exhausted in ' .. KEYS[1])
+end
+local seq = redis.call('INCR', KEYS[2])
+redis.call('ZADD', KEYS[1], 'XX', string.format('%.0f', (priority + 1) * space + base + seq), ARGV[1])
+return 1
+`)
+
+// renameScript renames the queue keys KEYS[1..6] to KEYS[7..12] and moves
+// the queue from ARGV[1] to ARGV[2] in the registry KEYS[13]. Returns false
+// if the old queue doesn't exist and an error if the new one does.
+var renameScript = redis.NewScript(`
+if redis.call('SISMEMBER', KEYS[13], ARGV[2]) == 1 or redis.call('EXISTS', unpack(KEYS, 7, 12)) > 0 then
+	return redis.error_reply('queue already exists')
+end
+if redis.call('SISMEMBER', KEYS[13], ARGV[1]) == 0 and redis.call('EXISTS', unpack(KEYS, 1, 6)) == 0 then
+	return false
+end
+for i = 1, 6 do
+	if redis.call('EXISTS', KEYS[i]) == 1 then
+		redis.call('RENAME', KEYS[i], KEYS[i + 6])
+	end
+end
+redis.call('SREM', KEYS[13], ARGV[1])
+redis.call('SADD', KEYS[13], ARGV[2])
+return 1
+`)
+
+// moveScript moves member ARGV[1] from the queue in KEYS[1..4] (queue,
+// index, counters, blobs) to the tail of the same level of the queue in
+// KEYS[5..9] (the same plus its sequence counter), registering it in
+// KEYS[10]. ARGV[2] is the member's blob id or empty, ARGV[3] and ARGV[4] are
+// seqSpace and seqBase. Returns false if the member is missing.
+var moveScript = redis.NewScript(`
+local current = redis.call('ZSCORE', KEYS[1], ARGV[1])
+if not current then
+	return false
+end
+current = tonumber(current)
+local space, base = tonumber(ARGV[3]), tonumber(ARGV[4])
+local priority
+if current < space then
+	priority = math.floor(current + 0.5)
+else
+	priority = math.floor(current / space) - 1
+end
+if tonumber(redis.call('GET', KEYS[9]) or '0') + 1 >= base then
+	return redis.error_reply('sequence exhausted in ' .. KEYS[5])
+end
+local enqueued = redis.call('ZSCORE', KEYS[2], ARGV[1])
+redis.call('ZREM', KEYS[1], ARGV[1])
+redis.call('ZREM', KEYS[2], ARGV[1])
+redis.call('HINCRBY', KEYS[3], 'dequeued', 1)
+if ARGV[2] ~= '' then
+	local body = redis.call('HGET', KEYS[4], ARGV[2])
+	if body then
+		redis.call('HSET', KEYS[8], ARGV[2], body)
+		redis.call('HDEL', KEYS[4], ARGV[2])
+	end
+end
+local seq = redis.call('INCR', KEYS[9])
+redis.call('ZADD', KEYS[5], string.format('%.0f', (priority + 1) * space + base + seq), ARGV[1])
+if enqueued then
+	redis.call('ZADD', KEYS[6], enqueued, ARGV[1])
+end
+redis.call('HINCRBY', KEYS[7], 'enqueued', 1)
+redis.call('SADD', KEYS[10], KEYS[5])
+return 1
+`)
+
+// popManyScript pops up to ARGV[1] members with the lowest scores up to
+// ARGV[2], drops them from the enqueue-time index and counts the dequeues
+var popManyScript = redis.NewScript(`
+local members = redis.call('ZRANGEBYSCORE', KEYS[1], '-inf', ARGV[2], 'LIMIT', 0, ARGV[1])
+if #members == 0 then
+	return {}
+end
+for _, member in ipairs(members) do
+	redis.call('ZREM', KEYS[1], member)
+	redis.call('ZREM', KEYS[2], member)
+end
+redis.call('HINCRBY', KEYS[3], 'dequeued', #members)
+return members
+`)
+
+// popLevelScript pops the first member scored within [ARGV[1], ARGV[2]],
+// drops it from the enqueue-time index and counts the dequeue
+var popLevelScript = redis.NewScript(`
+local head = redis.call('ZRANGEBYSCORE', KEYS[1], ARGV[1], ARGV[2], 'LIMIT', 0, 1)
+if #head == 0 then
+	return false
+end
+redis.call('ZREM', KEYS[1], head[1])
+redis.call('ZREM', KEYS[2], head[1])
+redis.call('HINCRBY', KEYS[3], 'dequeued', 1)
+return head[1]
+`)
+
+// pruneIndexScript removes members of the enqueue-time index KEYS[2] that
+// are missing from the queue KEYS[1]
+var pruneIndexScript = redis.NewScript(`
+local removed = 0
+for _, member in ipairs(redis.call('ZRANGE', KEYS[2], 0, -1)) do
+	if not redis.call('ZSCORE', KEYS[1], member) then
+		redis.call('ZREM', KEYS[2], member)
+		removed = removed + 1
+	end
+end
+return removed
+`)
+
+// popBandScript removes every member of the level holding the lowest score
+// up to ARGV[2]. ARGV[1] is seqSpace, the width of a level.
+var popBandScript = redis.NewScript(`
+local head = redis.call('ZRANGEBYSCORE', KEYS[1], '-inf', ARGV[2], 'WITHSCORES', 'LIMIT', 0, 1)
+if #head == 0 then
+	return {}
+end
+local space = tonumber(ARGV[1])
+local band = math.floor(tonumber(head[2]) / space)
+local members = redis.call('ZRANGEBYSCORE', KEYS[1],
+	string.format('%.0f', band * space), '(' .. string.format('%.0f', (band + 1) * space))
+for _, member in ipairs(members) do
+	redis.call('ZREM', KEYS[1], member)
+	redis.call('ZREM', KEYS[2], member)
+end
+redis.call('HINCRBY', KEYS[3], 'dequeued', #members)
+return members
+`)
+
+// scanBatch is the page size used when walking a queue member by member
+const scanBatch = 100
@@ -0,0 +1,73 @@
+package priorityqueue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// ErrReadOnly is returned by calls that would change a queue in read-only mode
+var ErrReadOnly = errors.New("read-only mode")
+
+// readOnlyKey names the set of read-only queue names. globalReadOnly in the
+// set makes every queue read-only.
+const (
+	readOnlyKey    = keyPrefix + "readonly"
+	globalReadOnly = "*"
+)
+
+// SetReadOnly switches a queue's read-only mode. While it is on, every call
+// that adds, removes or reorders items fails with ErrReadOnly, for every
+// client of the Redis server, until it is switched off. ClearQueues is not
+// affected. A call already past its check when the mode is switched on may
+// still complete.
+func (rpq *RedisPriorityQueue) SetReadOnly(ctx context.Context, queueName string, on bool) error {
+	var err error
+	if on {
+		err = rpq.client.SAdd(ctx, readOnlyKey, queueName).Err()
+	} else {
+		err = rpq.client.SRem(ctx, readOnlyKey, queueName).Err()
+	}
+	if err != nil {
+		return fmt.Errorf("redis error: %v", err)
+	}
+	return nil
+}
+
+// SetGlobalReadOnly switches read-only mode for every queue on the server,
+// independently of the per-queue switches
+func (rpq *RedisPriorityQueue) SetGlobalReadOnly(ctx context.Context, on bool) error {
+	return rpq.SetReadOnly(ctx, globalReadOnly, on)
+}
+
+// ReadOnly reports whether a queue is read-only, either by itself or through
+// the global switch
+func (rpq *RedisPriorityQueue) ReadOnly(ctx context.Context, queueName string) (bool, error) {
+	flags, err := rpq.client.SMIsMember(ctx, readOnlyKey, globalReadOnly, queueName).Result()
+	if err != nil {
+		return false, fmt.Errorf("redis error: %v", err)
+	}
+	return flags[0] || flags[1], nil
+}
+
+// checkReadOnly fails if any of the queues is read-only
+func (rpq *RedisPriorityQueue) checkReadOnly(ctx context.Context, queueNames ...string) error {
+	members := make([]interface{}, 0, len(queueNames)+1)
+	members = append(members, globalReadOnly)
+	for _, queueName := range queueNames {
+		members = append(members, queueName)
+	}
+	flags, err := rpq.client.SMIsMember(ctx, readOnlyKey, members...).Result()
+	if err != nil {
+		return fmt.Errorf("redis error: %v", err)
+	}
+	if flags[0] {
+		return fmt.Errorf("%w: all queues", ErrReadOnly)
+	}
+	for i, queueName := range queueNames {
+		if flags[i+1] {
+			return fmt.Errorf("%w: queue '%s'", ErrReadOnly, queueName)
+		}
+	}
+	return nil
+}
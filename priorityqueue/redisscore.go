@@ -0,0 +1,265 @@
+package priorityqueue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Redis scores pack a priority and a sequence number into a float64:
+//
+//	score = (priority+1)*seqSpace + seq, 0 <= seq < seqSpace
+//
+// Every score stays below 2^53, so it is an exact integer. Enqueue appends at
+// seqBase + n, where n comes from the queue's INCR counter, while top inserts
+// and reorders count down from seqBase. Each level therefore has seqBase
+// slots on either side. Running out of slots is an error, and ResequenceQueue
+// recovers the space. Scores below seqSpace are from the old scheme, which
+// scored items at their bare priority. They would sort ahead of every level
+// and fall outside levelRange, so each client converts a queue's old scores
+// with ResequenceQueue the first time it uses the queue.
+const (
+	seqSpace = int64(1) << 48
+	seqBase  = seqSpace / 2
+)
+
+// MaxRedisLevels is the most priority levels a RedisPriorityQueue can have
+// while every score stays below 2^53
+const MaxRedisLevels = int(int64(1)<<53/seqSpace) - 1
+
+// ErrSequenceExhausted is returned when a priority level has no sequence
+// numbers left on the side an item is being added to
+var ErrSequenceExhausted = errors.New("sequence space exhausted")
+
+// levelBase returns the score dividing top inserts from appends in a level
+func levelBase(priority int) float64 {
+	return float64(int64(priority+1)*seqSpace + seqBase)
+}
+
+// priorityOf recovers the priority of a score
+func priorityOf(score float64) int {
+	if score < float64(seqSpace) {
+		return int(score + 0.5) // Old scheme, see ResequenceQueue
+	}
+	return int(int64(score)/seqSpace) - 1
+}
+
+// levelRange returns the ZSET score bounds covering a single priority level
+func levelRange(priority int) (string, string) {
+	return strconv.FormatInt(int64(priority+1)*seqSpace, 10), "(" + strconv.FormatInt(int64(priority+2)*seqSpace, 10)
+}
+
+// seqKey names the counter handing out a queue's append sequence numbers
+func seqKey(queueName string) string {
+	return helperKey(queueName, "seq")
+}
+
+// enqueueScript appends one member per entry. KEYS holds six keys per
+// entry: the queue, its enqueue-time index, counters, sequence counter, blob
+// hash and the queue registry. ARGV starts with seqSpace, seqBase and the enqueue time, then
+// holds four values per entry: priority, member, blob id and blob body. Every
+// queue is checked before any is written, so an exhausted queue fails the
+// whole call.
+var enqueueScript = redis.NewScript(`
+local space, base, now = tonumber(ARGV[1]), tonumber(ARGV[2]), ARGV[3]
+local pending = {}
+for i = 1, #KEYS, 6 do
+	local seq = KEYS[i+3]
+	pending[seq] = (pending[seq] or tonumber(redis.call('GET', seq) or '0')) + 1
+	if pending[seq] >= base then
+		return redis.error_reply('sequence exhausted in ' .. KEYS[i])
+	end
+end
+for i = 1, #KEYS, 6 do
+	local a = 4 + (i - 1) / 6 * 4
+	local priority, member, id = tonumber(ARGV[a]), ARGV[a+1], ARGV[a+2]
+	local seq = redis.call('INCR', KEYS[i+3])
+	redis.call('ZADD', KEYS[i], string.format('%.0f', (priority + 1) * space + base + seq), member)
+	redis.call('ZADD', KEYS[i+1], now, member)
+	redis.call('HINCRBY', KEYS[i+2], 'enqueued', 1)
+	if id ~= '' then
+		redis.call('HSET', KEYS[i+4], id, ARGV[a+3])
+	end
+	redis.call('SADD', KEYS[i+5], KEYS[i])
+end
+return #KEYS / 6
+`)
+
+// appendMember atomically appends a stored member to the tail of a priority
+// level in each of the queues
+func (rpq *RedisPriorityQueue) appendMember(ctx context.Context, queueNames []string, priority int, member, id, body string) error {
+	entries := make([]storedEntry, len(queueNames))
+	for i, queueName := range queueNames {
+		entries[i] = storedEntry{queueName, priority, member, id, body}
+	}
+	return rpq.appendEntries(ctx, entries)
+}
+
+// storedEntry is a QueueEntry with its value already in stored form
+type storedEntry struct {
+	queueName string
+	priority  int
+	member    string
+	id        string
+	body      string
+}
+
+// appendEntries atomically appends every entry to the tail of its level
+func (rpq *RedisPriorityQueue) appendEntries(ctx context.Context, entries []storedEntry) error {
+	keys := make([]string, 0, len(entries)*6)
+	args := make([]interface{}, 0, 3+len(entries)*4)
+	args = append(args, seqSpace, seqBase, rpq.clock.Now().UnixMicro())
+	for _, e := range entries {
+		if err := checkQueueName(e.queueName); err != nil {
+			return err
+		}
+		keys = append(keys, e.queueName, enqueuedKey(e.queueName), countersKey(e.queueName), seqKey(e.queueName), blobsKey(e.queueName), registryKey)
+		args = append(args, e.priority, e.member, e.id, e.body)
+	}
+	err := enqueueScript.Run(ctx, rpq.client, keys, args...).Err()
+	if err != nil && strings.Contains(err.Error(), "sequence exhausted") {
+		return fmt.Errorf("%w: %v", ErrSequenceExhausted, err)
+	}
+	if err != nil {
+		return fmt.Errorf("redis error: %v", err)
+	}
+	return nil
+}
+
+// upgradeScores converts old-scheme scores in each queue the first time this
+// client uses it; later calls cost a map lookup. A queue this client may not
+// write is left as it is and checked again on its next use. The conversion
+// is a WATCH/MULTI transaction, so the queue's lock need not be held.
+func (rpq *RedisPriorityQueue) upgradeScores(ctx context.Context, queueNames ...string) error {
+	for _, queueName := range queueNames {
+		rpq.mutex.Lock()
+		done := rpq.upgraded[queueName]
+		rpq.mutex.Unlock()
+		if done {
+			continue
+		}
+
+		legacy, err := rpq.client.ZCount(ctx, queueName, "-inf", "("+strconv.FormatInt(seqSpace, 10)).Result()
+		if err != nil {
+			return fmt.Errorf("redis error: %v", err)
+		}
+		if legacy > 0 {
+			if rpq.checkWritable(ctx, queueName) != nil {
+				continue
+			}
+			if err := rpq.resequence(ctx, queueName); err != nil {
+				return err
+			}
+		}
+
+		rpq.mutex.Lock()
+		rpq.upgraded[queueName] = true
+		rpq.mutex.Unlock()
+	}
+	return nil
+}
+
+// ResequenceQueue rewrites every score of a queue compactly without changing
+// its order, giving each level its full sequence space back. It also converts
+// scores written before sequence numbers were introduced. The queue is read a
+// page at a time into a temporary copy, which replaces it only if nothing
+// wrote to the queue meanwhile, so it is best run while traffic is low.
+func (rpq *RedisPriorityQueue) ResequenceQueue(ctx context.Context, queueName string) error {
+	lock := rpq.queueLock(queueName)
+	lock.Lock()
+	defer lock.Unlock()
+
+	if err := rpq.checkWritable(ctx, queueName); err != nil {
+		return err
+	}
+	return rpq.resequence(ctx, queueName)
+}
+
+// resequence implements ResequenceQueue. WATCH keeps the rewrite consistent
+// on its own; holding the queue's lock only spares this client's writers
+// from retrying it. The queue is walked twice, to size each level and then
+// to score its members, holding one page and a count per level in memory.
+func (rpq *RedisPriorityQueue) resequence(ctx context.Context, queueName string) error {
+	tmp := helperKey(queueName, "resequence")
+	defer rpq.client.Del(ctx, tmp)
+
+	txf := func(tx *redis.Tx) error {
+		if err := tx.Del(ctx, tmp).Err(); err != nil {
+			return fmt.Errorf("redis error: %v", err)
+		}
+		sizes := make(map[int]int)
+		err := rpq.scanScores(ctx, queueName, func(page []redis.Z) error {
+			for _, z := range page {
+				sizes[priorityOf(z.Score)]++
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for priority, size := range sizes {
+			if int64(size) > seqBase {
+				return fmt.Errorf("%w: priority %d has too many items to reorder", ErrSequenceExhausted, priority)
+			}
+		}
+
+		placed := make(map[int]int)
+		err = rpq.scanScores(ctx, queueName, func(page []redis.Z) error {
+			zs := make([]redis.Z, len(page))
+			for i, z := range page {
+				priority := priorityOf(z.Score)
+				zs[i] = redis.Z{
+					Score:  levelBase(priority) - float64(sizes[priority]-placed[priority]),
+					Member: z.Member,
+				}
+				placed[priority]++
+			}
+			if err := tx.ZAdd(ctx, tmp, zs...).Err(); err != nil {
+				return fmt.Errorf("redis error: %v", err)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			if len(sizes) > 0 {
+				pipe.Rename(ctx, tmp, queueName)
+			}
+			pipe.Del(ctx, seqKey(queueName))
+			return nil
+		})
+		return err
+	}
+
+	for {
+		err := rpq.client.Watch(ctx, txf, queueName, seqKey(queueName))
+		if err != redis.TxFailedErr {
+			return err
+		}
+	}
+}
+
+// scanScores calls fn with each page of a queue's members and scores, in
+// score order
+func (rpq *RedisPriorityQueue) scanScores(ctx context.Context, queueName string, fn func(page []redis.Z) error) error {
+	for start := int64(0); ; start += scanBatch {
+		page, err := rpq.client.ZRangeWithScores(ctx, queueName, start, start+scanBatch-1).Result()
+		if err != nil {
+			return fmt.Errorf("redis error: %v", err)
+		}
+		if len(page) > 0 {
+			if err := fn(page); err != nil {
+				return err
+			}
+		}
+		if len(page) < scanBatch {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package priorityqueue
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// quarantineTTL bounds how long quarantined items are kept after the last one
+// was added, so a queue that keeps producing corrupt items can't grow its
+// quarantine list forever
+const quarantineTTL = 30 * 24 * time.Hour
+
+// orphanKeys name the auxiliary keys that only make sense while their
+// queue holds items. An empty queue may restart its sequence from zero.
+// Counters and quarantine lists outlive the queue on purpose.
+var orphanKeys = []func(queueName string) string{enqueuedKey, blobsKey, seqKey}
+
+// deleteOrphanScript deletes KEYS[2:] if the queue KEYS[1] doesn't exist. The
+// check and delete are atomic, so an enqueue recreating them can't be lost.
+var deleteOrphanScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 0 then
+	return redis.call('DEL', unpack(KEYS, 2))
+end
+return 0
+`)
+
+// SweepOrphans deletes auxiliary keys left behind by queues that no longer
+// hold items, such as an enqueue-time index whose queue was deleted by hand
+// or by a crashed client, and returns how many keys were removed. Only the
+// queues in the registry are checked, so keys of other applications sharing
+// the server that merely look like a queue's are never touched. The registry
+// is walked with SSCAN, so it is safe to run against a live server.
+func (rpq *RedisPriorityQueue) SweepOrphans(ctx context.Context) (int, error) {
+	removed := 0
+	iter := rpq.client.SScan(ctx, registryKey, 0, "", scanBatch).Iterator()
+	for iter.Next(ctx) {
+		queueName := iter.Val()
+		keys := []string{queueName}
+		for _, key := range orphanKeys {
+			keys = append(keys, key(queueName))
+		}
+		n, err := deleteOrphanScript.Run(ctx, rpq.client, keys).Int()
+		if err != nil {
+			return removed, fmt.Errorf("redis error: %v", err)
+		}
+		removed += n
+	}
+	if err := iter.Err(); err != nil {
+		return removed, fmt.Errorf("redis error: %v", err)
+	}
+	return removed, nil
+}
+
+// StartOrphanSweeper runs SweepOrphans every interval until the returned
+// function is called or ctx is done. Sweep errors are retried on the next tick.
+func (rpq *RedisPriorityQueue) StartOrphanSweeper(ctx context.Context, interval time.Duration) (stop func()) {
+	ticker := time.NewTicker(interval)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-ticker.C:
+				rpq.SweepOrphans(ctx)
+			case <-ctx.Done():
+				ticker.Stop()
+				return
+			case <-done:
+				ticker.Stop()
+				return
+			}
+		}
+	}()
+	return func() { close(done) }
+}
@@ -0,0 +1,283 @@
+package priorityqueue
+
+import (
+	"context"
+	"fmt"
+	"math"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// VerifyQueue checks a queue's keys against each other:
+//
+//   - every member passes its checksum and any blob it refers to exists
+//   - every score is an integer inside a priority level, in the current scheme
+//   - the sequence counter is ahead of every appended item
+//   - the enqueue-time index holds exactly the queued members
+//   - every blob is referenced by a member
+//   - the counters account for every queued item
+//
+// The queue, its index and its blobs are read a page at a time, holding only
+// the ids of referenced blobs in memory.
+//
+// With repair set, corrupt and unreachable members are quarantined, the
+// index, blobs, sequence counter and counters are fixed, and the queue is
+// resequenced if any score needs it. Items are never reordered otherwise.
+// Items above the levels set with SetLevels are reported but left alone,
+// since a client configured with more levels can still serve them.
+func (rpq *RedisPriorityQueue) VerifyQueue(ctx context.Context, queueName string, repair bool) (VerifyReport, error) {
+	lock := rpq.queueLock(queueName)
+	lock.Lock()
+	defer lock.Unlock()
+
+	if repair {
+		if err := rpq.checkWritable(ctx, queueName); err != nil {
+			return VerifyReport{}, err
+		}
+	}
+
+	counters, err := rpq.readCounters(ctx, queueName)
+	if err != nil {
+		return VerifyReport{}, err
+	}
+	seq, err := rpq.client.Get(ctx, seqKey(queueName)).Int64()
+	if err != nil && err != redis.Nil {
+		return VerifyReport{}, fmt.Errorf("redis error: %v", err)
+	}
+
+	var report VerifyReport
+	problem := func(format string, args ...interface{}) {
+		report.Problems = append(report.Problems, fmt.Sprintf(format, args...))
+	}
+
+	var quarantine, unindexed, stale, unreferenced []string
+	var maxSeq, size int64
+	resequence := false
+	referenced := make(map[string]bool)
+	err = rpq.scanScores(ctx, queueName, func(page []redis.Z) error {
+		names := make([]string, len(page))
+		for i, z := range page {
+			names[i] = z.Member.(string)
+		}
+		times, err := rpq.client.ZMScore(ctx, enqueuedKey(queueName), names...).Result()
+		if err != nil {
+			return fmt.Errorf("redis error: %v", err)
+		}
+		blobs, err := rpq.blobsExist(ctx, queueName, names)
+		if err != nil {
+			return err
+		}
+
+		for i, z := range page {
+			member := names[i]
+			size++
+			if times[i] == 0 {
+				problem("item %q is missing from the enqueue-time index", member)
+				unindexed = append(unindexed, member)
+			}
+
+			payload, err := decodeMember(member)
+			if err != nil {
+				problem("item %q fails its checksum", member)
+				quarantine = append(quarantine, member)
+				continue
+			}
+			if id, ok := blobID(payload); ok {
+				referenced[id] = true
+				if !blobs[id] {
+					problem("item %q refers to missing blob %s", member, id)
+					quarantine = append(quarantine, member)
+					continue
+				}
+			}
+
+			switch priority := priorityOf(z.Score); {
+			case z.Score != math.Trunc(z.Score):
+				problem("item %q has fractional score %v", member, z.Score)
+				resequence = true
+			case priority < 0 || priority >= MaxRedisLevels:
+				problem("item %q has score %v outside every priority level", member, z.Score)
+				quarantine = append(quarantine, member)
+			case z.Score < float64(seqSpace):
+				problem("item %q has a score from before sequence numbers", member)
+				resequence = true
+			default:
+				if priority >= rpq.levels {
+					problem("item %q has priority %d, above the %d levels this client serves", member, priority, rpq.levels)
+				}
+				if z.Score > levelBase(priority) {
+					if n := int64(z.Score - levelBase(priority)); n > maxSeq {
+						maxSeq = n
+					}
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return VerifyReport{}, err
+	}
+
+	for start := int64(0); ; start += scanBatch {
+		page, err := rpq.client.ZRange(ctx, enqueuedKey(queueName), start, start+scanBatch-1).Result()
+		if err != nil {
+			return VerifyReport{}, fmt.Errorf("redis error: %v", err)
+		}
+		queued, err := rpq.membersExist(ctx, queueName, page)
+		if err != nil {
+			return VerifyReport{}, err
+		}
+		for i, member := range page {
+			if !queued[i] {
+				problem("index entry %q has no item", member)
+				stale = append(stale, member)
+			}
+		}
+		if len(page) < scanBatch {
+			break
+		}
+	}
+
+	var cursor uint64
+	for {
+		pairs, next, err := rpq.client.HScan(ctx, blobsKey(queueName), cursor, "", scanBatch).Result()
+		if err != nil {
+			return VerifyReport{}, fmt.Errorf("redis error: %v", err)
+		}
+		for i := 0; i < len(pairs); i += 2 {
+			id := pairs[i]
+			if referenced[id] {
+				continue
+			}
+			// The blob may belong to an item enqueued since the queue was read
+			inUse, err := rpq.blobInUse(ctx, queueName, id)
+			if err != nil {
+				return VerifyReport{}, err
+			}
+			if !inUse {
+				problem("blob %s is not referenced by any item", id)
+				unreferenced = append(unreferenced, id)
+			}
+		}
+		if next == 0 {
+			break
+		}
+		cursor = next
+	}
+
+	if maxSeq > seq {
+		problem("sequence counter %d is behind appended item %d", seq, maxSeq)
+	}
+	size -= int64(len(quarantine))
+	counterProblem := countersProblem(counters, size)
+	if counterProblem != "" {
+		report.Problems = append(report.Problems, counterProblem)
+	}
+
+	if !repair || report.OK() {
+		return report, nil
+	}
+
+	now := float64(rpq.clock.Now().UnixMicro())
+	_, err = rpq.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, member := range unindexed {
+			pipe.ZAdd(ctx, enqueuedKey(queueName), redis.Z{Score: now, Member: member})
+		}
+		for _, member := range stale {
+			pipe.ZRem(ctx, enqueuedKey(queueName), member)
+		}
+		for _, id := range unreferenced {
+			pipe.HDel(ctx, blobsKey(queueName), id)
+		}
+		for _, member := range quarantine {
+			pipe.ZRem(ctx, queueName, member)
+			pipe.ZRem(ctx, enqueuedKey(queueName), member)
+			pipe.RPush(ctx, quarantineKey(queueName), member)
+			pipe.Expire(ctx, quarantineKey(queueName), quarantineTTL)
+		}
+		if maxSeq > seq {
+			pipe.Set(ctx, seqKey(queueName), maxSeq, 0)
+		}
+		if counterProblem != "" {
+			pipe.HSet(ctx, countersKey(queueName), "enqueued", counters.Dequeued+size)
+		}
+		return nil
+	})
+	if err != nil {
+		return report, fmt.Errorf("redis error: %v", err)
+	}
+	if resequence {
+		if err := rpq.resequence(ctx, queueName); err != nil {
+			return report, err
+		}
+	}
+	rpq.depths.forget(queueName)
+	report.Repaired = true
+	return report, nil
+}
+
+// blobsExist reports which of the blobs referred to by members exist
+func (rpq *RedisPriorityQueue) blobsExist(ctx context.Context, queueName string, members []string) (map[string]bool, error) {
+	pipe := rpq.client.Pipeline()
+	cmds := make(map[string]*redis.BoolCmd)
+	for _, member := range members {
+		payload, err := decodeMember(member)
+		if err != nil {
+			continue
+		}
+		if id, ok := blobID(payload); ok {
+			cmds[id] = pipe.HExists(ctx, blobsKey(queueName), id)
+		}
+	}
+	if len(cmds) == 0 {
+		return nil, nil
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, fmt.Errorf("redis error: %v", err)
+	}
+	exists := make(map[string]bool, len(cmds))
+	for id, cmd := range cmds {
+		exists[id] = cmd.Val()
+	}
+	return exists, nil
+}
+
+// membersExist reports which of members are in the queue. ZMSCORE can't be
+// used, as an old-scheme score of zero looks like a missing member.
+func (rpq *RedisPriorityQueue) membersExist(ctx context.Context, queueName string, members []string) ([]bool, error) {
+	if len(members) == 0 {
+		return nil, nil
+	}
+	pipe := rpq.client.Pipeline()
+	cmds := make([]*redis.FloatCmd, len(members))
+	for i, member := range members {
+		cmds[i] = pipe.ZScore(ctx, queueName, member)
+	}
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return nil, fmt.Errorf("redis error: %v", err)
+	}
+	exists := make([]bool, len(members))
+	for i, cmd := range cmds {
+		exists[i] = cmd.Err() == nil
+	}
+	return exists, nil
+}
+
+// blobInUse reports whether any member of the queue refers to a blob
+func (rpq *RedisPriorityQueue) blobInUse(ctx context.Context, queueName, id string) (bool, error) {
+	match := "*" + blobRefPrefix + id + "\x00*"
+	var cursor uint64
+	for {
+		pairs, next, err := rpq.client.ZScan(ctx, queueName, cursor, match, scanBatch).Result()
+		if err != nil {
+			return false, fmt.Errorf("redis error: %v", err)
+		}
+		if len(pairs) > 0 {
+			return true, nil
+		}
+		if next == 0 {
+			return false, nil
+		}
+		cursor = next
+	}
+}
@@ -0,0 +1,113 @@
+package priorityqueue
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Router delivers published items to every queue whose binding pattern matches
+// the item's routing key, in the style of an AMQP topic exchange. Routing keys
+// and patterns are dot-separated words; in a pattern "*" matches exactly one
+// word and "#" matches zero or more words.
+type Router struct {
+	pq       PriorityQueuer
+	bindings map[string][]string
+	mutex    sync.Mutex
+}
+
+// NewRouter creates a router that publishes into queues of pq
+func NewRouter(pq PriorityQueuer) *Router {
+	return &Router{
+		pq:       pq,
+		bindings: make(map[string][]string),
+	}
+}
+
+// Bind routes items whose routing key matches pattern to queueName
+func (r *Router) Bind(queueName, pattern string) error {
+	if pattern == "" {
+		return fmt.Errorf("binding pattern must not be empty")
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for _, existing := range r.bindings[queueName] {
+		if existing == pattern {
+			return fmt.Errorf("queue '%s' is already bound to '%s'", queueName, pattern)
+		}
+	}
+	r.bindings[queueName] = append(r.bindings[queueName], pattern)
+	return nil
+}
+
+// Unbind removes a binding previously added with Bind
+func (r *Router) Unbind(queueName, pattern string) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for i, existing := range r.bindings[queueName] {
+		if existing == pattern {
+			r.bindings[queueName] = append(r.bindings[queueName][:i], r.bindings[queueName][i+1:]...)
+			if len(r.bindings[queueName]) == 0 {
+				delete(r.bindings, queueName)
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("queue '%s' is not bound to '%s'", queueName, pattern)
+}
+
+// Publish enqueues value into every queue with a binding that matches
+// routingKey and returns the names of those queues. A queue bound by several
+// matching patterns receives the item once, and all matched queues receive
+// it atomically via EnqueueFanout.
+func (r *Router) Publish(ctx context.Context, routingKey string, value interface{}, priority int) ([]string, error) {
+	r.mutex.Lock()
+	var matched []string
+	for queueName, patterns := range r.bindings {
+		for _, pattern := range patterns {
+			if matchRoutingKey(pattern, routingKey) {
+				matched = append(matched, queueName)
+				break
+			}
+		}
+	}
+	r.mutex.Unlock()
+
+	if len(matched) == 0 {
+		return nil, nil
+	}
+	sort.Strings(matched)
+	if err := r.pq.EnqueueFanout(ctx, matched, value, priority); err != nil {
+		return nil, fmt.Errorf("publishing '%s': %v", routingKey, err)
+	}
+	return matched, nil
+}
+
+// matchRoutingKey reports whether a topic pattern matches a routing key
+func matchRoutingKey(pattern, routingKey string) bool {
+	return matchWords(strings.Split(pattern, "."), strings.Split(routingKey, "."))
+}
+
+func matchWords(pattern, key []string) bool {
+	if len(pattern) == 0 {
+		return len(key) == 0
+	}
+	switch pattern[0] {
+	case "#":
+		for i := 0; i <= len(key); i++ {
+			if matchWords(pattern[1:], key[i:]) {
+				return true
+			}
+		}
+		return false
+	case "*":
+		return len(key) > 0 && matchWords(pattern[1:], key[1:])
+	default:
+		return len(key) > 0 && pattern[0] == key[0] && matchWords(pattern[1:], key[1:])
+	}
+}
@@ -0,0 +1,137 @@
+package priorityqueue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ServiceWindow is a daily period during which a queue may be consumed.
+// Start and End are offsets from midnight in Location (UTC if nil). A window
+// whose End is before its Start runs past midnight.
+type ServiceWindow struct {
+	Start    time.Duration
+	End      time.Duration
+	Location *time.Location
+}
+
+// contains reports whether t falls inside the window
+func (w ServiceWindow) contains(t time.Time) bool {
+	loc := w.Location
+	if loc == nil {
+		loc = time.UTC
+	}
+	t = t.In(loc)
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, loc)
+	offset := t.Sub(midnight)
+	if w.Start <= w.End {
+		return offset >= w.Start && offset < w.End
+	}
+	return offset >= w.Start || offset < w.End
+}
+
+// ErrOutsideServiceWindow is returned when a queue is dequeued outside all of
+// its service windows
+var ErrOutsideServiceWindow = errors.New("outside service window")
+
+// ServiceWindowQueue wraps a PriorityQueuer and only lets items be removed
+// from a queue during that queue's service windows. Enqueueing is always
+// allowed, so work builds up until the next window opens. Queues with no
+// windows configured are always open.
+type ServiceWindowQueue struct {
+	PriorityQueuer
+	windows map[string][]ServiceWindow
+	clock   Clock
+	mutex   sync.Mutex
+}
+
+// NewServiceWindowQueue wraps pq with no windows configured
+func NewServiceWindowQueue(pq PriorityQueuer) *ServiceWindowQueue {
+	return &ServiceWindowQueue{
+		PriorityQueuer: pq,
+		windows:        make(map[string][]ServiceWindow),
+		clock:          SystemClock{},
+	}
+}
+
+// SetClock replaces the time source used to evaluate windows
+func (sq *ServiceWindowQueue) SetClock(clock Clock) {
+	sq.mutex.Lock()
+	defer sq.mutex.Unlock()
+	sq.clock = clock
+}
+
+// SetServiceWindows replaces a queue's windows. Calling it with no windows
+// leaves the queue always open.
+func (sq *ServiceWindowQueue) SetServiceWindows(queueName string, windows ...ServiceWindow) {
+	sq.mutex.Lock()
+	defer sq.mutex.Unlock()
+
+	if len(windows) == 0 {
+		delete(sq.windows, queueName)
+	} else {
+		sq.windows[queueName] = append([]ServiceWindow(nil), windows...)
+	}
+}
+
+// checkOpen fails if the queue is outside all of its windows
+func (sq *ServiceWindowQueue) checkOpen(queueName string) error {
+	sq.mutex.Lock()
+	windows, ok := sq.windows[queueName]
+	now := sq.clock.Now()
+	sq.mutex.Unlock()
+
+	if !ok {
+		return nil
+	}
+	for _, w := range windows {
+		if w.contains(now) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: queue '%s'", ErrOutsideServiceWindow, queueName)
+}
+
+func (sq *ServiceWindowQueue) Dequeue(ctx context.Context, queueName string) (interface{}, error) {
+	if err := sq.checkOpen(queueName); err != nil {
+		return nil, err
+	}
+	return sq.PriorityQueuer.Dequeue(ctx, queueName)
+}
+
+func (sq *ServiceWindowQueue) DequeueFromPriority(ctx context.Context, queueName string, priority int) (interface{}, error) {
+	if err := sq.checkOpen(queueName); err != nil {
+		return nil, err
+	}
+	return sq.PriorityQueuer.DequeueFromPriority(ctx, queueName, priority)
+}
+
+func (sq *ServiceWindowQueue) DequeueLevel(ctx context.Context, queueName string) ([]interface{}, error) {
+	if err := sq.checkOpen(queueName); err != nil {
+		return nil, err
+	}
+	return sq.PriorityQueuer.DequeueLevel(ctx, queueName)
+}
+
+func (sq *ServiceWindowQueue) DequeueBatch(ctx context.Context, queueName string, n int) ([]interface{}, error) {
+	if err := sq.checkOpen(queueName); err != nil {
+		return nil, err
+	}
+	return sq.PriorityQueuer.DequeueBatch(ctx, queueName, n)
+}
+
+func (sq *ServiceWindowQueue) DequeueWhere(ctx context.Context, queueName string, pred func(Item) bool) (interface{}, error) {
+	if err := sq.checkOpen(queueName); err != nil {
+		return nil, err
+	}
+	return sq.PriorityQueuer.DequeueWhere(ctx, queueName, pred)
+}
+
+func (sq *ServiceWindowQueue) DequeueFresh(ctx context.Context, queueName string, maxAge time.Duration, expire bool) (interface{}, error) {
+	if err := sq.checkOpen(queueName); err != nil {
+		return nil, err
+	}
+	return sq.PriorityQueuer.DequeueFresh(ctx, queueName, maxAge, expire)
+}
@@ -0,0 +1,131 @@
+package priorityqueue
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// TraceEvent is one recorded enqueue, At being its offset from the start of
+// the trace
+type TraceEvent struct {
+	At       time.Duration
+	Priority int
+}
+
+// Simulation replays an enqueue trace against a scheduling policy using a
+// virtual clock, so policies can be compared offline without real queues
+type Simulation struct {
+	Trace       []TraceEvent
+	Policy      SchedulingPolicy // defaults to StrictPolicy
+	Workers     int              // defaults to 1
+	ServiceTime time.Duration    // time each worker spends per item
+	Levels      int              // priority levels, defaults to DefaultLevels
+}
+
+// WaitStats summarizes how long items of one priority waited before being
+// dequeued
+type WaitStats struct {
+	Count int
+	Mean  time.Duration
+	P50   time.Duration
+	P95   time.Duration
+	P99   time.Duration
+	Max   time.Duration
+}
+
+// Run replays the trace until every item has been dequeued and returns wait
+// statistics per priority level
+func (s Simulation) Run() (map[int]WaitStats, error) {
+	policy := s.Policy
+	if policy == nil {
+		policy = StrictPolicy{}
+	}
+	workers := s.Workers
+	if workers <= 0 {
+		workers = 1
+	}
+
+	levelCount := s.Levels
+	if levelCount <= 0 {
+		levelCount = DefaultLevels
+	}
+
+	trace := append([]TraceEvent(nil), s.Trace...)
+	for _, event := range trace {
+		if err := checkPriority(event.Priority, levelCount); err != nil {
+			return nil, fmt.Errorf("trace %v, got %d", err, event.Priority)
+		}
+	}
+	sort.SliceStable(trace, func(i, j int) bool { return trace[i].At < trace[j].At })
+
+	var start time.Time
+	levels := make([][]Item, levelCount)
+	freeAt := make([]time.Duration, workers)
+	waits := make(map[int][]time.Duration)
+	next, queued := 0, 0
+
+	for next < len(trace) || queued > 0 {
+		// The next worker to become free takes the next item
+		w := 0
+		for i := range freeAt {
+			if freeAt[i] < freeAt[w] {
+				w = i
+			}
+		}
+		now := freeAt[w]
+		if queued == 0 && trace[next].At > now {
+			now = trace[next].At
+		}
+		for next < len(trace) && trace[next].At <= now {
+			event := trace[next]
+			levels[event.Priority] = append(levels[event.Priority], Item{
+				Priority:   event.Priority,
+				EnqueuedAt: start.Add(event.At),
+			})
+			next++
+			queued++
+		}
+
+		priority, i := policy.NextCandidate(SchedulingState{Levels: levels, Now: start.Add(now)})
+		if checkPriority(priority, levelCount) != nil || i < 0 || i >= len(levels[priority]) {
+			return nil, fmt.Errorf("policy chose no item with %d queued", queued)
+		}
+		item := levels[priority][i]
+		levels[priority] = append(levels[priority][:i], levels[priority][i+1:]...)
+		queued--
+
+		waits[priority] = append(waits[priority], start.Add(now).Sub(item.EnqueuedAt))
+		freeAt[w] = now + s.ServiceTime
+	}
+
+	stats := make(map[int]WaitStats)
+	for priority, ws := range waits {
+		stats[priority] = summarizeWaits(ws)
+	}
+	return stats, nil
+}
+
+// summarizeWaits computes nearest-rank percentiles over ws
+func summarizeWaits(ws []time.Duration) WaitStats {
+	sort.Slice(ws, func(i, j int) bool { return ws[i] < ws[j] })
+	var total time.Duration
+	for _, w := range ws {
+		total += w
+	}
+	percentile := func(p float64) time.Duration {
+		rank := int(p*float64(len(ws))+0.999999) - 1
+		if rank < 0 {
+			rank = 0
+		}
+		return ws[rank]
+	}
+	return WaitStats{
+		Count: len(ws),
+		Mean:  total / time.Duration(len(ws)),
+		P50:   percentile(0.50),
+		P95:   percentile(0.95),
+		P99:   percentile(0.99),
+		Max:   ws[len(ws)-1],
+	}
+}
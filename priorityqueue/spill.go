@@ -0,0 +1,252 @@
+package priorityqueue
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// SpillRule caps how deep a single priority level of a queue may grow.
+// Enqueues into a full level go to Overflow at the same priority, or, when
+// Overflow is empty, to the next lower priority that has room. The last
+// level never spills.
+type SpillRule struct {
+	MaxDepth int64
+	Overflow string
+}
+
+// SpillingQueue wraps a PriorityQueuer and applies per-queue SpillRules to
+// every call that adds items, so urgent levels stay shallow and their latency
+// predictable. The depth check and the enqueue are separate calls, so
+// concurrent producers may overshoot MaxDepth slightly.
+type SpillingQueue struct {
+	PriorityQueuer
+	rules map[string]SpillRule
+	mutex sync.Mutex
+}
+
+// NewSpillingQueue wraps pq with no rules configured
+func NewSpillingQueue(pq PriorityQueuer) *SpillingQueue {
+	return &SpillingQueue{
+		PriorityQueuer: pq,
+		rules:          make(map[string]SpillRule),
+	}
+}
+
+// SetSpillRule configures a queue's rule. A zero rule removes it.
+func (sq *SpillingQueue) SetSpillRule(queueName string, rule SpillRule) error {
+	if rule != (SpillRule{}) && rule.MaxDepth <= 0 {
+		return fmt.Errorf("spill depth must be positive")
+	}
+	if rule.Overflow == queueName && queueName != "" {
+		return fmt.Errorf("queue '%s' can't overflow into itself", queueName)
+	}
+
+	sq.mutex.Lock()
+	defer sq.mutex.Unlock()
+
+	if rule == (SpillRule{}) {
+		delete(sq.rules, queueName)
+	} else {
+		sq.rules[queueName] = rule
+	}
+	return nil
+}
+
+// levelKey names one priority level of one queue
+type levelKey struct {
+	queueName string
+	priority  int
+}
+
+// route returns where each entry lands under its queue's rule. Each entry
+// counts toward the depth of the level it lands in, so a batch spills like
+// the same items enqueued one at a time.
+func (sq *SpillingQueue) route(ctx context.Context, entries []QueueEntry) ([]QueueEntry, error) {
+	levels := sq.PriorityQueuer.Levels()
+
+	sq.mutex.Lock()
+	rules := make(map[string]SpillRule)
+	for _, entry := range entries {
+		if rule, ok := sq.rules[entry.QueueName]; ok {
+			rules[entry.QueueName] = rule
+		}
+	}
+	sq.mutex.Unlock()
+
+	depths := make(map[levelKey]int64)
+	routed := make([]QueueEntry, len(entries))
+	for i, entry := range entries {
+		if err := checkPriority(entry.Priority, levels); err != nil {
+			return nil, err
+		}
+		routed[i] = entry
+		rule, ok := rules[entry.QueueName]
+		if !ok {
+			continue
+		}
+
+		for ; routed[i].Priority < levels-1; routed[i].Priority++ {
+			key := levelKey{entry.QueueName, routed[i].Priority}
+			depth, seen := depths[key]
+			if !seen {
+				n, err := sq.PriorityQueuer.LevelLen(ctx, key.queueName, key.priority)
+				if err != nil {
+					return nil, err
+				}
+				depth = n
+				depths[key] = depth
+			}
+			if depth < rule.MaxDepth {
+				break
+			}
+			if rule.Overflow != "" {
+				routed[i].QueueName = rule.Overflow
+				break
+			}
+		}
+		if routed[i].QueueName == entry.QueueName {
+			depths[levelKey{entry.QueueName, routed[i].Priority}]++
+		}
+	}
+	return routed, nil
+}
+
+// routeOne routes a single value
+func (sq *SpillingQueue) routeOne(ctx context.Context, queueName string, value interface{}, priority int) (QueueEntry, error) {
+	routed, err := sq.route(ctx, []QueueEntry{{QueueName: queueName, Value: value, Priority: priority}})
+	if err != nil {
+		return QueueEntry{}, err
+	}
+	return routed[0], nil
+}
+
+// Enqueue adds value to the queue, spilling it according to the queue's rule
+// if its level is full
+func (sq *SpillingQueue) Enqueue(ctx context.Context, queueName string, value interface{}, priority int) error {
+	entry, err := sq.routeOne(ctx, queueName, value, priority)
+	if err != nil {
+		return err
+	}
+	return sq.PriorityQueuer.Enqueue(ctx, entry.QueueName, entry.Value, entry.Priority)
+}
+
+// EnqueueWithID adds value like Enqueue and returns its id
+func (sq *SpillingQueue) EnqueueWithID(ctx context.Context, queueName string, value interface{}, priority int) (string, error) {
+	entry, err := sq.routeOne(ctx, queueName, value, priority)
+	if err != nil {
+		return "", err
+	}
+	return sq.PriorityQueuer.EnqueueWithID(ctx, entry.QueueName, entry.Value, entry.Priority)
+}
+
+// InsertAtTop adds value at the head of its level, spilling it to the head of
+// the level or queue Enqueue would have used if its level is full
+func (sq *SpillingQueue) InsertAtTop(ctx context.Context, queueName string, value interface{}, priority int) error {
+	entry, err := sq.routeOne(ctx, queueName, value, priority)
+	if err != nil {
+		return err
+	}
+	return sq.PriorityQueuer.InsertAtTop(ctx, entry.QueueName, entry.Value, entry.Priority)
+}
+
+// InsertAtTopBatch adds values at the head of their level in order. Values
+// that don't fit spill as InsertAtTop would, each destination level taking
+// its share in one call, so a batch that spills is no longer atomic.
+func (sq *SpillingQueue) InsertAtTopBatch(ctx context.Context, queueName string, values []interface{}, priority int) error {
+	entries := make([]QueueEntry, len(values))
+	for i, value := range values {
+		entries[i] = QueueEntry{QueueName: queueName, Value: value, Priority: priority}
+	}
+	routed, err := sq.route(ctx, entries)
+	if err != nil {
+		return err
+	}
+
+	var order []levelKey
+	groups := make(map[levelKey][]interface{})
+	for _, entry := range routed {
+		key := levelKey{entry.QueueName, entry.Priority}
+		if _, ok := groups[key]; !ok {
+			order = append(order, key)
+		}
+		groups[key] = append(groups[key], entry.Value)
+	}
+	if len(order) == 0 {
+		return sq.PriorityQueuer.InsertAtTopBatch(ctx, queueName, values, priority)
+	}
+	for _, key := range order {
+		if err := sq.PriorityQueuer.InsertAtTopBatch(ctx, key.queueName, groups[key], key.priority); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// EnqueueBatch adds every item, spilling them as Enqueue would. A batch that
+// spills into an overflow queue is written with EnqueueMulti, so it stays
+// atomic.
+func (sq *SpillingQueue) EnqueueBatch(ctx context.Context, queueName string, items []Item) error {
+	entries := make([]QueueEntry, len(items))
+	for i, item := range items {
+		entries[i] = QueueEntry{QueueName: queueName, Value: item.Value, Priority: item.Priority}
+	}
+	routed, err := sq.route(ctx, entries)
+	if err != nil {
+		return err
+	}
+
+	spilled := make([]Item, len(items))
+	for i, entry := range routed {
+		if entry.QueueName != queueName {
+			return sq.PriorityQueuer.EnqueueMulti(ctx, routed)
+		}
+		spilled[i] = items[i]
+		spilled[i].Priority = entry.Priority
+	}
+	return sq.PriorityQueuer.EnqueueBatch(ctx, queueName, spilled)
+}
+
+// EnqueueMulti adds every entry, spilling each as Enqueue would
+func (sq *SpillingQueue) EnqueueMulti(ctx context.Context, entries []QueueEntry) error {
+	routed, err := sq.route(ctx, entries)
+	if err != nil {
+		return err
+	}
+	return sq.PriorityQueuer.EnqueueMulti(ctx, routed)
+}
+
+// EnqueueFanout adds a copy of value to each queue, spilling each copy as
+// Enqueue would. Copies that spill are written with EnqueueMulti, so the
+// fan-out stays atomic. A queue named more than once gets one copy, as with
+// the backends' own EnqueueFanout.
+func (sq *SpillingQueue) EnqueueFanout(ctx context.Context, queueNames []string, value interface{}, priority int) error {
+	queueNames = uniqueNames(queueNames)
+	entries := make([]QueueEntry, len(queueNames))
+	for i, queueName := range queueNames {
+		entries[i] = QueueEntry{QueueName: queueName, Value: value, Priority: priority}
+	}
+	routed, err := sq.route(ctx, entries)
+	if err != nil {
+		return err
+	}
+	for i, entry := range routed {
+		if entry.QueueName != entries[i].QueueName || entry.Priority != priority {
+			return sq.PriorityQueuer.EnqueueMulti(ctx, routed)
+		}
+	}
+	return sq.PriorityQueuer.EnqueueFanout(ctx, queueNames, value, priority)
+}
+
+// uniqueNames returns queueNames without repeats, keeping the first of each
+func uniqueNames(queueNames []string) []string {
+	seen := make(map[string]bool, len(queueNames))
+	unique := make([]string, 0, len(queueNames))
+	for _, queueName := range queueNames {
+		if !seen[queueName] {
+			seen[queueName] = true
+			unique = append(unique, queueName)
+		}
+	}
+	return unique
+}
@@ -0,0 +1,115 @@
+package priorityqueue
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// StatsSample is one point in a queue's statistics history
+type StatsSample struct {
+	At       time.Time
+	Depth    int64
+	Enqueued int64 // items enqueued since the previous sample
+	Dequeued int64 // items dequeued since the previous sample
+}
+
+// StatsRecorder samples the depth and throughput of a set of queues and keeps
+// the most recent samples of each in memory, enough to chart the last day
+// without external monitoring
+type StatsRecorder struct {
+	pq        PriorityQueuer
+	retention int
+	history   map[string][]StatsSample
+	last      map[string]QueueCounters
+	clock     Clock
+	mutex     sync.Mutex
+}
+
+// NewStatsRecorder records samples of pq's queues, keeping up to retention
+// samples per queue. With one sample a minute, 1440 covers a day.
+func NewStatsRecorder(pq PriorityQueuer, retention int) *StatsRecorder {
+	if retention <= 0 {
+		retention = 1440
+	}
+	return &StatsRecorder{
+		pq:        pq,
+		retention: retention,
+		history:   make(map[string][]StatsSample),
+		last:      make(map[string]QueueCounters),
+		clock:     SystemClock{},
+	}
+}
+
+// SetClock replaces the time source used to stamp samples. It must be called
+// before the recorder is shared between goroutines.
+func (r *StatsRecorder) SetClock(clock Clock) {
+	r.clock = clock
+}
+
+// Sample records one sample of each queue. The first sample of a queue has
+// zero throughput, since there is nothing to compare its counters with.
+func (r *StatsRecorder) Sample(ctx context.Context, queueNames ...string) error {
+	for _, queueName := range queueNames {
+		depth, err := r.pq.FastLen(ctx, queueName)
+		if err != nil {
+			return err
+		}
+		counters, err := r.pq.Counters(ctx, queueName)
+		if err != nil {
+			return err
+		}
+
+		r.mutex.Lock()
+		sample := StatsSample{At: r.clock.Now(), Depth: depth.Len}
+		if last, ok := r.last[queueName]; ok {
+			sample.Enqueued = counters.Enqueued - last.Enqueued
+			sample.Dequeued = counters.Dequeued - last.Dequeued
+		}
+		r.last[queueName] = counters
+		history := append(r.history[queueName], sample)
+		if len(history) > r.retention {
+			history = history[len(history)-r.retention:]
+		}
+		r.history[queueName] = history
+		r.mutex.Unlock()
+	}
+	return nil
+}
+
+// Start samples the queues every interval until the returned function is
+// called or ctx is done. Sampling errors are retried on the next tick.
+func (r *StatsRecorder) Start(ctx context.Context, interval time.Duration, queueNames ...string) (stop func()) {
+	ticker := time.NewTicker(interval)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-ticker.C:
+				r.Sample(ctx, queueNames...)
+			case <-ctx.Done():
+				ticker.Stop()
+				return
+			case <-done:
+				ticker.Stop()
+				return
+			}
+		}
+	}()
+	return func() { close(done) }
+}
+
+// StatsHistory returns a queue's samples from the last window, oldest first
+func (r *StatsRecorder) StatsHistory(queueName string, window time.Duration) []StatsSample {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	since := r.clock.Now().Add(-window)
+	history := r.history[queueName]
+	for i, sample := range history {
+		if !sample.At.Before(since) {
+			return append([]StatsSample(nil), history[i:]...)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,328 @@
+package priorityqueue
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// OpRecord describes one operation recorded by a TracingQueue. Item is the
+// id of the item for operations by id and for dequeues, falling back to the
+// value for items without one, and the value otherwise.
+type OpRecord struct {
+	At       time.Time
+	Op       string
+	Item     string
+	Priority int    // -1 when the operation has no priority
+	Target   string // the destination queue of MoveItem and RenameQueue
+	Latency  time.Duration
+	Err      error
+}
+
+// TracingQueue wraps a PriorityQueuer and keeps the last N enqueue, dequeue,
+// reordering and queue management operations of every queue in a ring
+// buffer, to answer "what happened to this queue recently" without full
+// logging. Read-only calls are passed through untraced.
+type TracingQueue struct {
+	PriorityQueuer
+	size  int
+	rings map[string]*opRing
+	clock Clock
+	mutex sync.Mutex
+}
+
+// opRing is a fixed-size ring buffer of operations
+type opRing struct {
+	records []OpRecord
+	next    int
+	full    bool
+}
+
+// NewTracingQueue wraps pq, remembering up to size operations per queue
+func NewTracingQueue(pq PriorityQueuer, size int) *TracingQueue {
+	if size <= 0 {
+		size = 100
+	}
+	return &TracingQueue{
+		PriorityQueuer: pq,
+		size:           size,
+		rings:          make(map[string]*opRing),
+		clock:          SystemClock{},
+	}
+}
+
+// SetClock replaces the time source used to stamp and time operations. It
+// must be called before the queue is shared between goroutines.
+func (tq *TracingQueue) SetClock(clock Clock) {
+	tq.clock = clock
+}
+
+// Operations returns the recorded operations of a queue, oldest first
+func (tq *TracingQueue) Operations(queueName string) []OpRecord {
+	tq.mutex.Lock()
+	defer tq.mutex.Unlock()
+
+	ring, ok := tq.rings[queueName]
+	if !ok {
+		return nil
+	}
+	if !ring.full {
+		return append([]OpRecord(nil), ring.records[:ring.next]...)
+	}
+	records := make([]OpRecord, 0, len(ring.records))
+	records = append(records, ring.records[ring.next:]...)
+	return append(records, ring.records[:ring.next]...)
+}
+
+// record appends an operation to a queue's ring buffer
+func (tq *TracingQueue) record(queueName, op string, item interface{}, priority int, start time.Time, err error) {
+	tq.recordMove(queueName, op, item, priority, "", start, err)
+}
+
+// recordMove is record for operations with a destination queue
+func (tq *TracingQueue) recordMove(queueName, op string, item interface{}, priority int, target string, start time.Time, err error) {
+	rec := OpRecord{
+		At:       start,
+		Op:       op,
+		Priority: priority,
+		Target:   target,
+		Latency:  tq.clock.Now().Sub(start),
+		Err:      err,
+	}
+	if item != nil {
+		rec.Item = fmt.Sprintf("%v", item)
+	}
+
+	tq.mutex.Lock()
+	defer tq.mutex.Unlock()
+
+	ring, ok := tq.rings[queueName]
+	if !ok {
+		ring = &opRing{records: make([]OpRecord, tq.size)}
+		tq.rings[queueName] = ring
+	}
+	ring.records[ring.next] = rec
+	ring.next = (ring.next + 1) % len(ring.records)
+	if ring.next == 0 {
+		ring.full = true
+	}
+}
+
+// removedItem returns the id of the i-th item a dequeue took, or its value if
+// the backend didn't report an id
+func removedItem(removed *removalLog, i int, value interface{}) interface{} {
+	if id := removed.id(i); id != "" {
+		return id
+	}
+	return value
+}
+
+func (tq *TracingQueue) Enqueue(ctx context.Context, queueName string, value interface{}, priority int) error {
+	start := tq.clock.Now()
+	err := tq.PriorityQueuer.Enqueue(ctx, queueName, value, priority)
+	tq.record(queueName, "Enqueue", value, priority, start, err)
+	return err
+}
+
+func (tq *TracingQueue) EnqueueFanout(ctx context.Context, queueNames []string, value interface{}, priority int) error {
+	start := tq.clock.Now()
+	err := tq.PriorityQueuer.EnqueueFanout(ctx, queueNames, value, priority)
+	for _, queueName := range queueNames {
+		tq.record(queueName, "EnqueueFanout", value, priority, start, err)
+	}
+	return err
+}
+
+func (tq *TracingQueue) EnqueueMulti(ctx context.Context, entries []QueueEntry) error {
+	start := tq.clock.Now()
+	err := tq.PriorityQueuer.EnqueueMulti(ctx, entries)
+	for _, e := range entries {
+		tq.record(e.QueueName, "EnqueueMulti", e.Value, e.Priority, start, err)
+	}
+	return err
+}
+
+func (tq *TracingQueue) EnqueueBatch(ctx context.Context, queueName string, items []Item) error {
+	start := tq.clock.Now()
+	err := tq.PriorityQueuer.EnqueueBatch(ctx, queueName, items)
+	tq.record(queueName, "EnqueueBatch", fmt.Sprintf("%d items", len(items)), -1, start, err)
+	return err
+}
+
+func (tq *TracingQueue) Dequeue(ctx context.Context, queueName string) (interface{}, error) {
+	start := tq.clock.Now()
+	ctx, removed := withRemovalLog(ctx)
+	value, err := tq.PriorityQueuer.Dequeue(ctx, queueName)
+	tq.record(queueName, "Dequeue", removedItem(removed, 0, value), -1, start, err)
+	return value, err
+}
+
+func (tq *TracingQueue) InsertAtTop(ctx context.Context, queueName string, value interface{}, priority int) error {
+	start := tq.clock.Now()
+	err := tq.PriorityQueuer.InsertAtTop(ctx, queueName, value, priority)
+	tq.record(queueName, "InsertAtTop", value, priority, start, err)
+	return err
+}
+
+func (tq *TracingQueue) InsertAtTopBatch(ctx context.Context, queueName string, values []interface{}, priority int) error {
+	start := tq.clock.Now()
+	err := tq.PriorityQueuer.InsertAtTopBatch(ctx, queueName, values, priority)
+	tq.record(queueName, "InsertAtTopBatch", fmt.Sprintf("%d items", len(values)), priority, start, err)
+	return err
+}
+
+func (tq *TracingQueue) EnqueueWithID(ctx context.Context, queueName string, value interface{}, priority int) (string, error) {
+	start := tq.clock.Now()
+	id, err := tq.PriorityQueuer.EnqueueWithID(ctx, queueName, value, priority)
+	tq.record(queueName, "EnqueueWithID", id, priority, start, err)
+	return id, err
+}
+
+func (tq *TracingQueue) DeleteByID(ctx context.Context, queueName, id string) error {
+	start := tq.clock.Now()
+	err := tq.PriorityQueuer.DeleteByID(ctx, queueName, id)
+	tq.record(queueName, "DeleteByID", id, -1, start, err)
+	return err
+}
+
+func (tq *TracingQueue) UpdateByID(ctx context.Context, queueName, id string, value interface{}) error {
+	start := tq.clock.Now()
+	err := tq.PriorityQueuer.UpdateByID(ctx, queueName, id, value)
+	tq.record(queueName, "UpdateByID", id, -1, start, err)
+	return err
+}
+
+func (tq *TracingQueue) DeleteItem(ctx context.Context, queueName string, value interface{}) error {
+	start := tq.clock.Now()
+	err := tq.PriorityQueuer.DeleteItem(ctx, queueName, value)
+	tq.record(queueName, "DeleteItem", value, -1, start, err)
+	return err
+}
+
+func (tq *TracingQueue) MoveToPosition(ctx context.Context, queueName string, itemID string, priority, position int) error {
+	start := tq.clock.Now()
+	err := tq.PriorityQueuer.MoveToPosition(ctx, queueName, itemID, priority, position)
+	tq.record(queueName, "MoveToPosition", itemID, priority, start, err)
+	return err
+}
+
+func (tq *TracingQueue) UpdatePriority(ctx context.Context, queueName string, value interface{}, newPriority int) error {
+	start := tq.clock.Now()
+	err := tq.PriorityQueuer.UpdatePriority(ctx, queueName, value, newPriority)
+	tq.record(queueName, "UpdatePriority", value, newPriority, start, err)
+	return err
+}
+
+func (tq *TracingQueue) MoveItem(ctx context.Context, srcQueue, dstQueue string, value interface{}) error {
+	start := tq.clock.Now()
+	err := tq.PriorityQueuer.MoveItem(ctx, srcQueue, dstQueue, value)
+	tq.recordMove(srcQueue, "MoveItem", value, -1, dstQueue, start, err)
+	tq.recordMove(dstQueue, "MoveItem", value, -1, dstQueue, start, err)
+	return err
+}
+
+func (tq *TracingQueue) RenameQueue(ctx context.Context, oldName, newName string) error {
+	start := tq.clock.Now()
+	err := tq.PriorityQueuer.RenameQueue(ctx, oldName, newName)
+	tq.recordMove(oldName, "RenameQueue", newName, -1, newName, start, err)
+	return err
+}
+
+func (tq *TracingQueue) SwapItems(ctx context.Context, queueName, itemA, itemB string) error {
+	start := tq.clock.Now()
+	err := tq.PriorityQueuer.SwapItems(ctx, queueName, itemA, itemB)
+	tq.record(queueName, "SwapItems", itemA+" <-> "+itemB, -1, start, err)
+	return err
+}
+
+func (tq *TracingQueue) DequeueFromPriority(ctx context.Context, queueName string, priority int) (interface{}, error) {
+	start := tq.clock.Now()
+	ctx, removed := withRemovalLog(ctx)
+	value, err := tq.PriorityQueuer.DequeueFromPriority(ctx, queueName, priority)
+	tq.record(queueName, "DequeueFromPriority", removedItem(removed, 0, value), priority, start, err)
+	return value, err
+}
+
+func (tq *TracingQueue) DequeueWhere(ctx context.Context, queueName string, pred func(Item) bool) (interface{}, error) {
+	start := tq.clock.Now()
+	ctx, removed := withRemovalLog(ctx)
+	value, err := tq.PriorityQueuer.DequeueWhere(ctx, queueName, pred)
+	tq.record(queueName, "DequeueWhere", removedItem(removed, 0, value), -1, start, err)
+	return value, err
+}
+
+func (tq *TracingQueue) DequeueLevel(ctx context.Context, queueName string) ([]interface{}, error) {
+	start := tq.clock.Now()
+	ctx, removed := withRemovalLog(ctx)
+	values, err := tq.PriorityQueuer.DequeueLevel(ctx, queueName)
+	if len(values) == 0 {
+		tq.record(queueName, "DequeueLevel", nil, -1, start, err)
+	}
+	for i, value := range values {
+		tq.record(queueName, "DequeueLevel", removedItem(removed, i, value), -1, start, err)
+	}
+	return values, err
+}
+
+func (tq *TracingQueue) DequeueBatch(ctx context.Context, queueName string, n int) ([]interface{}, error) {
+	start := tq.clock.Now()
+	ctx, removed := withRemovalLog(ctx)
+	values, err := tq.PriorityQueuer.DequeueBatch(ctx, queueName, n)
+	if len(values) == 0 {
+		tq.record(queueName, "DequeueBatch", nil, -1, start, err)
+	}
+	for i, value := range values {
+		tq.record(queueName, "DequeueBatch", removedItem(removed, i, value), -1, start, err)
+	}
+	return values, err
+}
+
+func (tq *TracingQueue) DequeueFresh(ctx context.Context, queueName string, maxAge time.Duration, expire bool) (interface{}, error) {
+	start := tq.clock.Now()
+	ctx, removed := withRemovalLog(ctx)
+	value, err := tq.PriorityQueuer.DequeueFresh(ctx, queueName, maxAge, expire)
+	tq.record(queueName, "DequeueFresh", removedItem(removed, 0, value), -1, start, err)
+	return value, err
+}
+
+func (tq *TracingQueue) Purge(ctx context.Context, queueName string) error {
+	start := tq.clock.Now()
+	err := tq.PriorityQueuer.Purge(ctx, queueName)
+	tq.record(queueName, "Purge", nil, -1, start, err)
+	return err
+}
+
+func (tq *TracingQueue) RemoveQueue(ctx context.Context, name string) error {
+	start := tq.clock.Now()
+	err := tq.PriorityQueuer.RemoveQueue(ctx, name)
+	tq.record(name, "RemoveQueue", nil, -1, start, err)
+	return err
+}
+
+func (tq *TracingQueue) FreezeQueue(ctx context.Context, queueName string) error {
+	start := tq.clock.Now()
+	err := tq.PriorityQueuer.FreezeQueue(ctx, queueName)
+	tq.record(queueName, "FreezeQueue", nil, -1, start, err)
+	return err
+}
+
+func (tq *TracingQueue) UnfreezeQueue(ctx context.Context, queueName string) error {
+	start := tq.clock.Now()
+	err := tq.PriorityQueuer.UnfreezeQueue(ctx, queueName)
+	tq.record(queueName, "UnfreezeQueue", nil, -1, start, err)
+	return err
+}
+
+// SetPriorityWeights records the weights as the item, or nothing when they
+// are cleared
+func (tq *TracingQueue) SetPriorityWeights(ctx context.Context, queueName string, weights []float64) error {
+	start := tq.clock.Now()
+	err := tq.PriorityQueuer.SetPriorityWeights(ctx, queueName, weights)
+	var item interface{}
+	if weights != nil {
+		item = weights
+	}
+	tq.record(queueName, "SetPriorityWeights", item, -1, start, err)
+	return err
+}
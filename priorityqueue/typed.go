@@ -0,0 +1,180 @@
+package priorityqueue
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+// TypedQueue wraps a PriorityQueuer so items go in and come out as T.
+// Values are stored encoded by each queue's Codec, JSON unless SetCodec
+// chooses another, so they survive the Redis backend's string conversion
+// intact and callers need no type assertions.
+type TypedQueue[T any] struct {
+	pq     PriorityQueuer
+	codecs map[string]Codec
+	mutex  sync.Mutex
+}
+
+// NewTypedQueue wraps pq for items of type T
+func NewTypedQueue[T any](pq PriorityQueuer) *TypedQueue[T] {
+	return &TypedQueue[T]{pq: pq, codecs: make(map[string]Codec)}
+}
+
+// NewTypedMultiPriorityQueue returns a memory-backed TypedQueue
+func NewTypedMultiPriorityQueue[T any]() *TypedQueue[T] {
+	return NewTypedQueue[T](NewMultiPriorityQueue())
+}
+
+// NewTypedRedisPriorityQueue returns a Redis-backed TypedQueue
+func NewTypedRedisPriorityQueue[T any](addr, password string, db int) *TypedQueue[T] {
+	return NewTypedQueue[T](NewRedisPriorityQueue(addr, password, db))
+}
+
+// Queue returns the underlying untyped queue
+func (tq *TypedQueue[T]) Queue() PriorityQueuer {
+	return tq.pq
+}
+
+// SetCodec chooses how a queue's items are encoded. Passing nil restores
+// JSONCodec. Items already queued are not re-encoded, so change codecs only
+// on empty queues.
+func (tq *TypedQueue[T]) SetCodec(queueName string, codec Codec) {
+	tq.mutex.Lock()
+	defer tq.mutex.Unlock()
+
+	if codec == nil {
+		delete(tq.codecs, queueName)
+	} else {
+		tq.codecs[queueName] = codec
+	}
+}
+
+// codec returns a queue's codec
+func (tq *TypedQueue[T]) codec(queueName string) Codec {
+	tq.mutex.Lock()
+	defer tq.mutex.Unlock()
+
+	if codec, ok := tq.codecs[queueName]; ok {
+		return codec
+	}
+	return JSONCodec{}
+}
+
+func (tq *TypedQueue[T]) encode(queueName string, value T) (string, error) {
+	data, err := tq.codec(queueName).Marshal(value)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode item for queue '%s': %w", queueName, err)
+	}
+	return string(data), nil
+}
+
+func (tq *TypedQueue[T]) decode(queueName string, raw interface{}) (T, error) {
+	var value T
+	err := tq.decodeInto(queueName, raw, &value)
+	return value, err
+}
+
+func (tq *TypedQueue[T]) decodeInto(queueName string, raw interface{}, dest interface{}) error {
+	s, ok := raw.(string)
+	if !ok {
+		return fmt.Errorf("failed to decode item from queue '%s': unexpected %T", queueName, raw)
+	}
+	if err := tq.codec(queueName).Unmarshal([]byte(s), dest); err != nil {
+		return fmt.Errorf("failed to decode item from queue '%s': %w", queueName, err)
+	}
+	return nil
+}
+
+func (tq *TypedQueue[T]) AddQueue(ctx context.Context, name string) error {
+	return tq.pq.AddQueue(ctx, name)
+}
+
+func (tq *TypedQueue[T]) Enqueue(ctx context.Context, queueName string, value T, priority int) error {
+	s, err := tq.encode(queueName, value)
+	if err != nil {
+		return err
+	}
+	return tq.pq.Enqueue(ctx, queueName, s, priority)
+}
+
+func (tq *TypedQueue[T]) Dequeue(ctx context.Context, queueName string) (T, error) {
+	raw, err := tq.pq.Dequeue(ctx, queueName)
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+	return tq.decode(queueName, raw)
+}
+
+// DequeueInto dequeues the next item and decodes it with the queue's codec
+// into dest, which must be a non-nil pointer, for callers that want it as a
+// type other than T. An item that fails to decode has still been removed.
+func (tq *TypedQueue[T]) DequeueInto(ctx context.Context, queueName string, dest interface{}) error {
+	if v := reflect.ValueOf(dest); v.Kind() != reflect.Pointer || v.IsNil() {
+		return fmt.Errorf("dest must be a non-nil pointer, got %T", dest)
+	}
+	raw, err := tq.pq.Dequeue(ctx, queueName)
+	if err != nil {
+		return err
+	}
+	return tq.decodeInto(queueName, raw, dest)
+}
+
+func (tq *TypedQueue[T]) DequeueFromPriority(ctx context.Context, queueName string, priority int) (T, error) {
+	raw, err := tq.pq.DequeueFromPriority(ctx, queueName, priority)
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+	return tq.decode(queueName, raw)
+}
+
+func (tq *TypedQueue[T]) Peek(ctx context.Context, queueName string) (T, error) {
+	raw, err := tq.pq.Peek(ctx, queueName)
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+	return tq.decode(queueName, raw)
+}
+
+func (tq *TypedQueue[T]) PeekPriority(ctx context.Context, queueName string, priority int) (T, error) {
+	raw, err := tq.pq.PeekPriority(ctx, queueName, priority)
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+	return tq.decode(queueName, raw)
+}
+
+func (tq *TypedQueue[T]) IsEmpty(ctx context.Context, queueName string) (bool, error) {
+	return tq.pq.IsEmpty(ctx, queueName)
+}
+
+func (tq *TypedQueue[T]) DeleteItem(ctx context.Context, queueName string, value T) error {
+	s, err := tq.encode(queueName, value)
+	if err != nil {
+		return err
+	}
+	return tq.pq.DeleteItem(ctx, queueName, s)
+}
+
+func (tq *TypedQueue[T]) ListContents(ctx context.Context, queueName string) (map[int][]T, error) {
+	contents, err := tq.pq.ListContents(ctx, queueName)
+	if err != nil {
+		return nil, err
+	}
+	typed := make(map[int][]T, len(contents))
+	for priority, values := range contents {
+		for _, raw := range values {
+			value, err := tq.decode(queueName, raw)
+			if err != nil {
+				return nil, err
+			}
+			typed[priority] = append(typed[priority], value)
+		}
+	}
+	return typed, nil
+}
@@ -0,0 +1,188 @@
+package priorityqueue
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// Validator checks an item before it is written to a queue. A non-nil error
+// rejects the item.
+type Validator func(value interface{}, priority int) error
+
+// ValidatingQueue wraps a PriorityQueuer and runs each queue's Validator
+// before any item is written to it or moved into it, and before an item's
+// priority changes, so schema checks and business rules are enforced in one
+// place for every producer using the wrapper
+type ValidatingQueue struct {
+	PriorityQueuer
+	validators map[string]Validator
+	mutex      sync.Mutex
+}
+
+// NewValidatingQueue wraps pq with no validators registered
+func NewValidatingQueue(pq PriorityQueuer) *ValidatingQueue {
+	return &ValidatingQueue{
+		PriorityQueuer: pq,
+		validators:     make(map[string]Validator),
+	}
+}
+
+// SetValidator registers the validator for a queue, replacing any previous
+// one. Passing nil removes it.
+func (vq *ValidatingQueue) SetValidator(queueName string, v Validator) {
+	vq.mutex.Lock()
+	defer vq.mutex.Unlock()
+
+	if v == nil {
+		delete(vq.validators, queueName)
+	} else {
+		vq.validators[queueName] = v
+	}
+}
+
+// validator returns a queue's validator, if any
+func (vq *ValidatingQueue) validator(queueName string) (Validator, bool) {
+	vq.mutex.Lock()
+	defer vq.mutex.Unlock()
+
+	v, ok := vq.validators[queueName]
+	return v, ok
+}
+
+// validate runs a queue's validator, if any, over each value
+func (vq *ValidatingQueue) validate(queueName string, priority int, values ...interface{}) error {
+	v, ok := vq.validator(queueName)
+	if !ok {
+		return nil
+	}
+	for _, value := range values {
+		if err := v(value, priority); err != nil {
+			return fmt.Errorf("invalid item '%v' for queue '%s': %w", value, queueName, err)
+		}
+	}
+	return nil
+}
+
+func (vq *ValidatingQueue) Enqueue(ctx context.Context, queueName string, value interface{}, priority int) error {
+	if err := vq.validate(queueName, priority, value); err != nil {
+		return err
+	}
+	return vq.PriorityQueuer.Enqueue(ctx, queueName, value, priority)
+}
+
+func (vq *ValidatingQueue) EnqueueWithID(ctx context.Context, queueName string, value interface{}, priority int) (string, error) {
+	if err := vq.validate(queueName, priority, value); err != nil {
+		return "", err
+	}
+	return vq.PriorityQueuer.EnqueueWithID(ctx, queueName, value, priority)
+}
+
+// UpdateByID validates the new value at the item's current priority
+func (vq *ValidatingQueue) UpdateByID(ctx context.Context, queueName, id string, value interface{}) error {
+	item, err := vq.PriorityQueuer.GetByID(ctx, queueName, id)
+	if err != nil {
+		return err
+	}
+	if err := vq.validate(queueName, item.Priority, value); err != nil {
+		return err
+	}
+	return vq.PriorityQueuer.UpdateByID(ctx, queueName, id, value)
+}
+
+// EnqueueFanout validates value against every named queue before writing to
+// any of them
+func (vq *ValidatingQueue) EnqueueFanout(ctx context.Context, queueNames []string, value interface{}, priority int) error {
+	for _, queueName := range queueNames {
+		if err := vq.validate(queueName, priority, value); err != nil {
+			return err
+		}
+	}
+	return vq.PriorityQueuer.EnqueueFanout(ctx, queueNames, value, priority)
+}
+
+// EnqueueMulti validates every entry before writing any of them
+func (vq *ValidatingQueue) EnqueueMulti(ctx context.Context, entries []QueueEntry) error {
+	for _, e := range entries {
+		if err := vq.validate(e.QueueName, e.Priority, e.Value); err != nil {
+			return err
+		}
+	}
+	return vq.PriorityQueuer.EnqueueMulti(ctx, entries)
+}
+
+// EnqueueBatch rejects the whole batch if any item fails validation
+func (vq *ValidatingQueue) EnqueueBatch(ctx context.Context, queueName string, items []Item) error {
+	for _, item := range items {
+		if err := vq.validate(queueName, item.Priority, item.Value); err != nil {
+			return err
+		}
+	}
+	return vq.PriorityQueuer.EnqueueBatch(ctx, queueName, items)
+}
+
+func (vq *ValidatingQueue) InsertAtTop(ctx context.Context, queueName string, value interface{}, priority int) error {
+	if err := vq.validate(queueName, priority, value); err != nil {
+		return err
+	}
+	return vq.PriorityQueuer.InsertAtTop(ctx, queueName, value, priority)
+}
+
+// InsertAtTopBatch rejects the whole batch if any value fails validation
+func (vq *ValidatingQueue) InsertAtTopBatch(ctx context.Context, queueName string, values []interface{}, priority int) error {
+	if err := vq.validate(queueName, priority, values...); err != nil {
+		return err
+	}
+	return vq.PriorityQueuer.InsertAtTopBatch(ctx, queueName, values, priority)
+}
+
+// UpdatePriority validates the item at its new priority
+func (vq *ValidatingQueue) UpdatePriority(ctx context.Context, queueName string, value interface{}, newPriority int) error {
+	if err := vq.validate(queueName, newPriority, value); err != nil {
+		return err
+	}
+	return vq.PriorityQueuer.UpdatePriority(ctx, queueName, value, newPriority)
+}
+
+// MoveToPosition validates the item at its new priority when the move
+// changes it
+func (vq *ValidatingQueue) MoveToPosition(ctx context.Context, queueName string, itemID string, priority, position int) error {
+	if _, ok := vq.validator(queueName); ok {
+		item, err := vq.PriorityQueuer.GetByID(ctx, queueName, itemID)
+		if err != nil {
+			return err
+		}
+		if item.Priority != priority {
+			if err := vq.validate(queueName, priority, item.Value); err != nil {
+				return err
+			}
+		}
+	}
+	return vq.PriorityQueuer.MoveToPosition(ctx, queueName, itemID, priority, position)
+}
+
+// MoveItem validates the item against dstQueue at the priority it keeps. The
+// priority is that of the first item in srcQueue matching value.
+func (vq *ValidatingQueue) MoveItem(ctx context.Context, srcQueue, dstQueue string, value interface{}) error {
+	if _, ok := vq.validator(dstQueue); ok {
+		want := fmt.Sprintf("%v", value)
+		priority := -1
+		err := vq.PriorityQueuer.IterateItems(ctx, srcQueue, func(item Item) bool {
+			if fmt.Sprintf("%v", item.Value) == want {
+				priority = item.Priority
+				return false
+			}
+			return true
+		})
+		if err != nil {
+			return err
+		}
+		// A missing item is left for the backend to report
+		if priority >= 0 {
+			if err := vq.validate(dstQueue, priority, value); err != nil {
+				return err
+			}
+		}
+	}
+	return vq.PriorityQueuer.MoveItem(ctx, srcQueue, dstQueue, value)
+}
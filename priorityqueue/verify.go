@@ -0,0 +1,78 @@
+package priorityqueue
+
+import (
+	"context"
+	"fmt"
+)
+
+// VerifyReport lists the inconsistencies VerifyQueue found in a queue
+type VerifyReport struct {
+	Problems []string // one line per inconsistency
+	Repaired bool     // whether the problems were repaired
+}
+
+// OK reports whether the queue passed every check
+func (r VerifyReport) OK() bool {
+	return len(r.Problems) == 0
+}
+
+// countersProblem describes counters that can't account for size queued
+// items, or returns "" if they can. Deletes don't count as dequeues, so the
+// counters may account for more items than are queued, but never fewer.
+func countersProblem(counters QueueCounters, size int64) string {
+	if counters.Dequeued > counters.Enqueued || counters.Enqueued-counters.Dequeued < size {
+		return fmt.Sprintf("counters (enqueued %d, dequeued %d) don't account for %d queued items",
+			counters.Enqueued, counters.Dequeued, size)
+	}
+	return ""
+}
+
+// VerifyQueue checks that every item sits in the level matching its priority
+// and has an enqueue time, and that the counters account for every item. With
+// repair set, items are fixed up in place and the enqueued counter is raised
+// to cover the queue.
+func (mpq *MultiPriorityQueue) VerifyQueue(ctx context.Context, queueName string, repair bool) (VerifyReport, error) {
+	mpq.mutex.Lock()
+	pq, exists := mpq.queues[queueName]
+	mpq.mutex.Unlock()
+
+	if !exists {
+		return VerifyReport{}, fmt.Errorf("queue '%s' does not exist", queueName)
+	}
+
+	pq.mutex.Lock()
+	defer pq.mutex.Unlock()
+
+	if repair && pq.frozen {
+		return VerifyReport{}, frozenError(queueName)
+	}
+
+	var report VerifyReport
+	var size int64
+	for priority, level := range pq.queues {
+		size += int64(len(level))
+		for i := range level {
+			item := &level[i]
+			if item.Priority != priority {
+				report.Problems = append(report.Problems, fmt.Sprintf("item '%v' has priority %d but sits in level %d", item.Value, item.Priority, priority))
+				if repair {
+					item.Priority = priority
+				}
+			}
+			if item.EnqueuedAt.IsZero() {
+				report.Problems = append(report.Problems, fmt.Sprintf("item '%v' has no enqueue time", item.Value))
+				if repair {
+					item.EnqueuedAt = mpq.clock.Now()
+				}
+			}
+		}
+	}
+	if problem := countersProblem(pq.counters, size); problem != "" {
+		report.Problems = append(report.Problems, problem)
+		if repair {
+			pq.counters.Enqueued = pq.counters.Dequeued + size
+		}
+	}
+	report.Repaired = repair && !report.OK()
+	return report, nil
+}
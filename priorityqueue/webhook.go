@@ -0,0 +1,442 @@
+package priorityqueue
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Webhook event types
+const (
+	EventQueueFrozen     = "queue.frozen"
+	EventQueueUnfrozen   = "queue.unfrozen"
+	EventDepthThreshold  = "queue.depth_threshold"
+	EventItemQuarantined = "item.quarantined"
+)
+
+// WebhookSignatureHeader carries the hex HMAC-SHA256 of the request body,
+// keyed with the publisher's secret
+const WebhookSignatureHeader = "X-PQ-Signature"
+
+// WebhookEvent is the JSON body POSTed to registered webhooks
+type WebhookEvent struct {
+	Type  string    `json:"type"`
+	Queue string    `json:"queue"`
+	Depth int64     `json:"depth,omitempty"`
+	Time  time.Time `json:"time"`
+}
+
+type webhook struct {
+	url    string
+	events map[string]bool // nil means every event
+}
+
+type notifierHook struct {
+	notifier  Notifier
+	queueName string          // empty means every queue
+	events    map[string]bool // nil means every event
+}
+
+// eventSet turns a list of event types into a lookup set, nil if empty
+func eventSet(events []string) map[string]bool {
+	if len(events) == 0 {
+		return nil
+	}
+	set := make(map[string]bool, len(events))
+	for _, e := range events {
+		set[e] = true
+	}
+	return set
+}
+
+// WebhookPublisher wraps a PriorityQueuer and POSTs queue events to
+// registered URLs and Notifiers: queues being frozen or unfrozen, an enqueue
+// taking a queue to its depth threshold, and corrupt items being quarantined
+// on dequeue. Deliveries run in the background and are retried on failure
+// until Close.
+type WebhookPublisher struct {
+	PriorityQueuer
+	client     *http.Client
+	secret     []byte
+	retries    int
+	backoff    time.Duration
+	onError    func(target string, event WebhookEvent, err error)
+	hooks      []webhook
+	notifiers  []notifierHook
+	thresholds map[string]int64
+	above      map[string]bool
+	clock      Clock
+	ctx        context.Context
+	cancel     context.CancelFunc
+	closed     bool
+	inflight   sync.WaitGroup
+	mutex      sync.Mutex
+}
+
+// NewWebhookPublisher wraps pq, signing every delivery with secret. By
+// default each delivery is retried 3 times, one second apart.
+func NewWebhookPublisher(pq PriorityQueuer, secret string) *WebhookPublisher {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &WebhookPublisher{
+		PriorityQueuer: pq,
+		client:         &http.Client{Timeout: 10 * time.Second},
+		secret:         []byte(secret),
+		retries:        3,
+		backoff:        time.Second,
+		thresholds:     make(map[string]int64),
+		above:          make(map[string]bool),
+		clock:          SystemClock{},
+		ctx:            ctx,
+		cancel:         cancel,
+	}
+}
+
+// AddWebhook registers url for the given event types, or for every event if
+// none are given
+func (wp *WebhookPublisher) AddWebhook(url string, events ...string) {
+	wp.mutex.Lock()
+	defer wp.mutex.Unlock()
+	wp.hooks = append(wp.hooks, webhook{url: url, events: eventSet(events)})
+}
+
+// AddNotifier registers n for the given event types on queueName, or on every
+// queue if queueName is empty. With no event types, n gets every event.
+func (wp *WebhookPublisher) AddNotifier(n Notifier, queueName string, events ...string) {
+	wp.mutex.Lock()
+	defer wp.mutex.Unlock()
+	wp.notifiers = append(wp.notifiers, notifierHook{notifier: n, queueName: queueName, events: eventSet(events)})
+}
+
+// SetDepthThreshold fires EventDepthThreshold when an enqueue takes the queue
+// from below depth to depth or more. Removals re-check the depth, so the
+// event fires again each time the queue drops below depth and refills. Zero
+// removes the threshold.
+func (wp *WebhookPublisher) SetDepthThreshold(queueName string, depth int64) {
+	wp.mutex.Lock()
+	defer wp.mutex.Unlock()
+
+	if depth <= 0 {
+		delete(wp.thresholds, queueName)
+	} else {
+		wp.thresholds[queueName] = depth
+	}
+	delete(wp.above, queueName)
+}
+
+// SetRetries sets how many times a failed delivery is retried and the pause
+// between attempts. It must be called before the publisher is shared between
+// goroutines.
+func (wp *WebhookPublisher) SetRetries(retries int, backoff time.Duration) {
+	wp.retries = retries
+	wp.backoff = backoff
+}
+
+// SetErrorHandler is called with deliveries that still fail after all
+// retries. The target is the webhook URL or the notifier's type. It must be
+// called before the publisher is shared between goroutines.
+func (wp *WebhookPublisher) SetErrorHandler(fn func(target string, event WebhookEvent, err error)) {
+	wp.onError = fn
+}
+
+// SetClock replaces the time source used to stamp events and to wait between
+// retries; a clock without After waits on the system timer. It must be
+// called before the publisher is shared between goroutines.
+func (wp *WebhookPublisher) SetClock(clock Clock) {
+	wp.clock = clock
+}
+
+// Wait blocks until every delivery started so far has finished
+func (wp *WebhookPublisher) Wait() {
+	wp.inflight.Wait()
+}
+
+// Close cancels pending retries and in-flight requests and waits for the
+// deliveries to stop. Their failures go to the error handler. Events
+// published after Close are dropped.
+func (wp *WebhookPublisher) Close() {
+	wp.mutex.Lock()
+	wp.closed = true
+	wp.mutex.Unlock()
+
+	wp.cancel()
+	wp.inflight.Wait()
+}
+
+// publish starts a delivery of the event to each webhook and notifier
+// subscribed to it
+func (wp *WebhookPublisher) publish(eventType, queueName string, depth int64) {
+	event := WebhookEvent{Type: eventType, Queue: queueName, Depth: depth, Time: wp.clock.Now()}
+	body, err := json.Marshal(event)
+	if err != nil {
+		return
+	}
+	mac := hmac.New(sha256.New, wp.secret)
+	mac.Write(body)
+	signature := hex.EncodeToString(mac.Sum(nil))
+
+	wp.mutex.Lock()
+	defer wp.mutex.Unlock()
+
+	if wp.closed {
+		return
+	}
+	for _, hook := range wp.hooks {
+		if hook.events != nil && !hook.events[eventType] {
+			continue
+		}
+		wp.inflight.Add(1)
+		url := hook.url
+		go wp.retry(url, event, func(ctx context.Context) error { return wp.deliver(ctx, url, body, signature) })
+	}
+	for _, hook := range wp.notifiers {
+		if hook.queueName != "" && hook.queueName != queueName {
+			continue
+		}
+		if hook.events != nil && !hook.events[eventType] {
+			continue
+		}
+		wp.inflight.Add(1)
+		n := hook.notifier
+		go wp.retry(fmt.Sprintf("%T", n), event, func(ctx context.Context) error { return n.Notify(ctx, event) })
+	}
+}
+
+// retry runs send until it succeeds, the retries run out or the publisher is
+// closed, reporting the final failure to the error handler. Backoff is
+// measured on the publisher's clock.
+func (wp *WebhookPublisher) retry(target string, event WebhookEvent, send func(ctx context.Context) error) {
+	defer wp.inflight.Done()
+
+	var err error
+	for attempt := 0; attempt <= wp.retries; attempt++ {
+		if attempt > 0 {
+			if waitErr := waitOn(wp.ctx, wp.clock, wp.backoff); waitErr != nil {
+				break
+			}
+		}
+		if err = send(wp.ctx); err == nil {
+			return
+		}
+		if wp.ctx.Err() != nil {
+			break
+		}
+	}
+	if wp.onError != nil {
+		wp.onError(target, event, err)
+	}
+}
+
+// deliver POSTs body to url once, failing unless the response is 2xx
+func (wp *WebhookPublisher) deliver(ctx context.Context, url string, body []byte, signature string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WebhookSignatureHeader, signature)
+
+	resp, err := wp.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+// checkDepth publishes EventDepthThreshold for each queue whose depth has
+// reached its threshold since the last check. It runs after removals as well
+// as enqueues, so a queue that drained below its threshold is re-armed.
+func (wp *WebhookPublisher) checkDepth(ctx context.Context, queueNames ...string) {
+	for _, queueName := range queueNames {
+		wp.mutex.Lock()
+		threshold, ok := wp.thresholds[queueName]
+		wp.mutex.Unlock()
+		if !ok {
+			continue
+		}
+
+		size, err := wp.PriorityQueuer.Size(ctx, queueName)
+		if err != nil {
+			continue
+		}
+		depth := int64(size)
+
+		wp.mutex.Lock()
+		crossed := depth >= threshold && !wp.above[queueName]
+		wp.above[queueName] = depth >= threshold
+		wp.mutex.Unlock()
+
+		if crossed {
+			wp.publish(EventDepthThreshold, queueName, depth)
+		}
+	}
+}
+
+// checkQuarantine publishes EventItemQuarantined when a dequeue hit a
+// corrupt item
+func (wp *WebhookPublisher) checkQuarantine(queueName string, err error) {
+	if errors.Is(err, ErrCorruptPayload) {
+		wp.publish(EventItemQuarantined, queueName, 0)
+	}
+}
+
+// checkRemoval follows a call that may have removed items: it reports a
+// quarantined item and re-checks the depth threshold
+func (wp *WebhookPublisher) checkRemoval(ctx context.Context, queueName string, err error) {
+	wp.checkQuarantine(queueName, err)
+	if err == nil || errors.Is(err, ErrCorruptPayload) {
+		wp.checkDepth(ctx, queueName)
+	}
+}
+
+func (wp *WebhookPublisher) Enqueue(ctx context.Context, queueName string, value interface{}, priority int) error {
+	err := wp.PriorityQueuer.Enqueue(ctx, queueName, value, priority)
+	if err == nil {
+		wp.checkDepth(ctx, queueName)
+	}
+	return err
+}
+
+func (wp *WebhookPublisher) EnqueueWithID(ctx context.Context, queueName string, value interface{}, priority int) (string, error) {
+	id, err := wp.PriorityQueuer.EnqueueWithID(ctx, queueName, value, priority)
+	if err == nil {
+		wp.checkDepth(ctx, queueName)
+	}
+	return id, err
+}
+
+func (wp *WebhookPublisher) EnqueueFanout(ctx context.Context, queueNames []string, value interface{}, priority int) error {
+	err := wp.PriorityQueuer.EnqueueFanout(ctx, queueNames, value, priority)
+	if err == nil {
+		wp.checkDepth(ctx, queueNames...)
+	}
+	return err
+}
+
+func (wp *WebhookPublisher) EnqueueMulti(ctx context.Context, entries []QueueEntry) error {
+	err := wp.PriorityQueuer.EnqueueMulti(ctx, entries)
+	if err == nil {
+		seen := make(map[string]bool)
+		for _, e := range entries {
+			if !seen[e.QueueName] {
+				seen[e.QueueName] = true
+				wp.checkDepth(ctx, e.QueueName)
+			}
+		}
+	}
+	return err
+}
+
+func (wp *WebhookPublisher) EnqueueBatch(ctx context.Context, queueName string, items []Item) error {
+	err := wp.PriorityQueuer.EnqueueBatch(ctx, queueName, items)
+	if err == nil {
+		wp.checkDepth(ctx, queueName)
+	}
+	return err
+}
+
+func (wp *WebhookPublisher) InsertAtTop(ctx context.Context, queueName string, value interface{}, priority int) error {
+	err := wp.PriorityQueuer.InsertAtTop(ctx, queueName, value, priority)
+	if err == nil {
+		wp.checkDepth(ctx, queueName)
+	}
+	return err
+}
+
+func (wp *WebhookPublisher) InsertAtTopBatch(ctx context.Context, queueName string, values []interface{}, priority int) error {
+	err := wp.PriorityQueuer.InsertAtTopBatch(ctx, queueName, values, priority)
+	if err == nil {
+		wp.checkDepth(ctx, queueName)
+	}
+	return err
+}
+
+func (wp *WebhookPublisher) Dequeue(ctx context.Context, queueName string) (interface{}, error) {
+	value, err := wp.PriorityQueuer.Dequeue(ctx, queueName)
+	wp.checkRemoval(ctx, queueName, err)
+	return value, err
+}
+
+func (wp *WebhookPublisher) DequeueFromPriority(ctx context.Context, queueName string, priority int) (interface{}, error) {
+	value, err := wp.PriorityQueuer.DequeueFromPriority(ctx, queueName, priority)
+	wp.checkRemoval(ctx, queueName, err)
+	return value, err
+}
+
+func (wp *WebhookPublisher) DequeueBatch(ctx context.Context, queueName string, n int) ([]interface{}, error) {
+	values, err := wp.PriorityQueuer.DequeueBatch(ctx, queueName, n)
+	wp.checkRemoval(ctx, queueName, err)
+	return values, err
+}
+
+func (wp *WebhookPublisher) DequeueLevel(ctx context.Context, queueName string) ([]interface{}, error) {
+	values, err := wp.PriorityQueuer.DequeueLevel(ctx, queueName)
+	wp.checkRemoval(ctx, queueName, err)
+	return values, err
+}
+
+func (wp *WebhookPublisher) DequeueWhere(ctx context.Context, queueName string, pred func(Item) bool) (interface{}, error) {
+	value, err := wp.PriorityQueuer.DequeueWhere(ctx, queueName, pred)
+	wp.checkRemoval(ctx, queueName, err)
+	return value, err
+}
+
+func (wp *WebhookPublisher) DequeueFresh(ctx context.Context, queueName string, maxAge time.Duration, expire bool) (interface{}, error) {
+	value, err := wp.PriorityQueuer.DequeueFresh(ctx, queueName, maxAge, expire)
+	// Expired items may have been deleted even when nothing was fresh
+	wp.checkDepth(ctx, queueName)
+	return value, err
+}
+
+func (wp *WebhookPublisher) DeleteItem(ctx context.Context, queueName string, value interface{}) error {
+	err := wp.PriorityQueuer.DeleteItem(ctx, queueName, value)
+	wp.checkRemoval(ctx, queueName, err)
+	return err
+}
+
+func (wp *WebhookPublisher) DeleteByID(ctx context.Context, queueName, id string) error {
+	err := wp.PriorityQueuer.DeleteByID(ctx, queueName, id)
+	wp.checkRemoval(ctx, queueName, err)
+	return err
+}
+
+func (wp *WebhookPublisher) Purge(ctx context.Context, queueName string) error {
+	err := wp.PriorityQueuer.Purge(ctx, queueName)
+	wp.checkRemoval(ctx, queueName, err)
+	return err
+}
+
+func (wp *WebhookPublisher) MoveItem(ctx context.Context, srcQueue, dstQueue string, value interface{}) error {
+	err := wp.PriorityQueuer.MoveItem(ctx, srcQueue, dstQueue, value)
+	if err == nil {
+		wp.checkDepth(ctx, srcQueue, dstQueue)
+	}
+	return err
+}
+
+func (wp *WebhookPublisher) FreezeQueue(ctx context.Context, queueName string) error {
+	err := wp.PriorityQueuer.FreezeQueue(ctx, queueName)
+	if err == nil {
+		wp.publish(EventQueueFrozen, queueName, 0)
+	}
+	return err
+}
+
+func (wp *WebhookPublisher) UnfreezeQueue(ctx context.Context, queueName string) error {
+	err := wp.PriorityQueuer.UnfreezeQueue(ctx, queueName)
+	if err == nil {
+		wp.publish(EventQueueUnfrozen, queueName, 0)
+	}
+	return err
+}
@@ -0,0 +1,74 @@
+package priorityqueue
+
+import (
+	"fmt"
+	"math/rand"
+)
+
+// validateWeights checks that weights has one non-negative entry per priority
+// level and that at least one of them is positive
+func validateWeights(weights []float64, levels int) error {
+	if len(weights) != levels {
+		return fmt.Errorf("weights must have exactly %d entries, got %d", levels, len(weights))
+	}
+	total := 0.0
+	for priority, w := range weights {
+		if w < 0 {
+			return fmt.Errorf("weight for priority %d must not be negative", priority)
+		}
+		total += w
+	}
+	if total == 0 {
+		return fmt.Errorf("at least one weight must be positive")
+	}
+	return nil
+}
+
+// weightAt returns the weight of a priority level, treating levels that
+// weights doesn't cover as zero
+func weightAt(weights []float64, priority int) float64 {
+	if priority < len(weights) {
+		return weights[priority]
+	}
+	return 0
+}
+
+// pickWeightedLevel chooses a non-empty priority level with probability
+// proportional to its weight. Non-empty levels whose weight is zero, or that
+// weights doesn't cover, are only chosen when no weighted level has items.
+// Returns -1 if every level is empty.
+func pickWeightedLevel(weights []float64, nonEmpty []bool) int {
+	total := 0.0
+	fallback := -1
+	for priority, has := range nonEmpty {
+		if !has {
+			continue
+		}
+		if fallback == -1 {
+			fallback = priority
+		}
+		total += weightAt(weights, priority)
+	}
+	if total == 0 {
+		return fallback
+	}
+
+	r := rand.Float64() * total
+	for priority, has := range nonEmpty {
+		w := weightAt(weights, priority)
+		if !has || w == 0 {
+			continue
+		}
+		r -= w
+		if r < 0 {
+			return priority
+		}
+	}
+	// Floating point rounding can leave r marginally above zero
+	for priority := len(nonEmpty) - 1; priority >= 0; priority-- {
+		if nonEmpty[priority] && weightAt(weights, priority) > 0 {
+			return priority
+		}
+	}
+	return fallback
+}